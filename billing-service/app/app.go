@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/billing-service/config"
+	httpController "github.com/director74/dz7_shop/billing-service/internal/controller/http"
+	"github.com/director74/dz7_shop/billing-service/internal/repo"
+	"github.com/director74/dz7_shop/billing-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/database"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/idempotency"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/outbox"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// App представляет приложение
+type App struct {
+	config          *config.Config
+	httpServer      *http.Server
+	db              *gorm.DB
+	broker          messaging.MessageBroker
+	jwtManager      *auth.JWTManager
+	billingUseCase  *usecase.BillingUseCase
+	outboxRelay     *outbox.Relay
+	revocations     *auth.PostgresRevocationStore
+	idempotencyKeys *idempotency.GormStore
+	// closeBroker брокер создан приложением и закрывается при остановке
+	closeBroker bool
+}
+
+// NewApp создает приложение с брокером, выбранным настройкой MESSAGE_BROKER
+func NewApp(config *config.Config) (*App, error) {
+	// Инициализируем подключение к RabbitMQ
+	broker, err := messaging.NewBroker(config.RabbitMQ)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к брокеру сообщений")
+	}
+
+	app, err := NewAppWithBroker(config, broker)
+	if err != nil {
+		broker.Close()
+		return nil, err
+	}
+	app.closeBroker = true
+	return app, nil
+}
+
+// NewAppWithBroker создает приложение, которое публикует и получает события через broker.
+// Брокер закрывает вызывающий код, так сервисы запускаются в одном процессе на общем брокере в памяти
+func NewAppWithBroker(config *config.Config, broker messaging.MessageBroker) (*App, error) {
+	// Инициализируем подключение к PostgreSQL
+	db, err := database.NewPostgresDB(config.Postgres)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к базе данных")
+	}
+
+	// Автомиграция моделей
+	/*if err := database.AutoMigrateWithCleanup(db, &entity.Account{}); err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
+	}*/
+
+	// Инициализируем JWT менеджер. Сервис только проверяет токены, поэтому для RS256 и EdDSA
+	// использует открытые ключи из JWKS сервиса заказов и не хранит секрета подписи
+	signingMethod, err := auth.SigningMethod(config.JWT.Algorithm)
+	if err != nil {
+		database.CloseDB(db)
+		return nil, err
+	}
+	jwtConfig := &auth.Config{
+		SigningKey:     config.JWT.SigningKey,
+		SigningMethod:  signingMethod,
+		TokenTTL:       config.JWT.TokenTTL,
+		TokenIssuer:    config.JWT.TokenIssuer,
+		TokenAudiences: config.JWT.TokenAudiences,
+	}
+	if config.JWT.Algorithm != auth.AlgorithmHS256 {
+		jwtConfig.Keys = auth.NewJWKSClient(config.JWT.JWKSURL, config.JWT.JWKSCacheTTL)
+	}
+	jwtManager := auth.NewJWTManager(jwtConfig)
+
+	// Создаем middleware для авторизации. Отзывы токенов приходят из сервиса заказов событиями auth_events
+	revocations := auth.NewPostgresRevocationStore(db)
+	authMiddleware := auth.NewAuthMiddleware(jwtManager, revocations)
+
+	// Создаем репозитории
+	billingRepo := repo.NewBillingRepository(db)
+	billingUseCase := usecase.NewBillingUseCase(billingRepo, "billing_events", config.Holds.TTL)
+
+	if err := SetupMessaging(broker, billingUseCase); err != nil {
+		database.CloseDB(db)
+		return nil, err
+	}
+
+	err = broker.ConsumeMessages("auth_billing_queue", "billing-service-auth", rabbitmq.ConsumerOptions{}, auth.HandleRevocationEvent(revocations))
+	if err != nil {
+		database.CloseDB(db)
+		return nil, errors.AppendPrefix(err, "ошибка при настройке обработчика отзыва токенов")
+	}
+
+	idempotencyKeys := idempotency.NewGormStore(db)
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyKeys)
+	billingHandler := httpController.NewBillingHandler(billingUseCase, authMiddleware, idempotencyMiddleware, broker)
+
+	// Инициализируем Gin роутер
+	router := gin.Default()
+
+	// Добавляем middleware для обработки ошибок и восстановления после паники
+	router.Use(errors.RecoveryMiddleware())
+	router.Use(errors.ErrorMiddleware())
+
+	// Настраиваем обработчики для 404 и 405 ошибок
+	router.NoRoute(errors.NotFoundHandler())
+	router.NoMethod(errors.MethodNotAllowedHandler())
+
+	// Регистрируем эндпоинты
+	billingHandler.RegisterRoutes(router)
+
+	httpServer := &http.Server{
+		Addr:         ":" + config.HTTP.Port,
+		Handler:      router,
+		ReadTimeout:  config.HTTP.ReadTimeout,
+		WriteTimeout: config.HTTP.WriteTimeout,
+	}
+
+	return &App{
+		config:          config,
+		httpServer:      httpServer,
+		db:              db,
+		broker:          broker,
+		jwtManager:      jwtManager,
+		billingUseCase:  billingUseCase,
+		outboxRelay:     outbox.NewRelay(db, broker, config.Outbox),
+		revocations:     revocations,
+		idempotencyKeys: idempotencyKeys,
+	}, nil
+}
+
+// Run запускает приложение
+func (a *App) Run() error {
+	// Настраиваем обработку сигналов завершения
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Запускаем HTTP сервер в горутине
+	go func() {
+		log.Printf("Сервис биллинга запущен на порту %s", a.config.HTTP.Port)
+		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка запуска HTTP сервера: %v", err)
+		}
+	}()
+
+	// Запускаем публикацию событий из outbox
+	go a.outboxRelay.Run(ctx)
+
+	// Запускаем очистку inbox обработанных событий
+	go messaging.RunInboxCleanup(ctx, a.db, a.config.Inbox)
+
+	// Запускаем очистку истекших отзывов токенов
+	go auth.RunRevocationCleanup(ctx, a.revocations)
+
+	// Запускаем удаление устаревших ключей идемпотентности
+	go idempotency.RunCleanup(ctx, a.idempotencyKeys)
+
+	// Запускаем периодическую сверку балансов с главной книгой
+	if a.config.Ledger.ReconcileInterval > 0 {
+		go a.billingUseCase.RunReconciliation(ctx, a.config.Ledger.ReconcileInterval)
+	}
+
+	// Запускаем освобождение просроченных холдов
+	if a.config.Holds.ExpiryInterval > 0 {
+		go a.billingUseCase.RunHoldExpiry(ctx, a.config.Holds.ExpiryInterval)
+	}
+
+	// Ожидаем сигнал завершения
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-quit:
+		log.Println("Получен сигнал завершения, закрываем приложение...")
+	case <-ctx.Done():
+		log.Println("Контекст завершен, закрываем приложение...")
+	}
+
+	return a.Shutdown()
+}
+
+// Shutdown корректно завершает работу приложения
+func (a *App) Shutdown() error {
+	errGroup := errors.NewErrorGroup()
+
+	// Закрываем HTTP сервер
+	if a.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := a.httpServer.Shutdown(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии HTTP сервера")
+		}
+	}
+
+	// Прекращаем получение сообщений и ждем обрабатываемые, затем закрываем RabbitMQ
+	if a.broker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.RabbitMQ.DrainTimeout)
+		defer cancel()
+
+		if err := a.broker.StopConsuming(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при остановке обработки сообщений")
+		}
+		if a.closeBroker {
+			a.broker.Close()
+		}
+	}
+
+	// Закрываем соединение с базой данных
+	if a.db != nil {
+		if err := database.CloseDB(a.db); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии соединения с базой данных")
+		}
+	}
+
+	if errGroup.HasErrors() {
+		errors.LogError(errGroup, "Shutdown")
+		return errGroup
+	}
+
+	log.Println("Приложение успешно завершено")
+	return nil
+}
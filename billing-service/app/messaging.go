@@ -0,0 +1,48 @@
+package app
+
+import (
+	"github.com/director74/dz7_shop/billing-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// SetupMessaging объявляет exchanges и очереди биллинга и подписывает обработчики событий
+// сервиса заказов. Та же топология используется биллингом в памяти (billingtest)
+func SetupMessaging(broker messaging.MessageBroker, billingUseCase *usecase.BillingUseCase) error {
+	// Настраиваем exchanges и очереди в RabbitMQ
+	exchanges := map[string]string{
+		"billing_events": "topic",
+		"order_events":   "topic",
+		"auth_events":    "topic",
+	}
+	queues := map[string]map[string]string{
+		"order_billing_queue": {
+			"order_events": "order.created",
+		},
+		"order_compensation_billing_queue": {
+			"order_events": "order.payment_compensation",
+		},
+		"auth_billing_queue": {
+			"auth_events": "auth.#",
+		},
+	}
+
+	if err := messaging.SetupExchangesAndQueues(broker, exchanges, queues); err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке RabbitMQ")
+	}
+
+	// Настраиваем обработчик сообщений из очереди заказов
+	err := broker.ConsumeMessages("order_billing_queue", "billing-service", rabbitmq.ConsumerOptions{}, billingUseCase.HandleOrderCreatedEvent)
+	if err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке обработчика сообщений")
+	}
+
+	// Возвраты оплаты отмененных заказов сервис заказов передает через outbox
+	err = broker.ConsumeMessages("order_compensation_billing_queue", "billing-service-compensation", rabbitmq.ConsumerOptions{}, billingUseCase.HandlePaymentCompensationEvent)
+	if err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке обработчика возвратов оплаты")
+	}
+
+	return nil
+}
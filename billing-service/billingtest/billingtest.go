@@ -0,0 +1,73 @@
+// Package billingtest запускает биллинг в памяти процесса: настоящий usecase и обработчики очередей
+// из app.SetupMessaging работают на переданном брокере, данные хранятся в памяти. Нужен тестам,
+// которые проверяют сагу оплаты заказа на всех трех сервисах в одном процессе
+package billingtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/app"
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/billing-service/internal/repo/memory"
+	"github.com/director74/dz7_shop/billing-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/money"
+	"github.com/director74/dz7_shop/pkg/outbox"
+)
+
+// holdTTL время жизни холда, в саге холды не истекают
+const holdTTL = time.Hour
+
+// Service биллинг в памяти процесса
+type Service struct {
+	useCase *usecase.BillingUseCase
+}
+
+// Start объявляет очереди биллинга на broker и подписывает обработчики событий сервиса заказов.
+// События outbox публикуются в broker сразу после фиксации транзакции
+func Start(broker messaging.MessageBroker) (*Service, error) {
+	repo := memory.NewRepository()
+	repo.OnCommit(outbox.PublishCommitted(broker))
+
+	billingUseCase := usecase.NewBillingUseCase(repo, "billing_events", holdTTL)
+	if err := app.SetupMessaging(broker, billingUseCase); err != nil {
+		return nil, err
+	}
+	return &Service{useCase: billingUseCase}, nil
+}
+
+// OpenAccount создает счет пользователя и пополняет его на amount
+func (s *Service) OpenAccount(ctx context.Context, userID uint, amount money.Money) error {
+	if _, err := s.useCase.CreateAccount(ctx, entity.CreateAccountRequest{UserID: userID}); err != nil {
+		return err
+	}
+	if amount.IsZero() {
+		return nil
+	}
+	_, err := s.useCase.Deposit(ctx, entity.DepositRequest{UserID: userID, Amount: amount})
+	return err
+}
+
+// Balance возвращает баланс счета пользователя
+func (s *Service) Balance(ctx context.Context, userID uint) (money.Money, error) {
+	account, err := s.useCase.GetAccount(ctx, userID)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return account.Balance, nil
+}
+
+// Reconcile сверяет балансы счетов с главной книгой и возвращает ошибку при расхождении
+func (s *Service) Reconcile(ctx context.Context) error {
+	report, err := s.useCase.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+	if !report.Balanced || len(report.Mismatches) > 0 {
+		return fmt.Errorf("главная книга не сходится: дебет %s, кредит %s, расхождений %d",
+			report.TotalDebit, report.TotalCredit, len(report.Mismatches))
+	}
+	return nil
+}
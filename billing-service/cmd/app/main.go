@@ -3,8 +3,8 @@ package main
 import (
 	"log"
 
+	"github.com/director74/dz7_shop/billing-service/app"
 	"github.com/director74/dz7_shop/billing-service/config"
-	"github.com/director74/dz7_shop/billing-service/internal/app"
 )
 
 func main() {
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/director74/dz7_shop/pkg/config"
 )
 
@@ -10,6 +12,24 @@ type Config struct {
 	Postgres config.PostgresConfig
 	RabbitMQ config.RabbitMQConfig
 	JWT      config.JWTConfig
+	Ledger   LedgerConfig
+	Holds    HoldsConfig
+	Outbox   config.OutboxConfig
+	Inbox    config.InboxConfig
+}
+
+// HoldsConfig содержит настройки резервирования средств
+type HoldsConfig struct {
+	// TTL срок действия холда, после которого резерв освобождается
+	TTL time.Duration
+	// ExpiryInterval период проверки просроченных холдов
+	ExpiryInterval time.Duration
+}
+
+// LedgerConfig содержит настройки главной книги
+type LedgerConfig struct {
+	// ReconcileInterval период сверки балансов с главной книгой, 0 отключает сверку
+	ReconcileInterval time.Duration
 }
 
 func NewConfig() (*Config, error) {
@@ -17,12 +37,24 @@ func NewConfig() (*Config, error) {
 	commonConfig := config.LoadCommonConfig("billing", "8081")
 
 	// Загружаем конфигурацию JWT
-	jwtConfig := config.LoadJWTConfig("microservices-auth")
+	jwtConfig, err := config.LoadJWTConfig("microservices-auth")
+	if err != nil {
+		return nil, err
+	}
 
 	return &Config{
 		HTTP:     commonConfig.HTTP,
 		Postgres: commonConfig.Postgres,
 		RabbitMQ: commonConfig.RabbitMQ,
 		JWT:      *jwtConfig,
+		Ledger: LedgerConfig{
+			ReconcileInterval: config.GetEnvAsDuration("LEDGER_RECONCILE_INTERVAL", 10*time.Minute),
+		},
+		Holds: HoldsConfig{
+			TTL:            config.GetEnvAsDuration("HOLD_TTL", 72*time.Hour),
+			ExpiryInterval: config.GetEnvAsDuration("HOLD_EXPIRY_INTERVAL", time.Minute),
+		},
+		Outbox: *config.LoadOutboxConfig(),
+		Inbox:  *config.LoadInboxConfig(),
 	}, nil
 }
@@ -1,25 +1,35 @@
 package http
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/director74/dz7_shop/billing-service/internal/entity"
 	"github.com/director74/dz7_shop/billing-service/internal/usecase"
 	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/idempotency"
+	"github.com/director74/dz7_shop/pkg/messaging"
 )
 
 type BillingHandler struct {
-	billingUseCase *usecase.BillingUseCase
-	authMiddleware *auth.AuthMiddleware
+	billingUseCase        *usecase.BillingUseCase
+	authMiddleware        *auth.AuthMiddleware
+	idempotencyMiddleware *idempotency.Middleware
+	broker                messaging.ConnectionStatus
 }
 
-func NewBillingHandler(billingUseCase *usecase.BillingUseCase, authMiddleware *auth.AuthMiddleware) *BillingHandler {
+func NewBillingHandler(billingUseCase *usecase.BillingUseCase, authMiddleware *auth.AuthMiddleware, idempotencyMiddleware *idempotency.Middleware, broker messaging.ConnectionStatus) *BillingHandler {
 	return &BillingHandler{
-		billingUseCase: billingUseCase,
-		authMiddleware: authMiddleware,
+		billingUseCase:        billingUseCase,
+		authMiddleware:        authMiddleware,
+		idempotencyMiddleware: idempotencyMiddleware,
+		broker:                broker,
 	}
 }
 
@@ -28,26 +38,67 @@ func (h *BillingHandler) RegisterRoutes(router *gin.Engine) {
 
 	api := router.Group("/api/v1")
 	{
-		// Публичные эндпоинты
-		api.POST("/accounts", h.CreateAccount)
-		api.GET("/accounts/:user_id", h.GetAccount)
+		// Аккаунт другого пользователя доступен только с разрешением billing:read_all
+		api.GET("/accounts/:user_id", h.authMiddleware.AuthRequired(),
+			h.authMiddleware.RequireOwner("user_id", auth.PermissionAccountsReadAll), h.GetAccount)
 
 		// Защищенные эндпоинты (требуют авторизации)
-		auth := api.Group("/billing")
-		auth.Use(h.authMiddleware.AuthRequired())
+		authorized := api.Group("/billing")
+		authorized.Use(h.authMiddleware.AuthRequired())
 		{
 			// Получение информации о своем аккаунте
-			auth.GET("/account", h.GetCurrentAccount)
+			authorized.GET("/account", h.GetCurrentAccount)
 
-			// Пополнение баланса для своего аккаунта
-			auth.POST("/deposit", h.Deposit)
-			auth.POST("/withdraw", h.Withdraw)
+			// Пополнение и списание поддерживают повтор запроса с заголовком Idempotency-Key
+			authorized.POST("/deposit", h.idempotencyMiddleware.Handle(), h.Deposit)
+			authorized.POST("/withdraw", h.idempotencyMiddleware.Handle(), h.Withdraw)
+
+			// История операций и выписка по своему счету
+			authorized.GET("/transactions", h.ListTransactions)
+			authorized.GET("/transactions/:id", h.GetTransaction)
+			authorized.GET("/statement", h.GetStatement)
+
+			// Холды создает, списывает и отменяет сервис заказов, пользователь может их только просматривать
+			authorized.GET("/holds/:id", h.GetHold)
+		}
+	}
+
+	// Административные операции
+	admin := api.Group("/admin")
+	admin.Use(h.authMiddleware.AuthRequired())
+	{
+		admin.POST("/accounts/:user_id/adjustments", h.authMiddleware.RequirePermission(auth.PermissionBalanceAdjust),
+			h.idempotencyMiddleware.Handle(), h.AdjustBalance)
+	}
+
+	// Внутренние эндпоинты принимают только токены сервисов. Операции со счетом сервис
+	// выполняет от имени пользователя из пути. Возврат и операции с холдами доступны только здесь
+	internal := router.Group("/internal")
+	internal.Use(h.authMiddleware.ServiceRequired())
+	{
+		internal.POST("/accounts", h.CreateAccount)
+
+		user := internal.Group("/users/:user_id")
+		user.Use(h.authMiddleware.OnBehalfOf("user_id"))
+		{
+			user.POST("/withdraw", h.idempotencyMiddleware.Handle(), h.Withdraw)
+			user.POST("/refund", h.idempotencyMiddleware.Handle(), h.Refund)
+
+			// Двухфазное списание: резервирование, затем списание или отмена резерва
+			user.POST("/holds", h.idempotencyMiddleware.Handle(), h.Authorize)
+			user.POST("/holds/:id/capture", h.idempotencyMiddleware.Handle(), h.Capture)
+			user.POST("/holds/:id/void", h.Void)
 		}
 	}
 }
 
+// HealthCheck возвращает 503, пока соединение с RabbitMQ не восстановлено
 func (h *BillingHandler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if !h.broker.IsConnected() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "rabbitmq": h.broker.State()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "rabbitmq": h.broker.State()})
 }
 
 func (h *BillingHandler) CreateAccount(c *gin.Context) {
@@ -122,6 +173,10 @@ func (h *BillingHandler) Deposit(c *gin.Context) {
 
 	resp, err := h.billingUseCase.Deposit(c.Request.Context(), req)
 	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidAmount) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -145,6 +200,11 @@ func (h *BillingHandler) Withdraw(c *gin.Context) {
 	}
 
 	req.UserID = userID
+	// Привязать списание к заказу может только сервис заказов, иначе пользователь мог бы
+	// занять единственную оплату чужого заказа
+	if auth.GetService(c) == "" {
+		req.OrderID = nil
+	}
 
 	if req.Email == "" {
 		req.Email = auth.GetEmail(c)
@@ -152,6 +212,14 @@ func (h *BillingHandler) Withdraw(c *gin.Context) {
 
 	resp, err := h.billingUseCase.Withdraw(c.Request.Context(), req)
 	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidAmount) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, usecase.ErrOrderAlreadyPaid) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -163,3 +231,315 @@ func (h *BillingHandler) Withdraw(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// Refund возвращает средства по ранее выполненному списанию
+func (h *BillingHandler) Refund(c *gin.Context) {
+	// Получаем ID пользователя из JWT токена
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	var req entity.RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.UserID = userID
+
+	if req.Email == "" {
+		req.Email = auth.GetEmail(c)
+	}
+
+	resp, err := h.billingUseCase.Refund(c.Request.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrTransactionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrRefundNotAllowed), errors.Is(err, usecase.ErrRefundAmountExceeded):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListTransactions возвращает историю транзакций счета текущего пользователя
+func (h *BillingHandler) ListTransactions(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	filter := entity.TransactionFilter{
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	var err error
+	if filter.From, err = parsePeriodBound(c.Query("from"), false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parsePeriodBound(c.Query("to"), true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.billingUseCase.ListTransactions(c.Request.Context(), userID, filter)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidFilter) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetTransaction возвращает транзакцию текущего пользователя по ID
+func (h *BillingHandler) GetTransaction(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	transactionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID транзакции"})
+		return
+	}
+
+	resp, err := h.billingUseCase.GetTransaction(c.Request.Context(), userID, uint(transactionID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetStatement возвращает выписку по счету текущего пользователя за период.
+// По умолчанию период - с начала текущего месяца до текущего момента
+func (h *BillingHandler) GetStatement(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+
+	if bound, err := parsePeriodBound(c.Query("from"), false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if bound != nil {
+		from = *bound
+	}
+	if bound, err := parsePeriodBound(c.Query("to"), true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if bound != nil {
+		to = *bound
+	}
+
+	resp, err := h.billingUseCase.GetStatement(c.Request.Context(), userID, from, to)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidFilter) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// parsePeriodBound разбирает границу периода в формате RFC3339 или YYYY-MM-DD.
+// Дата без времени в конце периода включает весь день
+func parsePeriodBound(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fmt.Errorf("некорректная дата %q: ожидается RFC3339 или YYYY-MM-DD", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// Authorize резервирует средства на счете текущего пользователя
+func (h *BillingHandler) Authorize(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	var req entity.AuthorizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.UserID = userID
+
+	resp, err := h.billingUseCase.Authorize(c.Request.Context(), req)
+	if err != nil {
+		h.holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GetHold возвращает холд текущего пользователя по ID
+func (h *BillingHandler) GetHold(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	holdID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID холда"})
+		return
+	}
+
+	resp, err := h.billingUseCase.GetHold(c.Request.Context(), userID, uint(holdID))
+	if err != nil {
+		h.holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Capture списывает зарезервированные средства. Тело запроса необязательно:
+// без суммы списывается весь холд
+func (h *BillingHandler) Capture(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	holdID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID холда"})
+		return
+	}
+
+	var req entity.CaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.UserID = userID
+	req.HoldID = uint(holdID)
+
+	resp, err := h.billingUseCase.Capture(c.Request.Context(), req)
+	if err != nil {
+		h.holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Void отменяет холд и освобождает резерв
+func (h *BillingHandler) Void(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return
+	}
+
+	holdID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID холда"})
+		return
+	}
+
+	resp, err := h.billingUseCase.Void(c.Request.Context(), userID, uint(holdID))
+	if err != nil {
+		h.holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// holdError отвечает кодом, соответствующим ошибке операции с холдом
+func (h *BillingHandler) holdError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidAmount), errors.Is(err, usecase.ErrInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrHoldNotActive), errors.Is(err, usecase.ErrHoldExpired),
+		errors.Is(err, usecase.ErrHoldAmountExceeded), errors.Is(err, usecase.ErrOrderAlreadyPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// AdjustBalance корректирует баланс аккаунта пользователя по запросу администратора
+func (h *BillingHandler) AdjustBalance(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+		return
+	}
+
+	var req entity.AdjustBalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.billingUseCase.AdjustBalance(c.Request.Context(), uint(userID), auth.GetUserID(c), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInvalidAmount):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrAccountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrInsufficientFunds):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -2,34 +2,42 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz7_shop/pkg/money"
 )
 
 // Account хранит информацию о финансовом аккаунте пользователя и его балансе
 type Account struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	UserID    uint       `json:"user_id" gorm:"column:user_id;type:integer;not null"`
-	Balance   float64    `json:"balance" gorm:"type:decimal(12,2);not null;default:0"`
-	CreatedAt time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	UpdatedAt time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt *time.Time `json:"deleted_at" gorm:"index"`
+	ID        uint        `json:"id" gorm:"primaryKey"`
+	UserID    uint        `json:"user_id" gorm:"column:user_id;type:integer;not null"`
+	Balance   money.Money `json:"balance" gorm:"type:decimal(12,2);not null;default:0"`
+	Held      money.Money `json:"held" gorm:"type:decimal(12,2);not null;default:0"` // сумма активных холдов
+	CreatedAt time.Time   `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time   `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt *time.Time  `json:"deleted_at" gorm:"index"`
 }
 
-// Transaction содержит запись о движении средств с типами deposit или withdrawal
+// Transaction содержит запись о движении средств с типами deposit, withdrawal, refund или adjustment
 type Transaction struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	AccountID uint       `json:"account_id" gorm:"index:idx_transactions_account_id"`
-	Amount    float64    `json:"amount" gorm:"type:decimal(12,2);not null"`
-	Type      string     `json:"type" gorm:"index:idx_transactions_type;type:varchar(20);not null"`     // deposit, withdrawal
-	Status    string     `json:"status" gorm:"index:idx_transactions_status;type:varchar(20);not null"` // success, failed
-	CreatedAt time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	UpdatedAt time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt *time.Time `json:"deleted_at" gorm:"index"`
+	ID                    uint        `json:"id" gorm:"primaryKey"`
+	AccountID             uint        `json:"account_id" gorm:"index:idx_transactions_account_id;index:idx_transactions_account_id_created_at,priority:1"`
+	Amount                money.Money `json:"amount" gorm:"type:decimal(12,2);not null"`
+	Type                  string      `json:"type" gorm:"index:idx_transactions_type;type:varchar(20);not null"`     // deposit, withdrawal, refund, adjustment
+	Status                string      `json:"status" gorm:"index:idx_transactions_status;type:varchar(20);not null"` // success, failed
+	OriginalTransactionID *uint       `json:"original_transaction_id,omitempty" gorm:"index:idx_transactions_original_transaction_id"`
+	OrderID               *uint       `json:"order_id,omitempty" gorm:"uniqueIndex:idx_transactions_order_id_paid,where:type = 'withdrawal' AND status = 'success'"` // заказ, оплаченный списанием
+	CreatedAt             time.Time   `json:"created_at" gorm:"index:idx_transactions_account_id_created_at,priority:2;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt             time.Time   `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt             *time.Time  `json:"deleted_at" gorm:"index"`
 }
 
 // Типы транзакций
 const (
 	TransactionTypeDeposit    = "deposit"
 	TransactionTypeWithdrawal = "withdrawal"
+	TransactionTypeRefund     = "refund"
+	// TransactionTypeAdjustment корректировка баланса администратором, сумма со знаком
+	TransactionTypeAdjustment = "adjustment"
 )
 
 // Статусы транзакций
@@ -43,37 +51,95 @@ type CreateAccountRequest struct {
 }
 
 type CreateAccountResponse struct {
-	ID      uint    `json:"id"`
-	UserID  uint    `json:"user_id"`
-	Balance float64 `json:"balance"`
+	ID      uint        `json:"id"`
+	UserID  uint        `json:"user_id"`
+	Balance money.Money `json:"balance"`
 }
 
+// GetAccountResponse информация об аккаунте. Available - баланс за вычетом активных холдов
 type GetAccountResponse struct {
-	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id"`
-	Balance   float64   `json:"balance"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uint        `json:"id"`
+	UserID    uint        `json:"user_id"`
+	Balance   money.Money `json:"balance"`
+	Held      money.Money `json:"held"`
+	Available money.Money `json:"available_balance"`
+	CreatedAt time.Time   `json:"created_at"`
 }
 
 type DepositRequest struct {
-	UserID uint    `json:"user_id" binding:"required"`
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Email  string  `json:"email" binding:"omitempty,email"`
+	UserID uint        `json:"user_id" binding:"required"`
+	Amount money.Money `json:"amount"`
+	Email  string      `json:"email" binding:"omitempty,email"`
 }
 
+// WithdrawRequest запрос на списание. Если указан OrderID, списание считается оплатой заказа,
+// и по одному заказу возможна только одна успешная оплата. OrderID принимается только от сервисов
 type WithdrawRequest struct {
-	UserID uint    `json:"user_id" binding:"required"`
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Email  string  `json:"email" binding:"omitempty,email"`
+	UserID  uint        `json:"user_id" binding:"required"`
+	Amount  money.Money `json:"amount"`
+	Email   string      `json:"email" binding:"omitempty,email"`
+	OrderID *uint       `json:"order_id,omitempty"`
+}
+
+// RefundRequest запрос на возврат средств по успешному списанию.
+// Если Amount не указан, возвращается весь невозвращенный остаток списания
+type RefundRequest struct {
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id" binding:"required"`
+	Amount        money.Money `json:"amount"`
+	Reason        string      `json:"reason"`
+	Email         string      `json:"email" binding:"omitempty,email"`
+}
+
+// AdjustBalanceRequest запрос администратора на корректировку баланса. Положительная сумма
+// зачисляется на счет, отрицательная списывается
+type AdjustBalanceRequest struct {
+	Amount money.Money `json:"amount"`
+	Reason string      `json:"reason" binding:"required,max=200"`
+}
+
+// AdjustBalanceResponse результат корректировки и баланс аккаунта после нее
+type AdjustBalanceResponse struct {
+	Transaction TransactionResponse `json:"transaction"`
+	Balance     money.Money         `json:"balance"`
 }
 
 type TransactionResponse struct {
-	ID        uint      `json:"id"`
-	AccountID uint      `json:"account_id"`
-	Amount    float64   `json:"amount"`
-	Type      string    `json:"type"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                    uint        `json:"id"`
+	AccountID             uint        `json:"account_id"`
+	Amount                money.Money `json:"amount"`
+	Type                  string      `json:"type"`
+	Status                string      `json:"status"`
+	OriginalTransactionID *uint       `json:"original_transaction_id,omitempty"`
+	OrderID               *uint       `json:"order_id,omitempty"`
+	CreatedAt             time.Time   `json:"created_at"`
+}
+
+// TransactionFilter фильтр истории транзакций счета. Период задается полуинтервалом [From, To)
+type TransactionFilter struct {
+	Type   string
+	Status string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+type ListTransactionsResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+	Total        int64                 `json:"total"`
+}
+
+// StatementResponse выписка по счету за период: входящий остаток, успешные движения и исходящий остаток
+type StatementResponse struct {
+	AccountID      uint                  `json:"account_id"`
+	From           time.Time             `json:"from"`
+	To             time.Time             `json:"to"`
+	OpeningBalance money.Money           `json:"opening_balance"`
+	TotalCredit    money.Money           `json:"total_credit"`
+	TotalDebit     money.Money           `json:"total_debit"`
+	ClosingBalance money.Money           `json:"closing_balance"`
+	Movements      []TransactionResponse `json:"movements"`
 }
 
 type WithdrawResponse struct {
@@ -85,3 +151,8 @@ type DepositResponse struct {
 	Transaction TransactionResponse `json:"transaction"`
 	Success     bool                `json:"success"`
 }
+
+type RefundResponse struct {
+	Transaction TransactionResponse `json:"transaction"`
+	Success     bool                `json:"success"`
+}
@@ -0,0 +1,67 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// Статусы холдов
+const (
+	HoldStatusActive   = "active"
+	HoldStatusCaptured = "captured"
+	HoldStatusVoided   = "voided"
+	HoldStatusExpired  = "expired"
+)
+
+// Hold резервирование средств на счете. Активный холд уменьшает доступный остаток,
+// но не баланс и не главную книгу; деньги списываются только при Capture
+type Hold struct {
+	ID             uint        `json:"id" gorm:"primaryKey"`
+	AccountID      uint        `json:"account_id" gorm:"index:idx_holds_account_id;not null"`
+	Amount         money.Money `json:"amount" gorm:"type:decimal(12,2);not null"`
+	CapturedAmount money.Money `json:"captured_amount" gorm:"type:decimal(12,2);not null;default:0"`
+	Status         string      `json:"status" gorm:"index:idx_holds_status_expires_at,priority:1;type:varchar(20);not null"`
+	Description    string      `json:"description" gorm:"type:varchar(255)"`
+	TransactionID  *uint       `json:"transaction_id,omitempty"`
+	OrderID        *uint       `json:"order_id,omitempty" gorm:"index:idx_holds_order_id"` // заказ, оплату которого резервирует холд
+	ExpiresAt      time.Time   `json:"expires_at" gorm:"index:idx_holds_status_expires_at,priority:2;not null"`
+	CreatedAt      time.Time   `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt      time.Time   `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// AuthorizeRequest запрос на резервирование средств
+type AuthorizeRequest struct {
+	UserID      uint        `json:"user_id"`
+	Amount      money.Money `json:"amount"`
+	Description string      `json:"description" binding:"max=255"`
+	// OrderID заказ, оплату которого резервирует холд. Списание по холду станет оплатой этого заказа
+	OrderID *uint `json:"order_id,omitempty"`
+}
+
+// CaptureRequest запрос на списание зарезервированных средств.
+// Если Amount не указан, списывается вся сумма холда; остаток холда освобождается
+type CaptureRequest struct {
+	UserID uint        `json:"-"`
+	HoldID uint        `json:"-"`
+	Amount money.Money `json:"amount"`
+}
+
+type HoldResponse struct {
+	ID             uint        `json:"id"`
+	AccountID      uint        `json:"account_id"`
+	Amount         money.Money `json:"amount"`
+	CapturedAmount money.Money `json:"captured_amount"`
+	Status         string      `json:"status"`
+	Description    string      `json:"description,omitempty"`
+	TransactionID  *uint       `json:"transaction_id,omitempty"`
+	OrderID        *uint       `json:"order_id,omitempty"`
+	ExpiresAt      time.Time   `json:"expires_at"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+type CaptureResponse struct {
+	Hold        HoldResponse        `json:"hold"`
+	Transaction TransactionResponse `json:"transaction"`
+	Success     bool                `json:"success"`
+}
@@ -0,0 +1,125 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// Типы счетов главной книги
+const (
+	LedgerAccountTypeUser   = "user"
+	LedgerAccountTypeSystem = "system"
+)
+
+// Стороны проводки. Нормальная сторона счета определяет, какая сторона увеличивает его остаток
+const (
+	PostingDebit  = "debit"
+	PostingCredit = "credit"
+)
+
+// Коды системных счетов главной книги
+const (
+	// LedgerAccountExternalFunding деньги, поступившие извне при пополнениях (актив)
+	LedgerAccountExternalFunding = "system:external_funding"
+	// LedgerAccountRevenue выручка от оплаченных списаний (доход)
+	LedgerAccountRevenue = "system:revenue"
+	// LedgerAccountRefunds возвраты, уменьшающие выручку (контрдоходный счет)
+	LedgerAccountRefunds = "system:refunds"
+	// LedgerAccountAdjustments корректировки балансов администраторами (актив)
+	LedgerAccountAdjustments = "system:adjustments"
+)
+
+// ErrUnbalancedEntry ошибка проводки, в которой дебет не равен кредиту
+var ErrUnbalancedEntry = errors.New("сумма дебета проводки не равна сумме кредита")
+
+// LedgerAccount счет главной книги. Счет пользователя связан с аккаунтом биллинга,
+// системные счета отражают внешние источники средств, выручку и возвраты
+type LedgerAccount struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Code          string    `json:"code" gorm:"type:varchar(64);not null;uniqueIndex:idx_ledger_accounts_code"`
+	Type          string    `json:"type" gorm:"type:varchar(20);not null"`
+	AccountID     *uint     `json:"account_id,omitempty" gorm:"uniqueIndex:idx_ledger_accounts_account_id"`
+	NormalBalance string    `json:"normal_balance" gorm:"type:varchar(10);not null"`
+	Currency      string    `json:"currency" gorm:"type:varchar(3);not null"`
+	CreatedAt     time.Time `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// JournalEntry запись журнала, объединяющая сбалансированные проводки одной операции
+type JournalEntry struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	TransactionID *uint     `json:"transaction_id,omitempty" gorm:"index:idx_journal_entries_transaction_id"`
+	Description   string    `json:"description" gorm:"type:varchar(255);not null"`
+	Postings      []Posting `json:"postings" gorm:"foreignKey:JournalEntryID"`
+	CreatedAt     time.Time `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// Posting проводка по одному счету главной книги. Сумма всегда положительна, знак задает сторона
+type Posting struct {
+	ID              uint        `json:"id" gorm:"primaryKey"`
+	JournalEntryID  uint        `json:"journal_entry_id" gorm:"index:idx_postings_journal_entry_id;not null"`
+	LedgerAccountID uint        `json:"ledger_account_id" gorm:"index:idx_postings_ledger_account_id;not null"`
+	Direction       string      `json:"direction" gorm:"type:varchar(10);not null"`
+	Amount          money.Money `json:"amount" gorm:"type:decimal(12,2);not null"`
+	CreatedAt       time.Time   `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// UserLedgerAccountCode возвращает код счета главной книги для аккаунта биллинга
+func UserLedgerAccountCode(accountID uint) string {
+	return fmt.Sprintf("user:%d", accountID)
+}
+
+// Validate проверяет, что запись содержит не меньше двух проводок с положительными суммами
+// в одной валюте и что сумма дебета равна сумме кредита
+func (e JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: запись должна содержать не меньше двух проводок", ErrUnbalancedEntry)
+	}
+
+	debit := money.Zero(e.Postings[0].Amount.Currency())
+	credit := money.Zero(e.Postings[0].Amount.Currency())
+
+	for _, p := range e.Postings {
+		if !p.Amount.IsPositive() {
+			return fmt.Errorf("%w: сумма проводки должна быть положительной", ErrUnbalancedEntry)
+		}
+
+		var err error
+		switch p.Direction {
+		case PostingDebit:
+			debit, err = debit.Add(p.Amount)
+		case PostingCredit:
+			credit, err = credit.Add(p.Amount)
+		default:
+			return fmt.Errorf("%w: неизвестная сторона проводки %q", ErrUnbalancedEntry, p.Direction)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnbalancedEntry, err)
+		}
+	}
+
+	if !debit.Equal(credit) {
+		return fmt.Errorf("%w: дебет %s, кредит %s", ErrUnbalancedEntry, debit, credit)
+	}
+
+	return nil
+}
+
+// BalanceMismatch расхождение между балансом аккаунта и остатком его счета в главной книге
+type BalanceMismatch struct {
+	AccountID     uint        `json:"account_id"`
+	UserID        uint        `json:"user_id"`
+	Balance       money.Money `json:"balance"`
+	LedgerBalance money.Money `json:"ledger_balance"`
+}
+
+// ReconciliationReport результат сверки балансов с главной книгой
+type ReconciliationReport struct {
+	TotalDebit  money.Money       `json:"total_debit"`
+	TotalCredit money.Money       `json:"total_credit"`
+	Balanced    bool              `json:"balanced"`
+	Mismatches  []BalanceMismatch `json:"mismatches"`
+	CheckedAt   time.Time         `json:"checked_at"`
+}
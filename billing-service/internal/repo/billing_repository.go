@@ -2,13 +2,23 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/money"
+	"github.com/director74/dz7_shop/pkg/outbox"
 )
 
-// BillingRepository представляет репозиторий для работы с биллингом
+// txKey ключ контекста, в котором хранится открытая транзакция базы данных
+type txKey struct{}
+
+// BillingRepository представляет репозиторий для работы с биллингом.
+// Внутри WithTransaction все методы работают в транзакции, переданной через контекст
 type BillingRepository struct {
 	db *gorm.DB
 }
@@ -19,47 +29,148 @@ func NewBillingRepository(db *gorm.DB) *BillingRepository {
 	}
 }
 
+// conn возвращает транзакцию из контекста или общее подключение
+func (r *BillingRepository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
 func (r *BillingRepository) CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error) {
-	err := r.db.WithContext(ctx).Create(&account).Error
+	err := r.conn(ctx).Create(&account).Error
 	return account, err
 }
 
 func (r *BillingRepository) GetAccountByUserID(ctx context.Context, userID uint) (entity.Account, error) {
 	var account entity.Account
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&account).Error
+	err := r.conn(ctx).Where("user_id = ?", userID).First(&account).Error
 	return account, err
 }
 
 // UpdateBalance обновляет баланс аккаунта
-func (r *BillingRepository) UpdateBalance(ctx context.Context, accountID uint, amount float64) error {
-	return r.db.WithContext(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
+func (r *BillingRepository) UpdateBalance(ctx context.Context, accountID uint, amount money.Money) error {
+	return r.conn(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
 		Update("balance", gorm.Expr("balance + ?", amount)).Error
 }
 
+// DebitBalance списывает сумму, только если доступного остатка (баланс за вычетом холдов) достаточно.
+// Проверка и списание выполняются одним условным UPDATE, поэтому параллельные списания
+// не уводят баланс в минус. Возвращает false, если средств недостаточно
+func (r *BillingRepository) DebitBalance(ctx context.Context, accountID uint, amount money.Money) (bool, error) {
+	result := r.conn(ctx).Model(&entity.Account{}).
+		Where("id = ? AND balance - held >= ?", accountID, amount).
+		Update("balance", gorm.Expr("balance - ?", amount))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
 func (r *BillingRepository) CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error) {
-	err := r.db.WithContext(ctx).Create(&transaction).Error
+	err := r.conn(ctx).Create(&transaction).Error
 	return transaction, err
 }
 
 func (r *BillingRepository) GetTransactionByID(ctx context.Context, id uint) (entity.Transaction, error) {
 	var transaction entity.Transaction
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&transaction).Error
+	err := r.conn(ctx).Where("id = ?", id).First(&transaction).Error
 	return transaction, err
 }
 
-func (r *BillingRepository) ListTransactionsByAccountID(ctx context.Context, accountID uint, limit, offset int) ([]entity.Transaction, int64, error) {
+// LockTransactionByID возвращает транзакцию, блокируя ее строку до конца текущей транзакции БД
+func (r *BillingRepository) LockTransactionByID(ctx context.Context, id uint) (entity.Transaction, error) {
+	var transaction entity.Transaction
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&transaction).Error
+	return transaction, err
+}
+
+// ListTransactionsByAccountID возвращает страницу транзакций счета по фильтру, новые первыми
+func (r *BillingRepository) ListTransactionsByAccountID(ctx context.Context, accountID uint, filter entity.TransactionFilter) ([]entity.Transaction, int64, error) {
 	var transactions []entity.Transaction
 	var total int64
 
-	r.db.WithContext(ctx).Model(&entity.Transaction{}).Where("account_id = ?", accountID).Count(&total)
-	err := r.db.WithContext(ctx).Where("account_id = ?", accountID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&transactions).Error
+	query := r.conn(ctx).Model(&entity.Transaction{}).Where("account_id = ?", accountID)
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Limit(filter.Limit).Offset(filter.Offset).Order("created_at DESC, id DESC").Find(&transactions).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return transactions, total, nil
+}
+
+// ListSuccessfulTransactions возвращает успешные транзакции счета за период [from, to) в хронологическом порядке
+func (r *BillingRepository) ListSuccessfulTransactions(ctx context.Context, accountID uint, from, to time.Time) ([]entity.Transaction, error) {
+	var transactions []entity.Transaction
+	err := r.conn(ctx).
+		Where("account_id = ? AND status = ? AND created_at >= ? AND created_at < ?",
+			accountID, entity.TransactionStatusSuccess, from, to).
+		Order("created_at ASC, id ASC").
+		Find(&transactions).Error
+	return transactions, err
+}
+
+// SumRefundsByOriginalTransactionID возвращает сумму успешных возвратов по исходной транзакции
+func (r *BillingRepository) SumRefundsByOriginalTransactionID(ctx context.Context, originalID uint) (money.Money, error) {
+	var total money.Money
+	err := r.conn(ctx).Model(&entity.Transaction{}).
+		Where("original_transaction_id = ? AND type = ? AND status = ?", originalID, entity.TransactionTypeRefund, entity.TransactionStatusSuccess).
+		Select("COALESCE(SUM(amount), 0)").
+		Row().Scan(&total)
+	return total, err
+}
+
+// HasSuccessfulOrderPayment сообщает, есть ли успешное списание по заказу orderID
+func (r *BillingRepository) HasSuccessfulOrderPayment(ctx context.Context, orderID uint) (bool, error) {
+	var count int64
+	err := r.conn(ctx).Model(&entity.Transaction{}).
+		Where("order_id = ? AND type = ? AND status = ?", orderID, entity.TransactionTypeWithdrawal, entity.TransactionStatusSuccess).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetOrderPayment возвращает успешное списание по заказу orderID
+func (r *BillingRepository) GetOrderPayment(ctx context.Context, orderID uint) (entity.Transaction, error) {
+	var transaction entity.Transaction
+	err := r.conn(ctx).
+		Where("order_id = ? AND type = ? AND status = ?", orderID, entity.TransactionTypeWithdrawal, entity.TransactionStatusSuccess).
+		First(&transaction).Error
+	return transaction, err
+}
+
+// MarkEventProcessed отмечает событие обработанным потребителем consumer.
+// Возвращает false, если событие уже было обработано
+func (r *BillingRepository) MarkEventProcessed(ctx context.Context, consumer, eventID string) (bool, error) {
+	return messaging.MarkProcessed(r.conn(ctx), consumer, eventID)
+}
 
-	return transactions, total, err
+// AddOutboxMessage сохраняет конверт события в outbox. Внутри WithTransaction событие будет
+// опубликовано, только если транзакция зафиксирована
+func (r *BillingRepository) AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	return outbox.Add(r.conn(ctx), exchange, routingKey, envelope)
 }
 
-// WithTransaction выполняет функцию в транзакции базы данных
-func (r *BillingRepository) WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return fn(tx)
+// WithTransaction выполняет функцию в транзакции базы данных. Контекст, переданный в fn,
+// содержит транзакцию, и вызовы репозитория с ним выполняются внутри нее
+func (r *BillingRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
 	})
 }
@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// newTestRepository подключается к PostgreSQL из TEST_POSTGRES_DSN и создает таблицы аккаунтов
+// и транзакций в отдельной схеме, которая удаляется после теста. Без переменной тест пропускается
+func newTestRepository(t *testing.T) *BillingRepository {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN не задан, тест с PostgreSQL пропущен")
+	}
+
+	schemaName := fmt.Sprintf("billing_test_%d", time.Now().UnixNano())
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		NamingStrategy: schema.NamingStrategy{TablePrefix: schemaName + "."},
+	})
+	if err != nil {
+		t.Fatalf("ошибка подключения к PostgreSQL: %v", err)
+	}
+	if err := db.Exec("CREATE SCHEMA " + schemaName).Error; err != nil {
+		t.Fatalf("ошибка создания схемы: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schemaName + " CASCADE")
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&entity.Account{}, &entity.Transaction{}); err != nil {
+		t.Fatalf("ошибка миграции: %v", err)
+	}
+	return NewBillingRepository(db)
+}
+
+func createTestAccount(t *testing.T, r *BillingRepository, balance string) entity.Account {
+	t.Helper()
+
+	account, err := r.CreateAccount(context.Background(), entity.Account{
+		UserID:    1,
+		Balance:   money.MustParse(balance, ""),
+		Held:      money.Zero(money.DefaultCurrency),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	return account
+}
+
+func TestConcurrentDebitBalance(t *testing.T) {
+	r := newTestRepository(t)
+	account := createTestAccount(t, r, "100.00")
+
+	// Каждое списание выполняется в своей транзакции, как в usecase
+	const attempts = 50
+	var mu sync.Mutex
+	var debited int
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := r.WithTransaction(context.Background(), func(ctx context.Context) error {
+				ok, err := r.DebitBalance(ctx, account.ID, money.MustParse("10.00", ""))
+				if ok {
+					mu.Lock()
+					debited++
+					mu.Unlock()
+				}
+				return err
+			})
+			if err != nil {
+				t.Errorf("DebitBalance: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if debited != 10 {
+		t.Errorf("успешных списаний %d, ожидалось 10", debited)
+	}
+
+	stored, err := r.GetAccountByUserID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetAccountByUserID: %v", err)
+	}
+	if !stored.Balance.IsZero() {
+		t.Errorf("баланс %s, ожидался 0.00", stored.Balance)
+	}
+}
+
+func TestConcurrentDebitAndReserveKeepAvailableBalance(t *testing.T) {
+	r := newTestRepository(t)
+	account := createTestAccount(t, r, "100.00")
+
+	const attempts = 40
+	var mu sync.Mutex
+	var succeeded int
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			amount := money.MustParse("10.00", "")
+
+			var ok bool
+			var err error
+			if i%2 == 0 {
+				ok, err = r.DebitBalance(context.Background(), account.ID, amount)
+			} else {
+				ok, err = r.ReserveFunds(context.Background(), account.ID, amount)
+			}
+			if err != nil {
+				t.Errorf("операция %d: %v", i, err)
+			}
+			if ok {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if succeeded != 10 {
+		t.Errorf("успешных операций %d, ожидалось 10", succeeded)
+	}
+
+	stored, err := r.GetAccountByUserID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("GetAccountByUserID: %v", err)
+	}
+	available, err := stored.Balance.Sub(stored.Held)
+	if err != nil || !available.IsZero() {
+		t.Errorf("доступный остаток %s, ожидался 0.00 (ошибка: %v)", available, err)
+	}
+}
+
+func TestOrderCanBePaidOnce(t *testing.T) {
+	r := newTestRepository(t)
+	account := createTestAccount(t, r, "100.00")
+
+	orderID := uint(7)
+	const attempts = 10
+	var mu sync.Mutex
+	var paid int
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.CreateTransaction(context.Background(), entity.Transaction{
+				AccountID: account.ID,
+				Amount:    money.MustParse("-10.00", ""),
+				Type:      entity.TransactionTypeWithdrawal,
+				Status:    entity.TransactionStatusSuccess,
+				OrderID:   &orderID,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			})
+			if err == nil {
+				mu.Lock()
+				paid++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if paid != 1 {
+		t.Errorf("сохранено %d успешных списаний по заказу, ожидалось 1", paid)
+	}
+
+	ok, err := r.HasSuccessfulOrderPayment(context.Background(), orderID)
+	if err != nil || !ok {
+		t.Errorf("HasSuccessfulOrderPayment = %v, %v, ожидалось true", ok, err)
+	}
+}
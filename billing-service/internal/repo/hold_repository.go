@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// ReserveFunds увеличивает зарезервированную сумму, только если доступного остатка достаточно.
+// Возвращает false, если средств недостаточно
+func (r *BillingRepository) ReserveFunds(ctx context.Context, accountID uint, amount money.Money) (bool, error) {
+	result := r.conn(ctx).Model(&entity.Account{}).
+		Where("id = ? AND balance - held >= ?", accountID, amount).
+		Update("held", gorm.Expr("held + ?", amount))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseFunds уменьшает зарезервированную сумму аккаунта
+func (r *BillingRepository) ReleaseFunds(ctx context.Context, accountID uint, amount money.Money) error {
+	return r.conn(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
+		Update("held", gorm.Expr("held - ?", amount)).Error
+}
+
+func (r *BillingRepository) CreateHold(ctx context.Context, hold entity.Hold) (entity.Hold, error) {
+	err := r.conn(ctx).Create(&hold).Error
+	return hold, err
+}
+
+func (r *BillingRepository) GetHoldByID(ctx context.Context, id uint) (entity.Hold, error) {
+	var hold entity.Hold
+	err := r.conn(ctx).Where("id = ?", id).First(&hold).Error
+	return hold, err
+}
+
+// LockHoldByID возвращает холд, блокируя его строку до конца текущей транзакции БД
+func (r *BillingRepository) LockHoldByID(ctx context.Context, id uint) (entity.Hold, error) {
+	var hold entity.Hold
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&hold).Error
+	return hold, err
+}
+
+func (r *BillingRepository) UpdateHold(ctx context.Context, hold entity.Hold) error {
+	return r.conn(ctx).Save(&hold).Error
+}
+
+// ListExpiredHoldIDs возвращает ID активных холдов, срок действия которых истек к моменту now
+func (r *BillingRepository) ListExpiredHoldIDs(ctx context.Context, now time.Time, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.conn(ctx).Model(&entity.Hold{}).
+		Where("status = ? AND expires_at <= ?", entity.HoldStatusActive, now).
+		Order("expires_at ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// ledgerBalanceExpr остаток счета главной книги: проводки по нормальной стороне увеличивают его,
+// проводки по противоположной стороне уменьшают
+const ledgerBalanceExpr = "COALESCE(SUM(CASE WHEN postings.direction = ledger_accounts.normal_balance " +
+	"THEN postings.amount ELSE -postings.amount END), 0)"
+
+func (r *BillingRepository) CreateLedgerAccount(ctx context.Context, account entity.LedgerAccount) (entity.LedgerAccount, error) {
+	err := r.conn(ctx).Create(&account).Error
+	return account, err
+}
+
+func (r *BillingRepository) GetLedgerAccountByCode(ctx context.Context, code string) (entity.LedgerAccount, error) {
+	var account entity.LedgerAccount
+	err := r.conn(ctx).Where("code = ?", code).First(&account).Error
+	return account, err
+}
+
+// CreateJournalEntry сохраняет запись журнала вместе с ее проводками
+func (r *BillingRepository) CreateJournalEntry(ctx context.Context, entry entity.JournalEntry) (entity.JournalEntry, error) {
+	err := r.conn(ctx).Create(&entry).Error
+	return entry, err
+}
+
+// LedgerBalanceBefore возвращает остаток счета главной книги по проводкам, созданным до момента before
+func (r *BillingRepository) LedgerBalanceBefore(ctx context.Context, ledgerAccountID uint, before time.Time) (money.Money, error) {
+	var balance money.Money
+	err := r.conn(ctx).Table("postings").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.ledger_account_id").
+		Where("postings.ledger_account_id = ? AND postings.created_at < ?", ledgerAccountID, before).
+		Select(ledgerBalanceExpr).
+		Row().Scan(&balance)
+	return balance, err
+}
+
+// SumPostings возвращает суммы всех дебетовых и кредитовых проводок главной книги
+func (r *BillingRepository) SumPostings(ctx context.Context) (money.Money, money.Money, error) {
+	var debit, credit money.Money
+	err := r.conn(ctx).Table("postings").
+		Select("COALESCE(SUM(CASE WHEN direction = ? THEN amount ELSE 0 END), 0), "+
+			"COALESCE(SUM(CASE WHEN direction = ? THEN amount ELSE 0 END), 0)",
+			entity.PostingDebit, entity.PostingCredit).
+		Row().Scan(&debit, &credit)
+	return debit, credit, err
+}
+
+// ListBalanceMismatches возвращает аккаунты, баланс которых не совпадает с остатком
+// их счета в главной книге
+func (r *BillingRepository) ListBalanceMismatches(ctx context.Context) ([]entity.BalanceMismatch, error) {
+	rows, err := r.conn(ctx).Table("accounts").
+		Select("accounts.id, accounts.user_id, accounts.balance, " + ledgerBalanceExpr).
+		Joins("LEFT JOIN ledger_accounts ON ledger_accounts.account_id = accounts.id").
+		Joins("LEFT JOIN postings ON postings.ledger_account_id = ledger_accounts.id").
+		Where("accounts.deleted_at IS NULL").
+		Group("accounts.id, accounts.user_id, accounts.balance").
+		Having("accounts.balance <> " + ledgerBalanceExpr).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mismatches []entity.BalanceMismatch
+	for rows.Next() {
+		var m entity.BalanceMismatch
+		if err := rows.Scan(&m.AccountID, &m.UserID, &m.Balance, &m.LedgerBalance); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+
+	return mismatches, rows.Err()
+}
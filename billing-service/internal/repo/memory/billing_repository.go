@@ -0,0 +1,640 @@
+// Package memory хранилище биллинга в памяти процесса для тестов usecase и саги оплаты заказа
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// ErrUniqueViolation ошибка нарушения уникального индекса, как ее вернула бы база данных
+var ErrUniqueViolation = errors.New("нарушение уникального индекса")
+
+// outboxMessage событие, сохраненное в outbox
+type outboxMessage struct {
+	Exchange   string
+	RoutingKey string
+	Envelope   events.Envelope
+}
+
+// memoryTx открытая транзакция: откат изменений, захваченные блокировки строк и события outbox
+type memoryTx struct {
+	parent *memoryTx
+	undo   []func()
+	locks  map[string]*sync.Mutex
+	outbox []outboxMessage
+}
+
+func (tx *memoryTx) root() *memoryTx {
+	for tx.parent != nil {
+		tx = tx.parent
+	}
+	return tx
+}
+
+type memoryTxKey struct{}
+
+// Repository хранилище биллинга в памяти. Как и в базе данных, каждая
+// операция атомарна сама по себе, а транзакции не сериализуются: изменения применяются сразу и
+// откатываются при ошибке, блокировки строк держатся до конца транзакции, а уникальный индекс
+// успешных списаний по заказу проверяется при вставке
+type Repository struct {
+	mu sync.Mutex
+
+	nextID         uint
+	accounts       map[uint]*entity.Account
+	transactions   map[uint]*entity.Transaction
+	ledgerAccounts map[uint]*entity.LedgerAccount
+	postings       []entity.Posting
+	holds          map[uint]*entity.Hold
+	processed      map[string]bool
+	outbox         []outboxMessage
+	onCommit       func(exchange, routingKey string, envelope events.Envelope)
+
+	rowLocks map[string]*sync.Mutex
+}
+
+func NewRepository() *Repository {
+	r := &Repository{
+		accounts:       make(map[uint]*entity.Account),
+		transactions:   make(map[uint]*entity.Transaction),
+		ledgerAccounts: make(map[uint]*entity.LedgerAccount),
+		holds:          make(map[uint]*entity.Hold),
+		processed:      make(map[string]bool),
+		rowLocks:       make(map[string]*sync.Mutex),
+	}
+
+	// Системные счета главной книги создаются миграцией
+	for _, system := range []struct{ code, normal string }{
+		{entity.LedgerAccountExternalFunding, entity.PostingDebit},
+		{entity.LedgerAccountRevenue, entity.PostingCredit},
+		{entity.LedgerAccountRefunds, entity.PostingDebit},
+		{entity.LedgerAccountAdjustments, entity.PostingDebit},
+	} {
+		r.nextID++
+		r.ledgerAccounts[r.nextID] = &entity.LedgerAccount{
+			ID:            r.nextID,
+			Code:          system.code,
+			Type:          entity.LedgerAccountTypeSystem,
+			NormalBalance: system.normal,
+			Currency:      money.DefaultCurrency,
+		}
+	}
+	return r
+}
+
+// lock захватывает хранилище на время одного запроса. Перед запросом горутина уступает
+// планировщику, чтобы запросы параллельных транзакций чередовались, как в базе данных
+func (r *Repository) lock() {
+	runtime.Gosched()
+	r.mu.Lock()
+}
+
+// apply выполняет изменение fn атомарно и регистрирует его откат в транзакции из контекста
+func (r *Repository) apply(ctx context.Context, fn func() (undo func(), err error)) error {
+	r.lock()
+	undo, err := fn()
+	r.mu.Unlock()
+	if err != nil || undo == nil {
+		return err
+	}
+
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		tx.undo = append(tx.undo, func() {
+			r.lock()
+			defer r.mu.Unlock()
+			undo()
+		})
+	}
+	return nil
+}
+
+// lockRow блокирует строку до конца транзакции из контекста, как SELECT ... FOR UPDATE
+func (r *Repository) lockRow(ctx context.Context, key string) {
+	tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx)
+	if !ok {
+		return
+	}
+	root := tx.root()
+	if _, held := root.locks[key]; held {
+		return
+	}
+
+	r.lock()
+	lock, ok := r.rowLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.rowLocks[key] = lock
+	}
+	r.mu.Unlock()
+
+	lock.Lock()
+	root.locks[key] = lock
+}
+
+// lockAccount блокирует строку аккаунта, которую UPDATE баланса держит до конца транзакции
+func (r *Repository) lockAccount(ctx context.Context, accountID uint) {
+	r.lockRow(ctx, fmt.Sprintf("accounts:%d", accountID))
+}
+
+func (r *Repository) newID() uint {
+	r.nextID++
+	return r.nextID
+}
+
+func (r *Repository) CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error) {
+	err := r.apply(ctx, func() (func(), error) {
+		account.ID = r.newID()
+		stored := account
+		r.accounts[account.ID] = &stored
+		return func() { delete(r.accounts, account.ID) }, nil
+	})
+	return account, err
+}
+
+func (r *Repository) GetAccountByUserID(_ context.Context, userID uint) (entity.Account, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	for _, account := range r.accounts {
+		if account.UserID == userID {
+			return *account, nil
+		}
+	}
+	return entity.Account{}, gorm.ErrRecordNotFound
+}
+
+func (r *Repository) UpdateBalance(ctx context.Context, accountID uint, amount money.Money) error {
+	r.lockAccount(ctx, accountID)
+	return r.apply(ctx, func() (func(), error) {
+		account, ok := r.accounts[accountID]
+		if !ok {
+			return nil, nil
+		}
+		previous := account.Balance
+		account.Balance = money.New(previous.Units()+amount.Units(), previous.Currency())
+		return func() {
+			account.Balance = money.New(account.Balance.Units()-amount.Units(), previous.Currency())
+		}, nil
+	})
+}
+
+func (r *Repository) DebitBalance(ctx context.Context, accountID uint, amount money.Money) (bool, error) {
+	r.lockAccount(ctx, accountID)
+	var debited bool
+	err := r.apply(ctx, func() (func(), error) {
+		account, ok := r.accounts[accountID]
+		if !ok || account.Balance.Units()-account.Held.Units() < amount.Units() {
+			return nil, nil
+		}
+		debited = true
+		currency := account.Balance.Currency()
+		account.Balance = money.New(account.Balance.Units()-amount.Units(), currency)
+		return func() {
+			account.Balance = money.New(account.Balance.Units()+amount.Units(), currency)
+		}, nil
+	})
+	return debited, err
+}
+
+func (r *Repository) CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error) {
+	err := r.apply(ctx, func() (func(), error) {
+		if transaction.OrderID != nil && isOrderPayment(transaction) {
+			for _, existing := range r.transactions {
+				if existing.OrderID != nil && *existing.OrderID == *transaction.OrderID && isOrderPayment(*existing) {
+					return nil, fmt.Errorf("%w: idx_transactions_order_id_paid", ErrUniqueViolation)
+				}
+			}
+		}
+		transaction.ID = r.newID()
+		stored := transaction
+		r.transactions[transaction.ID] = &stored
+		return func() { delete(r.transactions, transaction.ID) }, nil
+	})
+	return transaction, err
+}
+
+func isOrderPayment(t entity.Transaction) bool {
+	return t.Type == entity.TransactionTypeWithdrawal && t.Status == entity.TransactionStatusSuccess
+}
+
+func (r *Repository) GetTransactionByID(_ context.Context, id uint) (entity.Transaction, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	if transaction, ok := r.transactions[id]; ok {
+		return *transaction, nil
+	}
+	return entity.Transaction{}, gorm.ErrRecordNotFound
+}
+
+func (r *Repository) LockTransactionByID(ctx context.Context, id uint) (entity.Transaction, error) {
+	r.lockRow(ctx, fmt.Sprintf("transactions:%d", id))
+	return r.GetTransactionByID(ctx, id)
+}
+
+func (r *Repository) ListTransactionsByAccountID(_ context.Context, accountID uint, filter entity.TransactionFilter) ([]entity.Transaction, int64, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var result []entity.Transaction
+	for _, t := range r.transactions {
+		if t.AccountID != accountID ||
+			(filter.Type != "" && t.Type != filter.Type) ||
+			(filter.Status != "" && t.Status != filter.Status) ||
+			(filter.From != nil && t.CreatedAt.Before(*filter.From)) ||
+			(filter.To != nil && !t.CreatedAt.Before(*filter.To)) {
+			continue
+		}
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID > result[j].ID })
+
+	total := int64(len(result))
+	if filter.Offset >= len(result) {
+		return nil, total, nil
+	}
+	result = result[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(result) {
+		result = result[:filter.Limit]
+	}
+	return result, total, nil
+}
+
+func (r *Repository) ListSuccessfulTransactions(_ context.Context, accountID uint, from, to time.Time) ([]entity.Transaction, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var result []entity.Transaction
+	for _, t := range r.transactions {
+		if t.AccountID == accountID && t.Status == entity.TransactionStatusSuccess &&
+			!t.CreatedAt.Before(from) && t.CreatedAt.Before(to) {
+			result = append(result, *t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (r *Repository) SumRefundsByOriginalTransactionID(_ context.Context, originalID uint) (money.Money, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var units int64
+	for _, t := range r.transactions {
+		if t.OriginalTransactionID != nil && *t.OriginalTransactionID == originalID &&
+			t.Type == entity.TransactionTypeRefund && t.Status == entity.TransactionStatusSuccess {
+			units += t.Amount.Units()
+		}
+	}
+	return money.New(units, money.DefaultCurrency), nil
+}
+
+func (r *Repository) HasSuccessfulOrderPayment(_ context.Context, orderID uint) (bool, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.transactions {
+		if t.OrderID != nil && *t.OrderID == orderID && isOrderPayment(*t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *Repository) GetOrderPayment(_ context.Context, orderID uint) (entity.Transaction, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.transactions {
+		if t.OrderID != nil && *t.OrderID == orderID && isOrderPayment(*t) {
+			return *t, nil
+		}
+	}
+	return entity.Transaction{}, gorm.ErrRecordNotFound
+}
+
+func (r *Repository) CreateLedgerAccount(ctx context.Context, account entity.LedgerAccount) (entity.LedgerAccount, error) {
+	err := r.apply(ctx, func() (func(), error) {
+		for _, existing := range r.ledgerAccounts {
+			if existing.Code == account.Code {
+				return nil, fmt.Errorf("%w: idx_ledger_accounts_code", ErrUniqueViolation)
+			}
+		}
+		account.ID = r.newID()
+		stored := account
+		r.ledgerAccounts[account.ID] = &stored
+		return func() { delete(r.ledgerAccounts, account.ID) }, nil
+	})
+	return account, err
+}
+
+func (r *Repository) GetLedgerAccountByCode(_ context.Context, code string) (entity.LedgerAccount, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	for _, account := range r.ledgerAccounts {
+		if account.Code == code {
+			return *account, nil
+		}
+	}
+	return entity.LedgerAccount{}, gorm.ErrRecordNotFound
+}
+
+func (r *Repository) CreateJournalEntry(ctx context.Context, entry entity.JournalEntry) (entity.JournalEntry, error) {
+	err := r.apply(ctx, func() (func(), error) {
+		entry.ID = r.newID()
+		start := len(r.postings)
+		for i := range entry.Postings {
+			entry.Postings[i].ID = r.newID()
+			entry.Postings[i].JournalEntryID = entry.ID
+			r.postings = append(r.postings, entry.Postings[i])
+		}
+		ids := make(map[uint]bool)
+		for _, p := range r.postings[start:] {
+			ids[p.ID] = true
+		}
+		return func() {
+			kept := r.postings[:0]
+			for _, p := range r.postings {
+				if !ids[p.ID] {
+					kept = append(kept, p)
+				}
+			}
+			r.postings = kept
+		}, nil
+	})
+	return entry, err
+}
+
+// ledgerBalance возвращает остаток счета главной книги по проводкам, удовлетворяющим include
+func (r *Repository) ledgerBalance(account *entity.LedgerAccount, include func(entity.Posting) bool) int64 {
+	var units int64
+	for _, p := range r.postings {
+		if p.LedgerAccountID != account.ID || !include(p) {
+			continue
+		}
+		if p.Direction == account.NormalBalance {
+			units += p.Amount.Units()
+		} else {
+			units -= p.Amount.Units()
+		}
+	}
+	return units
+}
+
+func (r *Repository) LedgerBalanceBefore(_ context.Context, ledgerAccountID uint, before time.Time) (money.Money, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	account, ok := r.ledgerAccounts[ledgerAccountID]
+	if !ok {
+		return money.Zero(money.DefaultCurrency), nil
+	}
+	units := r.ledgerBalance(account, func(p entity.Posting) bool { return p.CreatedAt.Before(before) })
+	return money.New(units, account.Currency), nil
+}
+
+func (r *Repository) SumPostings(_ context.Context) (money.Money, money.Money, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var debit, credit int64
+	for _, p := range r.postings {
+		if p.Direction == entity.PostingDebit {
+			debit += p.Amount.Units()
+		} else {
+			credit += p.Amount.Units()
+		}
+	}
+	return money.New(debit, money.DefaultCurrency), money.New(credit, money.DefaultCurrency), nil
+}
+
+func (r *Repository) ListBalanceMismatches(_ context.Context) ([]entity.BalanceMismatch, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var mismatches []entity.BalanceMismatch
+	for _, account := range r.accounts {
+		var ledger int64
+		for _, la := range r.ledgerAccounts {
+			if la.AccountID != nil && *la.AccountID == account.ID {
+				ledger = r.ledgerBalance(la, func(entity.Posting) bool { return true })
+			}
+		}
+		if ledger != account.Balance.Units() {
+			mismatches = append(mismatches, entity.BalanceMismatch{
+				AccountID:     account.ID,
+				UserID:        account.UserID,
+				Balance:       account.Balance,
+				LedgerBalance: money.New(ledger, account.Balance.Currency()),
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+func (r *Repository) ReserveFunds(ctx context.Context, accountID uint, amount money.Money) (bool, error) {
+	r.lockAccount(ctx, accountID)
+	var reserved bool
+	err := r.apply(ctx, func() (func(), error) {
+		account, ok := r.accounts[accountID]
+		if !ok || account.Balance.Units()-account.Held.Units() < amount.Units() {
+			return nil, nil
+		}
+		reserved = true
+		currency := account.Balance.Currency()
+		account.Held = money.New(account.Held.Units()+amount.Units(), currency)
+		return func() {
+			account.Held = money.New(account.Held.Units()-amount.Units(), currency)
+		}, nil
+	})
+	return reserved, err
+}
+
+func (r *Repository) ReleaseFunds(ctx context.Context, accountID uint, amount money.Money) error {
+	r.lockAccount(ctx, accountID)
+	return r.apply(ctx, func() (func(), error) {
+		account, ok := r.accounts[accountID]
+		if !ok {
+			return nil, nil
+		}
+		currency := account.Balance.Currency()
+		account.Held = money.New(account.Held.Units()-amount.Units(), currency)
+		return func() {
+			account.Held = money.New(account.Held.Units()+amount.Units(), currency)
+		}, nil
+	})
+}
+
+func (r *Repository) CreateHold(ctx context.Context, hold entity.Hold) (entity.Hold, error) {
+	err := r.apply(ctx, func() (func(), error) {
+		hold.ID = r.newID()
+		stored := hold
+		r.holds[hold.ID] = &stored
+		return func() { delete(r.holds, hold.ID) }, nil
+	})
+	return hold, err
+}
+
+func (r *Repository) GetHoldByID(_ context.Context, id uint) (entity.Hold, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	if hold, ok := r.holds[id]; ok {
+		return *hold, nil
+	}
+	return entity.Hold{}, gorm.ErrRecordNotFound
+}
+
+func (r *Repository) LockHoldByID(ctx context.Context, id uint) (entity.Hold, error) {
+	r.lockRow(ctx, fmt.Sprintf("holds:%d", id))
+	return r.GetHoldByID(ctx, id)
+}
+
+func (r *Repository) UpdateHold(ctx context.Context, hold entity.Hold) error {
+	return r.apply(ctx, func() (func(), error) {
+		stored, ok := r.holds[hold.ID]
+		if !ok {
+			return nil, nil
+		}
+		previous := *stored
+		*stored = hold
+		return func() { *stored = previous }, nil
+	})
+}
+
+func (r *Repository) ListExpiredHoldIDs(_ context.Context, now time.Time, limit int) ([]uint, error) {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var ids []uint
+	for _, hold := range r.holds {
+		if hold.Status == entity.HoldStatusActive && !hold.ExpiresAt.After(now) {
+			ids = append(ids, hold.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (r *Repository) AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	message := outboxMessage{Exchange: exchange, RoutingKey: routingKey, Envelope: envelope}
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		tx.outbox = append(tx.outbox, message)
+		return nil
+	}
+	r.commitOutbox([]outboxMessage{message})
+	return nil
+}
+
+func (r *Repository) commitOutbox(messages []outboxMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	r.lock()
+	r.outbox = append(r.outbox, messages...)
+	onCommit := r.onCommit
+	r.mu.Unlock()
+
+	if onCommit != nil {
+		for _, m := range messages {
+			onCommit(m.Exchange, m.RoutingKey, m.Envelope)
+		}
+	}
+}
+
+// OnCommit задает функцию, которая получает события outbox после фиксации транзакции,
+// как relay получает их из базы данных
+func (r *Repository) OnCommit(fn func(exchange, routingKey string, envelope events.Envelope)) {
+	r.lock()
+	defer r.mu.Unlock()
+	r.onCommit = fn
+}
+
+func (r *Repository) MarkEventProcessed(ctx context.Context, consumer, eventID string) (bool, error) {
+	var created bool
+	key := consumer + "/" + eventID
+	// Первичный ключ inbox блокирует параллельную вставку того же события до конца транзакции
+	r.lockRow(ctx, "processed_events:"+key)
+	err := r.apply(ctx, func() (func(), error) {
+		if r.processed[key] {
+			return nil, nil
+		}
+		created = true
+		r.processed[key] = true
+		return func() { delete(r.processed, key) }, nil
+	})
+	return created, err
+}
+
+// WithTransaction выполняет fn в транзакции. Вложенный вызов работает как точка сохранения
+func (r *Repository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	parent, nested := ctx.Value(memoryTxKey{}).(*memoryTx)
+	tx := &memoryTx{parent: parent}
+	if !nested {
+		tx.locks = make(map[string]*sync.Mutex)
+	}
+
+	err := fn(context.WithValue(ctx, memoryTxKey{}, tx))
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+
+	if nested {
+		if err == nil {
+			parent.undo = append(parent.undo, tx.undo...)
+			parent.outbox = append(parent.outbox, tx.outbox...)
+		}
+		return err
+	}
+
+	for _, lock := range tx.locks {
+		lock.Unlock()
+	}
+	if err == nil {
+		r.commitOutbox(tx.outbox)
+	}
+	return err
+}
+
+// OutboxEvents возвращает типы событий, сохраненных в outbox
+func (r *Repository) OutboxEvents() []string {
+	r.lock()
+	defer r.mu.Unlock()
+
+	types := make([]string, 0, len(r.outbox))
+	for _, message := range r.outbox {
+		types = append(types, message.Envelope.Type)
+	}
+	return types
+}
+
+// OutboxEnvelopes возвращает конверты событий типа eventType, сохраненные в outbox
+func (r *Repository) OutboxEnvelopes(eventType string) []events.Envelope {
+	r.lock()
+	defer r.mu.Unlock()
+
+	var envelopes []events.Envelope
+	for _, message := range r.outbox {
+		if message.Envelope.Type == eventType {
+			envelopes = append(envelopes, message.Envelope)
+		}
+	}
+	return envelopes
+}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+)
+
+// ErrAccountNotFound ошибка, когда у пользователя нет аккаунта
+var ErrAccountNotFound = errors.New("аккаунт не найден")
+
+// AdjustBalance корректирует баланс аккаунта пользователя userID по запросу администратора adminID.
+// Отрицательная корректировка не может превышать доступный остаток. Причина и администратор
+// сохраняются в описании записи журнала
+func (uc *BillingUseCase) AdjustBalance(ctx context.Context, userID, adminID uint, req entity.AdjustBalanceRequest) (entity.AdjustBalanceResponse, error) {
+	amount := req.Amount
+	if amount.IsNegative() {
+		amount = amount.Neg()
+	}
+	if err := validateAmount(amount); err != nil {
+		return entity.AdjustBalanceResponse{}, err
+	}
+
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.AdjustBalanceResponse{}, fmt.Errorf("%w: %v", ErrAccountNotFound, err)
+	}
+
+	description := fmt.Sprintf("Корректировка баланса администратором %d: %s", adminID, req.Reason)
+	userCode := entity.UserLedgerAccountCode(account.ID)
+
+	var newTransaction entity.Transaction
+	var updated entity.Account
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Зачисление проводится со счета корректировок на счет пользователя, списание - обратно
+		debitCode, creditCode := entity.LedgerAccountAdjustments, userCode
+		if req.Amount.IsNegative() {
+			debited, err := uc.repo.DebitBalance(ctx, account.ID, amount)
+			if err != nil {
+				return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+			}
+			if !debited {
+				return ErrInsufficientFunds
+			}
+			debitCode, creditCode = userCode, entity.LedgerAccountAdjustments
+		} else if err := uc.repo.UpdateBalance(ctx, account.ID, amount); err != nil {
+			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+		}
+
+		var err error
+		newTransaction, err = uc.repo.CreateTransaction(ctx, entity.Transaction{
+			AccountID: account.ID,
+			Amount:    req.Amount,
+			Type:      entity.TransactionTypeAdjustment,
+			Status:    entity.TransactionStatusSuccess,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
+		}
+
+		if err := uc.postEntry(ctx, newTransaction, description, debitCode, creditCode, amount); err != nil {
+			return err
+		}
+
+		updated, err = uc.repo.GetAccountByUserID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return entity.AdjustBalanceResponse{}, err
+	}
+
+	log.Printf("Баланс пользователя %d скорректирован на %s администратором %d: %s",
+		userID, req.Amount, adminID, req.Reason)
+
+	return entity.AdjustBalanceResponse{
+		Transaction: toTransactionResponse(newTransaction),
+		Balance:     updated.Balance,
+	}, nil
+}
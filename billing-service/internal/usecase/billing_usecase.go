@@ -2,47 +2,92 @@ package usecase
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	"gorm.io/gorm"
-
 	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// ErrInvalidAmount ошибка, когда сумма операции не положительна или указана не в валюте счета
+var ErrInvalidAmount = errors.New("сумма операции должна быть больше нуля и указана в валюте счета")
+
+// Ошибки возврата средств
+var (
+	ErrTransactionNotFound  = errors.New("транзакция не найдена")
+	ErrRefundNotAllowed     = errors.New("возврат возможен только по успешному списанию")
+	ErrRefundAmountExceeded = errors.New("сумма возврата превышает невозвращенный остаток списания")
+)
+
+// ErrOrderAlreadyPaid ошибка, когда по заказу уже есть успешное списание
+var ErrOrderAlreadyPaid = errors.New("заказ уже оплачен")
+
+// ErrInvalidFilter ошибка некорректных параметров истории транзакций или выписки
+var ErrInvalidFilter = errors.New("некорректные параметры запроса")
+
+const (
+	// maxTransactionsPageSize максимальный размер страницы истории транзакций
+	maxTransactionsPageSize = 100
+	// maxStatementPeriod максимальная длина периода выписки
+	maxStatementPeriod = 366 * 24 * time.Hour
 )
 
+// eventProducer имя сервиса в конвертах публикуемых событий
+const eventProducer = "billing-service"
+
+// orderEventsConsumer имя потребителя событий заказов в inbox обработанных событий
+const orderEventsConsumer = "billing-service.order_created"
+
 // BillingRepository интерфейс для работы с хранилищем биллинга
 type BillingRepository interface {
 	CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error)
 	GetAccountByUserID(ctx context.Context, userID uint) (entity.Account, error)
-	UpdateBalance(ctx context.Context, accountID uint, amount float64) error
+	UpdateBalance(ctx context.Context, accountID uint, amount money.Money) error
+	DebitBalance(ctx context.Context, accountID uint, amount money.Money) (bool, error)
 	CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error)
 	GetTransactionByID(ctx context.Context, id uint) (entity.Transaction, error)
-	ListTransactionsByAccountID(ctx context.Context, accountID uint, limit, offset int) ([]entity.Transaction, int64, error)
-	WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error
-}
-
-// RabbitMQClient интерфейс для работы с RabbitMQ
-type RabbitMQClient interface {
-	PublishMessage(exchange, routingKey string, message interface{}) error
-	PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error
+	LockTransactionByID(ctx context.Context, id uint) (entity.Transaction, error)
+	ListTransactionsByAccountID(ctx context.Context, accountID uint, filter entity.TransactionFilter) ([]entity.Transaction, int64, error)
+	ListSuccessfulTransactions(ctx context.Context, accountID uint, from, to time.Time) ([]entity.Transaction, error)
+	SumRefundsByOriginalTransactionID(ctx context.Context, originalID uint) (money.Money, error)
+	HasSuccessfulOrderPayment(ctx context.Context, orderID uint) (bool, error)
+	GetOrderPayment(ctx context.Context, orderID uint) (entity.Transaction, error)
+	CreateLedgerAccount(ctx context.Context, account entity.LedgerAccount) (entity.LedgerAccount, error)
+	GetLedgerAccountByCode(ctx context.Context, code string) (entity.LedgerAccount, error)
+	CreateJournalEntry(ctx context.Context, entry entity.JournalEntry) (entity.JournalEntry, error)
+	LedgerBalanceBefore(ctx context.Context, ledgerAccountID uint, before time.Time) (money.Money, error)
+	SumPostings(ctx context.Context) (money.Money, money.Money, error)
+	ListBalanceMismatches(ctx context.Context) ([]entity.BalanceMismatch, error)
+	ReserveFunds(ctx context.Context, accountID uint, amount money.Money) (bool, error)
+	ReleaseFunds(ctx context.Context, accountID uint, amount money.Money) error
+	CreateHold(ctx context.Context, hold entity.Hold) (entity.Hold, error)
+	GetHoldByID(ctx context.Context, id uint) (entity.Hold, error)
+	LockHoldByID(ctx context.Context, id uint) (entity.Hold, error)
+	UpdateHold(ctx context.Context, hold entity.Hold) error
+	ListExpiredHoldIDs(ctx context.Context, now time.Time, limit int) ([]uint, error)
+	AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error
+	MarkEventProcessed(ctx context.Context, consumer, eventID string) (bool, error)
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // BillingUseCase представляет usecase для работы с биллингом
 type BillingUseCase struct {
 	repo        BillingRepository
-	rabbitMQ    RabbitMQClient
 	billingExch string
+	holdTTL     time.Duration
 }
 
-// NewBillingUseCase создает новый usecase для работы с биллингом
-func NewBillingUseCase(repo BillingRepository, rabbitMQ RabbitMQClient, billingExch string) *BillingUseCase {
+// NewBillingUseCase создает новый usecase для работы с биллингом. События публикуются
+// в exchange billingExch через outbox. holdTTL - срок действия холда, после которого
+// резерв освобождается автоматически
+func NewBillingUseCase(repo BillingRepository, billingExch string, holdTTL time.Duration) *BillingUseCase {
 	return &BillingUseCase{
 		repo:        repo,
-		rabbitMQ:    rabbitMQ,
 		billingExch: billingExch,
+		holdTTL:     holdTTL,
 	}
 }
 
@@ -54,14 +99,38 @@ func (uc *BillingUseCase) CreateAccount(ctx context.Context, req entity.CreateAc
 
 	account := entity.Account{
 		UserID:    req.UserID,
-		Balance:   0,
+		Balance:   money.Zero(money.DefaultCurrency),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	newAccount, err := uc.repo.CreateAccount(ctx, account)
+	var newAccount entity.Account
+
+	// Аккаунт и его счет в главной книге создаются вместе
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		newAccount, err = uc.repo.CreateAccount(ctx, account)
+		if err != nil {
+			return fmt.Errorf("ошибка при создании аккаунта: %w", err)
+		}
+
+		accountID := newAccount.ID
+		_, err = uc.repo.CreateLedgerAccount(ctx, entity.LedgerAccount{
+			Code:          entity.UserLedgerAccountCode(newAccount.ID),
+			Type:          entity.LedgerAccountTypeUser,
+			AccountID:     &accountID,
+			NormalBalance: entity.PostingCredit,
+			Currency:      newAccount.Balance.Currency(),
+			CreatedAt:     time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании счета главной книги: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return entity.CreateAccountResponse{}, fmt.Errorf("ошибка при создании аккаунта: %w", err)
+		return entity.CreateAccountResponse{}, err
 	}
 
 	return entity.CreateAccountResponse{
@@ -77,16 +146,27 @@ func (uc *BillingUseCase) GetAccount(ctx context.Context, userID uint) (entity.G
 		return entity.GetAccountResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
 	}
 
+	available, err := account.Balance.Sub(account.Held)
+	if err != nil {
+		return entity.GetAccountResponse{}, fmt.Errorf("ошибка при расчете доступного остатка: %w", err)
+	}
+
 	return entity.GetAccountResponse{
 		ID:        account.ID,
 		UserID:    account.UserID,
 		Balance:   account.Balance,
+		Held:      account.Held,
+		Available: available,
 		CreatedAt: account.CreatedAt,
 	}, nil
 }
 
 // Deposit пополняет баланс аккаунта
 func (uc *BillingUseCase) Deposit(ctx context.Context, req entity.DepositRequest) (entity.DepositResponse, error) {
+	if err := validateAmount(req.Amount); err != nil {
+		return entity.DepositResponse{}, err
+	}
+
 	account, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
 	if err != nil {
 		return entity.DepositResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
@@ -103,7 +183,7 @@ func (uc *BillingUseCase) Deposit(ctx context.Context, req entity.DepositRequest
 
 	var newTransaction entity.Transaction
 
-	err = uc.repo.WithTransaction(ctx, func(tx *gorm.DB) error {
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
 		// Обновляем баланс
 		if err := uc.repo.UpdateBalance(ctx, account.ID, req.Amount); err != nil {
 			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
@@ -115,49 +195,31 @@ func (uc *BillingUseCase) Deposit(ctx context.Context, req entity.DepositRequest
 			return fmt.Errorf("ошибка при создании транзакции: %w", txErr)
 		}
 
-		return nil
-	})
-
-	if err != nil {
-		return entity.DepositResponse{}, err
-	}
+		// Деньги поступают извне на счет пользователя
+		if err := uc.postEntry(ctx, newTransaction, "Пополнение баланса",
+			entity.LedgerAccountExternalFunding, entity.UserLedgerAccountCode(account.ID), req.Amount); err != nil {
+			return err
+		}
 
-	// Отправляем событие в RabbitMQ для нотификации с повторными попытками, если RabbitMQ инициализирован
-	if uc.rabbitMQ != nil {
 		// Определяем email для уведомления
 		email := req.Email
 		if email == "" {
 			email = "user" + fmt.Sprintf("%d", account.UserID) + "@example.com"
 		}
 
-		messageWithType := struct {
-			Type          string  `json:"type"`
-			UserID        uint    `json:"user_id"`
-			TransactionID uint    `json:"transaction_id"`
-			Amount        float64 `json:"amount"`
-			OperationType string  `json:"operation_type"`
-			Status        string  `json:"status"`
-			Email         string  `json:"email"`
-		}{
-			Type:          "billing.deposit",
+		// Уведомление сохраняется вместе с пополнением и будет опубликовано relay
+		return uc.addEvent(ctx, events.Deposit{
 			UserID:        account.UserID,
 			TransactionID: newTransaction.ID,
 			Amount:        req.Amount,
 			OperationType: entity.TransactionTypeDeposit,
 			Status:        entity.TransactionStatusSuccess,
 			Email:         email,
-		}
+		})
+	})
 
-		// Используем метод с повторными попытками для надежной публикации
-		err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.deposit", messageWithType, 3)
-		if err != nil {
-			// Логируем ошибку, но не прерываем выполнение
-			log.Printf("Ошибка при отправке нотификации о пополнении баланса после %d попыток: %v\n", 3, err)
-		} else {
-			// Логируем успешную отправку
-			log.Printf("Успешно отправлено уведомление о пополнении баланса для пользователя %d на email %s\n",
-				account.UserID, email)
-		}
+	if err != nil {
+		return entity.DepositResponse{}, err
 	}
 
 	return entity.DepositResponse{
@@ -175,177 +237,505 @@ func (uc *BillingUseCase) Deposit(ctx context.Context, req entity.DepositRequest
 
 // Withdraw снимает деньги с аккаунта
 func (uc *BillingUseCase) Withdraw(ctx context.Context, req entity.WithdrawRequest) (entity.WithdrawResponse, error) {
+	return uc.withdraw(ctx, req, nil)
+}
+
+// withdraw выполняет списание. Если задан onResult, он вызывается в той же транзакции
+// после сохранения результата списания, чтобы событие о нем попало в outbox атомарно
+func (uc *BillingUseCase) withdraw(ctx context.Context, req entity.WithdrawRequest,
+	onResult func(ctx context.Context, transaction entity.Transaction, debited bool) error) (entity.WithdrawResponse, error) {
+	if err := validateAmount(req.Amount); err != nil {
+		return entity.WithdrawResponse{}, err
+	}
+
 	account, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
 	if err != nil {
 		return entity.WithdrawResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
 	}
 
-	if account.Balance < req.Amount {
+	var newTransaction entity.Transaction
+	var debited bool
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Повторную оплату заказа отклоняем без записи неуспешной транзакции. Параллельную
+		// оплату того же заказа не даст зафиксировать уникальный индекс по order_id
+		if err := uc.checkOrderNotPaid(ctx, req.OrderID); err != nil {
+			return err
+		}
+
+		// Проверка баланса и списание выполняются атомарно в одном запросе
+		var err error
+		debited, err = uc.repo.DebitBalance(ctx, account.ID, req.Amount)
+		if err != nil {
+			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+		}
+
 		transaction := entity.Transaction{
 			AccountID: account.ID,
-			Amount:    req.Amount,
+			Amount:    req.Amount.Neg(), // Отрицательная сумма для снятия
 			Type:      entity.TransactionTypeWithdrawal,
-			Status:    entity.TransactionStatusFailed,
+			Status:    entity.TransactionStatusSuccess,
+			OrderID:   req.OrderID,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
+		if !debited {
+			// Неуспешная попытка сохраняется для истории, но в главную книгу не попадает
+			transaction.Status = entity.TransactionStatusFailed
+		}
 
-		newTransaction, err := uc.repo.CreateTransaction(ctx, transaction)
+		newTransaction, err = uc.repo.CreateTransaction(ctx, transaction)
 		if err != nil {
-			return entity.WithdrawResponse{}, fmt.Errorf("ошибка при создании транзакции: %w", err)
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
 		}
 
-		// Отправляем событие в RabbitMQ при недостатке средств
-		if uc.rabbitMQ != nil {
-			notification := struct {
-				Type          string  `json:"type"`
-				UserID        uint    `json:"user_id"`
-				TransactionID uint    `json:"transaction_id"`
-				Amount        float64 `json:"amount"`
-				OperationType string  `json:"operation_type"`
-				Status        string  `json:"status"`
-				Balance       float64 `json:"balance"`
-				Reason        string  `json:"reason"`
-				Email         string  `json:"email"`
-			}{
-				Type:          "billing.insufficient_funds",
-				UserID:        account.UserID,
-				TransactionID: newTransaction.ID,
-				Amount:        req.Amount,
-				OperationType: entity.TransactionTypeWithdrawal,
-				Status:        entity.TransactionStatusFailed,
-				Balance:       account.Balance,
-				Reason:        "insufficient_funds",
-				Email:         req.Email,
+		if !debited {
+			if err := uc.addInsufficientFundsEvent(ctx, account, req, newTransaction); err != nil {
+				return err
 			}
-
-			// Используем метод с повторными попытками для надежной публикации
-			err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.insufficient_funds", notification, 3)
-			if err != nil {
-				// Логируем ошибку, но не прерываем выполнение
-				log.Printf("Ошибка при отправке нотификации о недостатке средств после %d попыток: %v\n", 3, err)
+		} else {
+			// Списанные средства переходят со счета пользователя в выручку
+			if err := uc.postEntry(ctx, newTransaction, "Списание средств",
+				entity.UserLedgerAccountCode(account.ID), entity.LedgerAccountRevenue, req.Amount); err != nil {
+				return err
 			}
 		}
 
+		if onResult != nil {
+			return onResult(ctx, newTransaction, debited)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return entity.WithdrawResponse{}, err
+	}
+
+	if !debited {
 		return entity.WithdrawResponse{
 			Transaction: entity.TransactionResponse{
 				ID:        newTransaction.ID,
 				AccountID: newTransaction.AccountID,
-				Amount:    newTransaction.Amount,
+				Amount:    req.Amount,
 				Type:      newTransaction.Type,
 				Status:    newTransaction.Status,
+				OrderID:   newTransaction.OrderID,
 				CreatedAt: newTransaction.CreatedAt,
 			},
 			Success: false,
 		}, nil
 	}
 
-	transaction := entity.Transaction{
-		AccountID: account.ID,
-		Amount:    -req.Amount, // Отрицательная сумма для снятия
-		Type:      entity.TransactionTypeWithdrawal,
-		Status:    entity.TransactionStatusSuccess,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	return entity.WithdrawResponse{
+		Transaction: entity.TransactionResponse{
+			ID:        newTransaction.ID,
+			AccountID: newTransaction.AccountID,
+			Amount:    req.Amount, // Возвращаем положительную сумму для ясности
+			Type:      newTransaction.Type,
+			Status:    newTransaction.Status,
+			OrderID:   newTransaction.OrderID,
+			CreatedAt: newTransaction.CreatedAt,
+		},
+		Success: true,
+	}, nil
+}
+
+// addInsufficientFundsEvent сохраняет в outbox событие billing.insufficient_funds о неуспешном списании
+func (uc *BillingUseCase) addInsufficientFundsEvent(ctx context.Context, account entity.Account, req entity.WithdrawRequest, transaction entity.Transaction) error {
+	// Баланс мог измениться параллельными операциями, поэтому перечитываем его
+	balance := account.Balance
+	if current, err := uc.repo.GetAccountByUserID(ctx, account.UserID); err == nil {
+		balance = current.Balance
+	}
+
+	return uc.addEvent(ctx, events.InsufficientFunds{
+		UserID:        account.UserID,
+		TransactionID: transaction.ID,
+		Amount:        req.Amount,
+		OperationType: entity.TransactionTypeWithdrawal,
+		Status:        entity.TransactionStatusFailed,
+		Balance:       balance,
+		Reason:        "insufficient_funds",
+		Email:         req.Email,
+	})
+}
+
+// Refund возвращает средства по успешному списанию. Возврат записывается транзакцией
+// типа refund, связанной с исходной транзакцией; допускается частичный возврат
+func (uc *BillingUseCase) Refund(ctx context.Context, req entity.RefundRequest) (entity.RefundResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
+	if err != nil {
+		return entity.RefundResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	original, err := uc.repo.GetTransactionByID(ctx, req.TransactionID)
+	if err != nil || original.AccountID != account.ID {
+		return entity.RefundResponse{}, ErrTransactionNotFound
+	}
+
+	if original.Type != entity.TransactionTypeWithdrawal || original.Status != entity.TransactionStatusSuccess {
+		return entity.RefundResponse{}, ErrRefundNotAllowed
 	}
 
 	var newTransaction entity.Transaction
+	var amount money.Money
 
-	err = uc.repo.WithTransaction(ctx, func(tx *gorm.DB) error {
-		// Обновляем баланс
-		if err := uc.repo.UpdateBalance(ctx, account.ID, -req.Amount); err != nil {
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Блокируем исходное списание, чтобы параллельные возвраты не превысили его сумму
+		if _, err := uc.repo.LockTransactionByID(ctx, original.ID); err != nil {
+			return fmt.Errorf("ошибка при блокировке транзакции: %w", err)
+		}
+
+		refunded, err := uc.repo.SumRefundsByOriginalTransactionID(ctx, original.ID)
+		if err != nil {
+			return fmt.Errorf("ошибка при подсчете возвратов: %w", err)
+		}
+
+		// Сумма списания хранится со знаком минус
+		remaining, err := original.Amount.Neg().Sub(refunded)
+		if err != nil {
+			return fmt.Errorf("ошибка при расчете остатка списания: %w", err)
+		}
+
+		amount = req.Amount
+		if amount.IsZero() {
+			amount = remaining
+		}
+		if cmp, err := amount.Cmp(remaining); err != nil || !amount.IsPositive() || cmp > 0 {
+			return ErrRefundAmountExceeded
+		}
+
+		// Возвращаем средства на баланс
+		if err := uc.repo.UpdateBalance(ctx, account.ID, amount); err != nil {
 			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
 		}
 
-		var txErr error
-		newTransaction, txErr = uc.repo.CreateTransaction(ctx, transaction)
-		if txErr != nil {
-			return fmt.Errorf("ошибка при создании транзакции: %w", txErr)
+		originalID := original.ID
+		newTransaction, err = uc.repo.CreateTransaction(ctx, entity.Transaction{
+			AccountID:             account.ID,
+			Amount:                amount,
+			Type:                  entity.TransactionTypeRefund,
+			Status:                entity.TransactionStatusSuccess,
+			OriginalTransactionID: &originalID,
+			CreatedAt:             time.Now(),
+			UpdatedAt:             time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
 		}
 
-		return nil
+		// Возврат уменьшает выручку через счет возвратов и зачисляется пользователю
+		if err := uc.postEntry(ctx, newTransaction, fmt.Sprintf("Возврат по транзакции %d", original.ID),
+			entity.LedgerAccountRefunds, entity.UserLedgerAccountCode(account.ID), amount); err != nil {
+			return err
+		}
+
+		return uc.addEvent(ctx, events.Refund{
+			UserID:                account.UserID,
+			TransactionID:         newTransaction.ID,
+			OriginalTransactionID: original.ID,
+			Amount:                amount,
+			Reason:                req.Reason,
+			Email:                 req.Email,
+		})
 	})
 
 	if err != nil {
-		return entity.WithdrawResponse{}, err
+		return entity.RefundResponse{}, err
 	}
 
-	return entity.WithdrawResponse{
+	log.Printf("Выполнен возврат %s по транзакции %d для пользователя %d: %s",
+		amount, original.ID, account.UserID, req.Reason)
+
+	return entity.RefundResponse{
 		Transaction: entity.TransactionResponse{
-			ID:        newTransaction.ID,
-			AccountID: newTransaction.AccountID,
-			Amount:    req.Amount, // Возвращаем положительную сумму для ясности
-			Type:      newTransaction.Type,
-			Status:    newTransaction.Status,
-			CreatedAt: newTransaction.CreatedAt,
+			ID:                    newTransaction.ID,
+			AccountID:             newTransaction.AccountID,
+			Amount:                newTransaction.Amount,
+			Type:                  newTransaction.Type,
+			Status:                newTransaction.Status,
+			OriginalTransactionID: newTransaction.OriginalTransactionID,
+			CreatedAt:             newTransaction.CreatedAt,
 		},
 		Success: true,
 	}, nil
 }
 
-// HandleOrderCreatedEvent обрабатывает событие создания заказа
-func (uc *BillingUseCase) HandleOrderCreatedEvent(data []byte) error {
-	// Структура для десериализации сообщения
-	var message struct {
-		OrderID   uint    `json:"order_id"`
-		UserID    uint    `json:"user_id"`
-		TotalCost float64 `json:"total_cost"`
-		Email     string  `json:"email"`
+// ListTransactions возвращает историю транзакций счета пользователя с фильтрами и пагинацией
+func (uc *BillingUseCase) ListTransactions(ctx context.Context, userID uint, filter entity.TransactionFilter) (entity.ListTransactionsResponse, error) {
+	if err := validateFilter(&filter); err != nil {
+		return entity.ListTransactionsResponse{}, err
+	}
+
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.ListTransactionsResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
 	}
 
-	// Десериализуем сообщение
-	err := json.Unmarshal(data, &message)
+	transactions, total, err := uc.repo.ListTransactionsByAccountID(ctx, account.ID, filter)
+	if err != nil {
+		return entity.ListTransactionsResponse{}, fmt.Errorf("ошибка при получении транзакций: %w", err)
+	}
+
+	return entity.ListTransactionsResponse{
+		Transactions: toTransactionResponses(transactions),
+		Total:        total,
+	}, nil
+}
+
+// GetTransaction возвращает транзакцию по ID. Чужая транзакция не отличается от несуществующей
+func (uc *BillingUseCase) GetTransaction(ctx context.Context, userID, transactionID uint) (entity.TransactionResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.TransactionResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	transaction, err := uc.repo.GetTransactionByID(ctx, transactionID)
+	if err != nil || transaction.AccountID != account.ID {
+		return entity.TransactionResponse{}, ErrTransactionNotFound
+	}
+
+	return toTransactionResponse(transaction), nil
+}
+
+// GetStatement формирует выписку по счету за период [from, to). Входящий остаток берется из главной
+// книги на начало периода, исходящий равен входящему с учетом движений за период
+func (uc *BillingUseCase) GetStatement(ctx context.Context, userID uint, from, to time.Time) (entity.StatementResponse, error) {
+	if !from.Before(to) {
+		return entity.StatementResponse{}, fmt.Errorf("%w: начало периода должно быть раньше конца", ErrInvalidFilter)
+	}
+	if to.Sub(from) > maxStatementPeriod {
+		return entity.StatementResponse{}, fmt.Errorf("%w: период выписки не может превышать 366 дней", ErrInvalidFilter)
+	}
+
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	ledgerAccount, err := uc.repo.GetLedgerAccountByCode(ctx, entity.UserLedgerAccountCode(account.ID))
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("счет главной книги не найден: %w", err)
+	}
+
+	opening, err := uc.repo.LedgerBalanceBefore(ctx, ledgerAccount.ID, from)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при расчете входящего остатка: %w", err)
+	}
+	opening = opening.WithCurrency(account.Balance.Currency())
+
+	movements, err := uc.repo.ListSuccessfulTransactions(ctx, account.ID, from, to)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при получении движений: %w", err)
+	}
+
+	credit := money.Zero(opening.Currency())
+	debit := money.Zero(opening.Currency())
+	for _, t := range movements {
+		// Списания хранятся со знаком минус, пополнения и возвраты - со знаком плюс
+		if t.Amount.IsNegative() {
+			debit, err = debit.Add(t.Amount.Neg())
+		} else {
+			credit, err = credit.Add(t.Amount)
+		}
+		if err != nil {
+			return entity.StatementResponse{}, fmt.Errorf("ошибка при расчете оборотов: %w", err)
+		}
+	}
+
+	closing, err := opening.Add(credit)
+	if err == nil {
+		closing, err = closing.Sub(debit)
+	}
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при расчете исходящего остатка: %w", err)
+	}
+
+	return entity.StatementResponse{
+		AccountID:      account.ID,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		TotalCredit:    credit,
+		TotalDebit:     debit,
+		ClosingBalance: closing,
+		Movements:      toTransactionResponses(movements),
+	}, nil
+}
+
+// validateFilter проверяет фильтр истории транзакций и нормализует пагинацию
+func validateFilter(filter *entity.TransactionFilter) error {
+	switch filter.Type {
+	case "", entity.TransactionTypeDeposit, entity.TransactionTypeWithdrawal, entity.TransactionTypeRefund, entity.TransactionTypeAdjustment:
+	default:
+		return fmt.Errorf("%w: неизвестный тип транзакции %q", ErrInvalidFilter, filter.Type)
+	}
+
+	switch filter.Status {
+	case "", entity.TransactionStatusSuccess, entity.TransactionStatusFailed:
+	default:
+		return fmt.Errorf("%w: неизвестный статус транзакции %q", ErrInvalidFilter, filter.Status)
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return fmt.Errorf("%w: начало периода должно быть раньше конца", ErrInvalidFilter)
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = 10
+	}
+	if filter.Limit > maxTransactionsPageSize {
+		filter.Limit = maxTransactionsPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	return nil
+}
+
+func toTransactionResponse(t entity.Transaction) entity.TransactionResponse {
+	return entity.TransactionResponse{
+		ID:                    t.ID,
+		AccountID:             t.AccountID,
+		Amount:                t.Amount,
+		Type:                  t.Type,
+		Status:                t.Status,
+		OriginalTransactionID: t.OriginalTransactionID,
+		OrderID:               t.OrderID,
+		CreatedAt:             t.CreatedAt,
+	}
+}
+
+func toTransactionResponses(transactions []entity.Transaction) []entity.TransactionResponse {
+	result := make([]entity.TransactionResponse, 0, len(transactions))
+	for _, t := range transactions {
+		result = append(result, toTransactionResponse(t))
+	}
+	return result
+}
+
+// validateAmount проверяет, что сумма операции положительна и указана в валюте счета
+func validateAmount(amount money.Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	if amount.Currency() != money.DefaultCurrency {
+		return fmt.Errorf("%w: счета ведутся в %s, указано %s", ErrInvalidAmount, money.DefaultCurrency, amount.Currency())
+	}
+	return nil
+}
+
+// HandleOrderCreatedEvent обрабатывает событие создания заказа. Событие отмечается в inbox
+// в одной транзакции со списанием, поэтому повторная доставка не приводит к повторной оплате
+func (uc *BillingUseCase) HandleOrderCreatedEvent(ctx context.Context, data []byte) error {
+	envelope, err := events.Decode(data)
 	if err != nil {
 		return fmt.Errorf("ошибка при разборе сообщения о создании заказа: %w", err)
 	}
 
-	log.Printf("Получено событие создания заказа: OrderID=%d, UserID=%d, TotalCost=%.2f",
-		message.OrderID, message.UserID, message.TotalCost)
+	if !envelope.Is(events.TypeOrderCreated, 1) {
+		return envelope.Unsupported()
+	}
+	var message events.OrderCreated
+	if err := envelope.DecodePayload(&message); err != nil {
+		return err
+	}
+
+	log.Printf("Получено событие создания заказа %s: OrderID=%d, UserID=%d, TotalCost=%s",
+		envelope.EventID, message.OrderID, message.UserID, message.TotalCost)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx = events.WithCause(ctx, envelope)
 
 	// Создаем запрос на списание средств
+	orderID := message.OrderID
 	withdrawReq := entity.WithdrawRequest{
-		UserID: message.UserID,
-		Amount: message.TotalCost,
-		Email:  message.Email,
+		UserID:  message.UserID,
+		Amount:  message.TotalCost,
+		Email:   message.Email,
+		OrderID: &orderID,
 	}
 
-	// Выполняем списание средств
-	resp, err := uc.Withdraw(ctx, withdrawReq)
+	var resp entity.WithdrawResponse
+	var duplicate bool
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		created, err := uc.repo.MarkEventProcessed(ctx, orderEventsConsumer, envelope.EventID)
+		if err != nil {
+			return err
+		}
+		if !created {
+			duplicate = true
+			return nil
+		}
+
+		// Событие о результате оплаты сохраняется в outbox в одной транзакции со списанием
+		resp, err = uc.withdraw(ctx, withdrawReq, func(ctx context.Context, transaction entity.Transaction, debited bool) error {
+			return uc.addEvent(ctx, events.PaymentProcessed{
+				OrderID:       message.OrderID,
+				UserID:        message.UserID,
+				TransactionID: transaction.ID,
+				Amount:        message.TotalCost,
+				Status:        transaction.Status,
+				Success:       debited,
+			})
+		})
+		if errors.Is(err, ErrOrderAlreadyPaid) {
+			paid, err := uc.isSameOrderPayment(ctx, message)
+			if err != nil {
+				return err
+			}
+			if paid {
+				// Заказ оплачен по другому событию, результат той оплаты уже опубликован
+				duplicate = true
+				return nil
+			}
+
+			// Оплата другого пользователя или на другую сумму этот заказ не оплачивает
+			log.Printf("Заказ %d уже оплачен другим аккаунтом или на другую сумму, оплата отклонена", message.OrderID)
+			return uc.addEvent(ctx, events.PaymentProcessed{
+				OrderID: message.OrderID,
+				UserID:  message.UserID,
+				Amount:  message.TotalCost,
+				Status:  entity.TransactionStatusFailed,
+				Success: false,
+			})
+		}
+		return err
+	})
 	if err != nil {
 		log.Printf("Ошибка при списании средств для заказа %d: %v", message.OrderID, err)
 		return err
 	}
 
-	// Результат операции (статус транзакции)
-	transactionSuccess := resp.Success
-
-	// Отправляем событие о результате обработки платежа
-	paymentEvent := struct {
-		OrderID       uint    `json:"order_id"`
-		UserID        uint    `json:"user_id"`
-		TransactionID uint    `json:"transaction_id"`
-		Amount        float64 `json:"amount"`
-		Status        string  `json:"status"`
-		Success       bool    `json:"success"`
-	}{
-		OrderID:       message.OrderID,
-		UserID:        message.UserID,
-		TransactionID: resp.Transaction.ID,
-		Amount:        message.TotalCost,
-		Status:        resp.Transaction.Status,
-		Success:       transactionSuccess,
-	}
-
-	// Публикуем событие результата обработки
-	err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.payment_processed", paymentEvent, 3)
-	if err != nil {
-		log.Printf("Ошибка при отправке события обработки платежа: %v", err)
-		return err
+	if duplicate {
+		log.Printf("Событие %s для заказа %d уже обработано, пропускаем", envelope.EventID, message.OrderID)
+		return nil
 	}
 
-	log.Printf("Платеж для заказа %d обработан, результат: %v", message.OrderID, transactionSuccess)
+	log.Printf("Платеж для заказа %d обработан, результат: %v", message.OrderID, resp.Success)
 	return nil
 }
+
+// isSameOrderPayment сообщает, что успешное списание по заказу проведено со счета пользователя
+// из события создания заказа на сумму заказа
+func (uc *BillingUseCase) isSameOrderPayment(ctx context.Context, message events.OrderCreated) (bool, error) {
+	payment, err := uc.repo.GetOrderPayment(ctx, message.OrderID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при получении оплаты заказа: %w", err)
+	}
+	account, err := uc.repo.GetAccountByUserID(ctx, message.UserID)
+	if err != nil {
+		return false, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+	return payment.AccountID == account.ID && payment.Amount.Neg().Equal(message.TotalCost), nil
+}
+
+// addEvent сохраняет событие в outbox с ключом маршрутизации, равным типу события
+func (uc *BillingUseCase) addEvent(ctx context.Context, event events.Event) error {
+	envelope, err := events.New(ctx, eventProducer, event)
+	if err != nil {
+		return err
+	}
+	return uc.repo.AddOutboxMessage(ctx, uc.billingExch, envelope.Type, envelope)
+}
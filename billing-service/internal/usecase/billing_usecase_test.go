@@ -0,0 +1,389 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/billing-service/internal/repo/memory"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// newTestUseCase возвращает usecase на хранилище в памяти и аккаунт пользователя userID с балансом balance
+func newTestUseCase(t *testing.T, userID uint, balance string) (*BillingUseCase, *memory.Repository) {
+	t.Helper()
+
+	repo := memory.NewRepository()
+	uc := NewBillingUseCase(repo, "billing_events", time.Hour)
+	ctx := context.Background()
+
+	if _, err := uc.CreateAccount(ctx, entity.CreateAccountRequest{UserID: userID}); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	if _, err := uc.Deposit(ctx, entity.DepositRequest{UserID: userID, Amount: money.MustParse(balance, "")}); err != nil {
+		t.Fatalf("Deposit: %v", err)
+	}
+	return uc, repo
+}
+
+// runConcurrently запускает n вызовов fn одновременно и ждет их завершения
+func runConcurrently(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+// assertAccount проверяет баланс и резерв аккаунта и сходимость главной книги
+func assertAccount(t *testing.T, uc *BillingUseCase, userID uint, balance, held string) {
+	t.Helper()
+	ctx := context.Background()
+
+	account, err := uc.GetAccount(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	if !account.Balance.Equal(money.MustParse(balance, "")) {
+		t.Errorf("баланс %s, ожидался %s", account.Balance, balance)
+	}
+	if !account.Held.Equal(money.MustParse(held, "")) {
+		t.Errorf("резерв %s, ожидался %s", account.Held, held)
+	}
+	if account.Available.IsNegative() {
+		t.Errorf("доступный остаток отрицателен: %s", account.Available)
+	}
+
+	report, err := uc.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !report.Balanced || len(report.Mismatches) > 0 {
+		t.Errorf("главная книга не сходится: дебет %s, кредит %s, расхождений %d",
+			report.TotalDebit, report.TotalCredit, len(report.Mismatches))
+	}
+}
+
+func countEvents(repo *memory.Repository, eventType string) int {
+	var n int
+	for _, t := range repo.OutboxEvents() {
+		if t == eventType {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConcurrentWithdrawalsNeverOverdraw(t *testing.T) {
+	uc, repo := newTestUseCase(t, 1, "100.00")
+
+	const attempts = 50
+	results := make([]entity.WithdrawResponse, attempts)
+	errs := make([]error, attempts)
+	runConcurrently(attempts, func(i int) {
+		results[i], errs[i] = uc.Withdraw(context.Background(), entity.WithdrawRequest{
+			UserID: 1,
+			Amount: money.MustParse("10.00", ""),
+		})
+	})
+
+	var succeeded, failed int
+	for i := range results {
+		switch {
+		case errs[i] != nil:
+			t.Errorf("списание %d: %v", i, errs[i])
+		case results[i].Success:
+			succeeded++
+		default:
+			failed++
+		}
+	}
+	if succeeded != 10 || failed != attempts-10 {
+		t.Errorf("успешных списаний %d, неуспешных %d, ожидалось 10 и %d", succeeded, failed, attempts-10)
+	}
+
+	assertAccount(t, uc, 1, "0.00", "0.00")
+
+	history, err := uc.ListTransactions(context.Background(), 1, entity.TransactionFilter{Status: entity.TransactionStatusFailed})
+	if err != nil {
+		t.Fatalf("ListTransactions: %v", err)
+	}
+	if history.Total != int64(failed) {
+		t.Errorf("в истории %d неуспешных транзакций, ожидалось %d", history.Total, failed)
+	}
+	if got := countEvents(repo, events.TypeInsufficientFunds); got != failed {
+		t.Errorf("в outbox %d событий о нехватке средств, ожидалось %d", got, failed)
+	}
+}
+
+func TestConcurrentWithdrawalsAndHolds(t *testing.T) {
+	uc, _ := newTestUseCase(t, 1, "100.00")
+
+	const attempts = 40
+	var mu sync.Mutex
+	var withdrawn, held int
+	runConcurrently(attempts, func(i int) {
+		ctx := context.Background()
+		amount := money.MustParse("10.00", "")
+
+		if i%2 == 0 {
+			resp, err := uc.Withdraw(ctx, entity.WithdrawRequest{UserID: 1, Amount: amount})
+			if err != nil {
+				t.Errorf("списание %d: %v", i, err)
+				return
+			}
+			if resp.Success {
+				mu.Lock()
+				withdrawn++
+				mu.Unlock()
+			}
+			return
+		}
+
+		_, err := uc.Authorize(ctx, entity.AuthorizeRequest{UserID: 1, Amount: amount})
+		switch {
+		case err == nil:
+			mu.Lock()
+			held++
+			mu.Unlock()
+		case !errors.Is(err, ErrInsufficientFunds):
+			t.Errorf("резервирование %d: %v", i, err)
+		}
+	})
+
+	if withdrawn+held != 10 {
+		t.Errorf("списаний %d и холдов %d, ожидалось 10 операций в сумме", withdrawn, held)
+	}
+
+	balance := money.New(int64(100-10*withdrawn)*100, "")
+	assertAccount(t, uc, 1, balance.Decimal(), money.New(int64(10*held)*100, "").Decimal())
+}
+
+func TestConcurrentOrderPaymentsDebitOnce(t *testing.T) {
+	uc, _ := newTestUseCase(t, 1, "100.00")
+
+	const attempts = 20
+	orderID := uint(7)
+	results := make([]entity.WithdrawResponse, attempts)
+	errs := make([]error, attempts)
+	runConcurrently(attempts, func(i int) {
+		results[i], errs[i] = uc.Withdraw(context.Background(), entity.WithdrawRequest{
+			UserID:  1,
+			Amount:  money.MustParse("30.00", ""),
+			OrderID: &orderID,
+		})
+	})
+
+	// Проигравшие гонку получают ErrOrderAlreadyPaid или ошибку уникального индекса оплаты заказа
+	var paid int
+	for i := range results {
+		switch {
+		case errs[i] == nil && results[i].Success:
+			paid++
+		case errs[i] == nil:
+			t.Errorf("списание %d не прошло, хотя средств достаточно", i)
+		case !errors.Is(errs[i], ErrOrderAlreadyPaid) && !errors.Is(errs[i], memory.ErrUniqueViolation):
+			t.Errorf("списание %d: %v", i, errs[i])
+		}
+	}
+	if paid != 1 {
+		t.Errorf("заказ оплачен %d раз, ожидался 1", paid)
+	}
+
+	assertAccount(t, uc, 1, "70.00", "0.00")
+}
+
+func TestConcurrentRefundsDoNotExceedWithdrawal(t *testing.T) {
+	uc, _ := newTestUseCase(t, 1, "100.00")
+	ctx := context.Background()
+
+	withdrawal, err := uc.Withdraw(ctx, entity.WithdrawRequest{UserID: 1, Amount: money.MustParse("50.00", "")})
+	if err != nil || !withdrawal.Success {
+		t.Fatalf("Withdraw: %v, success=%v", err, withdrawal.Success)
+	}
+
+	const attempts = 20
+	errs := make([]error, attempts)
+	runConcurrently(attempts, func(i int) {
+		_, errs[i] = uc.Refund(ctx, entity.RefundRequest{
+			UserID:        1,
+			TransactionID: withdrawal.Transaction.ID,
+			Amount:        money.MustParse("10.00", ""),
+		})
+	})
+
+	var refunded int
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			refunded++
+		case !errors.Is(err, ErrRefundAmountExceeded):
+			t.Errorf("возврат %d: %v", i, err)
+		}
+	}
+	if refunded != 5 {
+		t.Errorf("успешных возвратов %d, ожидалось 5", refunded)
+	}
+
+	assertAccount(t, uc, 1, "100.00", "0.00")
+}
+
+func TestRedeliveredOrderCreatedIsPaidOnce(t *testing.T) {
+	uc, repo := newTestUseCase(t, 1, "100.00")
+
+	envelope, err := events.New(context.Background(), "order-service", events.OrderCreated{
+		OrderID:   42,
+		UserID:    1,
+		TotalCost: money.MustParse("25.00", ""),
+		Email:     "user@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Брокер доставляет одно событие несколько раз, в том числе параллельно
+	const deliveries = 10
+	errs := make([]error, deliveries)
+	runConcurrently(deliveries, func(i int) {
+		errs[i] = uc.HandleOrderCreatedEvent(context.Background(), data)
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("доставка %d: %v", i, err)
+		}
+	}
+
+	assertAccount(t, uc, 1, "75.00", "0.00")
+	if got := countEvents(repo, events.TypePaymentProcessed); got != 1 {
+		t.Errorf("в outbox %d результатов оплаты, ожидался 1", got)
+	}
+}
+
+func TestOrderCreatedForOrderPaidByAnotherPaymentFails(t *testing.T) {
+	tests := []struct {
+		name    string
+		payerID uint
+		amount  string
+		balance string
+	}{
+		{name: "другой аккаунт", payerID: 2, amount: "25.00", balance: "100.00"},
+		{name: "другая сумма", payerID: 1, amount: "10.00", balance: "90.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, repo := newTestUseCase(t, 1, "100.00")
+			ctx := context.Background()
+
+			if _, err := uc.CreateAccount(ctx, entity.CreateAccountRequest{UserID: 2}); err != nil {
+				t.Fatalf("CreateAccount: %v", err)
+			}
+			if _, err := uc.Deposit(ctx, entity.DepositRequest{UserID: 2, Amount: money.MustParse("100.00", "")}); err != nil {
+				t.Fatalf("Deposit: %v", err)
+			}
+
+			// Заказ пользователя 1 уже занят списанием, которое не совпадает с заказом
+			orderID := uint(42)
+			withdrawal, err := uc.Withdraw(ctx, entity.WithdrawRequest{
+				UserID:  tt.payerID,
+				Amount:  money.MustParse(tt.amount, ""),
+				OrderID: &orderID,
+			})
+			if err != nil || !withdrawal.Success {
+				t.Fatalf("Withdraw: %v, success=%v", err, withdrawal.Success)
+			}
+
+			envelope, err := events.New(ctx, "order-service", events.OrderCreated{
+				OrderID:   orderID,
+				UserID:    1,
+				TotalCost: money.MustParse("25.00", ""),
+				Email:     "user@example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := uc.HandleOrderCreatedEvent(ctx, data); err != nil {
+				t.Fatalf("HandleOrderCreatedEvent: %v", err)
+			}
+
+			assertAccount(t, uc, 1, tt.balance, "0.00")
+
+			results := repo.OutboxEnvelopes(events.TypePaymentProcessed)
+			if len(results) != 1 {
+				t.Fatalf("в outbox %d результатов оплаты, ожидался 1", len(results))
+			}
+			var result events.PaymentProcessed
+			if err := results[0].DecodePayload(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Success || result.Status != entity.TransactionStatusFailed || result.OrderID != orderID {
+				t.Errorf("результат оплаты %+v, ожидалась неуспешная оплата заказа %d", result, orderID)
+			}
+		})
+	}
+}
+
+func TestRedeliveredCompensationRefundsOnce(t *testing.T) {
+	uc, repo := newTestUseCase(t, 1, "100.00")
+	ctx := context.Background()
+
+	orderID := uint(42)
+	withdrawal, err := uc.Withdraw(ctx, entity.WithdrawRequest{
+		UserID:  1,
+		Amount:  money.MustParse("40.00", ""),
+		OrderID: &orderID,
+	})
+	if err != nil || !withdrawal.Success {
+		t.Fatalf("Withdraw: %v, success=%v", err, withdrawal.Success)
+	}
+
+	compensation := func() []byte {
+		envelope, err := events.New(ctx, "order-service", events.PaymentCompensation{
+			OrderID:       orderID,
+			UserID:        1,
+			TransactionID: withdrawal.Transaction.ID,
+			Reason:        "отмена заказа #42",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// Повторная доставка одного события и второе событие по тому же заказу возвращают деньги один раз
+	first := compensation()
+	for _, data := range [][]byte{first, first, compensation()} {
+		if err := uc.HandlePaymentCompensationEvent(ctx, data); err != nil {
+			t.Fatalf("HandlePaymentCompensationEvent: %v", err)
+		}
+	}
+
+	assertAccount(t, uc, 1, "100.00", "0.00")
+	if got := countEvents(repo, events.TypeRefund); got != 1 {
+		t.Errorf("в outbox %d событий о возврате, ожидалось 1", got)
+	}
+}
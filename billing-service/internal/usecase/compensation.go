@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
+)
+
+// compensationConsumer имя потребителя запросов на возврат оплаты в inbox обработанных событий
+const compensationConsumer = "billing-service.payment_compensation"
+
+// HandlePaymentCompensationEvent возвращает оплату отмененного заказа: отменяет холд или
+// возвращает остаток списания. Событие отмечается в inbox в одной транзакции с возвратом,
+// поэтому повторная доставка не вернет средства второй раз. Ошибка биллинга возвращается
+// брокеру, и сообщение будет доставлено повторно
+func (uc *BillingUseCase) HandlePaymentCompensationEvent(ctx context.Context, data []byte) error {
+	envelope, err := events.Decode(data)
+	if err != nil {
+		return fmt.Errorf("ошибка при разборе запроса на возврат оплаты: %w", err)
+	}
+
+	if !envelope.Is(events.TypePaymentCompensation, 1) {
+		return envelope.Unsupported()
+	}
+	var message events.PaymentCompensation
+	if err := envelope.DecodePayload(&message); err != nil {
+		return err
+	}
+
+	log.Printf("Получен запрос на возврат оплаты %s: OrderID=%d, UserID=%d, TransactionID=%d, HoldID=%d",
+		envelope.EventID, message.OrderID, message.UserID, message.TransactionID, message.HoldID)
+
+	ctx = events.WithCause(ctx, envelope)
+
+	var duplicate bool
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		created, err := uc.repo.MarkEventProcessed(ctx, compensationConsumer, envelope.EventID)
+		if err != nil {
+			return err
+		}
+		if !created {
+			duplicate = true
+			return nil
+		}
+
+		transactionID := message.TransactionID
+		if transactionID == 0 && message.HoldID != 0 {
+			_, err := uc.Void(ctx, message.UserID, message.HoldID)
+			switch {
+			case err == nil:
+				log.Printf("Холд %d заказа %d отменен", message.HoldID, message.OrderID)
+				return nil
+			case errors.Is(err, ErrHoldNotFound):
+				log.Printf("Холд %d заказа %d не найден, возврат не требуется", message.HoldID, message.OrderID)
+				return nil
+			case !errors.Is(err, ErrHoldNotActive):
+				return err
+			}
+
+			// Холд успели списать до отмены заказа: возвращаем списание
+			hold, err := uc.GetHold(ctx, message.UserID, message.HoldID)
+			if err != nil {
+				return err
+			}
+			if hold.TransactionID == nil {
+				return fmt.Errorf("холд %d списан, но не содержит транзакцию списания", hold.ID)
+			}
+			transactionID = *hold.TransactionID
+		}
+		if transactionID == 0 {
+			return nil
+		}
+
+		// Нулевая сумма возвращает весь невозвращенный остаток списания
+		_, err = uc.Refund(ctx, entity.RefundRequest{
+			UserID:        message.UserID,
+			TransactionID: transactionID,
+			Reason:        message.Reason,
+			Email:         message.Email,
+		})
+		if errors.Is(err, ErrRefundAmountExceeded) {
+			log.Printf("Списание %d заказа %d уже возвращено", transactionID, message.OrderID)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Ошибка при возврате оплаты заказа %d: %v", message.OrderID, err)
+		return err
+	}
+
+	if duplicate {
+		log.Printf("Запрос на возврат %s для заказа %d уже обработан, пропускаем", envelope.EventID, message.OrderID)
+	}
+	return nil
+}
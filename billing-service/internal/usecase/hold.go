@@ -0,0 +1,337 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// Ошибки холдов
+var (
+	ErrInsufficientFunds  = errors.New("недостаточно средств на счете")
+	ErrHoldNotFound       = errors.New("холд не найден")
+	ErrHoldNotActive      = errors.New("холд уже списан или отменен")
+	ErrHoldExpired        = errors.New("срок действия холда истек")
+	ErrHoldAmountExceeded = errors.New("сумма списания превышает сумму холда")
+)
+
+// expireHoldsBatchSize количество просроченных холдов, освобождаемых за один проход
+const expireHoldsBatchSize = 100
+
+// Authorize резервирует средства на счете и возвращает холд. Доступный остаток уменьшается сразу,
+// баланс и главная книга не меняются до Capture
+func (uc *BillingUseCase) Authorize(ctx context.Context, req entity.AuthorizeRequest) (entity.HoldResponse, error) {
+	if err := validateAmount(req.Amount); err != nil {
+		return entity.HoldResponse{}, err
+	}
+
+	account, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
+	if err != nil {
+		return entity.HoldResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	var hold entity.Hold
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Уже оплаченный заказ повторно не резервируем
+		if err := uc.checkOrderNotPaid(ctx, req.OrderID); err != nil {
+			return err
+		}
+
+		// Проверка доступного остатка и резервирование выполняются атомарно в одном запросе
+		reserved, err := uc.repo.ReserveFunds(ctx, account.ID, req.Amount)
+		if err != nil {
+			return fmt.Errorf("ошибка при резервировании средств: %w", err)
+		}
+		if !reserved {
+			return ErrInsufficientFunds
+		}
+
+		now := time.Now()
+		hold, err = uc.repo.CreateHold(ctx, entity.Hold{
+			AccountID:      account.ID,
+			Amount:         req.Amount,
+			CapturedAmount: money.Zero(req.Amount.Currency()),
+			Status:         entity.HoldStatusActive,
+			Description:    req.Description,
+			OrderID:        req.OrderID,
+			ExpiresAt:      now.Add(uc.holdTTL),
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании холда: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return entity.HoldResponse{}, err
+	}
+
+	log.Printf("Зарезервировано %s для пользователя %d, холд %d до %s",
+		req.Amount, account.UserID, hold.ID, hold.ExpiresAt.Format(time.RFC3339))
+
+	return toHoldResponse(hold), nil
+}
+
+// Capture списывает зарезервированные средства полностью или частично. Холд закрывается,
+// неиспользованный остаток резерва освобождается. Списание проводится как транзакция withdrawal
+func (uc *BillingUseCase) Capture(ctx context.Context, req entity.CaptureRequest) (entity.CaptureResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
+	if err != nil {
+		return entity.CaptureResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	var hold entity.Hold
+	var transaction entity.Transaction
+	var expired bool
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		hold, err = uc.lockOwnHold(ctx, account.ID, req.HoldID)
+		if err != nil {
+			return err
+		}
+		if hold.Status != entity.HoldStatusActive {
+			return ErrHoldNotActive
+		}
+
+		// Холд мог истечь до того, как его освободил фоновый процесс
+		if !time.Now().Before(hold.ExpiresAt) {
+			expired = true
+			return uc.releaseHold(ctx, &hold, entity.HoldStatusExpired)
+		}
+
+		amount := req.Amount
+		if amount.IsZero() {
+			amount = hold.Amount
+		}
+		if err := validateAmount(amount); err != nil {
+			return err
+		}
+		if cmp, err := amount.Cmp(hold.Amount); err != nil || cmp > 0 {
+			return ErrHoldAmountExceeded
+		}
+
+		// Списание по холду заказа становится его оплатой, поэтому проверяется так же, как обычное
+		// списание: параллельную оплату того же заказа не даст зафиксировать уникальный индекс по order_id
+		if err := uc.checkOrderNotPaid(ctx, hold.OrderID); err != nil {
+			return err
+		}
+
+		// Снимаем резерв целиком и списываем фактическую сумму
+		if err := uc.repo.ReleaseFunds(ctx, account.ID, hold.Amount); err != nil {
+			return fmt.Errorf("ошибка при освобождении резерва: %w", err)
+		}
+		if err := uc.repo.UpdateBalance(ctx, account.ID, amount.Neg()); err != nil {
+			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+		}
+
+		transaction, err = uc.repo.CreateTransaction(ctx, entity.Transaction{
+			AccountID: account.ID,
+			Amount:    amount.Neg(), // Отрицательная сумма для снятия
+			Type:      entity.TransactionTypeWithdrawal,
+			Status:    entity.TransactionStatusSuccess,
+			OrderID:   hold.OrderID,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
+		}
+
+		if err := uc.postEntry(ctx, transaction, fmt.Sprintf("Списание по холду %d", hold.ID),
+			entity.UserLedgerAccountCode(account.ID), entity.LedgerAccountRevenue, amount); err != nil {
+			return err
+		}
+
+		transactionID := transaction.ID
+		hold.Status = entity.HoldStatusCaptured
+		hold.CapturedAmount = amount
+		hold.TransactionID = &transactionID
+		hold.UpdatedAt = time.Now()
+		if err := uc.repo.UpdateHold(ctx, hold); err != nil {
+			return fmt.Errorf("ошибка при обновлении холда: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return entity.CaptureResponse{}, err
+	}
+	if expired {
+		return entity.CaptureResponse{}, ErrHoldExpired
+	}
+
+	log.Printf("Списано %s по холду %d для пользователя %d", hold.CapturedAmount, hold.ID, account.UserID)
+
+	return entity.CaptureResponse{
+		Hold:        toHoldResponse(hold),
+		Transaction: toTransactionResponse(transaction),
+		Success:     true,
+	}, nil
+}
+
+// Void отменяет холд и освобождает резерв. Повторная отмена, как и отмена истекшего холда,
+// возвращает холд без изменений; отменить списанный холд нельзя
+func (uc *BillingUseCase) Void(ctx context.Context, userID, holdID uint) (entity.HoldResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.HoldResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	var hold entity.Hold
+
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		hold, err = uc.lockOwnHold(ctx, account.ID, holdID)
+		if err != nil {
+			return err
+		}
+
+		switch hold.Status {
+		case entity.HoldStatusActive:
+			return uc.releaseHold(ctx, &hold, entity.HoldStatusVoided)
+		case entity.HoldStatusVoided, entity.HoldStatusExpired:
+			return nil
+		default:
+			return ErrHoldNotActive
+		}
+	})
+	if err != nil {
+		return entity.HoldResponse{}, err
+	}
+
+	return toHoldResponse(hold), nil
+}
+
+// GetHold возвращает холд пользователя. Чужой холд не отличается от несуществующего
+func (uc *BillingUseCase) GetHold(ctx context.Context, userID, holdID uint) (entity.HoldResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.HoldResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	hold, err := uc.repo.GetHoldByID(ctx, holdID)
+	if err != nil || hold.AccountID != account.ID {
+		return entity.HoldResponse{}, ErrHoldNotFound
+	}
+
+	return toHoldResponse(hold), nil
+}
+
+// ExpireHolds освобождает резерв по активным холдам с истекшим сроком и возвращает их количество
+func (uc *BillingUseCase) ExpireHolds(ctx context.Context) (int, error) {
+	ids, err := uc.repo.ListExpiredHoldIDs(ctx, time.Now(), expireHoldsBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при поиске просроченных холдов: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		released := false
+		err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+			hold, err := uc.repo.LockHoldByID(ctx, id)
+			if err != nil {
+				return fmt.Errorf("ошибка при блокировке холда: %w", err)
+			}
+			// Холд могли списать или отменить после выборки
+			if hold.Status != entity.HoldStatusActive || time.Now().Before(hold.ExpiresAt) {
+				return nil
+			}
+			released = true
+			return uc.releaseHold(ctx, &hold, entity.HoldStatusExpired)
+		})
+		if err != nil {
+			return expired, fmt.Errorf("ошибка при освобождении холда %d: %w", id, err)
+		}
+		if released {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// RunHoldExpiry периодически освобождает просроченные холды до отмены контекста
+func (uc *BillingUseCase) RunHoldExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := uc.ExpireHolds(ctx)
+		if err != nil {
+			log.Printf("Ошибка при освобождении просроченных холдов: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Освобождено просроченных холдов: %d", expired)
+		}
+	}
+}
+
+// checkOrderNotPaid возвращает ErrOrderAlreadyPaid, если у заказа orderID уже есть успешное списание
+func (uc *BillingUseCase) checkOrderNotPaid(ctx context.Context, orderID *uint) error {
+	if orderID == nil {
+		return nil
+	}
+
+	paid, err := uc.repo.HasSuccessfulOrderPayment(ctx, *orderID)
+	if err != nil {
+		return fmt.Errorf("ошибка при проверке оплаты заказа: %w", err)
+	}
+	if paid {
+		return fmt.Errorf("%w: заказ %d", ErrOrderAlreadyPaid, *orderID)
+	}
+	return nil
+}
+
+// lockOwnHold блокирует холд и проверяет, что он принадлежит аккаунту
+func (uc *BillingUseCase) lockOwnHold(ctx context.Context, accountID, holdID uint) (entity.Hold, error) {
+	hold, err := uc.repo.LockHoldByID(ctx, holdID)
+	if err != nil || hold.AccountID != accountID {
+		return entity.Hold{}, ErrHoldNotFound
+	}
+	return hold, nil
+}
+
+// releaseHold освобождает резерв активного холда и переводит его в статус status
+func (uc *BillingUseCase) releaseHold(ctx context.Context, hold *entity.Hold, status string) error {
+	if err := uc.repo.ReleaseFunds(ctx, hold.AccountID, hold.Amount); err != nil {
+		return fmt.Errorf("ошибка при освобождении резерва: %w", err)
+	}
+
+	hold.Status = status
+	hold.UpdatedAt = time.Now()
+	if err := uc.repo.UpdateHold(ctx, *hold); err != nil {
+		return fmt.Errorf("ошибка при обновлении холда: %w", err)
+	}
+
+	return nil
+}
+
+func toHoldResponse(hold entity.Hold) entity.HoldResponse {
+	return entity.HoldResponse{
+		ID:             hold.ID,
+		AccountID:      hold.AccountID,
+		Amount:         hold.Amount,
+		CapturedAmount: hold.CapturedAmount,
+		Status:         hold.Status,
+		Description:    hold.Description,
+		TransactionID:  hold.TransactionID,
+		OrderID:        hold.OrderID,
+		ExpiresAt:      hold.ExpiresAt,
+		CreatedAt:      hold.CreatedAt,
+	}
+}
@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// postEntry записывает в журнал операцию транзакции: дебет счета debitCode и кредит счета creditCode
+// на одну сумму. Вызывается внутри WithTransaction вместе с изменением баланса аккаунта
+func (uc *BillingUseCase) postEntry(ctx context.Context, transaction entity.Transaction, description, debitCode, creditCode string, amount money.Money) error {
+	debitAccount, err := uc.repo.GetLedgerAccountByCode(ctx, debitCode)
+	if err != nil {
+		return fmt.Errorf("счет главной книги %s не найден: %w", debitCode, err)
+	}
+
+	creditAccount, err := uc.repo.GetLedgerAccountByCode(ctx, creditCode)
+	if err != nil {
+		return fmt.Errorf("счет главной книги %s не найден: %w", creditCode, err)
+	}
+
+	if amount.Currency() != debitAccount.Currency || amount.Currency() != creditAccount.Currency {
+		return fmt.Errorf("%w: валюта проводки %s не совпадает с валютой счетов", entity.ErrUnbalancedEntry, amount.Currency())
+	}
+
+	// Проводки датируются временем транзакции, чтобы выписка и остатки по книге совпадали
+	transactionID := transaction.ID
+	entry := entity.JournalEntry{
+		TransactionID: &transactionID,
+		Description:   description,
+		CreatedAt:     transaction.CreatedAt,
+		Postings: []entity.Posting{
+			{LedgerAccountID: debitAccount.ID, Direction: entity.PostingDebit, Amount: amount, CreatedAt: transaction.CreatedAt},
+			{LedgerAccountID: creditAccount.ID, Direction: entity.PostingCredit, Amount: amount, CreatedAt: transaction.CreatedAt},
+		},
+	}
+
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := uc.repo.CreateJournalEntry(ctx, entry); err != nil {
+		return fmt.Errorf("ошибка при создании записи журнала: %w", err)
+	}
+
+	return nil
+}
+
+// Reconcile сверяет главную книгу: сумма всех дебетовых проводок должна совпадать с суммой
+// кредитовых, а баланс каждого аккаунта - с остатком его счета в книге
+func (uc *BillingUseCase) Reconcile(ctx context.Context) (entity.ReconciliationReport, error) {
+	debit, credit, err := uc.repo.SumPostings(ctx)
+	if err != nil {
+		return entity.ReconciliationReport{}, fmt.Errorf("ошибка при подсчете оборотов главной книги: %w", err)
+	}
+
+	mismatches, err := uc.repo.ListBalanceMismatches(ctx)
+	if err != nil {
+		return entity.ReconciliationReport{}, fmt.Errorf("ошибка при сверке балансов: %w", err)
+	}
+
+	return entity.ReconciliationReport{
+		TotalDebit:  debit,
+		TotalCredit: credit,
+		Balanced:    debit.Equal(credit),
+		Mismatches:  mismatches,
+		CheckedAt:   time.Now(),
+	}, nil
+}
+
+// RunReconciliation периодически выполняет сверку и пишет расхождения в лог до отмены контекста
+func (uc *BillingUseCase) RunReconciliation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := uc.Reconcile(ctx)
+		switch {
+		case err != nil:
+			log.Printf("Ошибка при сверке главной книги: %v", err)
+		case !report.Balanced || len(report.Mismatches) > 0:
+			log.Printf("КРИТИЧЕСКАЯ ОШИБКА: главная книга не сходится: дебет %s, кредит %s, расхождений по аккаунтам: %d",
+				report.TotalDebit, report.TotalCredit, len(report.Mismatches))
+			for _, m := range report.Mismatches {
+				log.Printf("Расхождение по аккаунту %d (пользователь %d): баланс %s, по книге %s",
+					m.AccountID, m.UserID, m.Balance, m.LedgerBalance)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Команда devstack запускает сервисы заказов, биллинга и уведомлений в одном процессе на общем
+// брокере сообщений в памяти, RabbitMQ не нужен. Нужен PostgreSQL с базами всех трех сервисов.
+//
+//	go run ./cmd/devstack
+//	ORDER_PAYMENT_MODE=async go run ./cmd/devstack
+//
+// Остальные настройки задаются теми же переменными окружения, что и в сервисах. Порты и базы
+// задаются флагами: HTTP_PORT и POSTGRES_DB в одном процессе были бы общими для всех сервисов
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+
+	billingApp "github.com/director74/dz7_shop/billing-service/app"
+	billingConfig "github.com/director74/dz7_shop/billing-service/config"
+	notificationApp "github.com/director74/dz7_shop/notification-service/app"
+	notificationConfig "github.com/director74/dz7_shop/notification-service/config"
+	orderApp "github.com/director74/dz7_shop/order-service/app"
+	orderConfig "github.com/director74/dz7_shop/order-service/config"
+	"github.com/director74/dz7_shop/pkg/messaging"
+)
+
+// app приложение сервиса, работающее до сигнала завершения
+type app interface {
+	Run() error
+}
+
+func main() {
+	orderPort := flag.String("order-port", "8080", "порт сервиса заказов")
+	billingPort := flag.String("billing-port", "8081", "порт сервиса биллинга")
+	notificationPort := flag.String("notification-port", "8082", "порт сервиса уведомлений")
+	orderDB := flag.String("order-db", "orders", "база данных сервиса заказов")
+	billingDB := flag.String("billing-db", "billing", "база данных сервиса биллинга")
+	notificationDB := flag.String("notification-db", "notifications", "база данных сервиса уведомлений")
+	flag.Parse()
+
+	orderCfg, err := orderConfig.NewConfig()
+	if err != nil {
+		log.Fatalf("Ошибка при загрузке конфигурации сервиса заказов: %v", err)
+	}
+	billingCfg, err := billingConfig.NewConfig()
+	if err != nil {
+		log.Fatalf("Ошибка при загрузке конфигурации сервиса биллинга: %v", err)
+	}
+	notificationCfg, err := notificationConfig.NewConfig()
+	if err != nil {
+		log.Fatalf("Ошибка при загрузке конфигурации сервиса уведомлений: %v", err)
+	}
+
+	orderCfg.HTTP.Port, orderCfg.Postgres.DBName = *orderPort, *orderDB
+	billingCfg.HTTP.Port, billingCfg.Postgres.DBName = *billingPort, *billingDB
+	notificationCfg.HTTP.Port, notificationCfg.Postgres.DBName = *notificationPort, *notificationDB
+
+	// Сервисы обращаются друг к другу и к JWKS сервиса заказов по портам этого процесса
+	orderCfg.Services.BillingURL = "http://localhost:" + *billingPort
+	orderCfg.Services.NotificationURL = "http://localhost:" + *notificationPort
+	jwksURL := "http://localhost:" + *orderPort + "/.well-known/jwks.json"
+	billingCfg.JWT.JWKSURL, notificationCfg.JWT.JWKSURL = jwksURL, jwksURL
+
+	// Брокер общий, поэтому события одного сервиса получают обработчики остальных
+	orderCfg.RabbitMQ.Broker = messaging.BrokerMemory
+	broker, err := messaging.NewBroker(orderCfg.RabbitMQ)
+	if err != nil {
+		log.Fatalf("Ошибка при создании брокера сообщений: %v", err)
+	}
+	defer broker.Close()
+
+	// Приложения создаются до запуска, поэтому очереди всех сервисов объявлены до первой публикации
+	orders, err := orderApp.NewAppWithBroker(orderCfg, broker)
+	if err != nil {
+		log.Fatalf("Ошибка при создании сервиса заказов: %v", err)
+	}
+	billing, err := billingApp.NewAppWithBroker(billingCfg, broker)
+	if err != nil {
+		log.Fatalf("Ошибка при создании сервиса биллинга: %v", err)
+	}
+	notifications, err := notificationApp.NewAppWithBroker(notificationCfg, broker)
+	if err != nil {
+		log.Fatalf("Ошибка при создании сервиса уведомлений: %v", err)
+	}
+
+	// Каждое приложение завершается по SIGINT или SIGTERM, брокер закрывается после всех
+	var wg sync.WaitGroup
+	for _, a := range []app{orders, billing, notifications} {
+		wg.Add(1)
+		go func(a app) {
+			defer wg.Done()
+			if err := a.Run(); err != nil {
+				log.Printf("Ошибка при работе приложения: %v", err)
+			}
+		}(a)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,75 @@
+// Команда dlq просматривает, возвращает в обработку и очищает очереди недоставленных сообщений.
+//
+//	dlq list -queue order_billing_queue -limit 20
+//	dlq replay -queue order_billing_queue -limit 100
+//	dlq purge -queue order_billing_queue
+//
+// Подключение к RabbitMQ задается теми же переменными окружения RABBITMQ_*, что и в сервисах
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/config"
+	"github.com/director74/dz7_shop/pkg/messaging"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	queue := flags.String("queue", "", "имя основной очереди, например order_billing_queue")
+	limit := flags.Int("limit", 20, "максимальное количество сообщений")
+	flags.Parse(os.Args[2:])
+
+	if *queue == "" || *limit <= 0 {
+		usage()
+	}
+
+	cfg := config.LoadCommonConfig("dlq", "")
+	rmq, err := messaging.InitRabbitMQ(cfg.RabbitMQ)
+	if err != nil {
+		log.Fatalf("Не удалось подключиться к RabbitMQ: %v", err)
+	}
+	defer rmq.Close()
+
+	switch command {
+	case "list":
+		letters, err := rmq.ListDeadLetters(*queue, *limit)
+		if err != nil {
+			log.Fatalf("Ошибка при чтении очереди недоставленных сообщений: %v", err)
+		}
+		for _, letter := range letters {
+			fmt.Printf("message_id=%s exchange=%s routing_key=%s retries=%d failed_at=%s\n  error: %s\n  body: %s\n",
+				letter.MessageID, letter.Exchange, letter.RoutingKey, letter.RetryCount,
+				letter.FailedAt.Format(time.RFC3339), letter.LastError, letter.Body)
+		}
+		fmt.Printf("Сообщений: %d\n", len(letters))
+	case "replay":
+		replayed, err := rmq.ReplayDeadLetters(*queue, *limit)
+		if err != nil {
+			log.Fatalf("Возвращено в обработку %d сообщений, ошибка: %v", replayed, err)
+		}
+		fmt.Printf("Возвращено в обработку: %d\n", replayed)
+	case "purge":
+		purged, err := rmq.PurgeDeadLetters(*queue)
+		if err != nil {
+			log.Fatalf("Ошибка при очистке очереди недоставленных сообщений: %v", err)
+		}
+		fmt.Printf("Удалено сообщений: %d\n", purged)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Использование: dlq <list|replay|purge> -queue <очередь> [-limit N]")
+	os.Exit(2)
+}
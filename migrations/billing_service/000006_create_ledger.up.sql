@@ -0,0 +1,88 @@
+CREATE TABLE ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    account_id INTEGER,
+    normal_balance VARCHAR(10) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_ledger_accounts_account FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    CONSTRAINT chk_ledger_accounts_normal_balance CHECK (normal_balance IN ('debit', 'credit'))
+);
+
+CREATE UNIQUE INDEX idx_ledger_accounts_code ON ledger_accounts(code);
+CREATE UNIQUE INDEX idx_ledger_accounts_account_id ON ledger_accounts(account_id);
+
+CREATE TABLE journal_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_journal_entries_transaction FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
+
+CREATE INDEX idx_journal_entries_transaction_id ON journal_entries(transaction_id);
+
+CREATE TABLE postings (
+    id SERIAL PRIMARY KEY,
+    journal_entry_id INTEGER NOT NULL,
+    ledger_account_id INTEGER NOT NULL,
+    direction VARCHAR(10) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_postings_journal_entry FOREIGN KEY (journal_entry_id) REFERENCES journal_entries(id) ON DELETE CASCADE,
+    CONSTRAINT fk_postings_ledger_account FOREIGN KEY (ledger_account_id) REFERENCES ledger_accounts(id),
+    CONSTRAINT chk_postings_direction CHECK (direction IN ('debit', 'credit')),
+    CONSTRAINT chk_postings_amount_positive CHECK (amount > 0)
+);
+
+CREATE INDEX idx_postings_journal_entry_id ON postings(journal_entry_id);
+CREATE INDEX idx_postings_ledger_account_id ON postings(ledger_account_id);
+
+-- Системные счета
+INSERT INTO ledger_accounts (code, type, normal_balance) VALUES
+    ('system:external_funding', 'system', 'debit'),
+    ('system:revenue', 'system', 'credit'),
+    ('system:refunds', 'system', 'debit');
+
+-- Счета пользователей для уже существующих аккаунтов
+INSERT INTO ledger_accounts (code, type, account_id, normal_balance)
+SELECT 'user:' || id, 'user', id, 'credit' FROM accounts;
+
+-- Неуспешные списания хранятся с тем же знаком, что и успешные
+UPDATE transactions SET amount = -amount
+WHERE type = 'withdrawal' AND status = 'failed' AND amount > 0;
+
+-- Перенос успешных транзакций в журнал
+INSERT INTO journal_entries (transaction_id, description, created_at)
+SELECT id,
+       CASE type
+           WHEN 'deposit' THEN 'Пополнение баланса'
+           WHEN 'withdrawal' THEN 'Списание средств'
+           ELSE 'Возврат по транзакции ' || original_transaction_id
+       END,
+       created_at
+FROM transactions
+WHERE status = 'success' AND deleted_at IS NULL AND amount <> 0;
+
+-- Проводка по счету пользователя: списание уменьшает его остаток, пополнение и возврат увеличивают
+INSERT INTO postings (journal_entry_id, ledger_account_id, direction, amount, created_at)
+SELECT je.id, la.id,
+       CASE WHEN t.type = 'withdrawal' THEN 'debit' ELSE 'credit' END,
+       ABS(t.amount), t.created_at
+FROM journal_entries je
+JOIN transactions t ON t.id = je.transaction_id
+JOIN ledger_accounts la ON la.account_id = t.account_id;
+
+-- Встречная проводка по системному счету
+INSERT INTO postings (journal_entry_id, ledger_account_id, direction, amount, created_at)
+SELECT je.id, la.id,
+       CASE WHEN t.type = 'withdrawal' THEN 'credit' ELSE 'debit' END,
+       ABS(t.amount), t.created_at
+FROM journal_entries je
+JOIN transactions t ON t.id = je.transaction_id
+JOIN ledger_accounts la ON la.code = CASE t.type
+    WHEN 'deposit' THEN 'system:external_funding'
+    WHEN 'withdrawal' THEN 'system:revenue'
+    ELSE 'system:refunds'
+END;
@@ -0,0 +1,22 @@
+ALTER TABLE accounts ADD COLUMN held DECIMAL(12, 2) NOT NULL DEFAULT 0;
+
+ALTER TABLE accounts ADD CONSTRAINT chk_accounts_held_within_balance CHECK (held >= 0 AND held <= balance);
+
+CREATE TABLE holds (
+    id SERIAL PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    captured_amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    description VARCHAR(255),
+    transaction_id INTEGER,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_holds_account FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_holds_transaction FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+    CONSTRAINT chk_holds_amount_positive CHECK (amount > 0)
+);
+
+CREATE INDEX idx_holds_account_id ON holds(account_id);
+CREATE INDEX idx_holds_status_expires_at ON holds(status, expires_at);
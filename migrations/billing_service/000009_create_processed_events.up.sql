@@ -0,0 +1,14 @@
+CREATE TABLE processed_events (
+    consumer VARCHAR(100) NOT NULL,
+    event_id VARCHAR(64) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (consumer, event_id)
+);
+
+CREATE INDEX idx_processed_events_processed_at ON processed_events(processed_at);
+
+-- Списание в оплату заказа: по одному заказу допускается только одна успешная оплата
+ALTER TABLE transactions ADD COLUMN order_id INTEGER;
+
+CREATE UNIQUE INDEX idx_transactions_order_id_paid ON transactions(order_id)
+    WHERE type = 'withdrawal' AND status = 'success';
@@ -0,0 +1,17 @@
+-- Отозванные access токены, проверяются при каждом запросе до истечения токена
+CREATE TABLE revoked_tokens (
+    token_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+
+-- Выход из всех сессий: токены пользователя, выпущенные раньше revoked_before, недействительны
+CREATE TABLE revoked_user_tokens (
+    user_id INTEGER PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_revoked_user_tokens_expires_at ON revoked_user_tokens(expires_at);
@@ -0,0 +1,3 @@
+-- Системный счет для корректировок балансов администраторами
+INSERT INTO ledger_accounts (code, type, normal_balance) VALUES
+    ('system:adjustments', 'system', 'debit');
@@ -0,0 +1,2 @@
+-- Индекс для удаления устаревших ключей идемпотентности
+CREATE INDEX idx_idempotency_keys_updated_at ON idempotency_keys (updated_at);
@@ -0,0 +1,5 @@
+-- Заказ, оплату которого резервирует холд. Списание по холду получает тот же order_id,
+-- поэтому на него распространяется уникальный индекс idx_transactions_order_id_paid
+ALTER TABLE holds ADD COLUMN order_id INTEGER;
+
+CREATE INDEX idx_holds_order_id ON holds(order_id);
@@ -0,0 +1,8 @@
+CREATE TABLE processed_events (
+    consumer VARCHAR(100) NOT NULL,
+    event_id VARCHAR(64) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (consumer, event_id)
+);
+
+CREATE INDEX idx_processed_events_processed_at ON processed_events(processed_at);
@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN payment_transaction_id INTEGER;
@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN payment_hold_id INTEGER;
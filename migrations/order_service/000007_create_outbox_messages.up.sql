@@ -0,0 +1,16 @@
+CREATE TABLE outbox_messages (
+    id SERIAL PRIMARY KEY,
+    exchange VARCHAR(255) NOT NULL,
+    routing_key VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+
+-- Relay выбирает только неотправленные сообщения
+CREATE INDEX idx_outbox_messages_pending ON outbox_messages(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_outbox_messages_sent_at ON outbox_messages(sent_at);
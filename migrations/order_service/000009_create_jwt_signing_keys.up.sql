@@ -0,0 +1,7 @@
+-- Закрытые ключи подписи JWT (PKCS #8), открытые части публикуются в /.well-known/jwks.json
+CREATE TABLE jwt_signing_keys (
+    key_id VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
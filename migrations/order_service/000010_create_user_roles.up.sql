@@ -0,0 +1,8 @@
+-- Роли пользователей. Роль user есть у всех пользователей и не хранится, разрешения ролей
+-- задаются в коде и записываются в токен при его выпуске
+CREATE TABLE user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
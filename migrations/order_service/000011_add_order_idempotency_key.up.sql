@@ -0,0 +1,6 @@
+-- Ключ идемпотентности запроса, создавшего заказ. Повтор запроса с тем же ключом продолжает
+-- этот заказ, поэтому списание в биллинге выполняется с прежним ключом
+ALTER TABLE orders ADD COLUMN idempotency_key VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_orders_user_idempotency_key ON orders (user_id, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
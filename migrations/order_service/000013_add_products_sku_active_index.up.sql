@@ -0,0 +1,5 @@
+-- SKU уникален только среди неудаленных товаров: SKU удаленного товара можно использовать повторно,
+-- а сам удаленный товар остается в базе для истории заказов
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_sku_key;
+
+CREATE UNIQUE INDEX idx_products_sku ON products(sku) WHERE deleted_at IS NULL;
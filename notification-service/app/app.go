@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/notification-service/config"
+	httpController "github.com/director74/dz7_shop/notification-service/internal/controller/http"
+	"github.com/director74/dz7_shop/notification-service/internal/entity"
+	"github.com/director74/dz7_shop/notification-service/internal/repo"
+	"github.com/director74/dz7_shop/notification-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/database"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/outbox"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// App представляет приложение
+type App struct {
+	config      *config.Config
+	httpServer  *http.Server
+	db          *gorm.DB
+	router      *gin.Engine
+	broker      messaging.MessageBroker
+	outboxRelay *outbox.Relay
+	revocations *auth.PostgresRevocationStore
+	useCase     *usecase.NotificationUseCase
+	// closeBroker брокер создан приложением и закрывается при остановке
+	closeBroker bool
+}
+
+// NewApp создает приложение с брокером, выбранным настройкой MESSAGE_BROKER
+func NewApp(config *config.Config) (*App, error) {
+	// Инициализируем подключение к RabbitMQ
+	broker, err := messaging.NewBroker(config.RabbitMQ)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к брокеру сообщений")
+	}
+
+	app, err := NewAppWithBroker(config, broker)
+	if err != nil {
+		broker.Close()
+		return nil, err
+	}
+	app.closeBroker = true
+	return app, nil
+}
+
+// NewAppWithBroker создает приложение, которое публикует и получает события через broker.
+// Брокер закрывает вызывающий код, так сервисы запускаются в одном процессе на общем брокере в памяти
+func NewAppWithBroker(config *config.Config, broker messaging.MessageBroker) (*App, error) {
+	// Инициализируем подключение к PostgreSQL
+	db, err := database.NewPostgresDB(config.Postgres)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к базе данных")
+	}
+
+	// Автомиграция моделей
+	if err := database.AutoMigrateWithCleanup(db, &entity.Notification{}, &outbox.Message{}, &messaging.ProcessedEvent{},
+		&auth.RevokedToken{}, &auth.RevokedUserTokens{}); err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
+	}
+
+	// Инициализируем зависимости
+	notificationRepo := repo.NewNotificationRepository(db)
+
+	// Для тестирования используем DummyEmailSender
+	emailSender := usecase.NewDummyEmailSender()
+	// Для реального использования SMTP:
+	// emailSender := usecase.NewSmtpEmailSender(
+	//     config.Mail.SMTPHost,
+	//     config.Mail.SMTPPort,
+	//     config.Mail.SMTPUser,
+	//     config.Mail.SMTPPassword,
+	//     config.Mail.FromEmail,
+	// )
+	notificationUseCase := usecase.NewNotificationUseCase(notificationRepo, emailSender)
+
+	// Очереди объявляются при создании приложения, до того как другие сервисы начнут публиковать события
+	if err := SetupMessaging(broker, notificationUseCase); err != nil {
+		database.CloseDB(db)
+		return nil, err
+	}
+
+	// Отзывы токенов приходят из сервиса заказов событиями auth_events
+	revocations := auth.NewPostgresRevocationStore(db)
+	err = broker.ConsumeMessages("auth_notification_queue", "notification-service-auth", rabbitmq.ConsumerOptions{}, auth.HandleRevocationEvent(revocations))
+	if err != nil {
+		database.CloseDB(db)
+		return nil, errors.AppendPrefix(err, "ошибка при настройке обработчика отзыва токенов")
+	}
+
+	// Инициализируем Gin роутер
+	router := gin.Default()
+
+	// Добавляем middleware для обработки ошибок и восстановления после паники
+	router.Use(errors.RecoveryMiddleware())
+	router.Use(errors.ErrorMiddleware())
+
+	// Настраиваем обработчики для 404 и 405 ошибок
+	router.NoRoute(errors.NotFoundHandler())
+	router.NoMethod(errors.MethodNotAllowedHandler())
+
+	httpServer := &http.Server{
+		Addr:         ":" + config.HTTP.Port,
+		Handler:      router,
+		ReadTimeout:  config.HTTP.ReadTimeout,
+		WriteTimeout: config.HTTP.WriteTimeout,
+	}
+
+	return &App{
+		config:      config,
+		httpServer:  httpServer,
+		db:          db,
+		router:      router,
+		broker:      broker,
+		outboxRelay: outbox.NewRelay(db, broker, config.Outbox),
+		revocations: revocations,
+		useCase:     notificationUseCase,
+	}, nil
+}
+
+// Run запускает приложение
+func (a *App) Run() error {
+	// Настраиваем обработку сигналов завершения
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Инициализируем JWT менеджер. Сервис только проверяет токены, поэтому для RS256 и EdDSA
+	// использует открытые ключи из JWKS сервиса заказов и не хранит секрета подписи
+	signingMethod, err := auth.SigningMethod(a.config.JWT.Algorithm)
+	if err != nil {
+		return err
+	}
+	jwtConfig := &auth.Config{
+		SigningKey:     a.config.JWT.SigningKey,
+		SigningMethod:  signingMethod,
+		TokenTTL:       a.config.JWT.TokenTTL,
+		TokenIssuer:    a.config.JWT.TokenIssuer,
+		TokenAudiences: a.config.JWT.TokenAudiences,
+	}
+	if a.config.JWT.Algorithm != auth.AlgorithmHS256 {
+		jwtConfig.Keys = auth.NewJWKSClient(a.config.JWT.JWKSURL, a.config.JWT.JWKSCacheTTL)
+	}
+	jwtManager := auth.NewJWTManager(jwtConfig)
+	authMiddleware := auth.NewAuthMiddleware(jwtManager, a.revocations)
+
+	// Регистрируем HTTP обработчики
+	notificationHandler := httpController.NewNotificationHandler(a.useCase, authMiddleware, a.broker)
+	notificationHandler.RegisterRoutes(a.router)
+
+	// Запускаем HTTP сервер в горутине
+	go func() {
+		log.Printf("HTTP сервер запущен на порту %s", a.config.HTTP.Port)
+		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка запуска HTTP сервера: %v", err)
+		}
+	}()
+
+	// Сервис пока не публикует событий, relay запущен, чтобы будущие события
+	// сохранялись в outbox так же, как в остальных сервисах
+	go a.outboxRelay.Run(ctx)
+
+	// Запускаем очистку inbox обработанных событий
+	go messaging.RunInboxCleanup(ctx, a.db, a.config.Inbox)
+
+	// Запускаем очистку истекших отзывов токенов
+	go auth.RunRevocationCleanup(ctx, a.revocations)
+
+	// Ожидаем сигнал завершения
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-quit:
+		log.Println("Получен сигнал завершения, закрываем приложение...")
+	case <-ctx.Done():
+		log.Println("Контекст завершен, закрываем приложение...")
+	}
+
+	return a.Shutdown()
+}
+
+// Shutdown корректно завершает работу приложения
+func (a *App) Shutdown() error {
+	errGroup := errors.NewErrorGroup()
+
+	// Закрываем HTTP сервер
+	if a.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := a.httpServer.Shutdown(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии HTTP сервера")
+		}
+	}
+
+	// Прекращаем получение сообщений и ждем обрабатываемые, затем закрываем RabbitMQ
+	if a.broker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.RabbitMQ.DrainTimeout)
+		defer cancel()
+
+		if err := a.broker.StopConsuming(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при остановке обработки сообщений")
+		}
+		if a.closeBroker {
+			a.broker.Close()
+		}
+	}
+
+	// Закрываем соединение с базой данных
+	if a.db != nil {
+		if err := database.CloseDB(a.db); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии соединения с базой данных")
+		}
+	}
+
+	if errGroup.HasErrors() {
+		errors.LogError(errGroup, "Shutdown")
+		return errGroup
+	}
+
+	log.Println("Приложение успешно завершено")
+	return nil
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"github.com/director74/dz7_shop/notification-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// SetupMessaging объявляет exchanges и очереди сервиса уведомлений и подписывает обработчики
+// событий заказов и биллинга. Та же топология используется сервисом уведомлений в памяти (notificationtest)
+func SetupMessaging(broker messaging.MessageBroker, notificationUseCase *usecase.NotificationUseCase) error {
+	// Настраиваем RabbitMQ
+	exchanges := map[string]string{
+		"order_events":   "topic",
+		"billing_events": "topic",
+		"auth_events":    "topic",
+	}
+	queues := map[string]map[string]string{
+		"auth_notification_queue": {
+			"auth_events": "auth.#",
+		},
+		"order_notification_queue": {
+			"order_events": "order.#",
+		},
+		"billing_notification_queue": {
+			"billing_events": "billing.#",
+		},
+	}
+
+	if err := messaging.SetupExchangesAndQueues(broker, exchanges, queues); err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке RabbitMQ")
+	}
+
+	// Настраиваем обработчик сообщений
+	err := broker.ConsumeMessages("order_notification_queue", "notification-service", rabbitmq.ConsumerOptions{}, notificationUseCase.HandleOrderEvent)
+	if err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке обработчика сообщений для заказов")
+	}
+
+	// Настраиваем обработчик сообщений от биллинга
+	err = broker.ConsumeMessages("billing_notification_queue", "notification-service-billing", rabbitmq.ConsumerOptions{}, notificationUseCase.HandleOrderEvent)
+	if err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке обработчика сообщений для биллинга")
+	}
+
+	return nil
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"log"
 
+	"github.com/director74/dz7_shop/notification-service/app"
 	"github.com/director74/dz7_shop/notification-service/config"
-	"github.com/director74/dz7_shop/notification-service/internal/app"
 )
 
 func main() {
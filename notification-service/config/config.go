@@ -9,7 +9,10 @@ type Config struct {
 	HTTP     config.HTTPConfig
 	Postgres config.PostgresConfig
 	RabbitMQ config.RabbitMQConfig
+	JWT      config.JWTConfig
 	Mail     MailConfig
+	Outbox   config.OutboxConfig
+	Inbox    config.InboxConfig
 }
 
 // MailConfig содержит настройки для отправки почты
@@ -37,10 +40,19 @@ func NewConfig() (*Config, error) {
 	commonConfig := config.LoadCommonConfig("notifications", "8082")
 	mailConfig := LoadMailConfig()
 
+	// Загружаем конфигурацию JWT
+	jwtConfig, err := config.LoadJWTConfig("microservices-auth")
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		HTTP:     commonConfig.HTTP,
 		Postgres: commonConfig.Postgres,
 		RabbitMQ: commonConfig.RabbitMQ,
+		JWT:      *jwtConfig,
 		Mail:     mailConfig,
+		Outbox:   *config.LoadOutboxConfig(),
+		Inbox:    *config.LoadInboxConfig(),
 	}, nil
 }
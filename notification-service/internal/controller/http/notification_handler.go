@@ -8,15 +8,21 @@ import (
 
 	"github.com/director74/dz7_shop/notification-service/internal/entity"
 	"github.com/director74/dz7_shop/notification-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/messaging"
 )
 
 type NotificationHandler struct {
 	notificationUseCase *usecase.NotificationUseCase
+	authMiddleware      *auth.AuthMiddleware
+	broker              messaging.ConnectionStatus
 }
 
-func NewNotificationHandler(notificationUseCase *usecase.NotificationUseCase) *NotificationHandler {
+func NewNotificationHandler(notificationUseCase *usecase.NotificationUseCase, authMiddleware *auth.AuthMiddleware, broker messaging.ConnectionStatus) *NotificationHandler {
 	return &NotificationHandler{
 		notificationUseCase: notificationUseCase,
+		authMiddleware:      authMiddleware,
+		broker:              broker,
 	}
 }
 
@@ -24,16 +30,23 @@ func (h *NotificationHandler) RegisterRoutes(router *gin.Engine) {
 	router.GET("/health", h.HealthCheck)
 
 	api := router.Group("/api/v1")
+	api.Use(h.authMiddleware.AuthRequired())
 	{
 		api.POST("/notifications", h.SendNotification)
 		api.GET("/notifications/:id", h.GetNotification)
-		api.GET("/users/:id/notifications", h.ListUserNotifications)
-		api.GET("/notifications", h.ListAllNotifications)
+		api.GET("/users/:id/notifications", h.authMiddleware.RequireOwner("id", auth.PermissionNotificationsReadAll), h.ListUserNotifications)
+		// Уведомления всех пользователей доступны только администраторам
+		api.GET("/notifications", h.authMiddleware.RequirePermission(auth.PermissionNotificationsReadAll), h.ListAllNotifications)
 	}
 }
 
+// HealthCheck возвращает 503, пока соединение с RabbitMQ не восстановлено
 func (h *NotificationHandler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if !h.broker.IsConnected() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "rabbitmq": h.broker.State()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "rabbitmq": h.broker.State()})
 }
 
 func (h *NotificationHandler) SendNotification(c *gin.Context) {
@@ -43,6 +56,12 @@ func (h *NotificationHandler) SendNotification(c *gin.Context) {
 		return
 	}
 
+	// Уведомление другому пользователю отправляется только с разрешением notifications:send_all
+	if !auth.CanAccess(c, req.UserID, auth.PermissionNotificationsSendAll) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "пользователь не найден"})
+		return
+	}
+
 	resp, err := h.notificationUseCase.SendNotification(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -60,9 +79,10 @@ func (h *NotificationHandler) GetNotification(c *gin.Context) {
 		return
 	}
 
+	// Чужое уведомление без разрешения notifications:read_all не отличается от несуществующего
 	resp, err := h.notificationUseCase.GetNotification(c.Request.Context(), uint(id))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	if err != nil || !auth.CanAccess(c, resp.UserID, auth.PermissionNotificationsReadAll) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "уведомление не найдено"})
 		return
 	}
 
@@ -2,73 +2,76 @@ package rabbitmq
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 
-	"github.com/director74/dz7_shop/notification-service/internal/entity"
 	"github.com/director74/dz7_shop/notification-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/messaging"
 	"github.com/director74/dz7_shop/pkg/rabbitmq"
 )
 
 type NotificationConsumer struct {
 	notificationUseCase *usecase.NotificationUseCase
-	rabbitMQ            *rabbitmq.RabbitMQ
+	broker              messaging.MessageBroker
 }
 
-func NewNotificationConsumer(notificationUseCase *usecase.NotificationUseCase, rabbitMQ *rabbitmq.RabbitMQ) *NotificationConsumer {
+func NewNotificationConsumer(notificationUseCase *usecase.NotificationUseCase, broker messaging.MessageBroker) *NotificationConsumer {
 	return &NotificationConsumer{
 		notificationUseCase: notificationUseCase,
-		rabbitMQ:            rabbitMQ,
+		broker:              broker,
 	}
 }
 
 // Setup настраивает обработчик событий
 func (c *NotificationConsumer) Setup(orderExch string) error {
 	// Объявляем exchange для заказов
-	err := c.rabbitMQ.DeclareExchange(orderExch, "topic")
+	err := c.broker.DeclareExchange(orderExch, "topic")
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении exchange для заказов: %w", err)
 	}
 
 	// Объявляем exchange для биллинга
-	err = c.rabbitMQ.DeclareExchange("billing_events", "topic")
+	err = c.broker.DeclareExchange("billing_events", "topic")
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении exchange для биллинга: %w", err)
 	}
 
 	// Объявляем очередь для заказов
-	orderQueue, err := c.rabbitMQ.DeclareQueueWithReturn("order_notifications")
+	orderQueue := "order_notifications"
+	err = c.broker.DeclareQueue(orderQueue)
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении очереди заказов: %w", err)
 	}
 
 	// Привязываем очередь заказов к exchange с ключом
-	err = c.rabbitMQ.BindQueue(orderQueue.Name, orderExch, "order.notification")
+	err = c.broker.BindQueue(orderQueue, orderExch, "order.notification")
 	if err != nil {
 		return fmt.Errorf("ошибка при привязке очереди заказов к exchange: %w", err)
 	}
 
 	// Объявляем очередь для пополнений баланса
-	depositQueue, err := c.rabbitMQ.DeclareQueueWithReturn("deposit_notifications")
+	depositQueue := "deposit_notifications"
+	err = c.broker.DeclareQueue(depositQueue)
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении очереди пополнений: %w", err)
 	}
 
 	// Привязываем очередь пополнений к exchange с ключом
-	err = c.rabbitMQ.BindQueue(depositQueue.Name, "billing_events", "billing.deposit")
+	err = c.broker.BindQueue(depositQueue, "billing_events", "billing.deposit")
 	if err != nil {
 		return fmt.Errorf("ошибка при привязке очереди пополнений к exchange: %w", err)
 	}
 
 	// Объявляем очередь для уведомлений о недостатке средств
-	insufficientFundsQueue, err := c.rabbitMQ.DeclareQueueWithReturn("insufficient_funds_notifications")
+	insufficientFundsQueue := "insufficient_funds_notifications"
+	err = c.broker.DeclareQueue(insufficientFundsQueue)
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении очереди недостатка средств: %w", err)
 	}
 
 	// Привязываем очередь недостатка средств к exchange с ключом
-	err = c.rabbitMQ.BindQueue(insufficientFundsQueue.Name, "billing_events", "billing.insufficient_funds")
+	err = c.broker.BindQueue(insufficientFundsQueue, "billing_events", "billing.insufficient_funds")
 	if err != nil {
 		return fmt.Errorf("ошибка при привязке очереди недостатка средств к exchange: %w", err)
 	}
@@ -78,17 +81,17 @@ func (c *NotificationConsumer) Setup(orderExch string) error {
 
 // StartConsuming начинает обработку сообщений
 func (c *NotificationConsumer) StartConsuming() error {
-	err := c.rabbitMQ.ConsumeMessages("order_notifications", "notification_service_orders", c.handleOrderNotification)
+	err := c.broker.ConsumeMessages("order_notifications", "notification_service_orders", rabbitmq.ConsumerOptions{}, c.handleOrderNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при начале обработки сообщений заказов: %w", err)
 	}
 
-	err = c.rabbitMQ.ConsumeMessages("deposit_notifications", "notification_service_deposits", c.handleDepositNotification)
+	err = c.broker.ConsumeMessages("deposit_notifications", "notification_service_deposits", rabbitmq.ConsumerOptions{}, c.handleDepositNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при начале обработки сообщений пополнений: %w", err)
 	}
 
-	err = c.rabbitMQ.ConsumeMessages("insufficient_funds_notifications", "notification_service_insufficient_funds", c.handleInsufficientFundsNotification)
+	err = c.broker.ConsumeMessages("insufficient_funds_notifications", "notification_service_insufficient_funds", rabbitmq.ConsumerOptions{}, c.handleInsufficientFundsNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при начале обработки сообщений о недостатке средств: %w", err)
 	}
@@ -97,17 +100,17 @@ func (c *NotificationConsumer) StartConsuming() error {
 }
 
 // handleOrderNotification обрабатывает уведомление о заказе
-func (c *NotificationConsumer) handleOrderNotification(body []byte) error {
-	var orderNotification entity.OrderNotification
+func (c *NotificationConsumer) handleOrderNotification(ctx context.Context, body []byte) error {
+	var orderNotification events.OrderNotification
 
-	err := json.Unmarshal(body, &orderNotification)
+	err := decodeEvent(body, events.TypeOrderNotification, &orderNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при десериализации сообщения о заказе: %w", err)
 	}
 
 	log.Printf("Получено уведомление о заказе: %+v", orderNotification)
 
-	err = c.notificationUseCase.ProcessOrderNotification(context.Background(), orderNotification)
+	err = c.notificationUseCase.ProcessOrderNotification(ctx, orderNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при обработке уведомления о заказе: %w", err)
 	}
@@ -117,17 +120,17 @@ func (c *NotificationConsumer) handleOrderNotification(body []byte) error {
 }
 
 // handleDepositNotification обрабатывает уведомление о пополнении баланса
-func (c *NotificationConsumer) handleDepositNotification(body []byte) error {
-	var depositNotification entity.DepositNotification
+func (c *NotificationConsumer) handleDepositNotification(ctx context.Context, body []byte) error {
+	var depositNotification events.Deposit
 
-	err := json.Unmarshal(body, &depositNotification)
+	err := decodeEvent(body, events.TypeDeposit, &depositNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при десериализации сообщения о пополнении: %w", err)
 	}
 
 	log.Printf("Получено уведомление о пополнении баланса: %+v", depositNotification)
 
-	err = c.notificationUseCase.ProcessDepositNotification(context.Background(), depositNotification)
+	err = c.notificationUseCase.ProcessDepositNotification(ctx, depositNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при обработке уведомления о пополнении: %w", err)
 	}
@@ -137,17 +140,17 @@ func (c *NotificationConsumer) handleDepositNotification(body []byte) error {
 }
 
 // handleInsufficientFundsNotification обрабатывает уведомление о недостатке средств
-func (c *NotificationConsumer) handleInsufficientFundsNotification(body []byte) error {
-	var insufficientFundsNotification entity.InsufficientFundsNotification
+func (c *NotificationConsumer) handleInsufficientFundsNotification(ctx context.Context, body []byte) error {
+	var insufficientFundsNotification events.InsufficientFunds
 
-	err := json.Unmarshal(body, &insufficientFundsNotification)
+	err := decodeEvent(body, events.TypeInsufficientFunds, &insufficientFundsNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при десериализации сообщения о недостатке средств: %w", err)
 	}
 
 	log.Printf("Получено уведомление о недостатке средств: %+v", insufficientFundsNotification)
 
-	err = c.notificationUseCase.ProcessInsufficientFundsNotification(context.Background(), insufficientFundsNotification)
+	err = c.notificationUseCase.ProcessInsufficientFundsNotification(ctx, insufficientFundsNotification)
 	if err != nil {
 		return fmt.Errorf("ошибка при обработке уведомления о недостатке средств: %w", err)
 	}
@@ -155,3 +158,15 @@ func (c *NotificationConsumer) handleInsufficientFundsNotification(body []byte)
 	log.Printf("Уведомление о недостатке средств успешно обработано")
 	return nil
 }
+
+// decodeEvent разбирает конверт события eventType версии 1 в event
+func decodeEvent(body []byte, eventType string, event events.Event) error {
+	envelope, err := events.Decode(body)
+	if err != nil {
+		return err
+	}
+	if !envelope.Is(eventType, 1) {
+		return envelope.Unsupported()
+	}
+	return envelope.DecodePayload(event)
+}
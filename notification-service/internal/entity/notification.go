@@ -52,35 +52,3 @@ type ListNotificationsResponse struct {
 	Notifications []GetNotificationResponse `json:"notifications"`
 	Total         int64                     `json:"total"`
 }
-
-// OrderNotification событие для уведомления о заказе (транспортная модель)
-type OrderNotification struct {
-	UserID  uint    `json:"user_id"`
-	Email   string  `json:"email"`
-	OrderID uint    `json:"order_id"`
-	Amount  float64 `json:"amount"`
-	Success bool    `json:"success"`
-}
-
-// DepositNotification событие для уведомления о пополнении баланса (транспортная модель)
-type DepositNotification struct {
-	Type          string  `json:"type"`
-	UserID        uint    `json:"user_id"`
-	TransactionID uint    `json:"transaction_id"`
-	Amount        float64 `json:"amount"`
-	OperationType string  `json:"operation_type"`
-	Status        string  `json:"status"`
-	Email         string  `json:"email"`
-}
-
-// InsufficientFundsNotification событие для уведомления о недостатке средств (транспортная модель)
-type InsufficientFundsNotification struct {
-	UserID        uint    `json:"user_id"`
-	TransactionID uint    `json:"transaction_id"`
-	Amount        float64 `json:"amount"`
-	Type          string  `json:"type"`
-	Status        string  `json:"status"`
-	Balance       float64 `json:"balance"`
-	Reason        string  `json:"reason"`
-	Email         string  `json:"email"`
-}
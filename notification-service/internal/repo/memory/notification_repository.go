@@ -0,0 +1,132 @@
+// Package memory хранилище сервиса уведомлений в памяти процесса для саги оплаты заказа
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/notification-service/internal/entity"
+)
+
+type memoryTxKey struct{}
+
+// NotificationRepository хранилище уведомлений и inbox обработанных событий в памяти.
+// Транзакции выполняются по очереди и откатываются при ошибке
+type NotificationRepository struct {
+	txMu sync.Mutex
+
+	mu            sync.Mutex
+	notifications []entity.Notification
+	processed     map[string]bool
+}
+
+func NewNotificationRepository() *NotificationRepository {
+	return &NotificationRepository{processed: make(map[string]bool)}
+}
+
+func (r *NotificationRepository) CreateNotification(_ context.Context, notification entity.Notification) (entity.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification.ID = uint(len(r.notifications) + 1)
+	r.notifications = append(r.notifications, notification)
+	return notification, nil
+}
+
+func (r *NotificationRepository) GetNotificationByID(_ context.Context, id uint) (entity.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, notification := range r.notifications {
+		if notification.ID == id {
+			return notification, nil
+		}
+	}
+	return entity.Notification{}, gorm.ErrRecordNotFound
+}
+
+func (r *NotificationRepository) UpdateNotificationStatus(_ context.Context, id uint, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.notifications {
+		if r.notifications[i].ID == id {
+			r.notifications[i].Status = status
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *NotificationRepository) ListNotificationsByUserID(_ context.Context, userID uint, limit, offset int) ([]entity.Notification, int64, error) {
+	return r.list(func(n entity.Notification) bool { return n.UserID == userID }, limit, offset)
+}
+
+func (r *NotificationRepository) ListAllNotifications(_ context.Context, limit, offset int) ([]entity.Notification, int64, error) {
+	return r.list(func(entity.Notification) bool { return true }, limit, offset)
+}
+
+// list возвращает уведомления, удовлетворяющие match, от новых к старым
+func (r *NotificationRepository) list(match func(entity.Notification) bool, limit, offset int) ([]entity.Notification, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []entity.Notification
+	for _, notification := range r.notifications {
+		if match(notification) {
+			result = append(result, notification)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID > result[j].ID })
+
+	total := int64(len(result))
+	if offset >= len(result) {
+		return nil, total, nil
+	}
+	result = result[offset:]
+	if limit > 0 && limit < len(result) {
+		result = result[:limit]
+	}
+	return result, total, nil
+}
+
+func (r *NotificationRepository) MarkEventProcessed(_ context.Context, consumer, eventID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := consumer + "/" + eventID
+	if r.processed[key] {
+		return false, nil
+	}
+	r.processed[key] = true
+	return true, nil
+}
+
+// WithTransaction выполняет fn в транзакции. Вложенный вызов выполняется в транзакции из контекста
+func (r *NotificationRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	r.mu.Lock()
+	notifications := append([]entity.Notification(nil), r.notifications...)
+	processed := make(map[string]bool, len(r.processed))
+	for key := range r.processed {
+		processed[key] = true
+	}
+	r.mu.Unlock()
+
+	err := fn(context.WithValue(ctx, memoryTxKey{}, true))
+	if err != nil {
+		r.mu.Lock()
+		r.notifications, r.processed = notifications, processed
+		r.mu.Unlock()
+	}
+	return err
+}
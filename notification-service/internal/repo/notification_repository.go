@@ -6,9 +6,14 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/director74/dz7_shop/notification-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/messaging"
 )
 
-// NotificationRepository доступ к хранилищу уведомлений
+// txKey ключ контекста, в котором хранится открытая транзакция базы данных
+type txKey struct{}
+
+// NotificationRepository доступ к хранилищу уведомлений.
+// Внутри WithTransaction все методы работают в транзакции, переданной через контекст
 type NotificationRepository struct {
 	db *gorm.DB
 }
@@ -19,19 +24,27 @@ func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
 	}
 }
 
+// conn возвращает транзакцию из контекста или общее подключение
+func (r *NotificationRepository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
 func (r *NotificationRepository) CreateNotification(ctx context.Context, notification entity.Notification) (entity.Notification, error) {
-	err := r.db.WithContext(ctx).Create(&notification).Error
+	err := r.conn(ctx).Create(&notification).Error
 	return notification, err
 }
 
 func (r *NotificationRepository) GetNotificationByID(ctx context.Context, id uint) (entity.Notification, error) {
 	var notification entity.Notification
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&notification).Error
+	err := r.conn(ctx).Where("id = ?", id).First(&notification).Error
 	return notification, err
 }
 
 func (r *NotificationRepository) UpdateNotificationStatus(ctx context.Context, id uint, status string) error {
-	return r.db.WithContext(ctx).Model(&entity.Notification{}).Where("id = ?", id).
+	return r.conn(ctx).Model(&entity.Notification{}).Where("id = ?", id).
 		Update("status", status).Error
 }
 
@@ -39,8 +52,8 @@ func (r *NotificationRepository) ListNotificationsByUserID(ctx context.Context,
 	var notifications []entity.Notification
 	var total int64
 
-	r.db.WithContext(ctx).Model(&entity.Notification{}).Where("user_id = ?", userID).Count(&total)
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&notifications).Error
+	r.conn(ctx).Model(&entity.Notification{}).Where("user_id = ?", userID).Count(&total)
+	err := r.conn(ctx).Where("user_id = ?", userID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&notifications).Error
 
 	return notifications, total, err
 }
@@ -49,8 +62,22 @@ func (r *NotificationRepository) ListAllNotifications(ctx context.Context, limit
 	var notifications []entity.Notification
 	var total int64
 
-	r.db.WithContext(ctx).Model(&entity.Notification{}).Count(&total)
-	err := r.db.WithContext(ctx).Limit(limit).Offset(offset).Order("created_at DESC").Find(&notifications).Error
+	r.conn(ctx).Model(&entity.Notification{}).Count(&total)
+	err := r.conn(ctx).Limit(limit).Offset(offset).Order("created_at DESC").Find(&notifications).Error
 
 	return notifications, total, err
 }
+
+// MarkEventProcessed отмечает событие обработанным потребителем consumer.
+// Возвращает false, если событие уже было обработано
+func (r *NotificationRepository) MarkEventProcessed(ctx context.Context, consumer, eventID string) (bool, error) {
+	return messaging.MarkProcessed(r.conn(ctx), consumer, eventID)
+}
+
+// WithTransaction выполняет функцию в транзакции базы данных. Контекст, переданный в fn,
+// содержит транзакцию, и вызовы репозитория с ним выполняются внутри нее
+func (r *NotificationRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
@@ -2,12 +2,12 @@ package usecase
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/director74/dz7_shop/notification-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
 )
 
 // NotificationRepository интерфейс для работы с хранилищем нотификаций
@@ -17,8 +17,13 @@ type NotificationRepository interface {
 	UpdateNotificationStatus(ctx context.Context, id uint, status string) error
 	ListNotificationsByUserID(ctx context.Context, userID uint, limit, offset int) ([]entity.Notification, int64, error)
 	ListAllNotifications(ctx context.Context, limit, offset int) ([]entity.Notification, int64, error)
+	MarkEventProcessed(ctx context.Context, consumer, eventID string) (bool, error)
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
+// eventsConsumer имя потребителя событий в inbox обработанных событий
+const eventsConsumer = "notification-service"
+
 // EmailSender интерфейс для отправки электронной почты
 type EmailSender interface {
 	SendEmail(to, subject, message string) error
@@ -75,16 +80,16 @@ func (uc *NotificationUseCase) SendNotification(ctx context.Context, req entity.
 	}, nil
 }
 
-func (uc *NotificationUseCase) ProcessOrderNotification(ctx context.Context, orderNotification entity.OrderNotification) error {
+func (uc *NotificationUseCase) ProcessOrderNotification(ctx context.Context, orderNotification events.OrderNotification) error {
 	var subject, message string
 
 	if orderNotification.Success {
 		subject = fmt.Sprintf("Заказ #%d успешно оформлен", orderNotification.OrderID)
-		message = fmt.Sprintf("Уважаемый клиент, ваш заказ #%d на сумму %.2f успешно оформлен. Спасибо за покупку!",
+		message = fmt.Sprintf("Уважаемый клиент, ваш заказ #%d на сумму %s успешно оформлен. Спасибо за покупку!",
 			orderNotification.OrderID, orderNotification.Amount)
 	} else {
 		subject = fmt.Sprintf("Проблема с заказом #%d", orderNotification.OrderID)
-		message = fmt.Sprintf("Уважаемый клиент, при оформлении заказа #%d на сумму %.2f возникла проблема. Пожалуйста, проверьте баланс вашего счета.",
+		message = fmt.Sprintf("Уважаемый клиент, при оформлении заказа #%d на сумму %s возникла проблема. Пожалуйста, проверьте баланс вашего счета.",
 			orderNotification.OrderID, orderNotification.Amount)
 	}
 
@@ -99,7 +104,43 @@ func (uc *NotificationUseCase) ProcessOrderNotification(ctx context.Context, ord
 	return err
 }
 
-func (uc *NotificationUseCase) ProcessDepositNotification(ctx context.Context, depositNotification entity.DepositNotification) error {
+// orderStatusTitles названия статусов заказа для текста уведомлений
+var orderStatusTitles = map[string]string{
+	"paid":      "оплачен",
+	"shipped":   "отправлен",
+	"delivered": "доставлен",
+	"completed": "завершен",
+	"canceled":  "отменен",
+	"failed":    "не оплачен",
+}
+
+func (uc *NotificationUseCase) ProcessOrderStatusChangedNotification(ctx context.Context, notification events.OrderStatusChanged) error {
+	if notification.Email == "" {
+		log.Printf("Пропускаем уведомление о смене статуса заказа #%d: не указан email", notification.OrderID)
+		return nil
+	}
+
+	title, ok := orderStatusTitles[notification.NewStatus]
+	if !ok {
+		title = notification.NewStatus
+	}
+
+	subject := fmt.Sprintf("Заказ #%d %s", notification.OrderID, title)
+	message := fmt.Sprintf("Уважаемый клиент, статус вашего заказа #%d на сумму %s изменен: %s.",
+		notification.OrderID, notification.Amount, title)
+
+	req := entity.SendNotificationRequest{
+		UserID:  notification.UserID,
+		Email:   notification.Email,
+		Subject: subject,
+		Message: message,
+	}
+
+	_, err := uc.SendNotification(ctx, req)
+	return err
+}
+
+func (uc *NotificationUseCase) ProcessDepositNotification(ctx context.Context, depositNotification events.Deposit) error {
 	// Используем email из сообщения или формируем заглушку
 	email := depositNotification.Email
 	if email == "" {
@@ -107,7 +148,7 @@ func (uc *NotificationUseCase) ProcessDepositNotification(ctx context.Context, d
 	}
 
 	subject := "Пополнение баланса"
-	message := fmt.Sprintf("Уважаемый клиент, ваш счет был пополнен на сумму %.2f. Текущая операция: %s.",
+	message := fmt.Sprintf("Уважаемый клиент, ваш счет был пополнен на сумму %s. Текущая операция: %s.",
 		depositNotification.Amount, depositNotification.OperationType)
 
 	req := entity.SendNotificationRequest{
@@ -121,7 +162,30 @@ func (uc *NotificationUseCase) ProcessDepositNotification(ctx context.Context, d
 	return err
 }
 
-func (uc *NotificationUseCase) ProcessInsufficientFundsNotification(ctx context.Context, notification entity.InsufficientFundsNotification) error {
+func (uc *NotificationUseCase) ProcessRefundNotification(ctx context.Context, notification events.Refund) error {
+	email := notification.Email
+	if email == "" {
+		email = fmt.Sprintf("user%d@example.com", notification.UserID)
+	}
+
+	subject := "Возврат средств"
+	message := fmt.Sprintf("Уважаемый клиент, на ваш счет возвращено %s.", notification.Amount)
+	if notification.Reason != "" {
+		message += fmt.Sprintf(" Причина: %s.", notification.Reason)
+	}
+
+	req := entity.SendNotificationRequest{
+		UserID:  notification.UserID,
+		Email:   email,
+		Subject: subject,
+		Message: message,
+	}
+
+	_, err := uc.SendNotification(ctx, req)
+	return err
+}
+
+func (uc *NotificationUseCase) ProcessInsufficientFundsNotification(ctx context.Context, notification events.InsufficientFunds) error {
 	// Используем email из уведомления
 	email := notification.Email
 
@@ -131,8 +195,8 @@ func (uc *NotificationUseCase) ProcessInsufficientFundsNotification(ctx context.
 	}
 
 	subject := "Недостаточно средств на вашем счете"
-	message := fmt.Sprintf("Уважаемый клиент, на вашем счете недостаточно средств для совершения операции на сумму %.2f. "+
-		"Текущий баланс: %.2f. Пожалуйста, пополните баланс для совершения покупок.",
+	message := fmt.Sprintf("Уважаемый клиент, на вашем счете недостаточно средств для совершения операции на сумму %s. "+
+		"Текущий баланс: %s. Пожалуйста, пополните баланс для совершения покупок.",
 		notification.Amount, notification.Balance)
 
 	req := entity.SendNotificationRequest{
@@ -213,50 +277,95 @@ func (uc *NotificationUseCase) ListAllNotifications(ctx context.Context, limit,
 	return response, nil
 }
 
-// HandleOrderEvent обрабатывает событие заказа из RabbitMQ
-func (uc *NotificationUseCase) HandleOrderEvent(data []byte) error {
-	log.Printf("Получено событие заказа: %s", string(data))
-
-	// Сначала пытаемся распознать тип события
-	var baseEvent struct {
-		Type string `json:"type"`
+// HandleOrderEvent обрабатывает событие заказа или биллинга из RabbitMQ. Событие известного типа
+// неподдерживаемой версии возвращается с ошибкой и после повторов попадает в очередь .dlq.
+// Уведомление сохраняется в одной транзакции с отметкой в inbox, поэтому повторно доставленное
+// событие не приводит к повторному уведомлению
+func (uc *NotificationUseCase) HandleOrderEvent(ctx context.Context, data []byte) error {
+	envelope, err := events.Decode(data)
+	if err != nil {
+		return fmt.Errorf("ошибка при разборе события: %w", err)
 	}
 
-	if err := json.Unmarshal(data, &baseEvent); err != nil {
-		return fmt.Errorf("ошибка при парсинге базового события: %w", err)
-	}
+	log.Printf("Получено событие %s v%d (%s, correlation_id=%s)",
+		envelope.Type, envelope.Version, envelope.EventID, envelope.CorrelationID)
+
+	return uc.repo.WithTransaction(events.WithCause(ctx, envelope), func(ctx context.Context) error {
+		created, err := uc.repo.MarkEventProcessed(ctx, eventsConsumer, envelope.EventID)
+		if err != nil {
+			return err
+		}
+		if !created {
+			log.Printf("Событие %s уже обработано, пропускаем", envelope.EventID)
+			return nil
+		}
+		return uc.handleEvent(ctx, envelope)
+	})
+}
+
+// handleEvent отправляет уведомление по событию в зависимости от его типа
+func (uc *NotificationUseCase) handleEvent(ctx context.Context, envelope events.Envelope) error {
+	switch envelope.Type {
+	case events.TypeOrderCreated, events.TypePaymentProcessed:
+		// Заказ еще не оплачен, уведомление будет отправлено по событию order.notification
+		return nil
+
+	case events.TypePaymentCompensation:
+		// Уведомление о возврате будет отправлено по событию billing.refund
+		return nil
+
+	case events.TypeOrderNotification:
+		if envelope.Version != 1 {
+			return envelope.Unsupported()
+		}
+		var event events.OrderNotification
+		if err := envelope.DecodePayload(&event); err != nil {
+			return err
+		}
+		return uc.ProcessOrderNotification(ctx, event)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	case events.TypeOrderStatusChanged:
+		if envelope.Version != 1 {
+			return envelope.Unsupported()
+		}
+		var event events.OrderStatusChanged
+		if err := envelope.DecodePayload(&event); err != nil {
+			return err
+		}
+		return uc.ProcessOrderStatusChangedNotification(ctx, event)
 
-	// В зависимости от типа события, обрабатываем его
-	switch {
-	case baseEvent.Type == "order.created" || baseEvent.Type == "":
-		// Событие создания заказа или без указанного типа (для обратной совместимости)
-		var orderEvent entity.OrderNotification
-		if err := json.Unmarshal(data, &orderEvent); err != nil {
-			return fmt.Errorf("ошибка при парсинге события создания заказа: %w", err)
+	case events.TypeDeposit:
+		if envelope.Version != 1 {
+			return envelope.Unsupported()
 		}
-		return uc.ProcessOrderNotification(ctx, orderEvent)
+		var event events.Deposit
+		if err := envelope.DecodePayload(&event); err != nil {
+			return err
+		}
+		return uc.ProcessDepositNotification(ctx, event)
 
-	case baseEvent.Type == "billing.deposit":
-		// Событие пополнения баланса
-		var depositEvent entity.DepositNotification
-		if err := json.Unmarshal(data, &depositEvent); err != nil {
-			return fmt.Errorf("ошибка при парсинге события пополнения баланса: %w", err)
+	case events.TypeRefund:
+		if envelope.Version != 1 {
+			return envelope.Unsupported()
+		}
+		var event events.Refund
+		if err := envelope.DecodePayload(&event); err != nil {
+			return err
 		}
-		return uc.ProcessDepositNotification(ctx, depositEvent)
+		return uc.ProcessRefundNotification(ctx, event)
 
-	case baseEvent.Type == "billing.insufficient_funds":
-		// Событие недостатка средств
-		var insufficientEvent entity.InsufficientFundsNotification
-		if err := json.Unmarshal(data, &insufficientEvent); err != nil {
-			return fmt.Errorf("ошибка при парсинге события недостатка средств: %w", err)
+	case events.TypeInsufficientFunds:
+		if envelope.Version != 1 {
+			return envelope.Unsupported()
+		}
+		var event events.InsufficientFunds
+		if err := envelope.DecodePayload(&event); err != nil {
+			return err
 		}
-		return uc.ProcessInsufficientFundsNotification(ctx, insufficientEvent)
+		return uc.ProcessInsufficientFundsNotification(ctx, event)
 
 	default:
-		log.Printf("Неизвестный тип события: %s, игнорируем", baseEvent.Type)
+		log.Printf("Неизвестный тип события: %s, игнорируем", envelope.Type)
 		return nil
 	}
 }
@@ -0,0 +1,45 @@
+// Package notificationtest запускает сервис уведомлений в памяти процесса: настоящий usecase и
+// обработчики очередей из app.SetupMessaging работают на переданном брокере, уведомления хранятся
+// в памяти. Нужен тестам, которые проверяют сагу оплаты заказа на всех трех сервисах в одном процессе
+package notificationtest
+
+import (
+	"context"
+
+	"github.com/director74/dz7_shop/notification-service/app"
+	"github.com/director74/dz7_shop/notification-service/internal/repo/memory"
+	"github.com/director74/dz7_shop/notification-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/messaging"
+)
+
+// maxNotifications максимальное количество уведомлений пользователя, которое возвращает Subjects
+const maxNotifications = 100
+
+// Service сервис уведомлений в памяти процесса
+type Service struct {
+	useCase *usecase.NotificationUseCase
+}
+
+// Start объявляет очереди сервиса уведомлений на broker и подписывает обработчики событий
+// заказов и биллинга
+func Start(broker messaging.MessageBroker) (*Service, error) {
+	notificationUseCase := usecase.NewNotificationUseCase(memory.NewNotificationRepository(), usecase.NewDummyEmailSender())
+	if err := app.SetupMessaging(broker, notificationUseCase); err != nil {
+		return nil, err
+	}
+	return &Service{useCase: notificationUseCase}, nil
+}
+
+// Subjects возвращает темы уведомлений, отправленных пользователю, от новых к старым
+func (s *Service) Subjects(ctx context.Context, userID uint) ([]string, error) {
+	list, err := s.useCase.ListUserNotifications(ctx, userID, maxNotifications, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]string, 0, len(list.Notifications))
+	for _, notification := range list.Notifications {
+		subjects = append(subjects, notification.Subject)
+	}
+	return subjects, nil
+}
@@ -0,0 +1,279 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/order-service/config"
+	httpController "github.com/director74/dz7_shop/order-service/internal/controller/http"
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/repo"
+	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/order-service/internal/usecase/webapi"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/database"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/idempotency"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/outbox"
+)
+
+// App представляет приложение
+type App struct {
+	config          *config.Config
+	httpServer      *http.Server
+	jwtManager      *auth.JWTManager
+	db              *gorm.DB
+	broker          messaging.MessageBroker
+	outboxRelay     *outbox.Relay
+	orderUseCase    *usecase.OrderUseCase
+	revocations     *auth.PostgresRevocationStore
+	idempotencyKeys *idempotency.GormStore
+	keySet          *auth.KeySet
+	// closeBroker брокер создан приложением и закрывается при остановке
+	closeBroker bool
+}
+
+// NewApp создает приложение с брокером, выбранным настройкой MESSAGE_BROKER
+func NewApp(config *config.Config) (*App, error) {
+	// Инициализируем подключение к RabbitMQ
+	broker, err := messaging.NewBroker(config.RabbitMQ)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к брокеру сообщений")
+	}
+
+	app, err := NewAppWithBroker(config, broker)
+	if err != nil {
+		broker.Close()
+		return nil, err
+	}
+	app.closeBroker = true
+	return app, nil
+}
+
+// NewAppWithBroker создает приложение, которое публикует и получает события через broker.
+// Брокер закрывает вызывающий код, так сервисы запускаются в одном процессе на общем брокере в памяти
+func NewAppWithBroker(config *config.Config, broker messaging.MessageBroker) (*App, error) {
+	// Инициализируем подключение к PostgreSQL
+	db, err := database.NewPostgresDB(config.Postgres)
+	if err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось подключиться к базе данных")
+	}
+
+	// Автомиграция моделей
+	if err := database.AutoMigrateWithCleanup(db, &entity.User{}, &entity.Order{}, &entity.OrderItem{}, &entity.Product{}, &idempotency.Record{}, &outbox.Message{},
+		&entity.RefreshToken{}, &auth.RevokedToken{}, &auth.RevokedUserTokens{}, &auth.StoredSigningKey{}, &entity.UserRole{}); err != nil {
+		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
+	}
+
+	// Инициализируем JWT менеджер
+	signingMethod, err := auth.SigningMethod(config.JWT.Algorithm)
+	if err != nil {
+		database.CloseDB(db)
+		return nil, err
+	}
+	jwtConfig := auth.NewConfig(
+		config.JWT.SigningKey,
+	)
+	jwtConfig.SigningMethod = signingMethod
+	jwtConfig.TokenTTL = config.JWT.TokenTTL
+	jwtConfig.RefreshTokenTTL = config.JWT.RefreshTokenTTL
+	jwtConfig.ServiceTokenTTL = config.JWT.ServiceTokenTTL
+	jwtConfig.TokenIssuer = config.JWT.TokenIssuer
+	jwtConfig.TokenAudiences = config.JWT.TokenAudiences
+
+	// Для RS256 и EdDSA сервис подписывает токены ключами из своей базы и публикует открытые ключи в JWKS
+	var keySet *auth.KeySet
+	if config.JWT.Algorithm != auth.AlgorithmHS256 {
+		keySet, err = auth.NewKeySet(context.Background(), auth.NewPostgresKeyStore(db), auth.KeySetConfig{
+			Algorithm:        config.JWT.Algorithm,
+			RotationInterval: config.JWT.KeyRotationInterval,
+			TokenTTL:         config.JWT.TokenTTL,
+		})
+		if err != nil {
+			database.CloseDB(db)
+			return nil, errors.AppendPrefix(err, "ошибка при загрузке ключей подписи JWT")
+		}
+		jwtConfig.Keys = keySet
+	}
+	jwtManager := auth.NewJWTManager(jwtConfig)
+
+	userRepo := repo.NewUserGormRepository(db)
+	orderRepo := repo.NewOrderRepository(db)
+	productRepo := repo.NewProductRepository(db)
+	sessionRepo := repo.NewSessionRepository(db)
+	roleRepo := repo.NewRoleRepository(db)
+
+	// Создаем клиент для биллинга. Сервис заказов сам подписывает токены, поэтому токен сервиса
+	// для внутренних эндпоинтов биллинга выпускает без запроса client credentials
+	billingClient := webapi.NewBillingClient(config.Services.BillingURL, auth.NewServiceTokenSource(jwtManager, "order-service"))
+
+	// Создаем middleware для аутентификации. Отозванные токены хранятся в базе сервиса
+	// и рассылаются остальным сервисам событиями auth_events
+	revocations := auth.NewPostgresRevocationStore(db)
+	authMiddleware := auth.NewAuthMiddleware(jwtManager, revocations)
+
+	// Повтор создания заказа с тем же Idempotency-Key возвращает сохраненный ответ
+	idempotencyKeys := idempotency.NewGormStore(db)
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyKeys)
+
+	authUseCase := usecase.NewAuthUseCase(userRepo, sessionRepo, roleRepo, jwtManager, billingClient, "auth_events",
+		config.Auth.ServiceClients)
+	if admin := config.Auth.Admin; admin.Password != "" {
+		err := authUseCase.BootstrapAdmin(context.Background(), entity.RegisterRequest{
+			Username: admin.Username,
+			Email:    admin.Email,
+			Password: admin.Password,
+		})
+		if err != nil {
+			database.CloseDB(db)
+			return nil, errors.AppendPrefix(err, "ошибка при создании администратора")
+		}
+	}
+	orderUseCase := usecase.NewOrderUseCase(orderRepo, userRepo, productRepo, billingClient, "order_events",
+		usecase.PaymentMode(config.Payment.Mode))
+	productUseCase := usecase.NewProductUseCase(productRepo)
+
+	if err := SetupMessaging(broker, orderUseCase); err != nil {
+		database.CloseDB(db)
+		return nil, err
+	}
+
+	authHandler := httpController.NewAuthHandler(authUseCase, authMiddleware, keySet)
+	orderHandler := httpController.NewOrderHandler(orderUseCase, authMiddleware, idempotencyMiddleware, broker)
+	productHandler := httpController.NewProductHandler(productUseCase, authMiddleware)
+
+	// Инициализируем Gin роутер
+	router := gin.Default()
+
+	// Добавляем middleware для обработки ошибок и восстановления после паники
+	router.Use(errors.RecoveryMiddleware())
+	router.Use(errors.ErrorMiddleware())
+
+	// Настраиваем обработчики для 404 и 405 ошибок
+	router.NoRoute(errors.NotFoundHandler())
+	router.NoMethod(errors.MethodNotAllowedHandler())
+
+	// Регистрируем эндпоинты
+	authHandler.RegisterRoutes(router)
+	orderHandler.RegisterRoutes(router)
+	productHandler.RegisterRoutes(router)
+
+	httpServer := &http.Server{
+		Addr:         ":" + config.HTTP.Port,
+		Handler:      router,
+		ReadTimeout:  config.HTTP.ReadTimeout,
+		WriteTimeout: config.HTTP.WriteTimeout,
+	}
+
+	return &App{
+		config:          config,
+		httpServer:      httpServer,
+		jwtManager:      jwtManager,
+		db:              db,
+		broker:          broker,
+		outboxRelay:     outbox.NewRelay(db, broker, config.Outbox),
+		orderUseCase:    orderUseCase,
+		revocations:     revocations,
+		idempotencyKeys: idempotencyKeys,
+		keySet:          keySet,
+	}, nil
+}
+
+// Run запускает приложение
+func (a *App) Run() error {
+	// Настраиваем обработку сигналов завершения
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Запускаем HTTP сервер в горутине
+	go func() {
+		log.Printf("HTTP сервер запущен на порту %s", a.config.HTTP.Port)
+		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка запуска HTTP сервера: %v", err)
+		}
+	}()
+
+	// Запускаем публикацию событий из outbox
+	go a.outboxRelay.Run(ctx)
+
+	// Запускаем повтор оплаты заказов, зависших в pending
+	if a.config.Payment.ReconcileInterval > 0 {
+		go a.orderUseCase.RunPendingReconciliation(ctx, a.config.Payment.ReconcileInterval, a.config.Payment.PendingTimeout)
+	}
+
+	// Запускаем очистку истекших отзывов токенов
+	go auth.RunRevocationCleanup(ctx, a.revocations)
+
+	// Запускаем удаление устаревших ключей идемпотентности
+	go idempotency.RunCleanup(ctx, a.idempotencyKeys)
+
+	// Запускаем ротацию ключей подписи JWT
+	if a.keySet != nil {
+		go a.keySet.RunRotation(ctx)
+	}
+
+	// Ожидаем сигнал завершения
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-quit:
+		log.Println("Получен сигнал завершения, закрываем приложение...")
+	case <-ctx.Done():
+		log.Println("Контекст завершен, закрываем приложение...")
+	}
+
+	return a.Shutdown()
+}
+
+// Shutdown корректно завершает работу приложения
+func (a *App) Shutdown() error {
+	errGroup := errors.NewErrorGroup()
+
+	// Закрываем HTTP сервер
+	if a.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := a.httpServer.Shutdown(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии HTTP сервера")
+		}
+	}
+
+	// Прекращаем получение сообщений и ждем обрабатываемые, затем закрываем RabbitMQ
+	if a.broker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.RabbitMQ.DrainTimeout)
+		defer cancel()
+
+		if err := a.broker.StopConsuming(ctx); err != nil {
+			errGroup.AddPrefix(err, "ошибка при остановке обработки сообщений")
+		}
+		if a.closeBroker {
+			a.broker.Close()
+		}
+	}
+
+	// Закрываем соединение с базой данных
+	if a.db != nil {
+		if err := database.CloseDB(a.db); err != nil {
+			errGroup.AddPrefix(err, "ошибка при закрытии соединения с базой данных")
+		}
+	}
+
+	if errGroup.HasErrors() {
+		errors.LogError(errGroup, "Shutdown")
+		return errGroup
+	}
+
+	log.Println("Приложение успешно завершено")
+	return nil
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	rabbitmqController "github.com/director74/dz7_shop/order-service/internal/controller/rabbitmq"
+	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/messaging"
+)
+
+// SetupMessaging объявляет exchanges и очереди сервиса заказов и подписывает обработчик
+// результатов оплаты. Та же топология используется сервисом заказов в памяти (ordertest)
+func SetupMessaging(broker messaging.MessageBroker, orderUseCase *usecase.OrderUseCase) error {
+	// Настраиваем exchanges и очереди в RabbitMQ
+	exchanges := map[string]string{
+		"order_events":   "topic",
+		"billing_events": "topic",
+		"auth_events":    "topic",
+	}
+	queues := map[string]map[string]string{
+		"order_payment_queue": {
+			"billing_events": "billing.payment_processed",
+		},
+	}
+
+	if err := messaging.SetupExchangesAndQueues(broker, exchanges, queues); err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке RabbitMQ")
+	}
+
+	// Результаты оплаты обрабатываются в любом режиме, чтобы заказы, созданные
+	// до переключения с async на sync, не зависли в статусе pending
+	paymentConsumer := rabbitmqController.NewPaymentConsumer(orderUseCase, broker)
+	if err := paymentConsumer.StartConsuming("order_payment_queue"); err != nil {
+		return errors.AppendPrefix(err, "ошибка при настройке обработчика сообщений")
+	}
+
+	return nil
+}
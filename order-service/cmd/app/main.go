@@ -3,8 +3,8 @@ package main
 import (
 	"log"
 
+	"github.com/director74/dz7_shop/order-service/app"
 	"github.com/director74/dz7_shop/order-service/config"
-	"github.com/director74/dz7_shop/order-service/internal/app"
 )
 
 func main() {
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/director74/dz7_shop/pkg/config"
 )
 
@@ -11,6 +15,36 @@ type Config struct {
 	RabbitMQ config.RabbitMQConfig
 	Services ServicesConfig
 	JWT      config.JWTConfig
+	Payment  PaymentConfig
+	Outbox   config.OutboxConfig
+	Auth     AuthConfig
+}
+
+// AuthConfig содержит настройки администратора и клиентов сервисов
+type AuthConfig struct {
+	// Admin учетные данные администратора, который создается при запуске сервиса.
+	// Пустой пароль отключает создание администратора
+	Admin AdminConfig
+	// ServiceClients client_id и client_secret сервисов, которым выдаются токены сервиса
+	ServiceClients map[string]string
+}
+
+// AdminConfig учетные данные администратора
+type AdminConfig struct {
+	Username string
+	Email    string
+	Password string
+}
+
+// PaymentConfig содержит настройки оплаты заказов
+type PaymentConfig struct {
+	// Mode режим оплаты: sync - HTTP-запрос к биллингу, async - сага через RabbitMQ,
+	// hold - резервирование при создании заказа и списание при отгрузке
+	Mode string
+	// PendingTimeout время, после которого заказ в pending без результата оплаты считается зависшим
+	PendingTimeout time.Duration
+	// ReconcileInterval период повтора оплаты зависших заказов, 0 отключает повтор
+	ReconcileInterval time.Duration
 }
 
 // ServicesConfig содержит настройки внешних сервисов
@@ -22,9 +56,22 @@ type ServicesConfig struct {
 func NewConfig() (*Config, error) {
 	// Загружаем общую конфигурацию
 	commonConfig := config.LoadCommonConfig("orders", "8080")
-	jwtConfig := config.LoadJWTConfig("microservices-auth")
+	jwtConfig, err := config.LoadJWTConfig("microservices-auth")
+	if err != nil {
+		return nil, err
+	}
 	servicesConfig := config.LoadServicesConfig()
 
+	paymentMode := config.GetEnv("ORDER_PAYMENT_MODE", "sync")
+	if paymentMode != "sync" && paymentMode != "async" && paymentMode != "hold" {
+		return nil, fmt.Errorf("некорректный ORDER_PAYMENT_MODE: %s (ожидается sync, async или hold)", paymentMode)
+	}
+
+	serviceClients, err := parseServiceClients(config.GetEnv("SERVICE_CLIENTS", ""))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		HTTP:     commonConfig.HTTP,
 		Postgres: commonConfig.Postgres,
@@ -34,5 +81,35 @@ func NewConfig() (*Config, error) {
 			NotificationURL: servicesConfig.NotificationURL,
 		},
 		JWT: *jwtConfig,
+		Payment: PaymentConfig{
+			Mode:              paymentMode,
+			PendingTimeout:    config.GetEnvAsDuration("PENDING_ORDER_TIMEOUT", time.Minute),
+			ReconcileInterval: config.GetEnvAsDuration("PENDING_ORDER_RECONCILE_INTERVAL", time.Minute),
+		},
+		Outbox: *config.LoadOutboxConfig(),
+		Auth: AuthConfig{
+			Admin: AdminConfig{
+				Username: config.GetEnv("ADMIN_USERNAME", "admin"),
+				Email:    config.GetEnv("ADMIN_EMAIL", "admin@example.com"),
+				Password: config.GetEnv("ADMIN_PASSWORD", ""),
+			},
+			ServiceClients: serviceClients,
+		},
 	}, nil
 }
+
+// parseServiceClients разбирает список клиентов сервисов в формате "client_id:client_secret,..."
+func parseServiceClients(value string) (map[string]string, error) {
+	clients := make(map[string]string)
+	for _, client := range strings.Split(value, ",") {
+		if client = strings.TrimSpace(client); client == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(client, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("некорректный клиент в SERVICE_CLIENTS: ожидается client_id:client_secret")
+		}
+		clients[id] = secret
+	}
+	return clients, nil
+}
@@ -1,29 +1,64 @@
 package http
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/repo"
 	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/auth"
 )
 
 type AuthHandler struct {
-	authUseCase *usecase.AuthUseCase
+	authUseCase    *usecase.AuthUseCase
+	authMiddleware *auth.AuthMiddleware
+	keySet         *auth.KeySet
 }
 
-func NewAuthHandler(authUseCase *usecase.AuthUseCase) *AuthHandler {
+// NewAuthHandler создает обработчик аутентификации. keySet nil, если токены подписываются HS256
+// и публиковать открытые ключи не нужно
+func NewAuthHandler(authUseCase *usecase.AuthUseCase, authMiddleware *auth.AuthMiddleware, keySet *auth.KeySet) *AuthHandler {
 	return &AuthHandler{
-		authUseCase: authUseCase,
+		authUseCase:    authUseCase,
+		authMiddleware: authMiddleware,
+		keySet:         keySet,
 	}
 }
 
 func (h *AuthHandler) RegisterRoutes(router *gin.Engine) {
-	auth := router.Group("/api/v1/auth")
+	// Открытые ключи, которыми остальные сервисы проверяют токены
+	if h.keySet != nil {
+		router.GET("/.well-known/jwks.json", auth.JWKSHandler(h.keySet))
+	}
+
+	authGroup := router.Group("/api/v1/auth")
+	{
+		authGroup.POST("/register", h.Register)
+		authGroup.POST("/login", h.Login)
+		authGroup.POST("/refresh", h.Refresh)
+		// Токены сервисов для внутренних эндпоинтов выдаются по client credentials
+		authGroup.POST("/token", h.ServiceToken)
+
+		// Выход требует действующего access токена
+		authorized := authGroup.Group("")
+		authorized.Use(h.authMiddleware.AuthRequired())
+		{
+			authorized.POST("/logout", h.Logout)
+			authorized.POST("/logout-all", h.LogoutAll)
+		}
+	}
+
+	// Назначение ролей доступно только администраторам
+	admin := router.Group("/api/v1/admin/users")
+	admin.Use(h.authMiddleware.AuthRequired(), h.authMiddleware.RequireRole(auth.RoleAdmin))
 	{
-		auth.POST("/register", h.Register)
-		auth.POST("/login", h.Login)
+		admin.GET("/:id/roles", h.GetUserRoles)
+		admin.PUT("/:id/roles", h.UpdateUserRoles)
 	}
 }
 
@@ -58,3 +93,122 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req entity.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.authUseCase.Refresh(c.Request.Context(), req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ServiceToken выдает токен сервиса по client_id и client_secret
+func (h *AuthHandler) ServiceToken(c *gin.Context) {
+	var req entity.ServiceTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.authUseCase.IssueServiceToken(req)
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidClient) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout завершает текущую сессию. Тело запроса необязательно
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req entity.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.authUseCase.Logout(c.Request.Context(), auth.GetUserID(c), auth.GetTokenID(c), auth.GetTokenExpiresAt(c), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll завершает все сессии пользователя
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	if err := h.authUseCase.LogoutAll(c.Request.Context(), auth.GetUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetUserRoles возвращает роли пользователя
+func (h *AuthHandler) GetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+		return
+	}
+
+	resp, err := h.authUseCase.GetUserRoles(c.Request.Context(), uint(userID))
+	if err != nil {
+		writeRolesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateUserRoles заменяет роли пользователя. Выпущенные ему access токены отзываются
+func (h *AuthHandler) UpdateUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+		return
+	}
+
+	var req entity.UpdateUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.authUseCase.SetUserRoles(c.Request.Context(), uint(userID), req)
+	if err != nil {
+		writeRolesError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func writeRolesError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repo.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrUnknownRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
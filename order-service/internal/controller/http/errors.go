@@ -0,0 +1,21 @@
+package http
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	pkgerrors "github.com/director74/dz7_shop/pkg/errors"
+)
+
+// writeError отправляет ошибку клиенту. Для ServiceError используется её HTTP-статус,
+// для остальных ошибок - переданный статус по умолчанию
+func writeError(c *gin.Context, defaultStatus int, err error) {
+	var se *pkgerrors.ServiceError
+	if errors.As(err, &se) {
+		c.JSON(se.Code, gin.H{"error": se.Message})
+		return
+	}
+
+	c.JSON(defaultStatus, gin.H{"error": err.Error()})
+}
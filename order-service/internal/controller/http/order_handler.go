@@ -1,7 +1,6 @@
 package http
 
 import (
-	"context"
 	"net/http"
 	"strconv"
 
@@ -10,17 +9,24 @@ import (
 	"github.com/director74/dz7_shop/order-service/internal/entity"
 	"github.com/director74/dz7_shop/order-service/internal/usecase"
 	"github.com/director74/dz7_shop/pkg/auth"
+	pkgerrors "github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/idempotency"
+	"github.com/director74/dz7_shop/pkg/messaging"
 )
 
 type OrderHandler struct {
-	orderUseCase   *usecase.OrderUseCase
-	authMiddleware *auth.AuthMiddleware
+	orderUseCase          *usecase.OrderUseCase
+	authMiddleware        *auth.AuthMiddleware
+	idempotencyMiddleware *idempotency.Middleware
+	broker                messaging.ConnectionStatus
 }
 
-func NewOrderHandler(orderUseCase *usecase.OrderUseCase, authMiddleware *auth.AuthMiddleware) *OrderHandler {
+func NewOrderHandler(orderUseCase *usecase.OrderUseCase, authMiddleware *auth.AuthMiddleware, idempotencyMiddleware *idempotency.Middleware, broker messaging.ConnectionStatus) *OrderHandler {
 	return &OrderHandler{
-		orderUseCase:   orderUseCase,
-		authMiddleware: authMiddleware,
+		orderUseCase:          orderUseCase,
+		authMiddleware:        authMiddleware,
+		idempotencyMiddleware: idempotencyMiddleware,
+		broker:                broker,
 	}
 }
 
@@ -36,15 +42,30 @@ func (h *OrderHandler) RegisterRoutes(router *gin.Engine) {
 		authorized := api.Group("")
 		authorized.Use(h.authMiddleware.AuthRequired())
 		{
-			authorized.POST("/orders", h.CreateOrder)
+			authorized.POST("/orders", h.idempotencyMiddleware.Handle(), h.CreateOrder)
 			authorized.GET("/orders/:id", h.GetOrder)
-			authorized.GET("/users/:id/orders", h.ListUserOrders)
+			authorized.POST("/orders/:id/cancel", h.CancelOrder)
+			authorized.GET("/users/:id/orders", h.authMiddleware.RequireOwner("id", auth.PermissionOrdersReadAll), h.ListUserOrders)
+		}
+
+		// Отгрузку, доставку и выполнение заказов отмечают сотрудники с разрешением orders:fulfil
+		fulfilment := api.Group("/orders/:id")
+		fulfilment.Use(h.authMiddleware.AuthRequired(), h.authMiddleware.RequirePermission(auth.PermissionOrdersFulfil))
+		{
+			fulfilment.POST("/ship", h.ShipOrder)
+			fulfilment.POST("/deliver", h.DeliverOrder)
+			fulfilment.POST("/complete", h.CompleteOrder)
 		}
 	}
 }
 
+// HealthCheck возвращает 503, пока соединение с RabbitMQ не восстановлено
 func (h *OrderHandler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if !h.broker.IsConnected() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "rabbitmq": h.broker.State()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "rabbitmq": h.broker.State()})
 }
 
 func (h *OrderHandler) CreateUser(c *gin.Context) {
@@ -76,20 +97,11 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 	req.UserID = userID
+	req.IdempotencyKey = c.GetHeader(idempotency.HeaderKey)
 
-	// Получаем JWT токен из контекста Gin
-	jwtToken, exists := c.Get("jwt_token")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
-		return
-	}
-
-	// Создаем новый контекст с JWT токеном
-	ctx := context.WithValue(c.Request.Context(), "jwt_token", jwtToken)
-
-	resp, err := h.orderUseCase.CreateOrder(ctx, req)
+	resp, err := h.orderUseCase.CreateOrder(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -104,12 +116,61 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 		return
 	}
 
-	// Здесь стоит добавить проверку, что заказ принадлежит текущему пользователю
-	// Но для простоты позволим любому авторизованному пользователю получить любой заказ
-
+	// Чужой заказ доступен только с разрешением orders:read_all и без него не отличается от несуществующего
 	resp, err := h.orderUseCase.GetOrder(c.Request.Context(), uint(id))
+	if err == nil && !auth.CanAccess(c, resp.UserID, auth.PermissionOrdersReadAll) {
+		err = pkgerrors.NewNotFoundError("Заказ", id)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ShipOrder переводит оплаченный заказ в статус shipped
+func (h *OrderHandler) ShipOrder(c *gin.Context) {
+	h.changeOrderStatus(c, entity.OrderStatusShipped)
+}
+
+// DeliverOrder переводит отправленный заказ в статус delivered
+func (h *OrderHandler) DeliverOrder(c *gin.Context) {
+	h.changeOrderStatus(c, entity.OrderStatusDelivered)
+}
+
+// CompleteOrder переводит доставленный заказ в статус completed
+func (h *OrderHandler) CompleteOrder(c *gin.Context) {
+	h.changeOrderStatus(c, entity.OrderStatusCompleted)
+}
+
+// CancelOrder отменяет заказ, ожидающий оплаты или уже оплаченный
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	h.changeOrderStatus(c, entity.OrderStatusCanceled)
+}
+
+func (h *OrderHandler) changeOrderStatus(c *gin.Context, status entity.OrderStatus) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID"})
+		return
+	}
+
+	// Отменить заказ может только владелец. Права на остальные переходы проверяет middleware маршрута
+	if status == entity.OrderStatusCanceled {
+		order, err := h.orderUseCase.GetOrder(c.Request.Context(), uint(id))
+		if err == nil && !auth.CanAccess(c, order.UserID, "") {
+			err = pkgerrors.NewNotFoundError("Заказ", id)
+		}
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	resp, err := h.orderUseCase.ChangeOrderStatus(c.Request.Context(), uint(id), status)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -124,13 +185,6 @@ func (h *OrderHandler) ListUserOrders(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, что пользователь запрашивает свои заказы
-	currentUserID := auth.GetUserID(c)
-	if currentUserID != uint(userID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "доступ запрещен"})
-		return
-	}
-
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
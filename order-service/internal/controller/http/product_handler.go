@@ -0,0 +1,126 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/auth"
+)
+
+type ProductHandler struct {
+	productUseCase *usecase.ProductUseCase
+	authMiddleware *auth.AuthMiddleware
+}
+
+func NewProductHandler(productUseCase *usecase.ProductUseCase, authMiddleware *auth.AuthMiddleware) *ProductHandler {
+	return &ProductHandler{
+		productUseCase: productUseCase,
+		authMiddleware: authMiddleware,
+	}
+}
+
+func (h *ProductHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api/v1")
+	{
+		// Публичные эндпоинты каталога
+		api.GET("/products", h.ListProducts)
+		api.GET("/products/:id", h.GetProduct)
+
+		// Управление каталогом доступно сотрудникам с разрешением catalog:write
+		authorized := api.Group("/products")
+		authorized.Use(h.authMiddleware.AuthRequired(), h.authMiddleware.RequirePermission(auth.PermissionCatalogWrite))
+		{
+			authorized.POST("", h.CreateProduct)
+			authorized.PUT("/:id", h.UpdateProduct)
+			authorized.DELETE("/:id", h.DeleteProduct)
+		}
+	}
+}
+
+func (h *ProductHandler) CreateProduct(c *gin.Context) {
+	var req entity.CreateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.productUseCase.CreateProduct(c.Request.Context(), req)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *ProductHandler) GetProduct(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID"})
+		return
+	}
+
+	resp, err := h.productUseCase.GetProduct(c.Request.Context(), uint(id))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListProducts возвращает каталог, по умолчанию только активные товары
+func (h *ProductHandler) ListProducts(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	activeOnly := c.DefaultQuery("active_only", "true") != "false"
+
+	resp, err := h.productUseCase.ListProducts(c.Request.Context(), activeOnly, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) UpdateProduct(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID"})
+		return
+	}
+
+	var req entity.UpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.productUseCase.UpdateProduct(c.Request.Context(), uint(id), req)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProductHandler) DeleteProduct(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID"})
+		return
+	}
+
+	if err := h.productUseCase.DeleteProduct(c.Request.Context(), uint(id)); err != nil {
+		writeError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
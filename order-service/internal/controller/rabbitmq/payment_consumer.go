@@ -0,0 +1,61 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// PaymentConsumer обрабатывает события биллинга о результатах оплаты заказов
+type PaymentConsumer struct {
+	orderUseCase *usecase.OrderUseCase
+	consumer     messaging.MessageConsumer
+}
+
+func NewPaymentConsumer(orderUseCase *usecase.OrderUseCase, consumer messaging.MessageConsumer) *PaymentConsumer {
+	return &PaymentConsumer{
+		orderUseCase: orderUseCase,
+		consumer:     consumer,
+	}
+}
+
+// StartConsuming начинает обработку сообщений из очереди результатов оплаты
+func (c *PaymentConsumer) StartConsuming(queueName string) error {
+	err := c.consumer.ConsumeMessages(queueName, "order-service-payments", rabbitmq.ConsumerOptions{}, c.handlePaymentProcessed)
+	if err != nil {
+		return fmt.Errorf("ошибка при начале обработки результатов оплаты: %w", err)
+	}
+
+	return nil
+}
+
+// handlePaymentProcessed обрабатывает событие billing.payment_processed
+func (c *PaymentConsumer) handlePaymentProcessed(ctx context.Context, body []byte) error {
+	envelope, err := events.Decode(body)
+	if err != nil {
+		return fmt.Errorf("ошибка при десериализации результата оплаты: %w", err)
+	}
+	if !envelope.Is(events.TypePaymentProcessed, 1) {
+		return envelope.Unsupported()
+	}
+
+	var event events.PaymentProcessed
+	if err := envelope.DecodePayload(&event); err != nil {
+		return err
+	}
+
+	log.Printf("Получен результат оплаты заказа (%s): %+v", envelope.EventID, event)
+
+	// События о результате заказа продолжают цепочку order.created -> billing.payment_processed
+	err = c.orderUseCase.HandlePaymentProcessed(events.WithCause(ctx, envelope), event)
+	if err != nil {
+		return fmt.Errorf("ошибка при обработке результата оплаты заказа %d: %w", event.OrderID, err)
+	}
+
+	return nil
+}
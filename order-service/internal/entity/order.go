@@ -1,7 +1,10 @@
 package entity
 
 import (
+	"errors"
 	"time"
+
+	"github.com/director74/dz7_shop/pkg/money"
 )
 
 // OrderStatus статус заказа
@@ -20,34 +23,46 @@ const (
 
 // OrderItem элемент заказа
 type OrderItem struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	OrderID   uint      `json:"order_id" gorm:"index"`
-	ProductID uint      `json:"product_id"`
-	Name      string    `json:"name"`
-	Price     float64   `json:"price"`
-	Quantity  int       `json:"quantity"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint        `json:"id" gorm:"primaryKey"`
+	OrderID   uint        `json:"order_id" gorm:"index"`
+	ProductID uint        `json:"product_id"`
+	Name      string      `json:"name"`
+	Price     money.Money `json:"price" gorm:"type:decimal(12,2);not null"`
+	Quantity  int         `json:"quantity"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
 }
 
 // Order хранит информацию о заказе клиента, его статусе и связанных товарах
 type Order struct {
-	ID        uint        `json:"id" gorm:"primaryKey"`
-	UserID    uint        `json:"user_id" gorm:"index"`
-	Items     []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
-	Amount    float64     `json:"amount"`
-	Status    OrderStatus `json:"status"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
-	DeletedAt *time.Time  `json:"-" gorm:"index"`
-	User      User        `json:"-" gorm:"foreignKey:UserID"`
+	ID                   uint        `json:"id" gorm:"primaryKey"`
+	UserID               uint        `json:"user_id" gorm:"index;uniqueIndex:idx_orders_user_idempotency_key,priority:1"`
+	Items                []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
+	Amount               money.Money `json:"amount" gorm:"type:decimal(12,2);not null"`
+	Status               OrderStatus `json:"status"`
+	PaymentTransactionID *uint       `json:"payment_transaction_id,omitempty"`
+	PaymentHoldID        *uint       `json:"payment_hold_id,omitempty"`
+	IdempotencyKey       *string     `json:"-" gorm:"size:255;uniqueIndex:idx_orders_user_idempotency_key,priority:2,where:idempotency_key IS NOT NULL"`
+	CreatedAt            time.Time   `json:"created_at"`
+	UpdatedAt            time.Time   `json:"updated_at"`
+	DeletedAt            *time.Time  `json:"-" gorm:"index"`
+	User                 User        `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// CreateOrderItemRequest позиция в запросе на создание заказа
+type CreateOrderItemRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Quantity  int  `json:"quantity" binding:"required,min=1"`
 }
 
-// CreateOrderRequest запрос на создание заказа
+// CreateOrderRequest запрос на создание заказа.
+// Стоимость рассчитывается по каталогу, Amount необязателен и служит для сверки с клиентом
 type CreateOrderRequest struct {
-	UserID uint        `json:"user_id"`
-	Items  []OrderItem `json:"items" binding:"required,min=1"`
-	Amount float64     `json:"amount" binding:"required,min=0"`
+	UserID uint                     `json:"user_id"`
+	Items  []CreateOrderItemRequest `json:"items" binding:"required,min=1,dive"`
+	Amount *money.Money             `json:"amount"`
+	// IdempotencyKey ключ из заголовка Idempotency-Key. Повтор с тем же ключом продолжает ранее созданный заказ
+	IdempotencyKey string `json:"-"`
 }
 
 // CreateOrderResponse ответ на запрос создания заказа
@@ -55,7 +70,7 @@ type CreateOrderResponse struct {
 	ID        uint        `json:"id"`
 	UserID    uint        `json:"user_id"`
 	Items     []OrderItem `json:"items"`
-	Amount    float64     `json:"amount"`
+	Amount    money.Money `json:"amount"`
 	Status    OrderStatus `json:"status"`
 	CreatedAt time.Time   `json:"created_at"`
 }
@@ -63,7 +78,8 @@ type CreateOrderResponse struct {
 type GetOrderResponse struct {
 	ID        uint        `json:"id"`
 	UserID    uint        `json:"user_id"`
-	Amount    float64     `json:"amount"`
+	Items     []OrderItem `json:"items"`
+	Amount    money.Money `json:"amount"`
 	Status    OrderStatus `json:"status"`
 	CreatedAt time.Time   `json:"created_at"`
 	UpdatedAt time.Time   `json:"updated_at"`
@@ -74,7 +90,27 @@ type ListOrdersResponse struct {
 	Total  int64              `json:"total"`
 }
 
+// PaymentResult результат списания или резервирования средств в биллинге.
+// При резервировании заполняется HoldID, при списании - TransactionID
+type PaymentResult struct {
+	Success       bool
+	TransactionID uint
+	HoldID        uint
+}
+
+// ErrPaymentHoldClosed ошибка, когда холд оплаты уже списан, отменен или истек
+var ErrPaymentHoldClosed = errors.New("резерв средств по заказу уже закрыт или истек")
+
+// RefundRequest запрос на возврат средств по списанию в биллинге
+type RefundRequest struct {
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	Reason        string      `json:"reason"`
+	Email         string      `json:"email,omitempty"`
+}
+
 type BillingRequest struct {
-	UserID uint    `json:"user_id"`
-	Amount float64 `json:"amount"`
+	UserID uint        `json:"user_id"`
+	Amount money.Money `json:"amount"`
 }
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// DefaultCurrency валюта каталога по умолчанию
+const DefaultCurrency = money.DefaultCurrency
+
+// Product товар каталога, цена которого используется при оформлении заказа
+type Product struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	SKU         string      `json:"sku" gorm:"size:64;not null;uniqueIndex:idx_products_sku,where:deleted_at IS NULL"`
+	Name        string      `json:"name" gorm:"size:255;not null"`
+	Description string      `json:"description" gorm:"type:text"`
+	Price       money.Money `json:"price" gorm:"type:decimal(12,2);not null"`
+	Currency    string      `json:"currency" gorm:"size:3;not null;default:'RUB'"`
+	Active      bool        `json:"active" gorm:"not null;default:true;index"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	// DeletedAt включает мягкое удаление: удаленный товар скрыт из каталога и заказов,
+	// но остается в базе для истории заказов
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// AfterFind восстанавливает валюту цены, которая хранится в отдельной колонке
+func (p *Product) AfterFind(tx *gorm.DB) error {
+	p.Price = p.Price.WithCurrency(p.Currency)
+	return nil
+}
+
+// CreateProductRequest запрос на создание товара
+type CreateProductRequest struct {
+	SKU         string      `json:"sku" binding:"required,max=64"`
+	Name        string      `json:"name" binding:"required,max=255"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	Currency    string      `json:"currency" binding:"omitempty,len=3"`
+	Active      *bool       `json:"active"`
+}
+
+// UpdateProductRequest запрос на изменение товара, пустые поля не изменяются
+type UpdateProductRequest struct {
+	Name        *string      `json:"name" binding:"omitempty,max=255"`
+	Description *string      `json:"description"`
+	Price       *money.Money `json:"price"`
+	Currency    *string      `json:"currency" binding:"omitempty,len=3"`
+	Active      *bool        `json:"active"`
+}
+
+type ProductResponse struct {
+	ID          uint        `json:"id"`
+	SKU         string      `json:"sku"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	Currency    string      `json:"currency"`
+	Active      bool        `json:"active"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+type ListProductsResponse struct {
+	Products []ProductResponse `json:"products"`
+	Total    int64             `json:"total"`
+}
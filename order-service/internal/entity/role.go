@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"time"
+)
+
+// UserRole роль, назначенная пользователю. Роль user есть у всех пользователей и не хранится
+type UserRole struct {
+	UserID    uint      `gorm:"primaryKey"`
+	Role      string    `gorm:"primaryKey;size:32"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
+
+// UpdateUserRolesRequest запрос на замену ролей пользователя
+type UpdateUserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// UserRolesResponse роли пользователя и разрешения, которые они дают
+type UserRolesResponse struct {
+	UserID      uint     `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"time"
+)
+
+// RefreshToken сохраненный refresh токен. Хранится только хеш токена. Токены, выданные
+// по цепочке обновлений от одного входа, имеют общий FamilyID
+type RefreshToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	FamilyID  string    `gorm:"size:64;not null;index"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RefreshRequest запрос на обновление пары токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest запрос на выход из текущей сессии. Если refresh токен передан,
+// отзывается и он вместе со всей цепочкой обновлений
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ServiceTokenRequest запрос токена сервиса по client credentials
+type ServiceTokenRequest struct {
+	GrantType    string `json:"grant_type" binding:"required,eq=client_credentials"`
+	ClientID     string `json:"client_id" binding:"required"`
+	ClientSecret string `json:"client_secret" binding:"required"`
+}
+
+// ServiceTokenResponse токен сервиса для запросов к внутренним эндпоинтам
+type ServiceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn время жизни токена в секундах
+	ExpiresIn int64 `json:"expires_in"`
+}
@@ -51,10 +51,13 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// LoginResponse ответ на запрос аутентификации
+// LoginResponse ответ на запрос аутентификации и обновления токенов
 type LoginResponse struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Token    string `json:"token"`
+	ID           uint   `json:"id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn время жизни access токена в секундах
+	ExpiresIn int64 `json:"expires_in"`
 }
@@ -0,0 +1,425 @@
+// Package memory хранилища сервиса заказов в памяти процесса для саги оплаты заказа
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/repo"
+	"github.com/director74/dz7_shop/pkg/events"
+)
+
+// errUniqueViolation ошибка нарушения уникального индекса, как ее вернула бы база данных
+var errUniqueViolation = errors.New("нарушение уникального индекса")
+
+// outboxMessage событие, сохраненное в outbox
+type outboxMessage struct {
+	exchange   string
+	routingKey string
+	envelope   events.Envelope
+}
+
+// memoryTx открытая транзакция: снимок заказов для отката и события outbox до фиксации
+type memoryTx struct {
+	snapshot map[uint]entity.Order
+	outbox   []outboxMessage
+}
+
+type memoryTxKey struct{}
+
+// OrderRepository хранилище заказов в памяти. Транзакции выполняются по очереди и
+// откатываются при ошибке, события outbox после фиксации передаются функции из OnCommit
+type OrderRepository struct {
+	txMu     sync.Mutex
+	mu       sync.Mutex
+	nextID   uint
+	orders   map[uint]entity.Order
+	onCommit func(exchange, routingKey string, envelope events.Envelope)
+}
+
+func NewOrderRepository() *OrderRepository {
+	return &OrderRepository{orders: make(map[uint]entity.Order)}
+}
+
+// OnCommit задает функцию, которая получает события outbox после фиксации транзакции,
+// как relay получает их из базы данных
+func (r *OrderRepository) OnCommit(fn func(exchange, routingKey string, envelope events.Envelope)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onCommit = fn
+}
+
+func (r *OrderRepository) Create(_ context.Context, order *entity.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if order.IdempotencyKey != nil {
+		for _, existing := range r.orders {
+			if existing.UserID == order.UserID && existing.IdempotencyKey != nil && *existing.IdempotencyKey == *order.IdempotencyKey {
+				return errUniqueViolation
+			}
+		}
+	}
+
+	r.nextID++
+	order.ID = r.nextID
+	for i := range order.Items {
+		r.nextID++
+		order.Items[i].ID = r.nextID
+		order.Items[i].OrderID = order.ID
+	}
+	r.orders[order.ID] = copyOrder(*order)
+	return nil
+}
+
+func (r *OrderRepository) GetByID(_ context.Context, id uint) (*entity.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, repo.ErrOrderNotFound
+	}
+	copied := copyOrder(order)
+	return &copied, nil
+}
+
+func (r *OrderRepository) GetByIdempotencyKey(_ context.Context, userID uint, key string) (*entity.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, order := range r.orders {
+		if order.UserID == userID && order.IdempotencyKey != nil && *order.IdempotencyKey == key {
+			copied := copyOrder(order)
+			return &copied, nil
+		}
+	}
+	return nil, repo.ErrOrderNotFound
+}
+
+func (r *OrderRepository) ListStalePendingOrders(_ context.Context, createdBefore time.Time, limit int) ([]*entity.Order, error) {
+	return r.filter(func(o entity.Order) bool {
+		return o.Status == entity.OrderStatusPending && o.PaymentTransactionID == nil &&
+			o.PaymentHoldID == nil && o.CreatedAt.Before(createdBefore)
+	}, limit, 0), nil
+}
+
+func (r *OrderRepository) GetByUserID(_ context.Context, userID uint, limit, offset int) ([]*entity.Order, error) {
+	return r.filter(func(o entity.Order) bool { return o.UserID == userID }, limit, offset), nil
+}
+
+func (r *OrderRepository) CountByUserID(_ context.Context, userID uint) (int64, error) {
+	return int64(len(r.filter(func(o entity.Order) bool { return o.UserID == userID }, 0, 0))), nil
+}
+
+func (r *OrderRepository) ListOrdersByUserID(ctx context.Context, userID uint, limit, offset int) ([]*entity.Order, int64, error) {
+	orders, _ := r.GetByUserID(ctx, userID, limit, offset)
+	total, _ := r.CountByUserID(ctx, userID)
+	return orders, total, nil
+}
+
+// filter возвращает заказы, удовлетворяющие match, в порядке создания
+func (r *OrderRepository) filter(match func(entity.Order) bool, limit, offset int) []*entity.Order {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []*entity.Order
+	for _, order := range r.orders {
+		if match(order) {
+			copied := copyOrder(order)
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	if offset >= len(result) {
+		return nil
+	}
+	result = result[offset:]
+	if limit > 0 && limit < len(result) {
+		result = result[:limit]
+	}
+	return result
+}
+
+func (r *OrderRepository) Update(_ context.Context, order *entity.Order) error {
+	return r.modify(order.ID, func(stored *entity.Order) error {
+		*stored = copyOrder(*order)
+		return nil
+	})
+}
+
+func (r *OrderRepository) UpdateStatus(_ context.Context, id uint, from, to entity.OrderStatus) error {
+	return r.modify(id, func(stored *entity.Order) error {
+		if stored.Status != from {
+			return repo.ErrOrderStatusChanged
+		}
+		stored.Status = to
+		stored.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *OrderRepository) SetPaymentTransactionID(_ context.Context, id uint, transactionID uint) error {
+	return r.modify(id, func(stored *entity.Order) error {
+		stored.PaymentTransactionID = &transactionID
+		return nil
+	})
+}
+
+func (r *OrderRepository) SetPaymentHoldID(_ context.Context, id uint, holdID uint) error {
+	return r.modify(id, func(stored *entity.Order) error {
+		stored.PaymentHoldID = &holdID
+		return nil
+	})
+}
+
+func (r *OrderRepository) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.orders, id)
+	return nil
+}
+
+// modify изменяет сохраненный заказ id функцией fn
+func (r *OrderRepository) modify(id uint, fn func(stored *entity.Order) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.orders[id]
+	if !ok {
+		return repo.ErrOrderNotFound
+	}
+	if err := fn(&stored); err != nil {
+		return err
+	}
+	r.orders[id] = stored
+	return nil
+}
+
+func (r *OrderRepository) AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	message := outboxMessage{exchange: exchange, routingKey: routingKey, envelope: envelope}
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		tx.outbox = append(tx.outbox, message)
+		return nil
+	}
+	r.relay([]outboxMessage{message})
+	return nil
+}
+
+// relay передает зафиксированные события outbox функции из OnCommit
+func (r *OrderRepository) relay(messages []outboxMessage) {
+	r.mu.Lock()
+	onCommit := r.onCommit
+	r.mu.Unlock()
+
+	if onCommit == nil {
+		return
+	}
+	for _, m := range messages {
+		onCommit(m.exchange, m.routingKey, m.envelope)
+	}
+}
+
+// WithTransaction выполняет fn в транзакции. Вложенный вызов выполняется в транзакции из контекста
+func (r *OrderRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		return fn(ctx)
+	}
+
+	r.txMu.Lock()
+	r.mu.Lock()
+	tx := &memoryTx{snapshot: make(map[uint]entity.Order, len(r.orders))}
+	for id, order := range r.orders {
+		tx.snapshot[id] = copyOrder(order)
+	}
+	r.mu.Unlock()
+
+	err := fn(context.WithValue(ctx, memoryTxKey{}, tx))
+	if err != nil {
+		r.mu.Lock()
+		r.orders = tx.snapshot
+		r.mu.Unlock()
+	}
+	r.txMu.Unlock()
+
+	if err == nil {
+		r.relay(tx.outbox)
+	}
+	return err
+}
+
+// copyOrder копирует заказ вместе с позициями, чтобы вызывающий код не менял хранилище
+func copyOrder(order entity.Order) entity.Order {
+	order.Items = append([]entity.OrderItem(nil), order.Items...)
+	return order
+}
+
+// UserRepository хранилище пользователей в памяти
+type UserRepository struct {
+	mu     sync.Mutex
+	nextID uint
+	users  map[uint]entity.User
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{users: make(map[uint]entity.User)}
+}
+
+func (r *UserRepository) Create(_ context.Context, user *entity.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Username == user.Username || existing.Email == user.Email {
+			return errUniqueViolation
+		}
+	}
+
+	r.nextID++
+	user.ID = r.nextID
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *UserRepository) GetByID(_ context.Context, id uint) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repo.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByEmail(_ context.Context, email string) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, repo.ErrUserNotFound
+}
+
+func (r *UserRepository) GetByUsername(_ context.Context, username string) (*entity.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, repo.ErrUserNotFound
+}
+
+func (r *UserRepository) Update(_ context.Context, user *entity.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *UserRepository) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+// ProductRepository каталог товаров в памяти
+type ProductRepository struct {
+	mu       sync.Mutex
+	nextID   uint
+	products map[uint]entity.Product
+}
+
+func NewProductRepository() *ProductRepository {
+	return &ProductRepository{products: make(map[uint]entity.Product)}
+}
+
+func (r *ProductRepository) Create(_ context.Context, product *entity.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	product.ID = r.nextID
+	r.products[product.ID] = *product
+	return nil
+}
+
+func (r *ProductRepository) GetByID(_ context.Context, id uint) (*entity.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, ok := r.products[id]
+	if !ok {
+		return nil, repo.ErrProductNotFound
+	}
+	return &product, nil
+}
+
+func (r *ProductRepository) GetByIDs(_ context.Context, ids []uint) ([]*entity.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []*entity.Product
+	for _, id := range ids {
+		if product, ok := r.products[id]; ok {
+			products = append(products, &product)
+		}
+	}
+	return products, nil
+}
+
+func (r *ProductRepository) GetBySKU(_ context.Context, sku string) (*entity.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, product := range r.products {
+		if product.SKU == sku {
+			return &product, nil
+		}
+	}
+	return nil, repo.ErrProductNotFound
+}
+
+func (r *ProductRepository) List(_ context.Context, activeOnly bool, limit, offset int) ([]*entity.Product, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []*entity.Product
+	for _, product := range r.products {
+		if !activeOnly || product.Active {
+			products = append(products, &product)
+		}
+	}
+	return products, int64(len(products)), nil
+}
+
+func (r *ProductRepository) Update(_ context.Context, product *entity.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.products[product.ID] = *product
+	return nil
+}
+
+func (r *ProductRepository) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.products, id)
+	return nil
+}
@@ -3,27 +3,44 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
 
 	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/outbox"
 )
 
 // OrderRepository интерфейс репозитория для работы с заказами
 type OrderRepository interface {
 	Create(ctx context.Context, order *entity.Order) error
 	GetByID(ctx context.Context, id uint) (*entity.Order, error)
+	GetByIdempotencyKey(ctx context.Context, userID uint, key string) (*entity.Order, error)
+	ListStalePendingOrders(ctx context.Context, createdBefore time.Time, limit int) ([]*entity.Order, error)
 	GetByUserID(ctx context.Context, userID uint, limit, offset int) ([]*entity.Order, error)
 	CountByUserID(ctx context.Context, userID uint) (int64, error)
 	Update(ctx context.Context, order *entity.Order) error
+	UpdateStatus(ctx context.Context, id uint, from, to entity.OrderStatus) error
+	SetPaymentTransactionID(ctx context.Context, id uint, transactionID uint) error
+	SetPaymentHoldID(ctx context.Context, id uint, holdID uint) error
 	Delete(ctx context.Context, id uint) error
 	ListOrdersByUserID(ctx context.Context, userID uint, limit, offset int) ([]*entity.Order, int64, error)
+	AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // ErrOrderNotFound ошибка, когда заказ не найден
 var ErrOrderNotFound = errors.New("заказ не найден")
 
-// OrderRepositoryImpl реализация репозитория заказов на GORM
+// ErrOrderStatusChanged ошибка, когда статус заказа был изменен параллельным запросом
+var ErrOrderStatusChanged = errors.New("статус заказа был изменен другим запросом")
+
+// txKey ключ контекста, в котором хранится открытая транзакция базы данных
+type txKey struct{}
+
+// OrderRepositoryImpl реализация репозитория заказов на GORM.
+// Внутри WithTransaction все методы работают в транзакции, переданной через контекст
 type OrderRepositoryImpl struct {
 	db *gorm.DB
 }
@@ -34,13 +51,51 @@ func NewOrderRepository(db *gorm.DB) OrderRepository {
 	}
 }
 
+// conn возвращает транзакцию из контекста или общее подключение
+func (r *OrderRepositoryImpl) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
+// Create сохраняет заказ вместе с его позициями в одной транзакции
 func (r *OrderRepositoryImpl) Create(ctx context.Context, order *entity.Order) error {
-	return r.db.WithContext(ctx).Create(order).Error
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items", "User").Create(order).Error; err != nil {
+			return err
+		}
+
+		if len(order.Items) == 0 {
+			return nil
+		}
+
+		for i := range order.Items {
+			order.Items[i].OrderID = order.ID
+		}
+
+		return tx.Create(&order.Items).Error
+	})
 }
 
 func (r *OrderRepositoryImpl) GetByID(ctx context.Context, id uint) (*entity.Order, error) {
 	var order entity.Order
-	result := r.db.WithContext(ctx).First(&order, id)
+	result := r.conn(ctx).Preload("Items").First(&order, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, result.Error
+	}
+	return &order, nil
+}
+
+// GetByIdempotencyKey возвращает заказ пользователя, созданный с ключом идемпотентности key
+func (r *OrderRepositoryImpl) GetByIdempotencyKey(ctx context.Context, userID uint, key string) (*entity.Order, error) {
+	var order entity.Order
+	result := r.conn(ctx).Preload("Items").
+		Where("user_id = ? AND idempotency_key = ?", userID, key).
+		First(&order)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrOrderNotFound
@@ -50,9 +105,28 @@ func (r *OrderRepositoryImpl) GetByID(ctx context.Context, id uint) (*entity.Ord
 	return &order, nil
 }
 
+// ListStalePendingOrders возвращает заказы в статусе pending без результата оплаты,
+// созданные раньше createdBefore, начиная с самых старых
+func (r *OrderRepositoryImpl) ListStalePendingOrders(ctx context.Context, createdBefore time.Time, limit int) ([]*entity.Order, error) {
+	var orders []*entity.Order
+	result := r.conn(ctx).
+		Preload("Items").
+		Where("status = ? AND payment_transaction_id IS NULL AND payment_hold_id IS NULL AND created_at < ?",
+			entity.OrderStatusPending, createdBefore).
+		Order("created_at").
+		Limit(limit).
+		Find(&orders)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return orders, nil
+}
+
 func (r *OrderRepositoryImpl) GetByUserID(ctx context.Context, userID uint, limit, offset int) ([]*entity.Order, error) {
 	var orders []*entity.Order
-	result := r.db.WithContext(ctx).
+	result := r.conn(ctx).
+		Preload("Items").
 		Where("user_id = ?", userID).
 		Limit(limit).
 		Offset(offset).
@@ -68,7 +142,7 @@ func (r *OrderRepositoryImpl) GetByUserID(ctx context.Context, userID uint, limi
 // CountByUserID подсчитывает количество заказов пользователя
 func (r *OrderRepositoryImpl) CountByUserID(ctx context.Context, userID uint) (int64, error) {
 	var count int64
-	result := r.db.WithContext(ctx).
+	result := r.conn(ctx).
 		Model(&entity.Order{}).
 		Where("user_id = ?", userID).
 		Count(&count)
@@ -81,12 +155,47 @@ func (r *OrderRepositoryImpl) CountByUserID(ctx context.Context, userID uint) (i
 
 // Update обновляет заказ
 func (r *OrderRepositoryImpl) Update(ctx context.Context, order *entity.Order) error {
-	return r.db.WithContext(ctx).Save(order).Error
+	return r.conn(ctx).Save(order).Error
+}
+
+// UpdateStatus меняет статус заказа, только если его текущий статус равен from
+func (r *OrderRepositoryImpl) UpdateStatus(ctx context.Context, id uint, from, to entity.OrderStatus) error {
+	result := r.conn(ctx).
+		Model(&entity.Order{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderStatusChanged
+	}
+	return nil
+}
+
+// SetPaymentTransactionID сохраняет ID транзакции списания в биллинге
+func (r *OrderRepositoryImpl) SetPaymentTransactionID(ctx context.Context, id uint, transactionID uint) error {
+	return r.conn(ctx).
+		Model(&entity.Order{}).
+		Where("id = ?", id).
+		Update("payment_transaction_id", transactionID).Error
+}
+
+// SetPaymentHoldID сохраняет ID холда, зарезервировавшего оплату заказа в биллинге
+func (r *OrderRepositoryImpl) SetPaymentHoldID(ctx context.Context, id uint, holdID uint) error {
+	return r.conn(ctx).
+		Model(&entity.Order{}).
+		Where("id = ?", id).
+		Update("payment_hold_id", holdID).Error
 }
 
 // Delete удаляет заказ
 func (r *OrderRepositoryImpl) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&entity.Order{}, id).Error
+	return r.conn(ctx).Delete(&entity.Order{}, id).Error
 }
 
 func (r *OrderRepositoryImpl) ListOrdersByUserID(ctx context.Context, userID uint, limit, offset int) ([]*entity.Order, int64, error) {
@@ -102,3 +211,17 @@ func (r *OrderRepositoryImpl) ListOrdersByUserID(ctx context.Context, userID uin
 
 	return orders, total, nil
 }
+
+// AddOutboxMessage сохраняет конверт события в outbox. Внутри WithTransaction событие будет
+// опубликовано, только если транзакция зафиксирована
+func (r *OrderRepositoryImpl) AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	return outbox.Add(r.conn(ctx), exchange, routingKey, envelope)
+}
+
+// WithTransaction выполняет функцию в транзакции базы данных. Контекст, переданный в fn,
+// содержит транзакцию, и вызовы репозитория с ним выполняются внутри нее
+func (r *OrderRepositoryImpl) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+)
+
+// ProductRepository интерфейс репозитория для работы с каталогом товаров
+type ProductRepository interface {
+	Create(ctx context.Context, product *entity.Product) error
+	GetByID(ctx context.Context, id uint) (*entity.Product, error)
+	GetByIDs(ctx context.Context, ids []uint) ([]*entity.Product, error)
+	GetBySKU(ctx context.Context, sku string) (*entity.Product, error)
+	List(ctx context.Context, activeOnly bool, limit, offset int) ([]*entity.Product, int64, error)
+	Update(ctx context.Context, product *entity.Product) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// ErrProductNotFound ошибка, когда товар не найден
+var ErrProductNotFound = errors.New("товар не найден")
+
+// ProductRepositoryImpl реализация репозитория товаров на GORM
+type ProductRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewProductRepository(db *gorm.DB) ProductRepository {
+	return &ProductRepositoryImpl{
+		db: db,
+	}
+}
+
+func (r *ProductRepositoryImpl) Create(ctx context.Context, product *entity.Product) error {
+	return r.db.WithContext(ctx).Create(product).Error
+}
+
+func (r *ProductRepositoryImpl) GetByID(ctx context.Context, id uint) (*entity.Product, error) {
+	var product entity.Product
+	result := r.db.WithContext(ctx).First(&product, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, result.Error
+	}
+	return &product, nil
+}
+
+// GetByIDs возвращает товары по списку ID, отсутствующие ID пропускаются
+func (r *ProductRepositoryImpl) GetByIDs(ctx context.Context, ids []uint) ([]*entity.Product, error) {
+	var products []*entity.Product
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	result := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&products)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return products, nil
+}
+
+// GetBySKU ищет неудаленный товар по SKU. SKU удаленного товара можно использовать повторно
+func (r *ProductRepositoryImpl) GetBySKU(ctx context.Context, sku string) (*entity.Product, error) {
+	var product entity.Product
+	result := r.db.WithContext(ctx).Where("sku = ?", sku).First(&product)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, result.Error
+	}
+	return &product, nil
+}
+
+// List возвращает страницу каталога и общее количество товаров
+func (r *ProductRepositoryImpl) List(ctx context.Context, activeOnly bool, limit, offset int) ([]*entity.Product, int64, error) {
+	var products []*entity.Product
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&entity.Product{})
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Limit(limit).Offset(offset).Order("id ASC").Find(&products).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return products, total, nil
+}
+
+// Update обновляет товар
+func (r *ProductRepositoryImpl) Update(ctx context.Context, product *entity.Product) error {
+	return r.db.WithContext(ctx).Save(product).Error
+}
+
+// Delete мягко удаляет товар: заполняет deleted_at
+func (r *ProductRepositoryImpl) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&entity.Product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
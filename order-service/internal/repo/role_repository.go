@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+)
+
+// RoleRepository интерфейс репозитория ролей пользователей
+type RoleRepository interface {
+	GetUserRoles(ctx context.Context, userID uint) ([]string, error)
+	AddUserRole(ctx context.Context, userID uint, role string) error
+	SetUserRoles(ctx context.Context, userID uint, roles []string) error
+}
+
+// RoleRepositoryImpl реализация репозитория ролей на GORM.
+// Внутри WithTransaction других репозиториев работает в транзакции, переданной через контекст
+type RoleRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &RoleRepositoryImpl{
+		db: db,
+	}
+}
+
+// conn возвращает транзакцию из контекста или общее подключение
+func (r *RoleRepositoryImpl) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
+func (r *RoleRepositoryImpl) GetUserRoles(ctx context.Context, userID uint) ([]string, error) {
+	var roles []string
+	err := r.conn(ctx).Model(&entity.UserRole{}).
+		Where("user_id = ?", userID).
+		Order("role").
+		Pluck("role", &roles).Error
+	return roles, err
+}
+
+// AddUserRole назначает роль, если она еще не назначена
+func (r *RoleRepositoryImpl) AddUserRole(ctx context.Context, userID uint, role string) error {
+	return r.conn(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&entity.UserRole{
+		UserID:    userID,
+		Role:      role,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// SetUserRoles заменяет роли пользователя на roles
+func (r *RoleRepositoryImpl) SetUserRoles(ctx context.Context, userID uint, roles []string) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&entity.UserRole{}).Error; err != nil {
+			return err
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		userRoles := make([]entity.UserRole, 0, len(roles))
+		for _, role := range roles {
+			userRoles = append(userRoles, entity.UserRole{UserID: userID, Role: role, CreatedAt: now})
+		}
+		return tx.Create(&userRoles).Error
+	})
+}
@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/outbox"
+)
+
+// SessionRepository интерфейс репозитория refresh токенов и отзыва access токенов
+type SessionRepository interface {
+	CreateRefreshToken(ctx context.Context, token *entity.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*entity.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id uint) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID uint) error
+	RevokeAccessToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) error
+	RevokeUserAccessTokens(ctx context.Context, userID uint, issuedBefore, expiresAt time.Time) error
+	AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ErrRefreshTokenNotFound ошибка, когда refresh токен не найден
+var ErrRefreshTokenNotFound = errors.New("refresh токен не найден")
+
+// SessionRepositoryImpl реализация репозитория сессий на GORM.
+// Внутри WithTransaction все методы работают в транзакции, переданной через контекст
+type SessionRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &SessionRepositoryImpl{
+		db: db,
+	}
+}
+
+// conn возвращает транзакцию из контекста или общее подключение
+func (r *SessionRepositoryImpl) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
+func (r *SessionRepositoryImpl) CreateRefreshToken(ctx context.Context, token *entity.RefreshToken) error {
+	return r.conn(ctx).Create(token).Error
+}
+
+func (r *SessionRepositoryImpl) GetRefreshToken(ctx context.Context, tokenHash string) (*entity.RefreshToken, error) {
+	var token entity.RefreshToken
+	result := r.conn(ctx).Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// RevokeRefreshToken отзывает refresh токен, если он еще не отозван. Возвращает false, если токен
+// уже был отозван, в том числе параллельным запросом
+func (r *SessionRepositoryImpl) RevokeRefreshToken(ctx context.Context, id uint) (bool, error) {
+	result := r.conn(ctx).Model(&entity.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeRefreshTokenFamily отзывает все refresh токены цепочки обновлений
+func (r *SessionRepositoryImpl) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return r.conn(ctx).Model(&entity.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserRefreshTokens отзывает все refresh токены пользователя
+func (r *SessionRepositoryImpl) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	return r.conn(ctx).Model(&entity.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *SessionRepositoryImpl) RevokeAccessToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) error {
+	return auth.RevokeToken(r.conn(ctx), tokenID, userID, expiresAt)
+}
+
+func (r *SessionRepositoryImpl) RevokeUserAccessTokens(ctx context.Context, userID uint, issuedBefore, expiresAt time.Time) error {
+	return auth.RevokeUserTokens(r.conn(ctx), userID, issuedBefore, expiresAt)
+}
+
+// AddOutboxMessage сохраняет конверт события в outbox. Внутри WithTransaction событие будет
+// опубликовано, только если транзакция зафиксирована
+func (r *SessionRepositoryImpl) AddOutboxMessage(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	return outbox.Add(r.conn(ctx), exchange, routingKey, envelope)
+}
+
+// WithTransaction выполняет функцию в транзакции базы данных. Контекст, переданный в fn,
+// содержит транзакцию, и вызовы репозитория с ним выполняются внутри нее
+func (r *SessionRepositoryImpl) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
@@ -2,13 +2,18 @@ package usecase
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"time"
 
 	"github.com/director74/dz7_shop/order-service/internal/entity"
 	"github.com/director74/dz7_shop/order-service/internal/repo"
 	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/events"
 )
 
 // ErrInvalidCredentials ошибка при неверных учетных данных
@@ -17,18 +22,42 @@ var ErrInvalidCredentials = errors.New("неверные учетные данн
 // ErrUserAlreadyExists ошибка, когда пользователь уже существует
 var ErrUserAlreadyExists = errors.New("пользователь с таким email или username уже существует")
 
+// ErrInvalidRefreshToken ошибка, когда refresh токен не найден, истек или отозван
+var ErrInvalidRefreshToken = errors.New("недействительный refresh токен")
+
+// ErrUnknownRole ошибка, когда назначаемая роль не существует
+var ErrUnknownRole = errors.New("неизвестная роль")
+
+// ErrInvalidClient ошибка, когда клиент сервиса не найден или секрет неверный
+var ErrInvalidClient = errors.New("неверные учетные данные клиента")
+
+// errRefreshTokenReused refresh токен уже был обменян параллельным запросом
+var errRefreshTokenReused = errors.New("refresh токен уже использован")
+
 // AuthUseCase сервис аутентификации
 type AuthUseCase struct {
-	userRepo   repo.UserRepository
-	jwtManager *auth.JWTManager
-	billing    BillingService
+	userRepo       repo.UserRepository
+	sessions       repo.SessionRepository
+	roles          repo.RoleRepository
+	jwtManager     *auth.JWTManager
+	billing        BillingService
+	authExch       string
+	serviceClients map[string]string
 }
 
-func NewAuthUseCase(userRepo repo.UserRepository, jwtManager *auth.JWTManager, billing BillingService) *AuthUseCase {
+// NewAuthUseCase создает usecase аутентификации. События об отзыве токенов публикуются
+// в exchange authExch через outbox, чтобы остальные сервисы тоже перестали их принимать.
+// Сервисы из serviceClients (client_id -> client_secret) получают токены сервиса
+func NewAuthUseCase(userRepo repo.UserRepository, sessions repo.SessionRepository, roles repo.RoleRepository, jwtManager *auth.JWTManager,
+	billing BillingService, authExch string, serviceClients map[string]string) *AuthUseCase {
 	return &AuthUseCase{
-		userRepo:   userRepo,
-		jwtManager: jwtManager,
-		billing:    billing,
+		userRepo:       userRepo,
+		sessions:       sessions,
+		roles:          roles,
+		jwtManager:     jwtManager,
+		billing:        billing,
+		authExch:       authExch,
+		serviceClients: serviceClients,
 	}
 }
 
@@ -79,7 +108,7 @@ func (uc *AuthUseCase) Register(ctx context.Context, req entity.RegisterRequest)
 	}, nil
 }
 
-// Login аутентифицирует пользователя и возвращает JWT токен
+// Login аутентифицирует пользователя и возвращает access токен и refresh токен новой сессии
 func (uc *AuthUseCase) Login(ctx context.Context, req entity.LoginRequest) (*entity.LoginResponse, error) {
 	// Ищем пользователя по username
 	user, err := uc.userRepo.GetByUsername(ctx, req.Username)
@@ -94,16 +123,306 @@ func (uc *AuthUseCase) Login(ctx context.Context, req entity.LoginRequest) (*ent
 		return nil, ErrInvalidCredentials
 	}
 
-	// Генерируем JWT токен
-	token, err := uc.jwtManager.GenerateToken(user.ID, user.Username, user.Email)
+	return uc.issueTokens(ctx, user, "")
+}
+
+// Refresh обменивает refresh токен на новую пару токенов. Предъявленный токен отзывается,
+// а повторное предъявление уже обменянного токена считается утечкой и отзывает всю цепочку
+func (uc *AuthUseCase) Refresh(ctx context.Context, req entity.RefreshRequest) (*entity.LoginResponse, error) {
+	stored, err := uc.sessions.GetRefreshToken(ctx, auth.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repo.ErrRefreshTokenNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, uc.revokeReusedFamily(ctx, stored)
+	}
+	if !time.Now().Before(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := uc.userRepo.GetByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrUserNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	var resp *entity.LoginResponse
+	err = uc.sessions.WithTransaction(ctx, func(ctx context.Context) error {
+		rotated, err := uc.sessions.RevokeRefreshToken(ctx, stored.ID)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			return errRefreshTokenReused
+		}
+
+		resp, err = uc.issueTokens(ctx, user, stored.FamilyID)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		return nil, uc.revokeReusedFamily(ctx, stored)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return resp, nil
+}
+
+// Logout отзывает access токен tokenID текущей сессии и, если передан refresh токен,
+// цепочку обновлений, к которой он относится
+func (uc *AuthUseCase) Logout(ctx context.Context, userID uint, tokenID string, expiresAt time.Time, req entity.LogoutRequest) error {
+	return uc.sessions.WithTransaction(ctx, func(ctx context.Context) error {
+		if req.RefreshToken != "" {
+			stored, err := uc.sessions.GetRefreshToken(ctx, auth.HashRefreshToken(req.RefreshToken))
+			if err != nil && !errors.Is(err, repo.ErrRefreshTokenNotFound) {
+				return err
+			}
+			// Чужой или неизвестный refresh токен игнорируем, выход из текущей сессии все равно выполняется
+			if err == nil && stored.UserID == userID {
+				if err := uc.sessions.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+					return fmt.Errorf("ошибка при отзыве refresh токена: %w", err)
+				}
+			}
+		}
+
+		if err := uc.sessions.RevokeAccessToken(ctx, tokenID, userID, expiresAt); err != nil {
+			return err
+		}
+		return uc.addEvent(ctx, events.TokenRevoked{
+			TokenID:   tokenID,
+			UserID:    userID,
+			ExpiresAt: expiresAt,
+		})
+	})
+}
+
+// LogoutAll завершает все сессии пользователя: отзывает его refresh токены и все выпущенные access токены
+func (uc *AuthUseCase) LogoutAll(ctx context.Context, userID uint) error {
+	now := time.Now()
+	// Access токены, выпущенные до now, истекут не позже now + TokenTTL
+	expiresAt := now.Add(uc.jwtManager.TokenTTL())
+
+	return uc.sessions.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.sessions.RevokeUserRefreshTokens(ctx, userID); err != nil {
+			return fmt.Errorf("ошибка при отзыве refresh токенов: %w", err)
+		}
+		if err := uc.sessions.RevokeUserAccessTokens(ctx, userID, now, expiresAt); err != nil {
+			return err
+		}
+		return uc.addEvent(ctx, events.UserTokensRevoked{
+			UserID:        userID,
+			RevokedBefore: now,
+			ExpiresAt:     expiresAt,
+		})
+	})
+}
+
+// BootstrapAdmin создает администратора из конфигурации сервиса, если его еще нет. Роль admin
+// назначается только созданному здесь пользователю: имя, занятое при регистрации через API,
+// роль не получает. Счет в биллинге администратору не создается
+func (uc *AuthUseCase) BootstrapAdmin(ctx context.Context, admin entity.RegisterRequest) error {
+	user, err := uc.userRepo.GetByUsername(ctx, admin.Username)
+	if err == nil {
+		roles, err := uc.roles.GetUserRoles(ctx, user.ID)
+		if err != nil {
+			return fmt.Errorf("ошибка при получении ролей пользователя %s: %w", admin.Username, err)
+		}
+		if !slices.Contains(roles, auth.RoleAdmin) {
+			log.Printf("Пользователь %s зарегистрирован без роли %s, администратор не создан", admin.Username, auth.RoleAdmin)
+		}
+		return nil
+	}
+	if !errors.Is(err, repo.ErrUserNotFound) {
+		return err
+	}
+
+	if _, err := uc.userRepo.GetByEmail(ctx, admin.Email); err == nil {
+		log.Printf("Email %s уже занят другим пользователем, администратор не создан", admin.Email)
+		return nil
+	} else if !errors.Is(err, repo.ErrUserNotFound) {
+		return err
+	}
+
+	hashedPassword, err := auth.HashPassword(admin.Password)
+	if err != nil {
+		return err
+	}
+
+	user = &entity.User{
+		Username:  admin.Username,
+		Email:     admin.Email,
+		Password:  hashedPassword,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := uc.userRepo.Create(ctx, user); err != nil {
+		return fmt.Errorf("ошибка при создании администратора: %w", err)
+	}
+
+	if err := uc.roles.AddUserRole(ctx, user.ID, auth.RoleAdmin); err != nil {
+		// Без роли пользователь при следующем запуске считался бы занявшим имя, поэтому удаляем его
+		if deleteErr := uc.userRepo.Delete(ctx, user.ID); deleteErr != nil {
+			log.Printf("Ошибка при удалении администратора после неудачного назначения роли: %v", deleteErr)
+		}
+		return fmt.Errorf("ошибка при назначении роли %s пользователю %s: %w", auth.RoleAdmin, admin.Username, err)
+	}
+
+	log.Printf("Создан администратор %s (ID: %d)", admin.Username, user.ID)
+	return nil
+}
+
+// GetUserRoles возвращает роли пользователя и их разрешения
+func (uc *AuthUseCase) GetUserRoles(ctx context.Context, userID uint) (*entity.UserRolesResponse, error) {
+	if _, err := uc.userRepo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	roles, err := uc.userRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.UserRolesResponse{
+		UserID:      userID,
+		Roles:       roles,
+		Permissions: auth.RolePermissions(roles),
+	}, nil
+}
+
+// SetUserRoles заменяет роли пользователя. Роли записаны в выпущенные access токены, поэтому они
+// отзываются, а по refresh токену пользователь получит токен с новыми ролями
+func (uc *AuthUseCase) SetUserRoles(ctx context.Context, userID uint, req entity.UpdateUserRolesRequest) (*entity.UserRolesResponse, error) {
+	roles := make([]string, 0, len(req.Roles))
+	for _, role := range req.Roles {
+		if !auth.IsKnownRole(role) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRole, role)
+		}
+		// Роль user есть у всех пользователей
+		if role != auth.RoleUser && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	if _, err := uc.userRepo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(uc.jwtManager.TokenTTL())
+	err := uc.sessions.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.roles.SetUserRoles(ctx, userID, roles); err != nil {
+			return fmt.Errorf("ошибка при сохранении ролей: %w", err)
+		}
+		if err := uc.sessions.RevokeUserAccessTokens(ctx, userID, now, expiresAt); err != nil {
+			return err
+		}
+		return uc.addEvent(ctx, events.UserTokensRevoked{
+			UserID:        userID,
+			RevokedBefore: now,
+			ExpiresAt:     expiresAt,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.GetUserRoles(ctx, userID)
+}
+
+// IssueServiceToken выдает токен сервиса по client credentials. Имя сервиса в токене - client_id
+func (uc *AuthUseCase) IssueServiceToken(req entity.ServiceTokenRequest) (*entity.ServiceTokenResponse, error) {
+	secret, ok := uc.serviceClients[req.ClientID]
+	expected := sha256.Sum256([]byte(secret))
+	actual := sha256.Sum256([]byte(req.ClientSecret))
+	if subtle.ConstantTimeCompare(expected[:], actual[:]) != 1 || !ok {
+		return nil, ErrInvalidClient
+	}
+
+	token, expiresAt, err := uc.jwtManager.GenerateServiceToken(req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при создании токена сервиса: %w", err)
+	}
+
+	return &entity.ServiceTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+	}, nil
+}
+
+// userRoles возвращает роль user и роли, назначенные пользователю
+func (uc *AuthUseCase) userRoles(ctx context.Context, userID uint) ([]string, error) {
+	stored, err := uc.roles.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении ролей пользователя: %w", err)
+	}
+	return append([]string{auth.RoleUser}, stored...), nil
+}
+
+// issueTokens выпускает access токен и refresh токен цепочки familyID. Пустой familyID начинает новую цепочку
+func (uc *AuthUseCase) issueTokens(ctx context.Context, user *entity.User, familyID string) (*entity.LoginResponse, error) {
+	roles, err := uc.userRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := uc.jwtManager.GenerateToken(user.ID, user.Username, user.Email, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации refresh токена: %w", err)
+	}
+	tokenHash := auth.HashRefreshToken(refreshToken)
+	if familyID == "" {
+		familyID = tokenHash
+	}
+
+	now := time.Now()
+	err = uc.sessions.CreateRefreshToken(ctx, &entity.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(uc.jwtManager.RefreshTokenTTL()),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при сохранении refresh токена: %w", err)
+	}
+
 	return &entity.LoginResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Token:    token,
+		ID:           user.ID,
+		Username:     user.Username,
+		Email:        user.Email,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(uc.jwtManager.TokenTTL().Seconds()),
 	}, nil
 }
+
+// revokeReusedFamily отзывает цепочку обновлений, в которой повторно предъявлен обменянный refresh токен
+func (uc *AuthUseCase) revokeReusedFamily(ctx context.Context, stored *entity.RefreshToken) error {
+	log.Printf("Повторное использование refresh токена пользователя %d, цепочка обновлений отозвана", stored.UserID)
+	if err := uc.sessions.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("ошибка при отзыве refresh токенов: %w", err)
+	}
+	return ErrInvalidRefreshToken
+}
+
+// addEvent сохраняет событие в outbox с ключом маршрутизации, равным типу события
+func (uc *AuthUseCase) addEvent(ctx context.Context, event events.Event) error {
+	envelope, err := events.New(ctx, eventProducer, event)
+	if err != nil {
+		return err
+	}
+	return uc.sessions.AddOutboxMessage(ctx, uc.authExch, envelope.Type, envelope)
+}
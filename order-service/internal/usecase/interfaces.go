@@ -2,16 +2,18 @@ package usecase
 
 import (
 	"context"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/money"
 )
 
-// BillingService интерфейс для работы с сервисом биллинга
+// BillingService интерфейс для работы с сервисом биллинга. Операции со счетом выполняются
+// от имени пользователя userID с учетными данными сервиса заказов
 type BillingService interface {
 	CreateAccount(ctx context.Context, userID uint) error
-	WithdrawMoney(ctx context.Context, userID uint, amount float64, email string, token string) (bool, error)
-}
-
-// RabbitMQClient интерфейс для работы с RabbitMQ
-type RabbitMQClient interface {
-	PublishMessage(exchange, routingKey string, message interface{}) error
-	PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error
+	WithdrawMoney(ctx context.Context, userID uint, orderID uint, amount money.Money, email string, idempotencyKey string) (entity.PaymentResult, error)
+	Refund(ctx context.Context, req entity.RefundRequest, idempotencyKey string) error
+	AuthorizePayment(ctx context.Context, userID uint, orderID uint, amount money.Money, description string, idempotencyKey string) (entity.PaymentResult, error)
+	CapturePayment(ctx context.Context, userID uint, holdID uint, amount money.Money, idempotencyKey string) (entity.PaymentResult, error)
+	VoidPayment(ctx context.Context, userID uint, holdID uint) error
 }
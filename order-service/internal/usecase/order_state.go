@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	pkgerrors "github.com/director74/dz7_shop/pkg/errors"
+)
+
+// ErrInvalidStatusTransition ошибка при недопустимом переходе статуса заказа
+var ErrInvalidStatusTransition = errors.New("недопустимый переход статуса заказа")
+
+// orderTransitions описывает допустимые переходы жизненного цикла заказа.
+// Статусы failed, canceled и completed являются конечными
+var orderTransitions = map[entity.OrderStatus][]entity.OrderStatus{
+	entity.OrderStatusCreated:   {entity.OrderStatusPending, entity.OrderStatusCanceled},
+	entity.OrderStatusPending:   {entity.OrderStatusPaid, entity.OrderStatusFailed, entity.OrderStatusCanceled},
+	entity.OrderStatusPaid:      {entity.OrderStatusShipped, entity.OrderStatusCanceled},
+	entity.OrderStatusShipped:   {entity.OrderStatusDelivered},
+	entity.OrderStatusDelivered: {entity.OrderStatusCompleted},
+}
+
+// CanTransition проверяет, допустим ли переход заказа из статуса from в статус to
+func CanTransition(from, to entity.OrderStatus) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTransition возвращает ошибку 409, если переход недопустим
+func validateTransition(from, to entity.OrderStatus) error {
+	if CanTransition(from, to) {
+		return nil
+	}
+
+	message := fmt.Sprintf("Недопустимый переход статуса заказа: %s -> %s", from, to)
+	return pkgerrors.NewServiceError(http.StatusConflict, message, ErrInvalidStatusTransition)
+}
@@ -5,28 +5,56 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/director74/dz7_shop/order-service/internal/entity"
 	"github.com/director74/dz7_shop/order-service/internal/repo"
+	pkgerrors "github.com/director74/dz7_shop/pkg/errors"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// eventProducer имя сервиса в конвертах публикуемых событий
+const eventProducer = "order-service"
+
+// reconcilePendingBatchSize максимальное количество зависших заказов, обрабатываемых за один проход сверки
+const reconcilePendingBatchSize = 100
+
+// maxOrdersPageSize максимальный размер страницы списка заказов
+const maxOrdersPageSize = 100
+
+// PaymentMode режим оплаты заказа
+type PaymentMode string
+
+const (
+	// PaymentModeSync списание через HTTP-запрос к биллингу при создании заказа
+	PaymentModeSync PaymentMode = "sync"
+	// PaymentModeAsync оплата через сагу на событиях order.created и billing.payment_processed
+	PaymentModeAsync PaymentMode = "async"
+	// PaymentModeHold резервирование средств при создании заказа и списание при отгрузке
+	PaymentModeHold PaymentMode = "hold"
 )
 
 // OrderUseCase представляет usecase для работы с заказами
 type OrderUseCase struct {
-	repo      repo.OrderRepository
-	userRepo  repo.UserRepository
-	billing   BillingService
-	rabbitMQ  RabbitMQClient
-	orderExch string
+	repo        repo.OrderRepository
+	userRepo    repo.UserRepository
+	productRepo repo.ProductRepository
+	billing     BillingService
+	orderExch   string
+	paymentMode PaymentMode
 }
 
-func NewOrderUseCase(orderRepo repo.OrderRepository, userRepo repo.UserRepository, billing BillingService, rabbitMQ RabbitMQClient, orderExch string) *OrderUseCase {
+// NewOrderUseCase создает usecase заказов. События публикуются в exchange orderExch через outbox
+func NewOrderUseCase(orderRepo repo.OrderRepository, userRepo repo.UserRepository, productRepo repo.ProductRepository, billing BillingService, orderExch string, paymentMode PaymentMode) *OrderUseCase {
 	return &OrderUseCase{
-		repo:      orderRepo,
-		userRepo:  userRepo,
-		billing:   billing,
-		rabbitMQ:  rabbitMQ,
-		orderExch: orderExch,
+		repo:        orderRepo,
+		userRepo:    userRepo,
+		productRepo: productRepo,
+		billing:     billing,
+		orderExch:   orderExch,
+		paymentMode: paymentMode,
 	}
 }
 
@@ -75,94 +103,434 @@ func (uc *OrderUseCase) CreateOrder(ctx context.Context, req entity.CreateOrderR
 		return entity.CreateOrderResponse{}, fmt.Errorf("пользователь не найден: %w", err)
 	}
 
-	// Получаем JWT токен из контекста запроса
-	token := ""
-	if tokenValue := ctx.Value("jwt_token"); tokenValue != nil {
-		if tokenStr, ok := tokenValue.(string); ok {
-			token = tokenStr
-		}
+	// Рассчитываем стоимость заказа по ценам каталога, цене клиента не доверяем
+	items, amount, err := uc.priceItems(ctx, req.Items)
+	if err != nil {
+		return entity.CreateOrderResponse{}, err
 	}
 
-	// Пытаемся снять деньги с аккаунта пользователя
-	success, err := uc.billing.WithdrawMoney(ctx, req.UserID, req.Amount, user.Email, token)
-	if err != nil {
-		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при списании средств: %w", err)
+	if req.Amount != nil && !req.Amount.Equal(amount) {
+		return entity.CreateOrderResponse{}, pkgerrors.NewValidationError("amount",
+			fmt.Sprintf("сумма %s не совпадает со стоимостью заказа %s", req.Amount, amount))
 	}
 
-	// Определяем статус заказа на основе результата списания
-	status := entity.OrderStatusFailed
-	if success {
-		status = entity.OrderStatusCompleted
+	// Повтор запроса с тем же ключом продолжает заказ, созданный первым запросом, поэтому
+	// ключ списания в биллинге остается прежним и средства не списываются второй раз
+	if req.IdempotencyKey != "" {
+		existing, err := uc.repo.GetByIdempotencyKey(ctx, req.UserID, req.IdempotencyKey)
+		if err == nil {
+			return uc.resumeOrder(ctx, existing, req.Items, user.Email)
+		}
+		if !errors.Is(err, repo.ErrOrderNotFound) {
+			return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при поиске заказа по ключу идемпотентности: %w", err)
+		}
 	}
 
-	// Создаем заказ с окончательным статусом
 	order := &entity.Order{
 		UserID:    req.UserID,
-		Amount:    req.Amount,
-		Status:    status,
+		Items:     items,
+		Amount:    amount,
+		Status:    entity.OrderStatusPending,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	if req.IdempotencyKey != "" {
+		order.IdempotencyKey = &req.IdempotencyKey
+	}
 
-	err = uc.repo.Create(ctx, order)
+	if uc.paymentMode == PaymentModeAsync {
+		err = uc.createOrderAsync(ctx, user, order)
+	} else {
+		// Заказ сохраняется до списания, чтобы ключ идемпотентности списания был привязан к заказу
+		err = uc.repo.Create(ctx, order)
+	}
 	if err != nil {
-		// Если не удалось создать заказ, но деньги были списаны, нужно их вернуть
-		// Это потребует дополнительного метода в BillingService
-		// Здесь мы просто логируем проблему
-		if success {
-			log.Printf("КРИТИЧЕСКАЯ ОШИБКА: Деньги были списаны, но заказ не был создан: userID=%d, amount=%.2f, error=%v",
-				req.UserID, req.Amount, err)
+		// Заказ с тем же ключом мог создать параллельный запрос
+		if req.IdempotencyKey != "" {
+			if existing, findErr := uc.repo.GetByIdempotencyKey(ctx, req.UserID, req.IdempotencyKey); findErr == nil {
+				return uc.resumeOrder(ctx, existing, req.Items, user.Email)
+			}
 		}
 		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при создании заказа: %w", err)
 	}
 
-	// Отправляем событие в RabbitMQ для нотификации о заказе (успешном или нет)
-	notification := struct {
-		UserID  uint    `json:"user_id"`
-		Email   string  `json:"email"`
-		OrderID uint    `json:"order_id"`
-		Amount  float64 `json:"amount"`
-		Success bool    `json:"success"`
-	}{
-		UserID:  user.ID,
-		Email:   user.Email,
-		OrderID: order.ID,
-		Amount:  order.Amount,
-		Success: success,
+	if uc.paymentMode == PaymentModeAsync {
+		return toCreateOrderResponse(order), nil
+	}
+	return uc.payOrder(ctx, order, user.Email)
+}
+
+// resumeOrder возвращает заказ, ранее созданный с тем же ключом идемпотентности. Если результат
+// оплаты заказа неизвестен, оплата повторяется с прежним ключом списания
+func (uc *OrderUseCase) resumeOrder(ctx context.Context, order *entity.Order, reqItems []entity.CreateOrderItemRequest, email string) (entity.CreateOrderResponse, error) {
+	if !sameItems(order.Items, reqItems) {
+		return entity.CreateOrderResponse{}, pkgerrors.NewServiceError(http.StatusConflict,
+			"Ключ идемпотентности уже использован для другого заказа", nil)
+	}
+
+	if uc.awaitsPayment(order) {
+		log.Printf("Повтор запроса для заказа %d в статусе pending, повторяем оплату", order.ID)
+		return uc.payOrder(ctx, order, email)
 	}
 
-	// Используем метод с повторными попытками для надежной публикации
-	err = uc.rabbitMQ.PublishMessageWithRetry(uc.orderExch, "order.notification", notification, 3)
+	return toCreateOrderResponse(order), nil
+}
+
+// awaitsPayment сообщает, что результат оплаты заказа неизвестен и ее можно повторить по HTTP.
+// В режиме async результат оплаты приходит событием от биллинга
+func (uc *OrderUseCase) awaitsPayment(order *entity.Order) bool {
+	return uc.paymentMode != PaymentModeAsync && order.Status == entity.OrderStatusPending &&
+		order.PaymentTransactionID == nil && order.PaymentHoldID == nil
+}
+
+// payOrder списывает или резервирует средства за заказ в статусе pending и сохраняет результат.
+// Ключ идемпотентности списания привязан к заказу, поэтому повтор вернет результат первой попытки
+func (uc *OrderUseCase) payOrder(ctx context.Context, order *entity.Order, email string) (entity.CreateOrderResponse, error) {
+	// В режиме холда средства только резервируются, списание выполняется при отгрузке
+	var payment entity.PaymentResult
+	var err error
+	if uc.paymentMode == PaymentModeHold {
+		payment, err = uc.billing.AuthorizePayment(ctx, order.UserID, order.ID, order.Amount, fmt.Sprintf("Заказ #%d", order.ID), paymentIdempotencyKey(order.ID))
+	} else {
+		payment, err = uc.billing.WithdrawMoney(ctx, order.UserID, order.ID, order.Amount, email, paymentIdempotencyKey(order.ID))
+	}
 	if err != nil {
-		// Логируем ошибку, но не прерываем выполнение
-		log.Printf("Ошибка при отправке нотификации после %d попыток: %v\n", 3, err)
+		// Результат списания неизвестен, поэтому заказ остается в pending: оплату повторит
+		// запрос с тем же ключом идемпотентности или сверка зависших заказов
+		log.Printf("Не удалось получить результат списания для заказа %d: %v", order.ID, err)
+		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при списании средств: %w", err)
 	}
 
-	return entity.CreateOrderResponse{
-		ID:     order.ID,
-		UserID: order.UserID,
-		Amount: order.Amount,
-		Status: status,
-	}, nil
+	err = uc.applyPaymentResult(ctx, order, payment, email)
+	if errors.Is(err, repo.ErrOrderStatusChanged) {
+		// Результат той же оплаты мог сохранить параллельный повтор
+		if current, getErr := uc.repo.GetByID(ctx, order.ID); getErr == nil && samePayment(current, payment) {
+			return toCreateOrderResponse(current), nil
+		}
+	}
+	if err != nil {
+		// Деньги списаны или зарезервированы, но оплата заказа не сохранена: компенсируем
+		if payment.Success && payment.HoldID != 0 {
+			if voidErr := uc.voidPayment(ctx, order.UserID, payment.HoldID); voidErr != nil {
+				log.Printf("КРИТИЧЕСКАЯ ОШИБКА: Средства зарезервированы, оплата заказа %d не сохранена и резерв не отменен: userID=%d, holdID=%d, amount=%s, error=%v, voidError=%v",
+					order.ID, order.UserID, payment.HoldID, order.Amount, err, voidErr)
+			}
+		} else if payment.Success {
+			refundErr := uc.refundPayment(ctx, order.ID, order.UserID, payment.TransactionID, order.Amount,
+				"оплата заказа не была сохранена")
+			if refundErr != nil {
+				log.Printf("КРИТИЧЕСКАЯ ОШИБКА: Деньги были списаны, оплата заказа %d не сохранена и возврат не выполнен: userID=%d, transactionID=%d, amount=%s, error=%v, refundError=%v",
+					order.ID, order.UserID, payment.TransactionID, order.Amount, err, refundErr)
+			}
+		}
+		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при сохранении результата оплаты заказа: %w", err)
+	}
+
+	return toCreateOrderResponse(order), nil
 }
 
-func (uc *OrderUseCase) GetOrder(ctx context.Context, id uint) (entity.GetOrderResponse, error) {
-	order, err := uc.repo.GetByID(ctx, id)
+// sameItems сообщает, что позиции заказа совпадают с позициями запроса. Цены не сравниваются,
+// так как каталог мог измениться после создания заказа
+func sameItems(items []entity.OrderItem, reqItems []entity.CreateOrderItemRequest) bool {
+	quantities := make(map[uint]int, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	for _, item := range reqItems {
+		quantities[item.ProductID] -= item.Quantity
+	}
+	for _, quantity := range quantities {
+		if quantity != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// samePayment сообщает, что у заказа уже сохранен результат оплаты payment
+func samePayment(order *entity.Order, payment entity.PaymentResult) bool {
+	if payment.HoldID != 0 {
+		return order.PaymentHoldID != nil && *order.PaymentHoldID == payment.HoldID
+	}
+	if payment.Success {
+		return order.PaymentTransactionID != nil && *order.PaymentTransactionID == payment.TransactionID
+	}
+	return order.Status == entity.OrderStatusFailed
+}
+
+// ReconcilePendingOrders повторяет оплату заказов, которые дольше olderThan остаются в pending
+// без результата оплаты, например после сбоя биллинга или сервиса заказов во время оформления.
+// Возвращает количество заказов, для которых результат оплаты получен
+func (uc *OrderUseCase) ReconcilePendingOrders(ctx context.Context, olderThan time.Duration) (int, error) {
+	if uc.paymentMode == PaymentModeAsync {
+		return 0, nil
+	}
+
+	orders, err := uc.repo.ListStalePendingOrders(ctx, time.Now().Add(-olderThan), reconcilePendingBatchSize)
 	if err != nil {
-		return entity.GetOrderResponse{}, fmt.Errorf("заказ не найден: %w", err)
+		return 0, fmt.Errorf("ошибка при поиске зависших заказов: %w", err)
 	}
 
-	return entity.GetOrderResponse{
+	resolved := 0
+	for _, order := range orders {
+		email := ""
+		if user, err := uc.userRepo.GetByID(ctx, order.UserID); err == nil {
+			email = user.Email
+		}
+
+		if _, err := uc.payOrder(ctx, order, email); err != nil {
+			log.Printf("Не удалось завершить оплату зависшего заказа %d: %v", order.ID, err)
+			continue
+		}
+		resolved++
+	}
+
+	return resolved, nil
+}
+
+// RunPendingReconciliation периодически завершает оплату зависших заказов до отмены контекста
+func (uc *OrderUseCase) RunPendingReconciliation(ctx context.Context, interval, olderThan time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resolved, err := uc.ReconcilePendingOrders(ctx, olderThan)
+		if err != nil {
+			log.Printf("Ошибка при сверке зависших заказов: %v", err)
+		}
+		if resolved > 0 {
+			log.Printf("Завершена оплата зависших заказов: %d", resolved)
+		}
+	}
+}
+
+// createOrderAsync сохраняет заказ в статусе pending вместе с событием order.created в outbox.
+// Результат оплаты приходит от биллинга событием billing.payment_processed
+func (uc *OrderUseCase) createOrderAsync(ctx context.Context, user *entity.User, order *entity.Order) error {
+	// Заказ без события не сохраняется: иначе биллинг не узнает о нем и заказ зависнет в pending
+	return uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.repo.Create(ctx, order); err != nil {
+			return err
+		}
+
+		event := events.OrderCreated{
+			OrderID:   order.ID,
+			UserID:    order.UserID,
+			TotalCost: order.Amount,
+			Email:     user.Email,
+		}
+
+		if err := uc.addEvent(ctx, event); err != nil {
+			return fmt.Errorf("ошибка при сохранении события создания заказа: %w", err)
+		}
+		return nil
+	})
+}
+
+// HandlePaymentProcessed завершает оплату заказа по событию billing.payment_processed
+func (uc *OrderUseCase) HandlePaymentProcessed(ctx context.Context, event events.PaymentProcessed) error {
+	order, err := uc.repo.GetByID(ctx, event.OrderID)
+	if err != nil {
+		if errors.Is(err, repo.ErrOrderNotFound) {
+			log.Printf("Получен результат оплаты для несуществующего заказа %d, пропускаем", event.OrderID)
+			return nil
+		}
+		return fmt.Errorf("ошибка при получении заказа: %w", err)
+	}
+
+	// Заказ отменили, пока биллинг списывал средства: запрос на возврат сохраняется вместе
+	// с транзакцией оплаты, поэтому повторная доставка события не создаст второй запрос
+	if order.Status == entity.OrderStatusCanceled && event.Success && order.PaymentTransactionID == nil {
+		return uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := uc.repo.SetPaymentTransactionID(ctx, order.ID, event.TransactionID); err != nil {
+				return fmt.Errorf("ошибка при сохранении транзакции оплаты заказа: %w", err)
+			}
+			order.PaymentTransactionID = &event.TransactionID
+			return uc.addPaymentCompensation(ctx, order, "заказ отменен до завершения оплаты")
+		})
+	}
+
+	// Повторно доставленное событие для уже обработанного заказа игнорируем
+	if order.Status != entity.OrderStatusPending {
+		log.Printf("Заказ %d уже в статусе %s, результат оплаты пропущен", order.ID, order.Status)
+		return nil
+	}
+
+	email := ""
+	if user, err := uc.userRepo.GetByID(ctx, order.UserID); err == nil {
+		email = user.Email
+	}
+
+	payment := entity.PaymentResult{Success: event.Success, TransactionID: event.TransactionID}
+	if err := uc.applyPaymentResult(ctx, order, payment, email); err != nil {
+		if errors.Is(err, repo.ErrOrderStatusChanged) {
+			log.Printf("Статус заказа %d изменен параллельно, результат оплаты пропущен", order.ID)
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// applyPaymentResult сохраняет результат списания или резервирования, переводит заказ
+// из pending в paid или failed и сохраняет уведомление order.notification в одной транзакции.
+// В режиме холда paid означает, что средства зарезервированы
+func (uc *OrderUseCase) applyPaymentResult(ctx context.Context, order *entity.Order, payment entity.PaymentResult, email string) error {
+	return uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		status := entity.OrderStatusFailed
+		if payment.Success && payment.HoldID != 0 {
+			status = entity.OrderStatusPaid
+
+			// ID холда нужен для списания при отгрузке и отмены резерва при отмене заказа
+			if err := uc.repo.SetPaymentHoldID(ctx, order.ID, payment.HoldID); err != nil {
+				return fmt.Errorf("ошибка при сохранении холда оплаты заказа: %w", err)
+			}
+			order.PaymentHoldID = &payment.HoldID
+		} else if payment.Success {
+			status = entity.OrderStatusPaid
+
+			// ID списания нужен для возврата средств при отмене оплаченного заказа
+			if err := uc.repo.SetPaymentTransactionID(ctx, order.ID, payment.TransactionID); err != nil {
+				return fmt.Errorf("ошибка при сохранении транзакции оплаты заказа: %w", err)
+			}
+			order.PaymentTransactionID = &payment.TransactionID
+		}
+
+		if err := uc.transition(ctx, order, status); err != nil {
+			return err
+		}
+
+		return uc.addOrderNotification(ctx, email, order, payment.Success)
+	})
+}
+
+// paymentIdempotencyKey возвращает ключ идемпотентности списания за заказ,
+// повторное списание с тем же ключом вернет результат первого
+func paymentIdempotencyKey(orderID uint) string {
+	return fmt.Sprintf("order-%d-payment", orderID)
+}
+
+// captureIdempotencyKey возвращает ключ идемпотентности списания по холду заказа
+func captureIdempotencyKey(orderID uint) string {
+	return fmt.Sprintf("order-%d-capture", orderID)
+}
+
+// refundIdempotencyKey возвращает ключ идемпотентности возврата средств за заказ
+func refundIdempotencyKey(orderID uint) string {
+	return fmt.Sprintf("order-%d-refund", orderID)
+}
+
+// addOrderNotification сохраняет в outbox событие order.notification о результате оформления заказа
+func (uc *OrderUseCase) addOrderNotification(ctx context.Context, email string, order *entity.Order, success bool) error {
+	notification := events.OrderNotification{
+		UserID:  order.UserID,
+		Email:   email,
+		OrderID: order.ID,
+		Amount:  order.Amount,
+		Success: success,
+	}
+
+	if err := uc.addEvent(ctx, notification); err != nil {
+		return fmt.Errorf("ошибка при сохранении уведомления о заказе: %w", err)
+	}
+	return nil
+}
+
+func toCreateOrderResponse(order *entity.Order) entity.CreateOrderResponse {
+	return entity.CreateOrderResponse{
 		ID:        order.ID,
 		UserID:    order.UserID,
+		Items:     order.Items,
 		Amount:    order.Amount,
 		Status:    order.Status,
 		CreatedAt: order.CreatedAt,
-		UpdatedAt: order.UpdatedAt,
-	}, nil
+	}
+}
+
+// priceItems формирует позиции заказа по данным каталога и возвращает итоговую сумму
+func (uc *OrderUseCase) priceItems(ctx context.Context, reqItems []entity.CreateOrderItemRequest) ([]entity.OrderItem, money.Money, error) {
+	ids := make([]uint, 0, len(reqItems))
+	for _, item := range reqItems {
+		ids = append(ids, item.ProductID)
+	}
+
+	products, err := uc.productRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, money.Money{}, fmt.Errorf("ошибка при получении товаров: %w", err)
+	}
+
+	productsByID := make(map[uint]*entity.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	items := make([]entity.OrderItem, 0, len(reqItems))
+	amount := money.Zero(money.DefaultCurrency)
+
+	for _, reqItem := range reqItems {
+		product, ok := productsByID[reqItem.ProductID]
+		if !ok {
+			return nil, money.Money{}, pkgerrors.NewNotFoundError("Товар", reqItem.ProductID)
+		}
+		if !product.Active {
+			return nil, money.Money{}, pkgerrors.NewBadRequestError(fmt.Sprintf("товар %s недоступен для заказа", product.SKU))
+		}
+		// Счета в биллинге ведутся в валюте по умолчанию, поэтому заказ оплачивается только в ней
+		if product.Price.Currency() != amount.Currency() {
+			return nil, money.Money{}, pkgerrors.NewBadRequestError(fmt.Sprintf(
+				"товар %s в валюте %s не может быть оплачен, заказы оплачиваются в %s",
+				product.SKU, product.Price.Currency(), amount.Currency()))
+		}
+
+		lineTotal, err := product.Price.Mul(int64(reqItem.Quantity))
+		if err == nil {
+			amount, err = amount.Add(lineTotal)
+		}
+		if err != nil {
+			return nil, money.Money{}, pkgerrors.NewBadRequestError(fmt.Sprintf("ошибка при расчете стоимости заказа: %v", err))
+		}
+
+		items = append(items, entity.OrderItem{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Price:     product.Price,
+			Quantity:  reqItem.Quantity,
+		})
+	}
+
+	return items, amount, nil
+}
+
+func (uc *OrderUseCase) GetOrder(ctx context.Context, id uint) (entity.GetOrderResponse, error) {
+	order, err := uc.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrOrderNotFound) {
+			return entity.GetOrderResponse{}, pkgerrors.NewNotFoundError("Заказ", id)
+		}
+		return entity.GetOrderResponse{}, fmt.Errorf("ошибка при получении заказа: %w", err)
+	}
+
+	return toGetOrderResponse(order), nil
 }
 
 func (uc *OrderUseCase) ListUserOrders(ctx context.Context, userID uint, limit, offset int) (entity.ListOrdersResponse, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > maxOrdersPageSize {
+		limit = maxOrdersPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
@@ -181,15 +549,211 @@ func (uc *OrderUseCase) ListUserOrders(ctx context.Context, userID uint, limit,
 	response.Orders = make([]entity.GetOrderResponse, len(orders))
 
 	for i, order := range orders {
-		response.Orders[i] = entity.GetOrderResponse{
-			ID:        order.ID,
-			UserID:    order.UserID,
-			Amount:    order.Amount,
-			Status:    order.Status,
-			CreatedAt: order.CreatedAt,
-			UpdatedAt: order.UpdatedAt,
-		}
+		response.Orders[i] = toGetOrderResponse(order)
 	}
 
 	return response, nil
 }
+
+// ChangeOrderStatus переводит заказ в новый статус с проверкой допустимости перехода
+// и публикует событие order.status_changed
+func (uc *OrderUseCase) ChangeOrderStatus(ctx context.Context, id uint, to entity.OrderStatus) (entity.GetOrderResponse, error) {
+	order, err := uc.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrOrderNotFound) {
+			return entity.GetOrderResponse{}, pkgerrors.NewNotFoundError("Заказ", id)
+		}
+		return entity.GetOrderResponse{}, fmt.Errorf("ошибка при получении заказа: %w", err)
+	}
+
+	from := order.Status
+
+	// Зарезервированные средства списываются до отгрузки, чтобы не отгрузить неоплаченный заказ
+	if to == entity.OrderStatusShipped && order.PaymentHoldID != nil && order.PaymentTransactionID == nil {
+		if err := validateTransition(from, to); err != nil {
+			return entity.GetOrderResponse{}, err
+		}
+		if err := uc.capturePayment(ctx, order); err != nil {
+			return entity.GetOrderResponse{}, err
+		}
+	}
+
+	if err := uc.transition(ctx, order, to); err != nil {
+		if errors.Is(err, repo.ErrOrderStatusChanged) {
+			return entity.GetOrderResponse{}, pkgerrors.NewServiceError(http.StatusConflict, "Статус заказа был изменен другим запросом", err)
+		}
+		return entity.GetOrderResponse{}, err
+	}
+
+	return toGetOrderResponse(order), nil
+}
+
+// refundPayment возвращает средства по списанию за заказ orderID через биллинг. Используется для компенсации,
+// поэтому выполняется даже если контекст исходного запроса уже отменен. Ключ идемпотентности
+// привязан к заказу, поэтому повтор возврата не вернет средства второй раз
+func (uc *OrderUseCase) refundPayment(ctx context.Context, orderID, userID, transactionID uint, amount money.Money, reason string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	email := ""
+	if user, err := uc.userRepo.GetByID(ctx, userID); err == nil {
+		email = user.Email
+	}
+
+	return uc.billing.Refund(ctx, entity.RefundRequest{
+		UserID:        userID,
+		TransactionID: transactionID,
+		Amount:        amount,
+		Reason:        reason,
+		Email:         email,
+	}, refundIdempotencyKey(orderID))
+}
+
+// capturePayment списывает зарезервированные по заказу средства и сохраняет ID транзакции списания
+func (uc *OrderUseCase) capturePayment(ctx context.Context, order *entity.Order) error {
+	payment, err := uc.billing.CapturePayment(ctx, order.UserID, *order.PaymentHoldID, order.Amount,
+		captureIdempotencyKey(order.ID))
+	if err != nil {
+		if errors.Is(err, entity.ErrPaymentHoldClosed) {
+			return pkgerrors.NewServiceError(http.StatusConflict, "Резерв средств по заказу закрыт или истек, списание невозможно", err)
+		}
+		return pkgerrors.NewServiceError(http.StatusBadGateway, "Не удалось списать зарезервированные средства", err)
+	}
+
+	// Списание уже выполнено, поэтому сохраняем его даже при отмене исходного запроса
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := uc.repo.SetPaymentTransactionID(saveCtx, order.ID, payment.TransactionID); err != nil {
+		log.Printf("КРИТИЧЕСКАЯ ОШИБКА: Средства по заказу %d списаны, но транзакция не сохранена: holdID=%d, transactionID=%d, error=%v",
+			order.ID, *order.PaymentHoldID, payment.TransactionID, err)
+		return fmt.Errorf("ошибка при сохранении транзакции оплаты заказа: %w", err)
+	}
+	order.PaymentTransactionID = &payment.TransactionID
+
+	return nil
+}
+
+// voidPayment отменяет резерв средств в биллинге. Используется для компенсации,
+// поэтому выполняется даже если контекст исходного запроса уже отменен
+func (uc *OrderUseCase) voidPayment(ctx context.Context, userID, holdID uint) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	return uc.billing.VoidPayment(ctx, userID, holdID)
+}
+
+// transition проверяет и сохраняет переход заказа в новый статус вместе с событием
+// order.status_changed в outbox. Статус меняется условно, поэтому параллельный переход
+// не будет перезаписан
+func (uc *OrderUseCase) transition(ctx context.Context, order *entity.Order, to entity.OrderStatus) error {
+	from := order.Status
+	if err := validateTransition(from, to); err != nil {
+		return err
+	}
+
+	updatedAt := time.Now()
+
+	err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.repo.UpdateStatus(ctx, order.ID, from, to); err != nil {
+			if errors.Is(err, repo.ErrOrderStatusChanged) {
+				return err
+			}
+			return fmt.Errorf("ошибка при изменении статуса заказа: %w", err)
+		}
+
+		if err := uc.addStatusChangedEvent(ctx, order, from, to, updatedAt); err != nil {
+			return err
+		}
+
+		// Запрос на возврат оплаты сохраняется в одной транзакции с отменой, поэтому не теряется
+		// при сбое биллинга: outbox доставит его, когда биллинг станет доступен
+		if to == entity.OrderStatusCanceled {
+			return uc.addPaymentCompensation(ctx, order, fmt.Sprintf("отмена заказа #%d", order.ID))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	order.Status = to
+	order.UpdatedAt = updatedAt
+
+	return nil
+}
+
+// addStatusChangedEvent сохраняет в outbox событие order.status_changed
+func (uc *OrderUseCase) addStatusChangedEvent(ctx context.Context, order *entity.Order, from, to entity.OrderStatus, changedAt time.Time) error {
+	email := ""
+	if user, err := uc.userRepo.GetByID(ctx, order.UserID); err == nil {
+		email = user.Email
+	} else {
+		log.Printf("Не удалось получить email пользователя %d для события смены статуса: %v", order.UserID, err)
+	}
+
+	event := events.OrderStatusChanged{
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		Email:     email,
+		Amount:    order.Amount,
+		OldStatus: string(from),
+		NewStatus: string(to),
+		ChangedAt: changedAt,
+	}
+
+	if err := uc.addEvent(ctx, event); err != nil {
+		return fmt.Errorf("ошибка при сохранении события смены статуса заказа: %w", err)
+	}
+	return nil
+}
+
+// addPaymentCompensation сохраняет в outbox запрос на возврат оплаты заказа: биллинг отменит
+// холд или вернет списание. Заказ без оплаты компенсировать не нужно
+func (uc *OrderUseCase) addPaymentCompensation(ctx context.Context, order *entity.Order, reason string) error {
+	event := events.PaymentCompensation{
+		OrderID: order.ID,
+		UserID:  order.UserID,
+		Amount:  order.Amount,
+		Reason:  reason,
+	}
+
+	switch {
+	case order.PaymentTransactionID != nil:
+		event.TransactionID = *order.PaymentTransactionID
+	case order.PaymentHoldID != nil:
+		event.HoldID = *order.PaymentHoldID
+	default:
+		return nil
+	}
+
+	if user, err := uc.userRepo.GetByID(ctx, order.UserID); err == nil {
+		event.Email = user.Email
+	}
+
+	if err := uc.addEvent(ctx, event); err != nil {
+		return fmt.Errorf("ошибка при сохранении запроса на возврат оплаты заказа: %w", err)
+	}
+	return nil
+}
+
+// addEvent сохраняет событие в outbox с ключом маршрутизации, равным типу события
+func (uc *OrderUseCase) addEvent(ctx context.Context, event events.Event) error {
+	envelope, err := events.New(ctx, eventProducer, event)
+	if err != nil {
+		return err
+	}
+	return uc.repo.AddOutboxMessage(ctx, uc.orderExch, envelope.Type, envelope)
+}
+
+func toGetOrderResponse(order *entity.Order) entity.GetOrderResponse {
+	return entity.GetOrderResponse{
+		ID:        order.ID,
+		UserID:    order.UserID,
+		Items:     order.Items,
+		Amount:    order.Amount,
+		Status:    order.Status,
+		CreatedAt: order.CreatedAt,
+		UpdatedAt: order.UpdatedAt,
+	}
+}
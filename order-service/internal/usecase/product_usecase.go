@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/repo"
+	pkgerrors "github.com/director74/dz7_shop/pkg/errors"
+)
+
+// maxProductsPageSize максимальный размер страницы каталога
+const maxProductsPageSize = 100
+
+// ProductUseCase представляет usecase для работы с каталогом товаров
+type ProductUseCase struct {
+	repo repo.ProductRepository
+}
+
+func NewProductUseCase(productRepo repo.ProductRepository) *ProductUseCase {
+	return &ProductUseCase{
+		repo: productRepo,
+	}
+}
+
+func (uc *ProductUseCase) CreateProduct(ctx context.Context, req entity.CreateProductRequest) (entity.ProductResponse, error) {
+	_, err := uc.repo.GetBySKU(ctx, req.SKU)
+	if err == nil {
+		return entity.ProductResponse{}, pkgerrors.NewAlreadyExistsError("Товар", "sku", req.SKU)
+	}
+	if !errors.Is(err, repo.ErrProductNotFound) {
+		return entity.ProductResponse{}, fmt.Errorf("ошибка при проверке SKU: %w", err)
+	}
+
+	// Валюта из отдельного поля имеет приоритет над валютой цены
+	currency := req.Price.Currency()
+	if req.Currency != "" {
+		currency = strings.ToUpper(req.Currency)
+	}
+
+	if !req.Price.IsPositive() {
+		return entity.ProductResponse{}, pkgerrors.NewValidationError("price", "цена должна быть больше нуля")
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	product := &entity.Product{
+		SKU:         req.SKU,
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price.WithCurrency(currency),
+		Currency:    currency,
+		Active:      active,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := uc.repo.Create(ctx, product); err != nil {
+		return entity.ProductResponse{}, fmt.Errorf("ошибка при создании товара: %w", err)
+	}
+
+	return toProductResponse(product), nil
+}
+
+func (uc *ProductUseCase) GetProduct(ctx context.Context, id uint) (entity.ProductResponse, error) {
+	product, err := uc.getProduct(ctx, id)
+	if err != nil {
+		return entity.ProductResponse{}, err
+	}
+
+	return toProductResponse(product), nil
+}
+
+func (uc *ProductUseCase) ListProducts(ctx context.Context, activeOnly bool, limit, offset int) (entity.ListProductsResponse, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > maxProductsPageSize {
+		limit = maxProductsPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	products, total, err := uc.repo.List(ctx, activeOnly, limit, offset)
+	if err != nil {
+		return entity.ListProductsResponse{}, fmt.Errorf("ошибка при получении списка товаров: %w", err)
+	}
+
+	var response entity.ListProductsResponse
+	response.Total = total
+	response.Products = make([]entity.ProductResponse, len(products))
+
+	for i, product := range products {
+		response.Products[i] = toProductResponse(product)
+	}
+
+	return response, nil
+}
+
+func (uc *ProductUseCase) UpdateProduct(ctx context.Context, id uint, req entity.UpdateProductRequest) (entity.ProductResponse, error) {
+	product, err := uc.getProduct(ctx, id)
+	if err != nil {
+		return entity.ProductResponse{}, err
+	}
+
+	if req.Name != nil {
+		product.Name = *req.Name
+	}
+	if req.Description != nil {
+		product.Description = *req.Description
+	}
+	if req.Price != nil {
+		if !req.Price.IsPositive() {
+			return entity.ProductResponse{}, pkgerrors.NewValidationError("price", "цена должна быть больше нуля")
+		}
+		product.Price = *req.Price
+		product.Currency = req.Price.Currency()
+	}
+	if req.Currency != nil {
+		product.Currency = strings.ToUpper(*req.Currency)
+	}
+	product.Price = product.Price.WithCurrency(product.Currency)
+	if req.Active != nil {
+		product.Active = *req.Active
+	}
+	product.UpdatedAt = time.Now()
+
+	if err := uc.repo.Update(ctx, product); err != nil {
+		return entity.ProductResponse{}, fmt.Errorf("ошибка при обновлении товара: %w", err)
+	}
+
+	return toProductResponse(product), nil
+}
+
+func (uc *ProductUseCase) DeleteProduct(ctx context.Context, id uint) error {
+	err := uc.repo.Delete(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrProductNotFound) {
+			return pkgerrors.NewNotFoundError("Товар", id)
+		}
+		return fmt.Errorf("ошибка при удалении товара: %w", err)
+	}
+
+	return nil
+}
+
+func (uc *ProductUseCase) getProduct(ctx context.Context, id uint) (*entity.Product, error) {
+	product, err := uc.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrProductNotFound) {
+			return nil, pkgerrors.NewNotFoundError("Товар", id)
+		}
+		return nil, fmt.Errorf("ошибка при получении товара: %w", err)
+	}
+
+	return product, nil
+}
+
+func toProductResponse(product *entity.Product) entity.ProductResponse {
+	return entity.ProductResponse{
+		ID:          product.ID,
+		SKU:         product.SKU,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Currency:    product.Currency,
+		Active:      product.Active,
+		CreatedAt:   product.CreatedAt,
+		UpdatedAt:   product.UpdatedAt,
+	}
+}
@@ -7,25 +7,43 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/pkg/auth"
+	"github.com/director74/dz7_shop/pkg/idempotency"
+	"github.com/director74/dz7_shop/pkg/money"
 )
 
-// BillingClient представляет HTTP клиент для работы с сервисом биллинга
+// BillingClient представляет HTTP клиент для работы с сервисом биллинга. Запросы отправляются
+// во внутренние эндпоинты биллинга с токеном сервиса, операции со счетом - от имени пользователя userID
 type BillingClient struct {
 	baseURL    string
 	httpClient *http.Client
+	tokens     auth.TokenSource
 }
 
-func NewBillingClient(baseURL string) *BillingClient {
+func NewBillingClient(baseURL string, tokens auth.TokenSource) *BillingClient {
 	return &BillingClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		tokens: tokens,
+	}
+}
+
+// authorize добавляет в запрос токен сервиса
+func (c *BillingClient) authorize(req *http.Request) error {
+	token, err := c.tokens.Token()
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
 }
 
 func (c *BillingClient) CreateAccount(ctx context.Context, userID uint) error {
-	url := fmt.Sprintf("%s/api/v1/accounts", c.baseURL)
+	url := fmt.Sprintf("%s/internal/accounts", c.baseURL)
 
 	reqBody := map[string]interface{}{
 		"user_id": userID,
@@ -42,6 +60,9 @@ func (c *BillingClient) CreateAccount(ctx context.Context, userID uint) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if err := c.authorize(req); err != nil {
+		return err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -56,55 +77,274 @@ func (c *BillingClient) CreateAccount(ctx context.Context, userID uint) error {
 	return nil
 }
 
-// WithdrawMoney снимает деньги с аккаунта в сервисе биллинга
-func (c *BillingClient) WithdrawMoney(ctx context.Context, userID uint, amount float64, email string, token string) (bool, error) {
-	url := fmt.Sprintf("%s/api/v1/billing/withdraw", c.baseURL)
+// paymentAttempts количество попыток платежного запроса при сетевых ошибках и сбоях биллинга
+const paymentAttempts = 3
+
+// retryPayment повторяет попытку платежного запроса, пока она сообщает, что повтор возможен.
+// Повторять безопасно только запросы с ключом идемпотентности
+func retryPayment(ctx context.Context, attempt func() (bool, error)) error {
+	var lastErr error
+	for i := 1; i <= paymentAttempts; i++ {
+		retry, err := attempt()
+		if err == nil || !retry {
+			return err
+		}
+		lastErr = err
+
+		if i < paymentAttempts {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %v", lastErr, ctx.Err())
+			case <-time.After(time.Duration(i) * 200 * time.Millisecond):
+			}
+		}
+	}
+
+	return fmt.Errorf("запрос не выполнен после %d попыток: %w", paymentAttempts, lastErr)
+}
+
+// WithdrawMoney снимает деньги с аккаунта в сервисе биллинга в оплату заказа orderID. Запрос отправляется
+// с ключом идемпотентности, поэтому повтор после сетевой ошибки не приводит к повторному списанию
+func (c *BillingClient) WithdrawMoney(ctx context.Context, userID uint, orderID uint, amount money.Money, email string, idempotencyKey string) (entity.PaymentResult, error) {
+	var result entity.PaymentResult
+	err := retryPayment(ctx, func() (bool, error) {
+		var retry bool
+		var err error
+		result, retry, err = c.withdraw(ctx, userID, orderID, amount, email, idempotencyKey)
+		return retry, err
+	})
+	if err != nil {
+		return entity.PaymentResult{}, err
+	}
+	return result, nil
+}
+
+// withdraw выполняет одну попытку списания и сообщает, можно ли ее повторить
+func (c *BillingClient) withdraw(ctx context.Context, userID uint, orderID uint, amount money.Money, email string, idempotencyKey string) (entity.PaymentResult, bool, error) {
+	url := fmt.Sprintf("%s/internal/users/%d/withdraw", c.baseURL, userID)
 
 	reqBody := map[string]interface{}{
-		"user_id": userID,
-		"amount":  amount,
-		"email":   email,
+		"user_id":  userID,
+		"amount":   amount,
+		"email":    email,
+		"order_id": orderID,
 	}
 
 	reqBodyJSON, err := json.Marshal(reqBody)
 	if err != nil {
-		return false, fmt.Errorf("ошибка при маршалинге запроса: %w", err)
+		return entity.PaymentResult{}, false, fmt.Errorf("ошибка при маршалинге запроса: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBodyJSON))
 	if err != nil {
-		return false, fmt.Errorf("ошибка при создании запроса: %w", err)
+		return entity.PaymentResult{}, false, fmt.Errorf("ошибка при создании запроса: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-
-	// Добавляем JWT токен в заголовок авторизации
-	if token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if idempotencyKey != "" {
+		req.Header.Set(idempotency.HeaderKey, idempotencyKey)
+	}
+	if err := c.authorize(req); err != nil {
+		return entity.PaymentResult{}, false, err
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return false, fmt.Errorf("ошибка при выполнении запроса: %w", err)
+		return entity.PaymentResult{}, idempotencyKey != "", fmt.Errorf("ошибка при выполнении запроса: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusBadRequest {
 		// Недостаточно средств
-		return false, nil
+		return entity.PaymentResult{Success: false}, false, nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("неуспешный ответ от сервиса биллинга: %s", resp.Status)
+		// Без ключа повтор небезопасен. С ключом повторяем сбои биллинга и запрос, который еще выполняется
+		retry := idempotencyKey != "" &&
+			(resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusConflict)
+		return entity.PaymentResult{}, retry, fmt.Errorf("неуспешный ответ от сервиса биллинга: %s", resp.Status)
 	}
 
 	var response struct {
+		Transaction struct {
+			ID uint `json:"id"`
+		} `json:"transaction"`
 		Success bool `json:"success"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return false, fmt.Errorf("ошибка при декодировании ответа: %w", err)
+		return entity.PaymentResult{}, false, fmt.Errorf("ошибка при декодировании ответа: %w", err)
+	}
+
+	return entity.PaymentResult{
+		Success:       response.Success,
+		TransactionID: response.Transaction.ID,
+	}, false, nil
+}
+
+// AuthorizePayment резервирует средства в биллинге под оплату заказа orderID. Успешный результат
+// содержит ID холда, при недостатке средств возвращается Success=false
+func (c *BillingClient) AuthorizePayment(ctx context.Context, userID uint, orderID uint, amount money.Money, description string, idempotencyKey string) (entity.PaymentResult, error) {
+	url := fmt.Sprintf("%s/internal/users/%d/holds", c.baseURL, userID)
+	body := map[string]interface{}{
+		"amount":      amount,
+		"description": description,
+		"order_id":    orderID,
+	}
+
+	var result entity.PaymentResult
+	err := retryPayment(ctx, func() (bool, error) {
+		var response struct {
+			ID uint `json:"id"`
+		}
+
+		status, retry, err := c.postPayment(ctx, url, body, idempotencyKey, &response, http.StatusCreated)
+		if err != nil {
+			return retry, err
+		}
+
+		switch status {
+		case http.StatusCreated:
+			result = entity.PaymentResult{Success: true, HoldID: response.ID}
+			return false, nil
+		case http.StatusBadRequest:
+			// Недостаточно средств
+			result = entity.PaymentResult{Success: false}
+			return false, nil
+		case http.StatusConflict:
+			// Запрос с тем же ключом еще выполняется или заказ уже оплачен
+			return idempotencyKey != "", fmt.Errorf("резервирование с ключом %s еще выполняется или заказ %d уже оплачен", idempotencyKey, orderID)
+		default:
+			return false, fmt.Errorf("неуспешный ответ от сервиса биллинга: %d", status)
+		}
+	})
+	if err != nil {
+		return entity.PaymentResult{}, err
+	}
+	return result, nil
+}
+
+// CapturePayment списывает зарезервированные средства по холду. Если холд уже закрыт
+// или истек, возвращает entity.ErrPaymentHoldClosed
+func (c *BillingClient) CapturePayment(ctx context.Context, userID uint, holdID uint, amount money.Money, idempotencyKey string) (entity.PaymentResult, error) {
+	url := fmt.Sprintf("%s/internal/users/%d/holds/%d/capture", c.baseURL, userID, holdID)
+	body := map[string]interface{}{
+		"amount": amount,
+	}
+
+	var result entity.PaymentResult
+	err := retryPayment(ctx, func() (bool, error) {
+		var response struct {
+			Transaction struct {
+				ID uint `json:"id"`
+			} `json:"transaction"`
+		}
+
+		status, retry, err := c.postPayment(ctx, url, body, idempotencyKey, &response, http.StatusOK)
+		if err != nil {
+			return retry, err
+		}
+		if status == http.StatusConflict || status == http.StatusNotFound {
+			return false, entity.ErrPaymentHoldClosed
+		}
+		if status == http.StatusBadRequest {
+			return false, fmt.Errorf("биллинг отклонил списание по холду %d", holdID)
+		}
+
+		result = entity.PaymentResult{Success: true, TransactionID: response.Transaction.ID, HoldID: holdID}
+		return false, nil
+	})
+	if err != nil {
+		return entity.PaymentResult{}, err
+	}
+	return result, nil
+}
+
+// VoidPayment отменяет холд и освобождает зарезервированные средства
+func (c *BillingClient) VoidPayment(ctx context.Context, userID uint, holdID uint) error {
+	url := fmt.Sprintf("%s/internal/users/%d/holds/%d/void", c.baseURL, userID, holdID)
+
+	return retryPayment(ctx, func() (bool, error) {
+		status, _, err := c.postPayment(ctx, url, nil, "", nil, http.StatusOK)
+		switch {
+		case err != nil:
+			// Отмена холда идемпотентна в биллинге, поэтому сетевые ошибки и сбои можно повторять без ключа
+			return status == 0 || status >= http.StatusInternalServerError, err
+		case status == http.StatusConflict:
+			return false, entity.ErrPaymentHoldClosed
+		case status != http.StatusOK:
+			return false, fmt.Errorf("холд %d не найден в сервисе биллинга", holdID)
+		}
+		return false, nil
+	})
+}
+
+// postPayment отправляет платежный POST-запрос и декодирует успешный ответ в out.
+// Коды 400, 404 и 409 возвращаются вызывающему без ошибки; для остальных неуспешных
+// ответов сообщает, можно ли повторить запрос
+func (c *BillingClient) postPayment(ctx context.Context, url string, body interface{}, idempotencyKey string, out interface{}, successStatus int) (int, bool, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return 0, false, fmt.Errorf("ошибка при маршалинге запроса: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return 0, false, fmt.Errorf("ошибка при создании запроса: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(idempotency.HeaderKey, idempotencyKey)
+	}
+	if err := c.authorize(req); err != nil {
+		return 0, false, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, idempotencyKey != "", fmt.Errorf("ошибка при выполнении запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case successStatus:
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return resp.StatusCode, false, fmt.Errorf("ошибка при декодировании ответа: %w", err)
+			}
+		}
+		return resp.StatusCode, false, nil
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict:
+		return resp.StatusCode, false, nil
 	}
 
-	return response.Success, nil
+	// Без ключа повтор небезопасен. С ключом повторяем сбои биллинга
+	retry := idempotencyKey != "" && resp.StatusCode >= http.StatusInternalServerError
+	return resp.StatusCode, retry, fmt.Errorf("неуспешный ответ от сервиса биллинга: %s", resp.Status)
+}
+
+// Refund возвращает деньги по ранее выполненному списанию. Запрос отправляется с ключом
+// идемпотентности, поэтому повтор после сетевой ошибки не приводит к повторному возврату
+func (c *BillingClient) Refund(ctx context.Context, refund entity.RefundRequest, idempotencyKey string) error {
+	url := fmt.Sprintf("%s/internal/users/%d/refund", c.baseURL, refund.UserID)
+
+	return retryPayment(ctx, func() (bool, error) {
+		status, retry, err := c.postPayment(ctx, url, refund, idempotencyKey, nil, http.StatusOK)
+		switch {
+		case err != nil:
+			return retry, err
+		case status == http.StatusConflict:
+			// Запрос с тем же ключом еще выполняется, либо возврат невозможен
+			return idempotencyKey != "", fmt.Errorf("биллинг отклонил возврат по транзакции %d: %d", refund.TransactionID, status)
+		case status != http.StatusOK:
+			return false, fmt.Errorf("биллинг отклонил возврат по транзакции %d: %d", refund.TransactionID, status)
+		}
+		return false, nil
+	})
 }
@@ -0,0 +1,124 @@
+// Package ordertest запускает сервис заказов в памяти процесса: настоящий usecase в режиме оплаты
+// async и обработчик результатов оплаты из app.SetupMessaging работают на переданном брокере, данные
+// хранятся в памяти. Нужен тестам, которые проверяют сагу оплаты заказа на всех трех сервисах в одном процессе
+package ordertest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/director74/dz7_shop/order-service/app"
+	"github.com/director74/dz7_shop/order-service/internal/entity"
+	"github.com/director74/dz7_shop/order-service/internal/repo/memory"
+	"github.com/director74/dz7_shop/order-service/internal/usecase"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/money"
+	"github.com/director74/dz7_shop/pkg/outbox"
+)
+
+// errSyncBilling ошибка HTTP-запроса к биллингу: в режиме async оплата и возвраты идут событиями
+var errSyncBilling = errors.New("сервис заказов в памяти обращается к биллингу только событиями")
+
+// eventBilling BillingService режима async, в котором HTTP-запросы к биллингу не выполняются
+type eventBilling struct{}
+
+func (eventBilling) CreateAccount(context.Context, uint) error {
+	return errSyncBilling
+}
+
+func (eventBilling) WithdrawMoney(context.Context, uint, uint, money.Money, string, string) (entity.PaymentResult, error) {
+	return entity.PaymentResult{}, errSyncBilling
+}
+
+func (eventBilling) Refund(context.Context, entity.RefundRequest, string) error {
+	return errSyncBilling
+}
+
+func (eventBilling) AuthorizePayment(context.Context, uint, uint, money.Money, string, string) (entity.PaymentResult, error) {
+	return entity.PaymentResult{}, errSyncBilling
+}
+
+func (eventBilling) CapturePayment(context.Context, uint, uint, money.Money, string) (entity.PaymentResult, error) {
+	return entity.PaymentResult{}, errSyncBilling
+}
+
+func (eventBilling) VoidPayment(context.Context, uint, uint) error {
+	return errSyncBilling
+}
+
+// Service сервис заказов в памяти процесса
+type Service struct {
+	users        *memory.UserRepository
+	products     *memory.ProductRepository
+	orderUseCase *usecase.OrderUseCase
+}
+
+// Start объявляет очереди сервиса заказов на broker и подписывает обработчик результатов оплаты.
+// События outbox публикуются в broker сразу после фиксации транзакции
+func Start(broker messaging.MessageBroker) (*Service, error) {
+	orders := memory.NewOrderRepository()
+	orders.OnCommit(outbox.PublishCommitted(broker))
+	users := memory.NewUserRepository()
+	products := memory.NewProductRepository()
+
+	orderUseCase := usecase.NewOrderUseCase(orders, users, products, eventBilling{}, "order_events", usecase.PaymentModeAsync)
+	if err := app.SetupMessaging(broker, orderUseCase); err != nil {
+		return nil, err
+	}
+	return &Service{users: users, products: products, orderUseCase: orderUseCase}, nil
+}
+
+// CreateUser создает пользователя и возвращает его ID. Счет в биллинге создается отдельно
+func (s *Service) CreateUser(ctx context.Context, username, email string) (uint, error) {
+	user := &entity.User{Username: username, Email: email, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := s.users.Create(ctx, user); err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// AddProduct добавляет в каталог активный товар и возвращает его ID
+func (s *Service) AddProduct(ctx context.Context, sku string, price money.Money) (uint, error) {
+	product := &entity.Product{
+		SKU:       sku,
+		Name:      sku,
+		Price:     price,
+		Currency:  price.Currency(),
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.products.Create(ctx, product); err != nil {
+		return 0, err
+	}
+	return product.ID, nil
+}
+
+// CreateOrder создает заказ пользователя на quantity единиц товара и возвращает его ID.
+// Заказ остается в pending до результата оплаты от биллинга
+func (s *Service) CreateOrder(ctx context.Context, userID, productID uint, quantity int) (uint, error) {
+	order, err := s.orderUseCase.CreateOrder(ctx, entity.CreateOrderRequest{
+		UserID: userID,
+		Items:  []entity.CreateOrderItemRequest{{ProductID: productID, Quantity: quantity}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return order.ID, nil
+}
+
+// OrderStatus возвращает статус заказа
+func (s *Service) OrderStatus(ctx context.Context, orderID uint) (string, error) {
+	order, err := s.orderUseCase.GetOrder(ctx, orderID)
+	if err != nil {
+		return "", err
+	}
+	return string(order.Status), nil
+}
+
+// CancelOrder отменяет заказ. Оплаченный заказ биллинг вернет по событию order.payment_compensation
+func (s *Service) CancelOrder(ctx context.Context, orderID uint) error {
+	_, err := s.orderUseCase.ChangeOrderStatus(ctx, orderID, entity.OrderStatusCanceled)
+	return err
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksFetchTimeout таймаут запроса JWKS
+	jwksFetchTimeout = 5 * time.Second
+	// jwksMinRefetchInterval минимальный интервал между запросами JWKS, чтобы токены с неизвестным kid
+	// и недоступность JWKS не приводили к запросу на каждый проверяемый токен
+	jwksMinRefetchInterval = 10 * time.Second
+)
+
+// JWK открытый ключ в формате JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N и E модуль и экспонента ключа RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve и X кривая и открытый ключ Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet набор открытых ключей, который отдает /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK кодирует открытый ключ RSA или Ed25519
+func NewJWK(kid string, method jwt.SigningMethod, publicKey crypto.PublicKey) (JWK, error) {
+	jwk := JWK{
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: method.Alg(),
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JWK{}, fmt.Errorf("неподдерживаемый тип ключа %s: %T", kid, publicKey)
+	}
+	return jwk, nil
+}
+
+// PublicKey декодирует метод подписи и открытый ключ
+func (j JWK) PublicKey() (jwt.SigningMethod, crypto.PublicKey, error) {
+	switch {
+	case j.KeyType == "RSA" && j.Algorithm == AlgorithmRS256:
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, nil, fmt.Errorf("некорректный модуль ключа %s: %w", j.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, nil, fmt.Errorf("некорректная экспонента ключа %s: %w", j.KeyID, err)
+		}
+		return jwt.SigningMethodRS256, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case j.KeyType == "OKP" && j.Curve == "Ed25519" && j.Algorithm == AlgorithmEdDSA:
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("некорректный ключ Ed25519 %s", j.KeyID)
+		}
+		return jwt.SigningMethodEdDSA, ed25519.PublicKey(x), nil
+	default:
+		return nil, nil, fmt.Errorf("неподдерживаемый ключ %s: kty=%s alg=%s", j.KeyID, j.KeyType, j.Algorithm)
+	}
+}
+
+// JWKSHandler отдает открытые ключи keys. Клиенты кэшируют ответ, новый ключ они запросят
+// при первом токене с неизвестным kid
+func JWKSHandler(keys *KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set, err := keys.JWKS()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, set)
+	}
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+// JWKSClient источник открытых ключей, загружаемых из JWKS сервиса, выпускающего токены.
+// Ключи кэшируются на cacheTTL, токен с неизвестным kid вызывает внеочередную загрузку
+type JWKSClient struct {
+	url      string
+	cacheTTL time.Duration
+	client   *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]verificationKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewJWKSClient(url string, cacheTTL time.Duration) *JWKSClient {
+	return &JWKSClient{
+		url:      url,
+		cacheTTL: cacheTTL,
+		client:   &http.Client{Timeout: jwksFetchTimeout},
+	}
+}
+
+func (c *JWKSClient) VerificationKey(kid string) (jwt.SigningMethod, crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, known := c.keys[kid]
+	stale := time.Since(c.fetchedAt) >= c.cacheTTL
+	if (stale || !known) && time.Since(c.attemptedAt) >= jwksMinRefetchInterval {
+		c.attemptedAt = time.Now()
+		if err := c.fetch(); err != nil {
+			// Пока JWKS недоступен, проверяем токены ранее загруженными ключами
+			if !known {
+				return nil, nil, err
+			}
+			log.Printf("Ошибка при обновлении JWKS, используются загруженные ранее ключи: %v", err)
+		} else {
+			key, known = c.keys[kid]
+		}
+	}
+
+	if !known {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	return key.method, key.key, nil
+}
+
+// fetch загружает JWKS. Вызывается под c.mu
+func (c *JWKSClient) fetch() error {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании запроса JWKS: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка при запросе JWKS %s: %w", c.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS %s вернул статус %d", c.url, resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("ошибка при разборе JWKS: %w", err)
+	}
+
+	keys := make(map[string]verificationKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		method, key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Ключ JWKS пропущен: %v", err)
+			continue
+		}
+		keys[jwk.KeyID] = verificationKey{method: method, key: key}
+	}
+
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
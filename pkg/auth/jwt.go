@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -8,30 +10,43 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// TokenClaims содержит данные пользователя и стандартные JWT claims
+// TokenClaims содержит данные пользователя, его роли и разрешения и стандартные JWT claims
 type TokenClaims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	UserID      uint     `json:"user_id"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // Config содержит настройки для JWT токенов
 type Config struct {
-	SigningKey     string
-	TokenTTL       time.Duration
-	SigningMethod  jwt.SigningMethod
+	// SigningKey общий секрет для HS256
+	SigningKey string
+	// TokenTTL время жизни access токена
+	TokenTTL time.Duration
+	// RefreshTokenTTL время жизни refresh токена, по которому выдается новая пара токенов
+	RefreshTokenTTL time.Duration
+	// ServiceTokenTTL время жизни токена сервиса
+	ServiceTokenTTL time.Duration
+	SigningMethod   jwt.SigningMethod
+	// Keys ключи проверки подписи RS256 и EdDSA. Подписывать токены может только KeySigner,
+	// сервисам, которые только проверяют токены, достаточно JWKSClient
+	Keys           KeySource
 	TokenIssuer    string
 	TokenAudiences []string
 }
 
 func NewConfig(signingKey string) *Config {
 	return &Config{
-		SigningKey:     signingKey,
-		TokenTTL:       24 * time.Hour,
-		SigningMethod:  jwt.SigningMethodHS256,
-		TokenIssuer:    "auth-service",
-		TokenAudiences: []string{"microservices"},
+		SigningKey:      signingKey,
+		TokenTTL:        15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		ServiceTokenTTL: 5 * time.Minute,
+		SigningMethod:   jwt.SigningMethodHS256,
+		TokenIssuer:     "auth-service",
+		TokenAudiences:  []string{"microservices"},
 	}
 }
 
@@ -46,15 +61,34 @@ func NewJWTManager(config *Config) *JWTManager {
 	}
 }
 
+// TokenTTL возвращает время жизни access токена
+func (m *JWTManager) TokenTTL() time.Duration {
+	return m.config.TokenTTL
+}
+
+// RefreshTokenTTL возвращает время жизни refresh токена
+func (m *JWTManager) RefreshTokenTTL() time.Duration {
+	return m.config.RefreshTokenTTL
+}
+
 // GenerateToken создаёт JWT токен с данными пользователя и временем истечения,
-// установленным в конфигурации
-func (m *JWTManager) GenerateToken(userID uint, username, email string) (string, error) {
+// установленным в конфигурации. Каждый токен получает уникальный jti, по которому его можно отозвать.
+// В токен записываются роли пользователя и разрешения этих ролей
+func (m *JWTManager) GenerateToken(userID uint, username, email string, roles []string) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("ошибка генерации идентификатора токена: %w", err)
+	}
+
 	now := time.Now()
 	claims := TokenClaims{
-		UserID:   userID,
-		Username: username,
-		Email:    email,
+		UserID:      userID,
+		Username:    username,
+		Email:       email,
+		Roles:       roles,
+		Permissions: RolePermissions(roles),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.config.TokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
@@ -63,26 +97,92 @@ func (m *JWTManager) GenerateToken(userID uint, username, email string) (string,
 		},
 	}
 
-	token := jwt.NewWithClaims(m.config.SigningMethod, claims)
-	return token.SignedString([]byte(m.config.SigningKey))
+	return m.sign(claims)
 }
 
-// ParseToken проверяет валидность JWT токена и извлекает из него данные
+// sign подписывает claims общим секретом HS256 или текущим ключом подписи
+func (m *JWTManager) sign(claims jwt.Claims) (string, error) {
+	if isHMAC(m.config.SigningMethod) {
+		token := jwt.NewWithClaims(m.config.SigningMethod, claims)
+		return token.SignedString([]byte(m.config.SigningKey))
+	}
+
+	signer, ok := m.config.Keys.(KeySigner)
+	if !ok {
+		return "", errors.New("сервис не может подписывать токены: нет ключа подписи")
+	}
+	key, err := signer.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// ParseToken проверяет валидность JWT токена пользователя и извлекает из него данные.
+// Токены сервисов выпускаются для другой аудитории и не принимаются
 func (m *JWTManager) ParseToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if len(m.config.TokenAudiences) > 0 {
+		options = append(options, jwt.WithAudience(m.config.TokenAudiences[0]))
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, m.verificationKey, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("недействительный токен")
+	}
+	// Токен без jti нельзя отозвать
+	if claims.ID == "" {
+		return nil, errors.New("токен не содержит идентификатор")
+	}
+
+	return claims, nil
+}
+
+// verificationKey возвращает ключ проверки подписи токена. Метод подписи токена должен совпадать
+// с методом ключа, иначе открытый ключ можно было бы выдать за секрет HMAC
+func (m *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	if isHMAC(m.config.SigningMethod) {
+		if !isHMAC(token.Method) {
 			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
 		}
 		return []byte(m.config.SigningKey), nil
-	})
+	}
 
+	if m.config.Keys == nil {
+		return nil, errors.New("не заданы ключи проверки подписи")
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("токен не содержит kid")
+	}
+	method, key, err := m.config.Keys.VerificationKey(kid)
 	if err != nil {
 		return nil, err
 	}
-
-	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
-		return claims, nil
+	if token.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
 	}
+	return key, nil
+}
+
+func isHMAC(method jwt.SigningMethod) bool {
+	_, ok := method.(*jwt.SigningMethodHMAC)
+	return ok
+}
 
-	return nil, errors.New("недействительный токен")
+// newTokenID генерирует случайный идентификатор токена
+func newTokenID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
 }
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Алгоритмы подписи токенов
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	// rsaKeyBits размер генерируемых ключей RSA
+	rsaKeyBits = 2048
+	// keyRefreshInterval период перечитывания ключей, чтобы экземпляры сервиса подхватывали
+	// ключи, созданные другими экземплярами
+	keyRefreshInterval = time.Minute
+	// keyActivationDelay время между публикацией нового ключа и началом подписи им. За это время
+	// ключ узнают остальные экземпляры сервиса и клиенты JWKS, ограничивающие частоту запросов
+	keyActivationDelay = 2*keyRefreshInterval + jwksMinRefetchInterval
+)
+
+// ErrUnknownKey ошибка, когда ключ с kid из заголовка токена не найден
+var ErrUnknownKey = errors.New("неизвестный ключ подписи")
+
+// SigningMethod возвращает метод подписи для алгоритма HS256, RS256 или EdDSA
+func SigningMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый алгоритм подписи JWT: %s", algorithm)
+	}
+}
+
+// KeySource источник открытых ключей, которыми проверяется асимметричная подпись токенов
+type KeySource interface {
+	// VerificationKey возвращает метод подписи и открытый ключ kid
+	VerificationKey(kid string) (jwt.SigningMethod, crypto.PublicKey, error)
+}
+
+// KeySigner источник ключа, которым подписываются новые токены
+type KeySigner interface {
+	SigningKey() (*SigningKey, error)
+}
+
+// SigningKey закрытый ключ подписи с идентификатором kid, который записывается в заголовок токена
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	CreatedAt  time.Time
+}
+
+// StoredSigningKey закрытый ключ подписи в хранилище в формате PKCS #8
+type StoredSigningKey struct {
+	KeyID      string    `gorm:"primaryKey;size:64"`
+	Algorithm  string    `gorm:"size:16;not null"`
+	PrivateKey []byte    `gorm:"type:bytea;not null"`
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+func (StoredSigningKey) TableName() string {
+	return "jwt_signing_keys"
+}
+
+// KeyStore хранилище ключей подписи, общее для всех экземпляров сервиса, выпускающего токены
+type KeyStore interface {
+	ListKeys(ctx context.Context) ([]StoredSigningKey, error)
+	AddKey(ctx context.Context, key StoredSigningKey) error
+	DeleteKey(ctx context.Context, kid string) error
+}
+
+// PostgresKeyStore реализация хранилища ключей подписи на GORM
+type PostgresKeyStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresKeyStore(db *gorm.DB) *PostgresKeyStore {
+	return &PostgresKeyStore{
+		db: db,
+	}
+}
+
+func (s *PostgresKeyStore) ListKeys(ctx context.Context) ([]StoredSigningKey, error) {
+	var keys []StoredSigningKey
+	err := s.db.WithContext(ctx).Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+func (s *PostgresKeyStore) AddKey(ctx context.Context, key StoredSigningKey) error {
+	return s.db.WithContext(ctx).Create(&key).Error
+}
+
+func (s *PostgresKeyStore) DeleteKey(ctx context.Context, kid string) error {
+	return s.db.WithContext(ctx).Delete(&StoredSigningKey{}, "key_id = ?", kid).Error
+}
+
+// KeySetConfig настройки ротации ключей подписи
+type KeySetConfig struct {
+	// Algorithm алгоритм подписи RS256 или EdDSA
+	Algorithm string
+	// RotationInterval возраст ключа, после которого создается новый ключ подписи
+	RotationInterval time.Duration
+	// TokenTTL время жизни access токена. Замененный ключ публикуется, пока не истекут подписанные им токены
+	TokenTTL time.Duration
+}
+
+// KeySet ключи подписи сервиса, выпускающего токены. Новые токены подписываются последним ключом,
+// опубликованным дольше keyActivationDelay, проверяются всеми опубликованными. Ключи хранятся
+// в KeyStore, поэтому переживают перезапуск и одинаковы во всех экземплярах сервиса
+type KeySet struct {
+	store  KeyStore
+	config KeySetConfig
+	method jwt.SigningMethod
+
+	mu sync.RWMutex
+	// keys опубликованные ключи по возрастанию CreatedAt
+	keys []*SigningKey
+}
+
+// NewKeySet загружает ключи из store и создает ключ подписи, если его нет или он устарел
+func NewKeySet(ctx context.Context, store KeyStore, config KeySetConfig) (*KeySet, error) {
+	if config.Algorithm != AlgorithmRS256 && config.Algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("ключи подписи поддерживают только %s и %s, указан %s", AlgorithmRS256, AlgorithmEdDSA, config.Algorithm)
+	}
+	method, err := SigningMethod(config.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &KeySet{
+		store:  store,
+		config: config,
+		method: method,
+	}
+	if err := k.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// SigningKey возвращает текущий ключ подписи. Новый ключ используется сразу, только если
+// других ключей нет
+func (k *KeySet) SigningKey() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("нет ключа подписи токенов")
+	}
+
+	activeBefore := time.Now().Add(-keyActivationDelay)
+	for i := len(k.keys) - 1; i > 0; i-- {
+		if k.keys[i].CreatedAt.Before(activeBefore) {
+			return k.keys[i], nil
+		}
+	}
+	return k.keys[0], nil
+}
+
+func (k *KeySet) VerificationKey(kid string) (jwt.SigningMethod, crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key.Method, key.PrivateKey.Public(), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+}
+
+// JWKS возвращает опубликованные открытые ключи
+func (k *KeySet) JWKS() (JWKSet, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk, err := NewJWK(key.ID, key.Method, key.PrivateKey.Public())
+		if err != nil {
+			return JWKSet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// Refresh перечитывает ключи из хранилища, создает новый ключ подписи, если текущий старше
+// RotationInterval, и удаляет ключи, которыми подписаны только истекшие токены
+func (k *KeySet) Refresh(ctx context.Context) error {
+	keys, err := k.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(keys) == 0 || now.Sub(keys[len(keys)-1].CreatedAt) >= k.config.RotationInterval {
+		key, err := k.generate(ctx, now)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		log.Printf("Создан новый ключ подписи JWT %s (%s)", key.ID, k.config.Algorithm)
+	}
+
+	// Ключ подписывает токены до активации следующего и публикуется, пока они не истекут
+	published := keys[:0]
+	for i, key := range keys {
+		if i < len(keys)-1 && now.Sub(keys[i+1].CreatedAt) > keyActivationDelay+k.config.TokenTTL {
+			if err := k.store.DeleteKey(ctx, key.ID); err != nil {
+				return fmt.Errorf("ошибка при удалении ключа подписи %s: %w", key.ID, err)
+			}
+			continue
+		}
+		published = append(published, key)
+	}
+
+	k.mu.Lock()
+	k.keys = published
+	k.mu.Unlock()
+
+	return nil
+}
+
+// RunRotation раз в минуту обновляет ключи до отмены контекста
+func (k *KeySet) RunRotation(ctx context.Context) {
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := k.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка при ротации ключей подписи JWT: %v", err)
+		}
+	}
+}
+
+// load читает ключи алгоритма KeySet из хранилища. Ключи другого алгоритма, оставшиеся после смены
+// JWT_ALGORITHM, не используются
+func (k *KeySet) load(ctx context.Context) ([]*SigningKey, error) {
+	stored, err := k.store.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при загрузке ключей подписи: %w", err)
+	}
+
+	keys := make([]*SigningKey, 0, len(stored))
+	for _, s := range stored {
+		if s.Algorithm != k.config.Algorithm {
+			continue
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(s.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при разборе ключа подписи %s: %w", s.KeyID, err)
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("ключ подписи %s не поддерживает подпись", s.KeyID)
+		}
+		keys = append(keys, &SigningKey{
+			ID:         s.KeyID,
+			Method:     k.method,
+			PrivateKey: signer,
+			CreatedAt:  s.CreatedAt,
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// generate создает и сохраняет новый ключ подписи
+func (k *KeySet) generate(ctx context.Context, now time.Time) (*SigningKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch k.config.Algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации ключа подписи: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации ключа подписи: %w", err)
+	}
+	kid, err := newTokenID()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации идентификатора ключа: %w", err)
+	}
+
+	err = k.store.AddKey(ctx, StoredSigningKey{
+		KeyID:      kid,
+		Algorithm:  k.config.Algorithm,
+		PrivateKey: der,
+		CreatedAt:  now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при сохранении ключа подписи: %w", err)
+	}
+
+	return &SigningKey{
+		ID:         kid,
+		Method:     k.method,
+		PrivateKey: signer,
+		CreatedAt:  now,
+	}, nil
+}
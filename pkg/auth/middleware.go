@@ -2,45 +2,53 @@ package auth
 
 import (
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // AuthMiddleware middleware для проверки JWT токена
 type AuthMiddleware struct {
-	jwtManager *JWTManager
+	jwtManager  *JWTManager
+	revocations RevocationStore
 }
 
-// NewAuthMiddleware создает новый middleware для проверки авторизации
-func NewAuthMiddleware(jwtManager *JWTManager) *AuthMiddleware {
+// NewAuthMiddleware создает новый middleware для проверки авторизации. Токены, отозванные
+// в revocations, отклоняются
+func NewAuthMiddleware(jwtManager *JWTManager, revocations RevocationStore) *AuthMiddleware {
 	return &AuthMiddleware{
-		jwtManager: jwtManager,
+		jwtManager:  jwtManager,
+		revocations: revocations,
 	}
 }
 
 // AuthRequired middleware требует авторизации для доступа к endpoint
 func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
-			c.Abort()
+		tokenString, ok := bearerToken(c)
+		if !ok {
 			return
 		}
 
-		// Проверяем формат токена "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "неверный формат токена авторизации"})
+		// Парсим и проверяем токен
+		claims, err := m.jwtManager.ParseToken(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен: " + err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Парсим и проверяем токен
-		claims, err := m.jwtManager.ParseToken(parts[1])
+		// Проверяем, что токен не отозван выходом из сессии
+		revoked, err := m.revocations.IsRevoked(c.Request.Context(), claims)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен: " + err.Error()})
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "не удалось проверить токен авторизации"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "токен отозван"})
 			c.Abort()
 			return
 		}
@@ -49,7 +57,60 @@ func (m *AuthMiddleware) AuthRequired() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
-		c.Set("jwt_token", parts[1])
+		c.Set("jwt_token", tokenString)
+		c.Set("token_id", claims.ID)
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+
+		c.Next()
+	}
+}
+
+// bearerToken возвращает токен из заголовка "Authorization: Bearer <token>". Если токена нет
+// или формат неверный, отвечает 401 и прерывает обработку запроса
+func bearerToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
+		c.Abort()
+		return "", false
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "неверный формат токена авторизации"})
+		c.Abort()
+		return "", false
+	}
+	return parts[1], true
+}
+
+// RequireRole middleware пропускает пользователей, у которых есть хотя бы одна из ролей roles.
+// Подключается после AuthRequired
+func (m *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, role := range roles {
+			if HasRole(c, role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "недостаточно прав"})
+		c.Abort()
+	}
+}
+
+// RequirePermission middleware пропускает пользователей с разрешением permission.
+// Подключается после AuthRequired
+func (m *AuthMiddleware) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "недостаточно прав"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
@@ -78,3 +139,49 @@ func GetEmail(c *gin.Context) string {
 	}
 	return email.(string)
 }
+
+// GetTokenID возвращает jti токена текущего запроса
+func GetTokenID(c *gin.Context) string {
+	tokenID, exists := c.Get("token_id")
+	if !exists {
+		return ""
+	}
+	return tokenID.(string)
+}
+
+// GetTokenExpiresAt возвращает время истечения токена текущего запроса
+func GetTokenExpiresAt(c *gin.Context) time.Time {
+	expiresAt, exists := c.Get("token_expires_at")
+	if !exists {
+		return time.Time{}
+	}
+	return expiresAt.(time.Time)
+}
+
+// GetRoles возвращает роли пользователя из токена текущего запроса
+func GetRoles(c *gin.Context) []string {
+	roles, exists := c.Get("roles")
+	if !exists {
+		return nil
+	}
+	return roles.([]string)
+}
+
+// GetPermissions возвращает разрешения пользователя из токена текущего запроса
+func GetPermissions(c *gin.Context) []string {
+	permissions, exists := c.Get("permissions")
+	if !exists {
+		return nil
+	}
+	return permissions.([]string)
+}
+
+// HasRole проверяет, что у пользователя текущего запроса есть роль role
+func HasRole(c *gin.Context, role string) bool {
+	return slices.Contains(GetRoles(c), role)
+}
+
+// HasPermission проверяет, что у пользователя текущего запроса есть разрешение permission
+func HasPermission(c *gin.Context, permission string) bool {
+	return slices.Contains(GetPermissions(c), permission)
+}
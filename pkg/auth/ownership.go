@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CanAccess проверяет, что ресурс пользователя ownerID доступен пользователю запроса: он владелец
+// ресурса или у него есть разрешение permission. Пустой permission оставляет доступ только владельцу.
+// Недоступный ресурс обработчик должен отклонять так же, как несуществующий, с кодом 404
+func CanAccess(c *gin.Context, ownerID uint, permission string) bool {
+	if userID := GetUserID(c); userID != 0 && userID == ownerID {
+		return true
+	}
+	return permission != "" && HasPermission(c, permission)
+}
+
+// RequireOwner middleware пропускает запросы к ресурсам пользователя, ID которого передан в параметре
+// пути param, если CanAccess разрешает доступ. Иначе отвечает 404, чтобы по ответу нельзя было
+// перебором узнать, какие ресурсы существуют. Подключается после AuthRequired
+func (m *AuthMiddleware) RequireOwner(param, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ownerID, err := strconv.ParseUint(c.Param(param), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+			c.Abort()
+			return
+		}
+
+		if !CanAccess(c, uint(ownerID), permission) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ресурс не найден"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
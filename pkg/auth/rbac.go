@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"sort"
+)
+
+// Роли пользователей. Роль user есть у каждого пользователя, остальные роли хранятся в сервисе заказов
+const (
+	RoleUser  = "user"
+	RoleStaff = "staff" // сотрудник магазина: ведет каталог и выполняет заказы
+	RoleAdmin = "admin"
+)
+
+// Разрешения служебных и административных операций
+const (
+	// PermissionNotificationsReadAll просмотр уведомлений всех пользователей
+	PermissionNotificationsReadAll = "notifications:read_all"
+	// PermissionNotificationsSendAll отправка уведомлений другим пользователям
+	PermissionNotificationsSendAll = "notifications:send_all"
+	// PermissionOrdersReadAll просмотр заказов других пользователей
+	PermissionOrdersReadAll = "orders:read_all"
+	// PermissionOrdersFulfil перевод заказов в статусы отгрузки, доставки и выполнения
+	PermissionOrdersFulfil = "orders:fulfil"
+	// PermissionCatalogWrite создание, изменение и удаление товаров каталога
+	PermissionCatalogWrite = "catalog:write"
+	// PermissionAccountsReadAll просмотр аккаунтов биллинга других пользователей
+	PermissionAccountsReadAll = "billing:read_all"
+	// PermissionBalanceAdjust корректировка баланса любого аккаунта
+	PermissionBalanceAdjust = "billing:adjust_balance"
+)
+
+// rolePermissions разрешения ролей. Сервис заказов записывает в токен разрешения всех ролей
+// пользователя, поэтому остальным сервисам таблица не нужна
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleStaff: {
+		PermissionOrdersReadAll,
+		PermissionOrdersFulfil,
+		PermissionCatalogWrite,
+	},
+	RoleAdmin: {
+		PermissionNotificationsReadAll,
+		PermissionNotificationsSendAll,
+		PermissionOrdersReadAll,
+		PermissionOrdersFulfil,
+		PermissionCatalogWrite,
+		PermissionAccountsReadAll,
+		PermissionBalanceAdjust,
+	},
+}
+
+// IsKnownRole проверяет, что роль существует
+func IsKnownRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions возвращает отсортированный список разрешений ролей без повторов
+func RolePermissions(roles []string) []string {
+	set := make(map[string]struct{})
+	for _, role := range roles {
+		for _, permission := range rolePermissions[role] {
+			set[permission] = struct{}{}
+		}
+	}
+
+	permissions := make([]string, 0, len(set))
+	for permission := range set {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz7_shop/pkg/events"
+)
+
+// revocationCleanupInterval период удаления записей об отозванных токенах, срок действия которых истек
+const revocationCleanupInterval = time.Hour
+
+// RevocationStore хранилище отозванных access токенов, которое проверяет AuthMiddleware
+type RevocationStore interface {
+	// RevokeToken отзывает токен tokenID до истечения его срока действия expiresAt
+	RevokeToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) error
+	// RevokeUserTokens отзывает все токены пользователя, выпущенные раньше issuedBefore.
+	// expiresAt - момент, после которого все такие токены истекут сами
+	RevokeUserTokens(ctx context.Context, userID uint, issuedBefore, expiresAt time.Time) error
+	// IsRevoked сообщает, что токен отозван сам по себе или вместе со всеми токенами пользователя
+	IsRevoked(ctx context.Context, claims *TokenClaims) (bool, error)
+}
+
+// RevokedToken отозванный access токен
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey;size:64"`
+	UserID    uint      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index:idx_revoked_tokens_expires_at"`
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// RevokedUserTokens отзыв всех access токенов пользователя, выпущенных раньше RevokedBefore
+type RevokedUserTokens struct {
+	UserID        uint      `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null;index:idx_revoked_user_tokens_expires_at"`
+}
+
+func (RevokedUserTokens) TableName() string {
+	return "revoked_user_tokens"
+}
+
+// RevokeToken сохраняет отзыв токена. db может быть транзакцией, в которой выполняется выход из сессии
+func RevokeToken(db *gorm.DB, tokenID string, userID uint, expiresAt time.Time) error {
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("ошибка при отзыве токена %s: %w", tokenID, err)
+	}
+	return nil
+}
+
+// RevokeUserTokens сохраняет отзыв всех токенов пользователя. Повторный отзыв только сдвигает
+// границы вперед, поэтому события отзыва можно применять в любом порядке
+func RevokeUserTokens(db *gorm.DB, userID uint, issuedBefore, expiresAt time.Time) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "revoked_before"}, Value: gorm.Expr("GREATEST(revoked_user_tokens.revoked_before, EXCLUDED.revoked_before)")},
+			{Column: clause.Column{Name: "expires_at"}, Value: gorm.Expr("GREATEST(revoked_user_tokens.expires_at, EXCLUDED.expires_at)")},
+		},
+	}).Create(&RevokedUserTokens{
+		UserID:        userID,
+		RevokedBefore: issuedBefore,
+		ExpiresAt:     expiresAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("ошибка при отзыве токенов пользователя %d: %w", userID, err)
+	}
+	return nil
+}
+
+// PostgresRevocationStore реализация хранилища отозванных токенов на GORM
+type PostgresRevocationStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresRevocationStore(db *gorm.DB) *PostgresRevocationStore {
+	return &PostgresRevocationStore{
+		db: db,
+	}
+}
+
+func (s *PostgresRevocationStore) RevokeToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) error {
+	return RevokeToken(s.db.WithContext(ctx), tokenID, userID, expiresAt)
+}
+
+func (s *PostgresRevocationStore) RevokeUserTokens(ctx context.Context, userID uint, issuedBefore, expiresAt time.Time) error {
+	return RevokeUserTokens(s.db.WithContext(ctx), userID, issuedBefore, expiresAt)
+}
+
+func (s *PostgresRevocationStore) IsRevoked(ctx context.Context, claims *TokenClaims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	var revoked bool
+	err := s.db.WithContext(ctx).Raw(
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = ?)
+			OR EXISTS (SELECT 1 FROM revoked_user_tokens WHERE user_id = ? AND revoked_before > ?)`,
+		claims.ID, claims.UserID, issuedAt,
+	).Scan(&revoked).Error
+	if err != nil {
+		return false, fmt.Errorf("ошибка при проверке отзыва токена %s: %w", claims.ID, err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpired удаляет отзывы токенов, которые истекли раньше момента before
+func (s *PostgresRevocationStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	db := s.db.WithContext(ctx)
+	if err := db.Where("expires_at < ?", before).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", before).Delete(&RevokedUserTokens{}).Error
+}
+
+// RunRevocationCleanup раз в час удаляет истекшие отзывы токенов до отмены контекста
+func RunRevocationCleanup(ctx context.Context, store *PostgresRevocationStore) {
+	ticker := time.NewTicker(revocationCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := store.DeleteExpired(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка при очистке отозванных токенов: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HandleRevocationEvent возвращает обработчик событий auth_events, который копирует отзывы токенов,
+// сделанные сервисом заказов, в хранилище store. Применение отзыва идемпотентно, поэтому inbox не нужен
+func HandleRevocationEvent(store RevocationStore) func(ctx context.Context, body []byte) error {
+	return func(ctx context.Context, body []byte) error {
+		envelope, err := events.Decode(body)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case envelope.Is(events.TypeTokenRevoked, 1):
+			var event events.TokenRevoked
+			if err := envelope.DecodePayload(&event); err != nil {
+				return err
+			}
+			return store.RevokeToken(ctx, event.TokenID, event.UserID, event.ExpiresAt)
+		case envelope.Is(events.TypeUserTokensRevoked, 1):
+			var event events.UserTokensRevoked
+			if err := envelope.DecodePayload(&event); err != nil {
+				return err
+			}
+			return store.RevokeUserTokens(ctx, event.UserID, event.RevokedBefore, event.ExpiresAt)
+		default:
+			log.Printf("Неизвестный тип события: %s, игнорируем", envelope.Type)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ServiceAudience аудитория токенов сервисов. Такие токены принимает только ServiceRequired,
+// а AuthRequired их отклоняет
+const ServiceAudience = "service"
+
+// ServiceClaims данные токена сервиса. Имя сервиса записывается в sub
+type ServiceClaims struct {
+	jwt.RegisteredClaims
+}
+
+// GenerateServiceToken создает токен сервиса service с временем жизни ServiceTokenTTL
+func (m *JWTManager) GenerateServiceToken(service string) (string, time.Time, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("ошибка генерации идентификатора токена: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(m.config.ServiceTokenTTL)
+	claims := ServiceClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   service,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    m.config.TokenIssuer,
+			Audience:  jwt.ClaimStrings{ServiceAudience},
+		},
+	}
+
+	token, err := m.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseServiceToken проверяет токен сервиса и извлекает из него данные. Принимаются только
+// токены, выпущенные с издателем из конфигурации
+func (m *JWTManager) ParseServiceToken(tokenString string) (*ServiceClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ServiceClaims{}, m.verificationKey,
+		jwt.WithExpirationRequired(), jwt.WithAudience(ServiceAudience), jwt.WithIssuer(m.config.TokenIssuer))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*ServiceClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("недействительный токен")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("токен не содержит имя сервиса")
+	}
+
+	return claims, nil
+}
+
+// TokenSource источник токена, которым сервис авторизует свои запросы к другим сервисам
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// ServiceTokenSource выпускает токены сервиса ключами JWTManager. Используется сервисом,
+// который сам подписывает токены. Токен переиспользуется до истечения половины срока действия
+type ServiceTokenSource struct {
+	jwtManager *JWTManager
+	service    string
+
+	mu      sync.Mutex
+	token   string
+	renewAt time.Time
+}
+
+func NewServiceTokenSource(jwtManager *JWTManager, service string) *ServiceTokenSource {
+	return &ServiceTokenSource{
+		jwtManager: jwtManager,
+		service:    service,
+	}
+}
+
+func (s *ServiceTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Before(s.renewAt) {
+		return s.token, nil
+	}
+
+	token, expiresAt, err := s.jwtManager.GenerateServiceToken(s.service)
+	if err != nil {
+		return "", fmt.Errorf("ошибка при выпуске токена сервиса %s: %w", s.service, err)
+	}
+	s.token = token
+	s.renewAt = now.Add(expiresAt.Sub(now) / 2)
+	return token, nil
+}
+
+// ServiceRequired middleware пропускает только запросы сервисов с действующим токеном
+// аудитории service. Токены пользователей отклоняются
+func (m *AuthMiddleware) ServiceRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		claims, err := m.jwtManager.ParseServiceToken(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен сервиса: " + err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("service", claims.Subject)
+
+		c.Next()
+	}
+}
+
+// OnBehalfOf middleware выполняет запрос сервиса от имени пользователя, ID которого передан
+// в параметре пути param: обработчики получают его через GetUserID. Подключается после ServiceRequired
+func (m *AuthMiddleware) OnBehalfOf(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.Param(param), 10, 32)
+		if err != nil || userID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", uint(userID))
+
+		c.Next()
+	}
+}
+
+// GetService возвращает имя сервиса, выполняющего запрос
+func GetService(c *gin.Context) string {
+	service, exists := c.Get("service")
+	if !exists {
+		return ""
+	}
+	return service.(string)
+}
@@ -1,14 +1,12 @@
 package config
 
 import (
-	"math/rand"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	"log"
-
 	"github.com/joho/godotenv"
 )
 
@@ -38,19 +36,69 @@ type PostgresConfig struct {
 
 // RabbitMQConfig содержит настройки RabbitMQ
 type RabbitMQConfig struct {
+	// Broker реализация брокера: rabbitmq или memory (в памяти процесса, для тестов и локального запуска)
+	Broker   string
 	Host     string
 	Port     string
 	User     string
 	Password string
 	VHost    string
+	// MaxRetries количество повторных попыток обработки сообщения до переноса в очередь .dlq
+	MaxRetries int
+	// RetryBaseDelay задержка перед первым повтором, каждый следующий повтор откладывается вдвое дольше
+	RetryBaseDelay time.Duration
+	// PublisherChannels количество каналов, в которых сообщения публикуются параллельно
+	PublisherChannels int
+	// Prefetch количество неподтвержденных сообщений, которые брокер передает одной подписке
+	Prefetch int
+	// ConsumerWorkers количество сообщений одной подписки, обрабатываемых параллельно
+	ConsumerWorkers int
+	// HandlerTimeout максимальное время обработки одного сообщения
+	HandlerTimeout time.Duration
+	// DrainTimeout время ожидания обрабатываемых сообщений при остановке сервиса
+	DrainTimeout time.Duration
 }
 
 // JWTConfig содержит настройки для JWT
 type JWTConfig struct {
-	SigningKey     string
-	TokenTTL       time.Duration
-	TokenIssuer    string
-	TokenAudiences []string
+	// Algorithm алгоритм подписи: RS256 и EdDSA - ключи сервиса заказов, публикуемые в JWKS,
+	// HS256 - общий секрет SigningKey во всех сервисах
+	Algorithm string
+	// SigningKey общий секрет для HS256
+	SigningKey string
+	// TokenTTL время жизни access токена
+	TokenTTL time.Duration
+	// RefreshTokenTTL время жизни refresh токена
+	RefreshTokenTTL time.Duration
+	// ServiceTokenTTL время жизни токена, которым сервис авторизует запросы к другим сервисам
+	ServiceTokenTTL time.Duration
+	TokenIssuer     string
+	TokenAudiences  []string
+	// JWKSURL адрес открытых ключей сервиса заказов для проверки RS256 и EdDSA
+	JWKSURL string
+	// JWKSCacheTTL время кэширования открытых ключей
+	JWKSCacheTTL time.Duration
+	// KeyRotationInterval возраст ключа подписи, после которого сервис заказов создает новый
+	KeyRotationInterval time.Duration
+}
+
+// OutboxConfig содержит настройки публикации событий из outbox
+type OutboxConfig struct {
+	// PollInterval период опроса outbox
+	PollInterval time.Duration
+	// BatchSize количество сообщений, публикуемых за один проход
+	BatchSize int
+	// MaxBackoff максимальная задержка между повторами публикации одного сообщения
+	MaxBackoff time.Duration
+	// Retention срок хранения опубликованных сообщений, 0 отключает очистку
+	Retention time.Duration
+}
+
+// InboxConfig содержит настройки inbox обработанных событий
+type InboxConfig struct {
+	// Retention срок хранения записей об обработанных событиях, 0 отключает очистку.
+	// Должен превышать срок, в течение которого событие может быть доставлено повторно
+	Retention time.Duration
 }
 
 // ServicesConfig содержит настройки внешних сервисов
@@ -79,29 +127,70 @@ func LoadCommonConfig(serviceName string, port string) *CommonConfig {
 			SSLMode:  GetEnv("POSTGRES_SSLMODE", "disable"),
 		},
 		RabbitMQ: RabbitMQConfig{
+			Broker:   GetEnv("MESSAGE_BROKER", "rabbitmq"),
 			Host:     GetEnv("RABBITMQ_HOST", "localhost"),
 			Port:     GetEnv("RABBITMQ_PORT", "5672"),
 			User:     GetEnv("RABBITMQ_USER", "guest"),
 			Password: GetEnv("RABBITMQ_PASSWORD", "guest"),
 			VHost:    GetEnv("RABBITMQ_VHOST", "/"),
+
+			MaxRetries:        GetEnvAsInt("RABBITMQ_MAX_RETRIES", 3),
+			RetryBaseDelay:    GetEnvAsDuration("RABBITMQ_RETRY_BASE_DELAY", time.Second),
+			PublisherChannels: GetEnvAsInt("RABBITMQ_PUBLISHER_CHANNELS", 4),
+			Prefetch:          GetEnvAsInt("RABBITMQ_PREFETCH", 10),
+			ConsumerWorkers:   GetEnvAsInt("RABBITMQ_CONSUMER_WORKERS", 1),
+			HandlerTimeout:    GetEnvAsDuration("RABBITMQ_HANDLER_TIMEOUT", 10*time.Second),
+			DrainTimeout:      GetEnvAsDuration("RABBITMQ_DRAIN_TIMEOUT", 15*time.Second),
 		},
 	}
 }
 
-// LoadJWTConfig загружает конфигурацию JWT из переменных окружения
-func LoadJWTConfig(serviceName string) *JWTConfig {
-	signingKey := GetEnv("JWT_SIGNING_KEY", "")
-	if signingKey == "" {
-		// Генерируем случайный ключ, если не задан
-		signingKey = GenerateRandomKey(32)
-		log.Println("ВНИМАНИЕ: JWT_SIGNING_KEY не задан! Сгенерирован случайный ключ. Для работы JWT между сервисами необходимо указать одинаковый JWT_SIGNING_KEY во всех сервисах.")
+// LoadJWTConfig загружает конфигурацию JWT из переменных окружения. Для HS256 JWT_SIGNING_KEY
+// обязателен: случайный ключ в каждом сервисе сделал бы токены непроверяемыми в остальных
+func LoadJWTConfig(serviceName string) (*JWTConfig, error) {
+	cfg := &JWTConfig{
+		Algorithm:           GetEnv("JWT_ALGORITHM", "RS256"),
+		SigningKey:          GetEnv("JWT_SIGNING_KEY", ""),
+		TokenTTL:            GetEnvAsDuration("JWT_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:     GetEnvAsDuration("JWT_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		ServiceTokenTTL:     GetEnvAsDuration("JWT_SERVICE_TOKEN_TTL", 5*time.Minute),
+		TokenIssuer:         GetEnv("JWT_TOKEN_ISSUER", serviceName),
+		TokenAudiences:      strings.Split(GetEnv("JWT_TOKEN_AUDIENCES", "microservices"), ","),
+		JWKSURL:             GetEnv("JWT_JWKS_URL", "http://localhost:8080/.well-known/jwks.json"),
+		JWKSCacheTTL:        GetEnvAsDuration("JWT_JWKS_CACHE_TTL", 5*time.Minute),
+		KeyRotationInterval: GetEnvAsDuration("JWT_KEY_ROTATION_INTERVAL", 24*time.Hour),
 	}
 
-	return &JWTConfig{
-		SigningKey:     signingKey,
-		TokenTTL:       GetEnvAsDuration("JWT_TOKEN_TTL", 24*time.Hour),
-		TokenIssuer:    GetEnv("JWT_TOKEN_ISSUER", serviceName),
-		TokenAudiences: strings.Split(GetEnv("JWT_TOKEN_AUDIENCES", "microservices"), ","),
+	switch cfg.Algorithm {
+	case "HS256":
+		if cfg.SigningKey == "" {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY обязателен для JWT_ALGORITHM=HS256")
+		}
+	case "RS256", "EdDSA":
+		if cfg.KeyRotationInterval <= 0 {
+			return nil, fmt.Errorf("JWT_KEY_ROTATION_INTERVAL должен быть больше нуля")
+		}
+	default:
+		return nil, fmt.Errorf("некорректный JWT_ALGORITHM: %s (ожидается RS256, EdDSA или HS256)", cfg.Algorithm)
+	}
+
+	return cfg, nil
+}
+
+// LoadOutboxConfig загружает настройки outbox из переменных окружения
+func LoadOutboxConfig() *OutboxConfig {
+	return &OutboxConfig{
+		PollInterval: GetEnvAsDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:    GetEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+		MaxBackoff:   GetEnvAsDuration("OUTBOX_MAX_BACKOFF", 5*time.Minute),
+		Retention:    GetEnvAsDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+	}
+}
+
+// LoadInboxConfig загружает настройки inbox из переменных окружения
+func LoadInboxConfig() *InboxConfig {
+	return &InboxConfig{
+		Retention: GetEnvAsDuration("INBOX_RETENTION", 30*24*time.Hour),
 	}
 }
 
@@ -113,19 +202,6 @@ func LoadServicesConfig() *ServicesConfig {
 	}
 }
 
-// GenerateRandomKey генерирует случайный ключ заданной длины
-func GenerateRandomKey(length int) string {
-	// Инициализируем генератор случайных чисел
-	rand.Seed(time.Now().UnixNano())
-
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(b)
-}
-
 func GetEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
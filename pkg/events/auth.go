@@ -0,0 +1,31 @@
+package events
+
+import (
+	"time"
+)
+
+// События аутентификации сервиса заказов (exchange auth_events)
+const (
+	TypeTokenRevoked      = "auth.token_revoked"
+	TypeUserTokensRevoked = "auth.user_tokens_revoked"
+)
+
+// TokenRevoked access токен отозван при выходе из сессии
+type TokenRevoked struct {
+	TokenID   string    `json:"token_id"`
+	UserID    uint      `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (TokenRevoked) EventType() string { return TypeTokenRevoked }
+func (TokenRevoked) EventVersion() int { return 1 }
+
+// UserTokensRevoked отозваны все access токены пользователя, выпущенные раньше RevokedBefore
+type UserTokensRevoked struct {
+	UserID        uint      `json:"user_id"`
+	RevokedBefore time.Time `json:"revoked_before"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func (UserTokensRevoked) EventType() string { return TypeUserTokensRevoked }
+func (UserTokensRevoked) EventVersion() int { return 1 }
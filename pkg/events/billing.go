@@ -0,0 +1,67 @@
+package events
+
+import (
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// События сервиса биллинга (exchange billing_events)
+const (
+	TypePaymentProcessed  = "billing.payment_processed"
+	TypeDeposit           = "billing.deposit"
+	TypeRefund            = "billing.refund"
+	TypeInsufficientFunds = "billing.insufficient_funds"
+)
+
+// PaymentProcessed результат оплаты заказа по событию order.created
+type PaymentProcessed struct {
+	OrderID       uint        `json:"order_id"`
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	Status        string      `json:"status"`
+	Success       bool        `json:"success"`
+}
+
+func (PaymentProcessed) EventType() string { return TypePaymentProcessed }
+func (PaymentProcessed) EventVersion() int { return 1 }
+
+// Deposit баланс пополнен
+type Deposit struct {
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	OperationType string      `json:"operation_type"`
+	Status        string      `json:"status"`
+	Email         string      `json:"email"`
+}
+
+func (Deposit) EventType() string { return TypeDeposit }
+func (Deposit) EventVersion() int { return 1 }
+
+// Refund средства возвращены по списанию OriginalTransactionID
+type Refund struct {
+	UserID                uint        `json:"user_id"`
+	TransactionID         uint        `json:"transaction_id"`
+	OriginalTransactionID uint        `json:"original_transaction_id"`
+	Amount                money.Money `json:"amount"`
+	Reason                string      `json:"reason"`
+	Email                 string      `json:"email"`
+}
+
+func (Refund) EventType() string { return TypeRefund }
+func (Refund) EventVersion() int { return 1 }
+
+// InsufficientFunds списание отклонено из-за недостатка средств
+type InsufficientFunds struct {
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	OperationType string      `json:"operation_type"`
+	Status        string      `json:"status"`
+	Balance       money.Money `json:"balance"`
+	Reason        string      `json:"reason"`
+	Email         string      `json:"email"`
+}
+
+func (InsufficientFunds) EventType() string { return TypeInsufficientFunds }
+func (InsufficientFunds) EventVersion() int { return 1 }
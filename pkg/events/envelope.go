@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ошибки разбора конверта
+var (
+	ErrInvalidEnvelope    = errors.New("сообщение не является конвертом события")
+	ErrUnsupportedVersion = errors.New("неподдерживаемая версия события")
+)
+
+// Event полезная нагрузка события. Тип события совпадает с ключом маршрутизации,
+// версия увеличивается при несовместимом изменении структуры
+type Event interface {
+	EventType() string
+	EventVersion() int
+}
+
+// Envelope конверт, в котором публикуются все события. CorrelationID общий для цепочки событий,
+// начатой одним действием, CausationID - ID события, при обработке которого возникло это
+type Envelope struct {
+	EventID       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Producer      string          `json:"producer"`
+	CorrelationID string          `json:"correlation_id"`
+	CausationID   string          `json:"causation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+type causeKey struct{}
+
+// cause событие, обработка которого привела к публикации новых событий
+type cause struct {
+	eventID       string
+	correlationID string
+}
+
+// WithCause возвращает контекст, события из которого продолжают цепочку события envelope
+func WithCause(ctx context.Context, envelope Envelope) context.Context {
+	return context.WithValue(ctx, causeKey{}, cause{
+		eventID:       envelope.EventID,
+		correlationID: envelope.CorrelationID,
+	})
+}
+
+// New упаковывает событие в конверт. Если контекст получен из WithCause, событие продолжает
+// цепочку, иначе начинает новую с CorrelationID, равным собственному ID
+func New(ctx context.Context, producer string, event Event) (Envelope, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("ошибка сериализации события %s: %w", event.EventType(), err)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Envelope{}, fmt.Errorf("ошибка генерации ID события: %w", err)
+	}
+
+	envelope := Envelope{
+		EventID:       id,
+		Type:          event.EventType(),
+		Version:       event.EventVersion(),
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+		CorrelationID: id,
+		Payload:       payload,
+	}
+	if c, ok := ctx.Value(causeKey{}).(cause); ok {
+		envelope.CausationID = c.eventID
+		if c.correlationID != "" {
+			envelope.CorrelationID = c.correlationID
+		}
+	}
+
+	return envelope, nil
+}
+
+// Decode разбирает конверт события
+func Decode(data []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if envelope.EventID == "" || envelope.Type == "" || envelope.Version == 0 {
+		return Envelope{}, ErrInvalidEnvelope
+	}
+	return envelope, nil
+}
+
+// Is сообщает, что событие имеет тип eventType и версию version
+func (e Envelope) Is(eventType string, version int) bool {
+	return e.Type == eventType && e.Version == version
+}
+
+// DecodePayload разбирает полезную нагрузку события в event
+func (e Envelope) DecodePayload(event Event) error {
+	if err := json.Unmarshal(e.Payload, event); err != nil {
+		return fmt.Errorf("ошибка при разборе события %s v%d (%s): %w", e.Type, e.Version, e.EventID, err)
+	}
+	return nil
+}
+
+// Unsupported возвращает ошибку для события, версию которого потребитель не умеет обрабатывать
+func (e Envelope) Unsupported() error {
+	return fmt.Errorf("%w: %s v%d (%s)", ErrUnsupportedVersion, e.Type, e.Version, e.EventID)
+}
+
+// newID генерирует случайный UUID версии 4
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// События сервиса заказов (exchange order_events)
+const (
+	TypeOrderCreated        = "order.created"
+	TypeOrderNotification   = "order.notification"
+	TypeOrderStatusChanged  = "order.status_changed"
+	TypePaymentCompensation = "order.payment_compensation"
+)
+
+// OrderCreated заказ создан и ожидает оплаты в биллинге
+type OrderCreated struct {
+	OrderID   uint        `json:"order_id"`
+	UserID    uint        `json:"user_id"`
+	TotalCost money.Money `json:"total_cost"`
+	Email     string      `json:"email"`
+}
+
+func (OrderCreated) EventType() string { return TypeOrderCreated }
+func (OrderCreated) EventVersion() int { return 1 }
+
+// OrderNotification результат оформления заказа для уведомления пользователя
+type OrderNotification struct {
+	UserID  uint        `json:"user_id"`
+	Email   string      `json:"email"`
+	OrderID uint        `json:"order_id"`
+	Amount  money.Money `json:"amount"`
+	Success bool        `json:"success"`
+}
+
+func (OrderNotification) EventType() string { return TypeOrderNotification }
+func (OrderNotification) EventVersion() int { return 1 }
+
+// OrderStatusChanged статус заказа изменен
+type OrderStatusChanged struct {
+	OrderID   uint        `json:"order_id"`
+	UserID    uint        `json:"user_id"`
+	Email     string      `json:"email"`
+	Amount    money.Money `json:"amount"`
+	OldStatus string      `json:"old_status"`
+	NewStatus string      `json:"new_status"`
+	ChangedAt time.Time   `json:"changed_at"`
+}
+
+func (OrderStatusChanged) EventType() string { return TypeOrderStatusChanged }
+func (OrderStatusChanged) EventVersion() int { return 1 }
+
+// PaymentCompensation оплату отмененного заказа нужно вернуть: отменить холд HoldID
+// или вернуть списание TransactionID. Нулевой ID означает, что такой операции нет
+type PaymentCompensation struct {
+	OrderID       uint        `json:"order_id"`
+	UserID        uint        `json:"user_id"`
+	TransactionID uint        `json:"transaction_id,omitempty"`
+	HoldID        uint        `json:"hold_id,omitempty"`
+	Amount        money.Money `json:"amount"`
+	Reason        string      `json:"reason"`
+	Email         string      `json:"email"`
+}
+
+func (PaymentCompensation) EventType() string { return TypePaymentCompensation }
+func (PaymentCompensation) EventVersion() int { return 1 }
@@ -0,0 +1,200 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/director74/dz7_shop/pkg/auth"
+)
+
+const (
+	// HeaderKey заголовок, в котором клиент передает ключ идемпотентности
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed заголовок ответа, повторенного из сохраненного результата
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	// defaultLease время, после которого незавершенный запрос без продления аренды считается прерванным
+	defaultLease = time.Minute
+)
+
+// Middleware обеспечивает идемпотентность запросов по заголовку Idempotency-Key
+type Middleware struct {
+	store Store
+	// lease срок аренды ключа выполняющимся запросом. Пока обработчик работает, аренда
+	// продлевается каждую треть срока, поэтому ключ освобождается, только если процесс прерван
+	lease time.Duration
+}
+
+func NewMiddleware(store Store) *Middleware {
+	return &Middleware{
+		store: store,
+		lease: defaultLease,
+	}
+}
+
+// Handle возвращает сохраненный ответ на повтор запроса с тем же ключом и телом.
+// Запросы без заголовка обрабатываются как обычно. Должен подключаться после AuthRequired,
+// так как ключи разделяются по пользователям
+func (m *Middleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ключ идемпотентности длиннее %d символов", maxKeyLength)})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ошибка при чтении тела запроса"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := hashRequest(c.Request.URL.Path, body)
+		ctx := c.Request.Context()
+		record, created, err := m.reserve(ctx, &Record{
+			Scope:       fmt.Sprintf("user:%d %s %s", auth.GetUserID(c), c.Request.Method, c.FullPath()),
+			Key:         key,
+			RequestHash: hash,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка при проверке ключа идемпотентности"})
+			c.Abort()
+			return
+		}
+
+		if !created {
+			switch {
+			case record.RequestHash != hash:
+				c.JSON(http.StatusConflict, gin.H{"error": "ключ идемпотентности уже использован с другим запросом"})
+			case !record.Completed:
+				c.JSON(http.StatusConflict, gin.H{"error": "запрос с этим ключом идемпотентности еще выполняется"})
+			default:
+				c.Header(HeaderReplayed, "true")
+				c.Data(record.StatusCode, "application/json; charset=utf-8", record.ResponseBody)
+			}
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		stopRenewal := m.renewLease(ctx, record.ID, key)
+		c.Next()
+		stopRenewal()
+
+		// Ответ сохраняется и после отмены запроса клиентом, иначе повтор выполнит операцию второй раз
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		// Ошибку сервера не запоминаем, чтобы клиент мог повторить запрос с тем же ключом
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := m.store.Delete(storeCtx, record.ID); err != nil {
+				log.Printf("Ошибка при удалении ключа идемпотентности %s: %v", key, err)
+			}
+			return
+		}
+
+		if err := m.store.Complete(storeCtx, record.ID, recorder.Status(), recorder.body.Bytes()); err != nil {
+			log.Printf("Ошибка при сохранении ответа для ключа идемпотентности %s: %v", key, err)
+		}
+	}
+}
+
+// reserve резервирует ключ, освобождая ключи прерванных запросов
+func (m *Middleware) reserve(ctx context.Context, record *Record) (*Record, bool, error) {
+	existing, created, err := m.store.Reserve(ctx, record)
+	if err != nil || created || existing.Completed || time.Since(existing.UpdatedAt) < m.lease {
+		return existing, created, err
+	}
+
+	// Аренда истекла, но запрос мог продлить ее после чтения записи: тогда ключ не освобождается
+	deleted, err := m.store.DeleteStale(ctx, existing.ID, existing.UpdatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	if !deleted {
+		return existing, false, nil
+	}
+	return m.store.Reserve(ctx, record)
+}
+
+// renewLease продлевает аренду ключа, пока выполняется обработчик запроса. Возвращает функцию,
+// которая останавливает продление
+func (m *Middleware) renewLease(ctx context.Context, id uint, key string) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(m.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := m.store.Renew(ctx, id); err != nil && ctx.Err() == nil {
+				log.Printf("Ошибка при продлении аренды ключа идемпотентности %s: %v", key, err)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// hashRequest вычисляет хеш пути и тела запроса без учета форматирования JSON. Путь учитывается,
+// так как ключи разделяются по шаблону маршрута, а параметры пути (например ID) могут отличаться
+func hashRequest(path string, body []byte) string {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, body); err == nil {
+		body = compacted.Bytes()
+	}
+
+	h := sha256.New()
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder копирует тело ответа для сохранения вместе с ключом
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,319 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore хранилище ключей в памяти с той же семантикой, что и GormStore
+type memoryStore struct {
+	mu      sync.Mutex
+	nextID  uint
+	records map[uint]*Record
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[uint]*Record)}
+}
+
+func (s *memoryStore) Reserve(_ context.Context, record *Record) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.records {
+		if existing.Scope == record.Scope && existing.Key == record.Key {
+			copied := *existing
+			return &copied, false, nil
+		}
+	}
+
+	s.nextID++
+	now := time.Now()
+	stored := *record
+	stored.ID = s.nextID
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.records[stored.ID] = &stored
+
+	*record = stored
+	return record, true, nil
+}
+
+func (s *memoryStore) Complete(_ context.Context, id uint, statusCode int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[id]; ok {
+		record.Completed = true
+		record.StatusCode = statusCode
+		record.ResponseBody = append([]byte(nil), body...)
+		record.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) Renew(_ context.Context, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[id]; ok && !record.Completed {
+		record.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteStale(_ context.Context, id uint, updatedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok || record.Completed || !record.UpdatedAt.Equal(updatedAt) {
+		return false, nil
+	}
+	delete(s.records, id)
+	return true, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *memoryStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// testRouter возвращает маршрут POST /orders под middleware m. Пользователь берется из заголовка X-User
+func testRouter(m *Middleware, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/orders", func(c *gin.Context) {
+		userID := uint(1)
+		if c.GetHeader("X-User") == "2" {
+			userID = 2
+		}
+		c.Set("user_id", userID)
+		c.Next()
+	}, m.Handle(), handler)
+	return router
+}
+
+func doRequest(router http.Handler, key, body, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReplayReturnsStoredResponse(t *testing.T) {
+	var calls atomic.Int32
+	router := testRouter(NewMiddleware(newMemoryStore()), func(c *gin.Context) {
+		n := calls.Add(1)
+		c.JSON(http.StatusCreated, gin.H{"id": n})
+	})
+
+	first := doRequest(router, "key-1", `{"amount": 1}`, "")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("первый запрос: статус %d, ожидался 201", first.Code)
+	}
+
+	// Форматирование JSON не влияет на хеш запроса
+	second := doRequest(router, "key-1", `{ "amount" : 1 }`, "")
+	if second.Code != http.StatusCreated {
+		t.Fatalf("повтор: статус %d, ожидался 201", second.Code)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("повтор вернул %s, ожидался сохраненный ответ %s", second.Body, first.Body)
+	}
+	if second.Header().Get(HeaderReplayed) != "true" {
+		t.Errorf("повтор без заголовка %s", HeaderReplayed)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("обработчик вызван %d раз, ожидался 1", got)
+	}
+}
+
+func TestKeyWithDifferentBodyIsRejected(t *testing.T) {
+	router := testRouter(NewMiddleware(newMemoryStore()), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	doRequest(router, "key-1", `{"amount": 1}`, "")
+	w := doRequest(router, "key-1", `{"amount": 2}`, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("повтор с другим телом: статус %d, ожидался 409", w.Code)
+	}
+}
+
+func TestKeysAreScopedByUser(t *testing.T) {
+	var calls atomic.Int32
+	router := testRouter(NewMiddleware(newMemoryStore()), func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	doRequest(router, "key-1", `{}`, "1")
+	w := doRequest(router, "key-1", `{}`, "2")
+	if w.Header().Get(HeaderReplayed) != "" {
+		t.Errorf("ключ другого пользователя вернул сохраненный ответ")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 2", got)
+	}
+}
+
+func TestServerErrorReleasesKey(t *testing.T) {
+	var calls atomic.Int32
+	router := testRouter(NewMiddleware(newMemoryStore()), func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "биллинг недоступен"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	if w := doRequest(router, "key-1", `{}`, ""); w.Code != http.StatusBadGateway {
+		t.Fatalf("первый запрос: статус %d, ожидался 502", w.Code)
+	}
+	w := doRequest(router, "key-1", `{}`, "")
+	if w.Code != http.StatusCreated || w.Header().Get(HeaderReplayed) != "" {
+		t.Errorf("повтор после ошибки сервера: статус %d, replayed=%q, ожидалось новое выполнение",
+			w.Code, w.Header().Get(HeaderReplayed))
+	}
+}
+
+func TestConcurrentRequestsExecuteOnce(t *testing.T) {
+	var calls atomic.Int32
+	router := testRouter(NewMiddleware(newMemoryStore()), func(c *gin.Context) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	const requests = 20
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = doRequest(router, "key-1", `{"amount": 1}`, "").Code
+		}(i)
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("обработчик вызван %d раз, ожидался 1", got)
+	}
+	for i, code := range codes {
+		if code != http.StatusCreated && code != http.StatusConflict {
+			t.Errorf("запрос %d: статус %d, ожидался 201 или 409", i, code)
+		}
+	}
+}
+
+func TestLeaseIsRenewedWhileHandlerRuns(t *testing.T) {
+	m := NewMiddleware(newMemoryStore())
+	m.lease = 30 * time.Millisecond
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := testRouter(m, func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- doRequest(router, "key-1", `{}`, "").Code
+	}()
+	<-started
+
+	// Медленный запрос выполняется дольше нескольких сроков аренды, но продлевает ее
+	time.Sleep(4 * m.lease)
+	if w := doRequest(router, "key-1", `{}`, ""); w.Code != http.StatusConflict {
+		t.Errorf("повтор во время выполнения: статус %d, ожидался 409", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusCreated {
+		t.Errorf("медленный запрос: статус %d, ожидался 201", code)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("обработчик вызван %d раз, ожидался 1", got)
+	}
+}
+
+func TestAbandonedReservationIsReleased(t *testing.T) {
+	store := newMemoryStore()
+	m := NewMiddleware(store)
+	m.lease = 10 * time.Millisecond
+
+	// Ключ прерванного запроса: аренду никто не продлевает
+	record := &Record{
+		Scope:       "user:1 POST /orders",
+		Key:         "key-1",
+		RequestHash: hashRequest("/orders", []byte(`{}`)),
+	}
+	if _, _, err := store.Reserve(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * m.lease)
+
+	var calls atomic.Int32
+	router := testRouter(m, func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	if w := doRequest(router, "key-1", `{}`, ""); w.Code != http.StatusCreated {
+		t.Errorf("повтор после прерванного запроса: статус %d, ожидался 201", w.Code)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("обработчик вызван %d раз, ожидался 1", got)
+	}
+	if got := store.len(); got != 1 {
+		t.Errorf("в хранилище %d ключей, ожидался 1", got)
+	}
+}
+
+func TestRequestWithoutKeyIsNotStored(t *testing.T) {
+	store := newMemoryStore()
+	router := testRouter(NewMiddleware(store), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	doRequest(router, "", `{}`, "")
+	doRequest(router, "", `{}`, "")
+	if got := store.len(); got != 0 {
+		t.Errorf("в хранилище %d ключей, ожидалось 0", got)
+	}
+
+	if w := doRequest(router, strings.Repeat("k", maxKeyLength+1), `{}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("слишком длинный ключ: статус %d, ожидался 400", w.Code)
+	}
+}
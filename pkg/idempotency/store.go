@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// KeyTTL срок хранения ключа идемпотентности. Повтор запроса с ключом старше KeyTTL
+	// выполняется как новый запрос
+	KeyTTL = 24 * time.Hour
+	// cleanupInterval период удаления устаревших ключей
+	cleanupInterval = time.Hour
+)
+
+// Record сохраненный ключ идемпотентности вместе с хешем запроса и ответом на него
+type Record struct {
+	ID           uint      `gorm:"primaryKey"`
+	Scope        string    `gorm:"size:255;not null;uniqueIndex:idx_idempotency_keys_scope_key"`
+	Key          string    `gorm:"size:255;not null;uniqueIndex:idx_idempotency_keys_scope_key"`
+	RequestHash  string    `gorm:"size:64;not null"`
+	Completed    bool      `gorm:"not null;default:false"`
+	StatusCode   int       `gorm:"not null;default:0"`
+	ResponseBody []byte    `gorm:"type:bytea"`
+	CreatedAt    time.Time `gorm:"not null"`
+	UpdatedAt    time.Time `gorm:"not null;index:idx_idempotency_keys_updated_at"`
+}
+
+func (Record) TableName() string {
+	return "idempotency_keys"
+}
+
+// Store хранилище ключей идемпотентности
+type Store interface {
+	// Reserve сохраняет новый ключ. Если ключ уже существует, возвращает сохраненную запись и created=false
+	Reserve(ctx context.Context, record *Record) (existing *Record, created bool, err error)
+	Complete(ctx context.Context, id uint, statusCode int, body []byte) error
+	// Renew продлевает аренду незавершенного ключа выполняющимся запросом
+	Renew(ctx context.Context, id uint) error
+	// DeleteStale удаляет незавершенный ключ, если его аренду не продлевали после updatedAt
+	DeleteStale(ctx context.Context, id uint, updatedAt time.Time) (bool, error)
+	Delete(ctx context.Context, id uint) error
+}
+
+// GormStore реализация хранилища ключей идемпотентности на GORM
+type GormStore struct {
+	db *gorm.DB
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{
+		db: db,
+	}
+}
+
+func (s *GormStore) Reserve(ctx context.Context, record *Record) (*Record, bool, error) {
+	result := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(record)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	var existing Record
+	err := s.db.WithContext(ctx).
+		Where("scope = ? AND key = ?", record.Scope, record.Key).
+		First(&existing).Error
+	if err != nil {
+		// Запись удалили между вставкой и чтением, повторяем резервирование
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.Reserve(ctx, record)
+		}
+		return nil, false, err
+	}
+
+	return &existing, false, nil
+}
+
+func (s *GormStore) Complete(ctx context.Context, id uint, statusCode int, body []byte) error {
+	return s.db.WithContext(ctx).Model(&Record{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"completed":     true,
+		"status_code":   statusCode,
+		"response_body": body,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+func (s *GormStore) Renew(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Model(&Record{}).
+		Where("id = ? AND completed = ?", id, false).
+		Update("updated_at", time.Now()).Error
+}
+
+func (s *GormStore) DeleteStale(ctx context.Context, id uint, updatedAt time.Time) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Where("id = ? AND completed = ? AND updated_at = ?", id, false, updatedAt).
+		Delete(&Record{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *GormStore) Delete(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Delete(&Record{}, id).Error
+}
+
+// DeleteExpired удаляет ключи, которые не обновлялись с момента before: сохраненные ответы
+// с истекшим сроком хранения и ключи прерванных запросов
+func (s *GormStore) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("updated_at < ?", before).Delete(&Record{})
+	return result.RowsAffected, result.Error
+}
+
+// RunCleanup раз в час удаляет ключи старше KeyTTL до отмены контекста
+func RunCleanup(ctx context.Context, store *GormStore) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := store.DeleteExpired(ctx, time.Now().Add(-KeyTTL))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка при очистке ключей идемпотентности: %v", err)
+		}
+		if deleted > 0 {
+			log.Printf("Удалено устаревших ключей идемпотентности: %d", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
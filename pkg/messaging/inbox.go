@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz7_shop/pkg/config"
+)
+
+// inboxCleanupInterval период удаления устаревших записей inbox
+const inboxCleanupInterval = time.Hour
+
+// ProcessedEvent запись inbox: событие EventID обработано потребителем Consumer.
+// Сохраняется в одной транзакции с результатом обработки, поэтому повторная доставка
+// того же события распознается и пропускается
+type ProcessedEvent struct {
+	Consumer    string    `gorm:"primaryKey;size:100"`
+	EventID     string    `gorm:"primaryKey;size:64"`
+	ProcessedAt time.Time `gorm:"not null;index:idx_processed_events_processed_at"`
+}
+
+func (ProcessedEvent) TableName() string {
+	return "processed_events"
+}
+
+// MarkProcessed отмечает событие обработанным потребителем consumer. db должен быть транзакцией
+// обработчика: если она откатится, отметка исчезнет вместе с результатом и событие обработается
+// повторно. Возвращает false, если событие уже было обработано. Параллельная обработка
+// того же события ждет фиксации первой транзакции на уникальном ключе
+func MarkProcessed(db *gorm.DB, consumer, eventID string) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ProcessedEvent{
+		Consumer:    consumer,
+		EventID:     eventID,
+		ProcessedAt: time.Now(),
+	})
+	if result.Error != nil {
+		return false, fmt.Errorf("ошибка при сохранении обработанного события %s: %w", eventID, result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteProcessed удаляет записи inbox, созданные раньше момента before
+func DeleteProcessed(ctx context.Context, db *gorm.DB, before time.Time) error {
+	return db.WithContext(ctx).
+		Where("processed_at < ?", before).
+		Delete(&ProcessedEvent{}).Error
+}
+
+// RunInboxCleanup раз в час удаляет записи inbox старше Retention до отмены контекста
+func RunInboxCleanup(ctx context.Context, db *gorm.DB, cfg config.InboxConfig) {
+	if cfg.Retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(inboxCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := DeleteProcessed(ctx, db, time.Now().Add(-cfg.Retention)); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка при очистке inbox: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
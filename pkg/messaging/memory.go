@@ -0,0 +1,420 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// MemoryConfig настройки брокера в памяти процесса
+type MemoryConfig struct {
+	// MaxRetries количество повторных доставок сообщения до переноса в очередь .dlq
+	MaxRetries int
+	// RetryBaseDelay задержка перед первой повторной доставкой, каждая следующая вдвое больше
+	RetryBaseDelay time.Duration
+	// Consumer настройки подписок по умолчанию. Prefetch не используется: сообщение
+	// забирается из очереди, только когда обработчик свободен
+	Consumer rabbitmq.ConsumerOptions
+}
+
+// memoryMessage сообщение в очереди брокера в памяти. attempt - номер повторной доставки
+type memoryMessage struct {
+	body    []byte
+	attempt int
+}
+
+// memoryQueue очередь сообщений. ready получает сигнал, когда в очередь добавлено сообщение
+type memoryQueue struct {
+	messages []memoryMessage
+	ready    chan struct{}
+}
+
+// memoryBinding привязка очереди к exchange по шаблону ключа маршрутизации
+type memoryBinding struct {
+	queue    string
+	exchange string
+	pattern  string
+}
+
+// MemoryBroker брокер сообщений в памяти процесса с семантикой RabbitMQ: exchanges типов direct,
+// fanout и topic (шаблоны с * и #), подтверждение обработки, повторная доставка с нарастающей
+// задержкой и очередь .dlq после MaxRetries повторов. Сообщения не переживают перезапуск процесса.
+// Предназначен для тестов и локального запуска без RabbitMQ; один экземпляр можно передать
+// нескольким сервисам, запущенным в одном процессе
+type MemoryBroker struct {
+	config MemoryConfig
+
+	mu        sync.Mutex
+	exchanges map[string]string
+	queues    map[string]*memoryQueue
+	bindings  []memoryBinding
+	closed    bool
+
+	handlerCtx     context.Context
+	cancelHandlers context.CancelFunc
+	consuming      sync.WaitGroup
+	stopConsuming  chan struct{}
+	stopOnce       sync.Once
+}
+
+// NewMemoryBroker создает брокер в памяти процесса
+func NewMemoryBroker(cfg MemoryConfig) *MemoryBroker {
+	b := &MemoryBroker{
+		config:        cfg,
+		exchanges:     make(map[string]string),
+		queues:        make(map[string]*memoryQueue),
+		stopConsuming: make(chan struct{}),
+	}
+	b.handlerCtx, b.cancelHandlers = context.WithCancel(context.Background())
+	return b
+}
+
+// DeclareExchange объявляет exchange типа direct, fanout или topic
+func (b *MemoryBroker) DeclareExchange(name string, kind string) error {
+	switch kind {
+	case "direct", "fanout", "topic":
+	default:
+		return fmt.Errorf("неподдерживаемый тип exchange %s: %s", name, kind)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if existing, ok := b.exchanges[name]; ok && existing != kind {
+		return fmt.Errorf("exchange %s уже объявлен с типом %s", name, existing)
+	}
+	b.exchanges[name] = kind
+	return nil
+}
+
+// DeclareQueue объявляет очередь вместе с ее очередью недоставленных сообщений .dlq
+func (b *MemoryBroker) DeclareQueue(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.declareQueue(name)
+	b.declareQueue(rabbitmq.DeadLetterQueueName(name))
+	return nil
+}
+
+func (b *MemoryBroker) declareQueue(name string) {
+	if _, ok := b.queues[name]; !ok {
+		b.queues[name] = &memoryQueue{ready: make(chan struct{}, 1)}
+	}
+}
+
+// BindQueue привязывает очередь к exchange
+func (b *MemoryBroker) BindQueue(queueName, exchangeName, routingKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.queues[queueName]; !ok {
+		return fmt.Errorf("ошибка при привязке очереди %s: очередь не объявлена", queueName)
+	}
+	if _, ok := b.exchanges[exchangeName]; !ok {
+		return fmt.Errorf("ошибка при привязке очереди %s: exchange %s не объявлен", queueName, exchangeName)
+	}
+
+	binding := memoryBinding{queue: queueName, exchange: exchangeName, pattern: routingKey}
+	for _, existing := range b.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+	b.bindings = append(b.bindings, binding)
+	return nil
+}
+
+// PublishMessage публикует сообщение в формате JSON
+func (b *MemoryBroker) PublishMessage(exchange, routingKey string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("%w: %v", rabbitmq.ErrInvalidMessage, err)
+	}
+	return b.publish(exchange, routingKey, body)
+}
+
+// PublishMessageWithRetry публикует сообщение. Маршрутизация в памяти не меняется между попытками,
+// поэтому повторы не выполняются
+func (b *MemoryBroker) PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error {
+	return b.PublishMessage(exchange, routingKey, message)
+}
+
+// PublishEvent публикует конверт события
+func (b *MemoryBroker) PublishEvent(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("%w: %v", rabbitmq.ErrInvalidMessage, err)
+	}
+	return b.publish(exchange, routingKey, body)
+}
+
+// publish помещает сообщение во все очереди, привязки которых подходят к ключу маршрутизации.
+// Как и публикация с флагом mandatory в RabbitMQ, сообщение без подходящих очередей
+// возвращается ошибкой *rabbitmq.ReturnedError
+func (b *MemoryBroker) publish(exchange, routingKey string, body []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("ошибка при публикации сообщения: %w", rabbitmq.ErrNotConnected)
+	}
+
+	var targets []string
+	if exchange == "" {
+		// Exchange по умолчанию доставляет сообщение в очередь с именем, равным ключу
+		if _, ok := b.queues[routingKey]; ok {
+			targets = append(targets, routingKey)
+		}
+	} else {
+		kind, ok := b.exchanges[exchange]
+		if !ok {
+			return fmt.Errorf("%w: exchange %s не объявлен", rabbitmq.ErrPublishFailed, exchange)
+		}
+		for _, binding := range b.bindings {
+			if binding.exchange == exchange && routes(kind, binding.pattern, routingKey) && !contains(targets, binding.queue) {
+				targets = append(targets, binding.queue)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return &rabbitmq.ReturnedError{Exchange: exchange, RoutingKey: routingKey, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	}
+
+	for _, queue := range targets {
+		b.enqueue(queue, memoryMessage{body: body})
+	}
+	return nil
+}
+
+// enqueue добавляет сообщение в очередь и будит ожидающий обработчик. Вызывается под b.mu
+func (b *MemoryBroker) enqueue(queue string, msg memoryMessage) {
+	q := b.queues[queue]
+	q.messages = append(q.messages, msg)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// ConsumeMessages начинает обработку сообщений очереди в opts.Workers горутинах
+func (b *MemoryBroker) ConsumeMessages(queueName, consumerName string, opts rabbitmq.ConsumerOptions, handler rabbitmq.Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || b.consumingStopped() {
+		return fmt.Errorf("ошибка при начале обработки сообщений из очереди %s: подписки остановлены", queueName)
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return fmt.Errorf("ошибка при начале обработки сообщений: очередь %s не объявлена", queueName)
+	}
+
+	opts = opts.WithDefaults(b.config.Consumer)
+	for i := 0; i < opts.Workers; i++ {
+		b.consuming.Add(1)
+		go b.runWorker(queueName, q, opts, handler)
+	}
+	return nil
+}
+
+// runWorker забирает сообщения из очереди и обрабатывает их до остановки подписок
+func (b *MemoryBroker) runWorker(queueName string, q *memoryQueue, opts rabbitmq.ConsumerOptions, handler rabbitmq.Handler) {
+	defer b.consuming.Done()
+
+	for {
+		b.mu.Lock()
+		var msg memoryMessage
+		ok := len(q.messages) > 0
+		if ok {
+			msg = q.messages[0]
+			q.messages = q.messages[1:]
+		}
+		b.mu.Unlock()
+
+		if !ok {
+			select {
+			case <-b.stopConsuming:
+				return
+			case <-q.ready:
+			}
+			continue
+		}
+
+		// Сообщений может быть больше, чем сигналов: будим следующий обработчик
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+
+		if b.consumingStopped() {
+			b.requeue(queueName, msg)
+			return
+		}
+		b.handle(queueName, msg, opts, handler)
+	}
+}
+
+// handle вызывает обработчик и при ошибке откладывает повторную доставку, а после MaxRetries
+// повторов переносит сообщение в очередь .dlq. Сообщение, прерванное остановкой, возвращается
+// в очередь без учета попытки
+func (b *MemoryBroker) handle(queueName string, msg memoryMessage, opts rabbitmq.ConsumerOptions, handler rabbitmq.Handler) {
+	ctx, cancel := context.WithTimeout(b.handlerCtx, opts.HandlerTimeout)
+	err := handler(ctx, msg.body)
+	cancel()
+
+	switch {
+	case err == nil:
+	case b.handlerCtx.Err() != nil:
+		log.Printf("Обработка сообщения из очереди %s прервана остановкой: %v", queueName, err)
+		b.requeue(queueName, msg)
+	case msg.attempt >= b.config.MaxRetries:
+		log.Printf("Сообщение из очереди %s перенесено в %s после %d повторов: %v",
+			queueName, rabbitmq.DeadLetterQueueName(queueName), msg.attempt, err)
+		b.mu.Lock()
+		b.enqueue(rabbitmq.DeadLetterQueueName(queueName), msg)
+		b.mu.Unlock()
+	default:
+		msg.attempt++
+		delay := b.config.RetryBaseDelay << (msg.attempt - 1)
+		log.Printf("Повтор %d/%d сообщения из очереди %s через %v: %v",
+			msg.attempt, b.config.MaxRetries, queueName, delay, err)
+		time.AfterFunc(delay, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.enqueue(queueName, msg)
+		})
+	}
+}
+
+// requeue возвращает сообщение в начало очереди
+func (b *MemoryBroker) requeue(queueName string, msg memoryMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[queueName]
+	q.messages = append([]memoryMessage{msg}, q.messages...)
+}
+
+// DeadLetters возвращает тела сообщений из очереди .dlq для очереди queue, не удаляя их
+func (b *MemoryBroker) DeadLetters(queue string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[rabbitmq.DeadLetterQueueName(queue)]
+	if !ok {
+		return nil
+	}
+	bodies := make([][]byte, 0, len(q.messages))
+	for _, msg := range q.messages {
+		bodies = append(bodies, msg.body)
+	}
+	return bodies
+}
+
+// StopConsuming прекращает выдачу сообщений обработчикам и ждет завершения обрабатываемых
+// до истечения ctx. Если ctx истек раньше, контекст обработчиков отменяется
+func (b *MemoryBroker) StopConsuming(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.stopConsuming) })
+
+	drained := make(chan struct{})
+	go func() {
+		b.consuming.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		b.cancelHandlers()
+		return fmt.Errorf("обработка сообщений не завершилась до остановки: %w", ctx.Err())
+	}
+}
+
+func (b *MemoryBroker) consumingStopped() bool {
+	select {
+	case <-b.stopConsuming:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close останавливает подписки и отменяет контекст обработчиков. Неподтвержденные сообщения теряются
+func (b *MemoryBroker) Close() error {
+	b.stopOnce.Do(func() { close(b.stopConsuming) })
+	b.cancelHandlers()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// State возвращает состояние брокера: connected до вызова Close
+func (b *MemoryBroker) State() rabbitmq.ConnectionState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return rabbitmq.StateClosed
+	}
+	return rabbitmq.StateConnected
+}
+
+// IsConnected сообщает, что брокер не закрыт
+func (b *MemoryBroker) IsConnected() bool {
+	return b.State() == rabbitmq.StateConnected
+}
+
+// routes сообщает, подходит ли ключ маршрутизации к шаблону привязки exchange типа kind
+func routes(kind, pattern, routingKey string) bool {
+	switch kind {
+	case "fanout":
+		return true
+	case "topic":
+		return matchTopic(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+	default:
+		return pattern == routingKey
+	}
+}
+
+// matchTopic сопоставляет слова ключа со словами шаблона: * заменяет ровно одно слово,
+// # - ноль или больше слов
+func matchTopic(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchTopic(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchTopic(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchTopic(pattern[1:], key[1:])
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
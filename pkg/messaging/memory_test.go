@@ -0,0 +1,236 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		kind       string
+		pattern    string
+		routingKey string
+		want       bool
+	}{
+		{kind: "direct", pattern: "order.created", routingKey: "order.created", want: true},
+		{kind: "direct", pattern: "order.created", routingKey: "order.notification", want: false},
+		{kind: "direct", pattern: "order.*", routingKey: "order.created", want: false},
+		{kind: "fanout", pattern: "", routingKey: "any.key", want: true},
+		{kind: "topic", pattern: "order.created", routingKey: "order.created", want: true},
+		{kind: "topic", pattern: "order.*", routingKey: "order.created", want: true},
+		{kind: "topic", pattern: "order.*", routingKey: "order", want: false},
+		{kind: "topic", pattern: "order.*", routingKey: "order.payment.compensation", want: false},
+		{kind: "topic", pattern: "*.created", routingKey: "order.created", want: true},
+		{kind: "topic", pattern: "order.#", routingKey: "order", want: true},
+		{kind: "topic", pattern: "order.#", routingKey: "order.payment.compensation", want: true},
+		{kind: "topic", pattern: "order.#", routingKey: "billing.deposit", want: false},
+		{kind: "topic", pattern: "#", routingKey: "billing.deposit", want: true},
+		{kind: "topic", pattern: "#.deposit", routingKey: "billing.deposit", want: true},
+		{kind: "topic", pattern: "order.#.done", routingKey: "order.a.b.done", want: true},
+		{kind: "topic", pattern: "order.#.done", routingKey: "order.a.b", want: false},
+		{kind: "topic", pattern: "*.*", routingKey: "order", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := routes(tt.kind, tt.pattern, tt.routingKey); got != tt.want {
+			t.Errorf("routes(%s, %q, %q) = %v, want %v", tt.kind, tt.pattern, tt.routingKey, got, tt.want)
+		}
+	}
+}
+
+// newTestBroker возвращает брокер с короткими задержками повторов, который закрывается после теста
+func newTestBroker(t *testing.T, maxRetries int) *MemoryBroker {
+	t.Helper()
+
+	b := NewMemoryBroker(MemoryConfig{
+		MaxRetries:     maxRetries,
+		RetryBaseDelay: time.Millisecond,
+		Consumer:       rabbitmq.ConsumerOptions{Workers: 1, HandlerTimeout: time.Second},
+	})
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// collector собирает тела обработанных сообщений
+type collector struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (c *collector) handle(_ context.Context, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bodies = append(c.bodies, string(body))
+	return nil
+}
+
+func (c *collector) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.bodies)
+}
+
+// waitFor ждет выполнения условия до истечения секунды
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPublishRoutesToMatchingQueues(t *testing.T) {
+	b := newTestBroker(t, 0)
+
+	err := SetupExchangesAndQueues(b,
+		map[string]string{"order_events": "topic"},
+		map[string]map[string]string{
+			"billing":       {"order_events": "order.created"},
+			"notifications": {"order_events": "order.#"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var billing, notifications collector
+	if err := b.ConsumeMessages("billing", "billing", rabbitmq.ConsumerOptions{}, billing.handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ConsumeMessages("notifications", "notifications", rabbitmq.ConsumerOptions{}, notifications.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"order.created", "order.notification", "order.status_changed"} {
+		if err := b.PublishMessage("order_events", key, key); err != nil {
+			t.Fatalf("PublishMessage(%s): %v", key, err)
+		}
+	}
+
+	waitFor(t, "три уведомления", func() bool { return notifications.len() == 3 })
+	waitFor(t, "одно сообщение биллингу", func() bool { return billing.len() == 1 })
+	if billing.bodies[0] != `"order.created"` {
+		t.Errorf("биллинг получил %s, ожидалось order.created", billing.bodies[0])
+	}
+}
+
+func TestPublishWithoutRouteIsReturned(t *testing.T) {
+	b := newTestBroker(t, 0)
+
+	if err := b.DeclareExchange("order_events", "topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	var returned *rabbitmq.ReturnedError
+	if err := b.PublishMessage("order_events", "order.created", "{}"); !errors.As(err, &returned) {
+		t.Errorf("публикация без очередей: ошибка %v, ожидалась *rabbitmq.ReturnedError", err)
+	}
+	if err := b.PublishMessage("unknown", "order.created", "{}"); !errors.Is(err, rabbitmq.ErrPublishFailed) {
+		t.Errorf("публикация в необъявленный exchange: ошибка %v, ожидалась ErrPublishFailed", err)
+	}
+	if err := b.DeclareExchange("order_events", "direct"); err == nil {
+		t.Errorf("повторное объявление exchange с другим типом должно вернуть ошибку")
+	}
+}
+
+func TestFailedMessageIsRedelivered(t *testing.T) {
+	b := newTestBroker(t, 3)
+	if err := b.DeclareQueue("payments"); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts atomic.Int32
+	err := b.ConsumeMessages("payments", "payments", rabbitmq.ConsumerOptions{}, func(context.Context, []byte) error {
+		if attempts.Add(1) < 3 {
+			return errors.New("временная ошибка")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.PublishMessage("", "payments", "{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "успешная третья попытка", func() bool { return attempts.Load() == 3 })
+	time.Sleep(20 * time.Millisecond)
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 3", got)
+	}
+	if got := len(b.DeadLetters("payments")); got != 0 {
+		t.Errorf("в .dlq %d сообщений, ожидалось 0", got)
+	}
+}
+
+func TestMessageMovesToDeadLetterQueueAfterRetries(t *testing.T) {
+	b := newTestBroker(t, 2)
+	if err := b.DeclareQueue("payments"); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts atomic.Int32
+	err := b.ConsumeMessages("payments", "payments", rabbitmq.ConsumerOptions{}, func(context.Context, []byte) error {
+		attempts.Add(1)
+		return errors.New("постоянная ошибка")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.PublishMessage("", "payments", map[string]int{"order_id": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "перенос в .dlq", func() bool { return len(b.DeadLetters("payments")) == 1 })
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("обработчик вызван %d раз, ожидалось 3 (первая доставка и 2 повтора)", got)
+	}
+	if got := string(b.DeadLetters("payments")[0]); got != `{"order_id":1}` {
+		t.Errorf("в .dlq сообщение %s, ожидалось исходное", got)
+	}
+}
+
+func TestStopConsumingWaitsForHandlers(t *testing.T) {
+	b := newTestBroker(t, 0)
+	if err := b.DeclareQueue("payments"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	err := b.ConsumeMessages("payments", "payments", rabbitmq.ConsumerOptions{}, func(context.Context, []byte) error {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.PublishMessage("", "payments", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if err := b.StopConsuming(context.Background()); err != nil {
+		t.Fatalf("StopConsuming: %v", err)
+	}
+	if !finished.Load() {
+		t.Errorf("StopConsuming вернулся до завершения обработчика")
+	}
+	if err := b.ConsumeMessages("payments", "payments", rabbitmq.ConsumerOptions{}, func(context.Context, []byte) error { return nil }); err == nil {
+		t.Errorf("подписка после остановки должна вернуть ошибку")
+	}
+}
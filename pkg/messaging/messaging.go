@@ -1,9 +1,12 @@
 package messaging
 
 import (
+	"context"
+	"fmt"
 	"log"
 
 	"github.com/director74/dz7_shop/pkg/config"
+	"github.com/director74/dz7_shop/pkg/events"
 	"github.com/director74/dz7_shop/pkg/rabbitmq"
 )
 
@@ -13,21 +16,62 @@ type MessagePublisher interface {
 	PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error
 }
 
-// MessageConsumer интерфейс для получения сообщений
+// MessageConsumer интерфейс для получения сообщений. Контекст обработчика отменяется по истечении
+// времени обработки или если остановка StopConsuming не дождалась завершения обработчика
 type MessageConsumer interface {
 	DeclareQueue(name string) error
 	BindQueue(queueName, exchangeName, routingKey string) error
-	ConsumeMessages(queueName, consumerName string, handler func([]byte) error) error
+	ConsumeMessages(queueName, consumerName string, opts rabbitmq.ConsumerOptions, handler rabbitmq.Handler) error
+	StopConsuming(ctx context.Context) error
 }
 
-// MessageBroker объединяет функциональность публикации и обработки сообщений
+// ConnectionStatus сообщает состояние подключения к брокеру для проверки работоспособности сервиса
+type ConnectionStatus interface {
+	IsConnected() bool
+	State() rabbitmq.ConnectionState
+}
+
+// MessageBroker объединяет функциональность публикации и обработки сообщений.
+// Реализации: *rabbitmq.RabbitMQ и *MemoryBroker
 type MessageBroker interface {
 	MessagePublisher
 	MessageConsumer
+	ConnectionStatus
 	DeclareExchange(name string, kind string) error
+	PublishEvent(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error
 	Close() error
 }
 
+// Реализации брокера, выбираемые настройкой MESSAGE_BROKER
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerMemory   = "memory"
+)
+
+// NewBroker создает брокер, выбранный в настройках: RabbitMQ или брокер в памяти процесса
+func NewBroker(cfg config.RabbitMQConfig) (MessageBroker, error) {
+	switch cfg.Broker {
+	case "", BrokerRabbitMQ:
+		rmq, err := InitRabbitMQ(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return rmq, nil
+	case BrokerMemory:
+		log.Println("Используется брокер сообщений в памяти процесса: события получают только сервисы этого процесса (cmd/devstack), при перезапуске они теряются")
+		return NewMemoryBroker(MemoryConfig{
+			MaxRetries:     cfg.MaxRetries,
+			RetryBaseDelay: cfg.RetryBaseDelay,
+			Consumer: rabbitmq.ConsumerOptions{
+				Workers:        cfg.ConsumerWorkers,
+				HandlerTimeout: cfg.HandlerTimeout,
+			},
+		}), nil
+	default:
+		return nil, fmt.Errorf("неизвестный брокер сообщений %q, допустимы %s и %s", cfg.Broker, BrokerRabbitMQ, BrokerMemory)
+	}
+}
+
 // InitRabbitMQ инициализирует подключение к RabbitMQ с общими параметрами
 func InitRabbitMQ(cfg config.RabbitMQConfig) (*rabbitmq.RabbitMQ, error) {
 	rmqCfg := rabbitmq.Config{
@@ -36,6 +80,16 @@ func InitRabbitMQ(cfg config.RabbitMQConfig) (*rabbitmq.RabbitMQ, error) {
 		User:     cfg.User,
 		Password: cfg.Password,
 		VHost:    cfg.VHost,
+
+		MaxRetries:        cfg.MaxRetries,
+		RetryBaseDelay:    cfg.RetryBaseDelay,
+		PublisherChannels: cfg.PublisherChannels,
+
+		Consumer: rabbitmq.ConsumerOptions{
+			Prefetch:       cfg.Prefetch,
+			Workers:        cfg.ConsumerWorkers,
+			HandlerTimeout: cfg.HandlerTimeout,
+		},
 	}
 
 	rmq, err := rabbitmq.NewRabbitMQ(rmqCfg)
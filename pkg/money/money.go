@@ -0,0 +1,334 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency валюта по умолчанию для сумм без явно указанной валюты
+const DefaultCurrency = "RUB"
+
+// fractionDigits количество дробных цифр суммы. Все используемые валюты имеют две дробные цифры
+const fractionDigits = 2
+
+// unitsPerMajor количество минорных единиц (копеек, центов) в основной единице валюты
+const unitsPerMajor = 100
+
+// Ошибки работы с денежными суммами
+var (
+	ErrInvalidAmount    = errors.New("некорректная денежная сумма")
+	ErrCurrencyMismatch = errors.New("валюты сумм не совпадают")
+	ErrOverflow         = errors.New("переполнение денежной суммы")
+)
+
+// Money денежная сумма в минорных единицах валюты. Арифметика выполняется в целых числах,
+// поэтому суммы не накапливают ошибку округления. Пустая валюта означает DefaultCurrency.
+// В JSON сумма представлена объектом {"value": "500.00", "currency": "RUB"},
+// в БД хранится как DECIMAL, валюта при необходимости хранится в отдельной колонке
+type Money struct {
+	units    int64
+	currency string
+}
+
+// New создает сумму из минорных единиц
+func New(units int64, currency string) Money {
+	return Money{units: units, currency: normalizeCurrency(currency)}
+}
+
+// Zero возвращает нулевую сумму в валюте
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Parse разбирает десятичную запись суммы, например "500", "-12.5" или "0.01".
+// Запись с большим числом дробных цифр, чем у валюты, отклоняется, а не округляется
+func Parse(value string, currency string) (Money, error) {
+	units, err := parseUnits(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return New(units, currency), nil
+}
+
+// MustParse разбирает сумму и паникует при ошибке. Используется для констант
+func MustParse(value string, currency string) Money {
+	m, err := Parse(value, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Units возвращает сумму в минорных единицах
+func (m Money) Units() int64 {
+	return m.units
+}
+
+// Currency возвращает код валюты
+func (m Money) Currency() string {
+	if m.currency == "" {
+		return DefaultCurrency
+	}
+	return m.currency
+}
+
+// WithCurrency возвращает ту же сумму в другой валюте без конвертации
+func (m Money) WithCurrency(currency string) Money {
+	return New(m.units, currency)
+}
+
+func (m Money) IsZero() bool {
+	return m.units == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.units > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.units < 0
+}
+
+// Neg возвращает сумму с противоположным знаком
+func (m Money) Neg() Money {
+	return Money{units: -m.units, currency: m.currency}
+}
+
+// Add складывает суммы одной валюты
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	if (other.units > 0 && m.units > math.MaxInt64-other.units) ||
+		(other.units < 0 && m.units < math.MinInt64-other.units) {
+		return Money{}, ErrOverflow
+	}
+	return Money{units: m.units + other.units, currency: m.currency}, nil
+}
+
+// Sub вычитает сумму той же валюты
+func (m Money) Sub(other Money) (Money, error) {
+	if other.units == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(other.Neg())
+}
+
+// Mul умножает сумму на целое число, например цену на количество
+func (m Money) Mul(n int64) (Money, error) {
+	if m.units == 0 || n == 0 {
+		return Money{units: 0, currency: m.currency}, nil
+	}
+	result := m.units * n
+	if result/n != m.units || (m.units == -1 && n == math.MinInt64) || (n == -1 && m.units == math.MinInt64) {
+		return Money{}, ErrOverflow
+	}
+	return Money{units: result, currency: m.currency}, nil
+}
+
+// Cmp сравнивает суммы одной валюты: -1, если m меньше other, 0 при равенстве, 1 если больше
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.units < other.units:
+		return -1, nil
+	case m.units > other.units:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Equal проверяет равенство сумм с учетом валюты
+func (m Money) Equal(other Money) bool {
+	return m.units == other.units && m.Currency() == other.Currency()
+}
+
+// Decimal возвращает десятичную запись суммы без валюты, например "500.00"
+func (m Money) Decimal() string {
+	units := m.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+
+	// Модуль считаем в uint64, чтобы не переполниться на MinInt64
+	abs := uint64(units)
+	if units < 0 {
+		abs = uint64(-(units + 1)) + 1
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/unitsPerMajor, fractionDigits, abs%unitsPerMajor)
+}
+
+// String возвращает сумму с валютой, например "500.00 RUB"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency()
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency() != other.Currency() {
+		return fmt.Errorf("%w: %s и %s", ErrCurrencyMismatch, m.Currency(), other.Currency())
+	}
+	return nil
+}
+
+// jsonMoney JSON представление суммы
+type jsonMoney struct {
+	Value    json.RawMessage `json:"value"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value    string `json:"value"`
+		Currency string `json:"currency"`
+	}{
+		Value:    m.Decimal(),
+		Currency: m.Currency(),
+	})
+}
+
+// UnmarshalJSON принимает объект {"value": ..., "currency": ...}, а также число или строку
+// в валюте по умолчанию. Значение разбирается из текста без преобразования во float64
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	currency := ""
+	if len(data) > 0 && data[0] == '{' {
+		var obj jsonMoney
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+		}
+		if len(obj.Value) == 0 {
+			return fmt.Errorf("%w: не указано значение", ErrInvalidAmount)
+		}
+		data = bytes.TrimSpace(obj.Value)
+		currency = obj.Currency
+	}
+
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+		}
+	}
+
+	units, err := parseUnits(text)
+	if err != nil {
+		return err
+	}
+
+	*m = New(units, currency)
+	return nil
+}
+
+// Value сохраняет сумму в колонку DECIMAL
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+// Scan читает сумму из колонки DECIMAL, валюта остается по умолчанию
+func (m *Money) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		units, err := Money{units: v}.Mul(unitsPerMajor)
+		if err != nil {
+			return err
+		}
+		*m = units
+		return nil
+	case float64:
+		text = strconv.FormatFloat(v, 'f', fractionDigits, 64)
+	default:
+		return fmt.Errorf("%w: неподдерживаемый тип %T", ErrInvalidAmount, src)
+	}
+
+	units, err := parseUnits(text)
+	if err != nil {
+		return err
+	}
+	*m = Money{units: units}
+	return nil
+}
+
+// GormDataType тип колонки для автомиграции GORM
+func (Money) GormDataType() string {
+	return "decimal(12,2)"
+}
+
+// parseUnits переводит десятичную запись в минорные единицы
+func parseUnits(value string) (int64, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return 0, fmt.Errorf("%w: пустое значение", ErrInvalidAmount)
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, fraction, hasFraction := strings.Cut(s, ".")
+	if whole == "" || (hasFraction && fraction == "") {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	// Лишние дробные цифры допустимы, только если это нули
+	if len(fraction) > fractionDigits {
+		if strings.Trim(fraction[fractionDigits:], "0") != "" {
+			return 0, fmt.Errorf("%w: больше %d знаков после запятой в %q", ErrInvalidAmount, fractionDigits, value)
+		}
+		fraction = fraction[:fractionDigits]
+	}
+	fraction += strings.Repeat("0", fractionDigits-len(fraction))
+
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, value)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
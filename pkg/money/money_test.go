@@ -0,0 +1,268 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int64
+		wantErr error
+	}{
+		{name: "целое", value: "500", want: 50000},
+		{name: "одна дробная цифра", value: "12.5", want: 1250},
+		{name: "две дробные цифры", value: "0.01", want: 1},
+		{name: "лишние нули в дробной части", value: "1.2300", want: 123},
+		{name: "пробелы вокруг", value: "  7.10 ", want: 710},
+		{name: "отрицательная", value: "-12.5", want: -1250},
+		{name: "явный плюс", value: "+3", want: 300},
+		{name: "ноль", value: "0", want: 0},
+		{name: "максимум int64", value: "92233720368547758.07", want: math.MaxInt64},
+		{name: "минимум без переполнения", value: "-92233720368547758.07", want: -math.MaxInt64},
+		{name: "переполнение на копейку", value: "92233720368547758.08", wantErr: ErrOverflow},
+		{name: "переполнение целой части", value: "100000000000000000", wantErr: ErrOverflow},
+		{name: "переполнение отрицательной", value: "-92233720368547758.09", wantErr: ErrOverflow},
+		{name: "три значащие дробные цифры", value: "1.001", wantErr: ErrInvalidAmount},
+		{name: "значащая цифра после нулей", value: "1.0001", wantErr: ErrInvalidAmount},
+		{name: "пустая строка", value: "", wantErr: ErrInvalidAmount},
+		{name: "только знак", value: "-", wantErr: ErrInvalidAmount},
+		{name: "точка без дробной части", value: "1.", wantErr: ErrInvalidAmount},
+		{name: "точка без целой части", value: ".5", wantErr: ErrInvalidAmount},
+		{name: "двойной знак", value: "--1", wantErr: ErrInvalidAmount},
+		{name: "буквы", value: "12a", wantErr: ErrInvalidAmount},
+		{name: "экспонента", value: "1e3", wantErr: ErrInvalidAmount},
+		{name: "две точки", value: "1.2.3", wantErr: ErrInvalidAmount},
+		{name: "запятая", value: "1,50", wantErr: ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.value, "rub")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.value, err)
+			}
+			if got.Units() != tt.want {
+				t.Errorf("Parse(%q) = %d units, want %d", tt.value, got.Units(), tt.want)
+			}
+			if got.Currency() != "RUB" {
+				t.Errorf("Parse(%q) currency = %q, want RUB", tt.value, got.Currency())
+			}
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		units int64
+		want  string
+	}{
+		{units: 0, want: "0.00"},
+		{units: 1, want: "0.01"},
+		{units: 50000, want: "500.00"},
+		{units: -1250, want: "-12.50"},
+		{units: math.MaxInt64, want: "92233720368547758.07"},
+		{units: math.MinInt64, want: "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := New(tt.units, "").Decimal(); got != tt.want {
+			t.Errorf("New(%d).Decimal() = %q, want %q", tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	max := New(math.MaxInt64, "")
+	min := New(math.MinInt64, "")
+	one := New(1, "")
+
+	if _, err := max.Add(one); !errors.Is(err, ErrOverflow) {
+		t.Errorf("MaxInt64 + 1: error = %v, want ErrOverflow", err)
+	}
+	if _, err := min.Sub(one); !errors.Is(err, ErrOverflow) {
+		t.Errorf("MinInt64 - 1: error = %v, want ErrOverflow", err)
+	}
+	if _, err := one.Sub(min); !errors.Is(err, ErrOverflow) {
+		t.Errorf("1 - MinInt64: error = %v, want ErrOverflow", err)
+	}
+	if _, err := max.Mul(2); !errors.Is(err, ErrOverflow) {
+		t.Errorf("MaxInt64 * 2: error = %v, want ErrOverflow", err)
+	}
+	if _, err := min.Mul(-1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("MinInt64 * -1: error = %v, want ErrOverflow", err)
+	}
+	if _, err := New(1, "RUB").Add(New(1, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("RUB + USD: error = %v, want ErrCurrencyMismatch", err)
+	}
+
+	got, err := New(250, "").Mul(3)
+	if err != nil || got.Units() != 750 {
+		t.Errorf("2.50 * 3 = %v, %v, want 7.50", got, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    int64
+		wantErr error
+	}{
+		{name: "nil", src: nil, want: 0},
+		{name: "bytes", src: []byte("500.00"), want: 50000},
+		{name: "string", src: "12.5", want: 1250},
+		{name: "отрицательная строка", src: "-0.01", want: -1},
+		{name: "int64", src: int64(42), want: 4200},
+		{name: "int64 с переполнением", src: int64(math.MaxInt64), wantErr: ErrOverflow},
+		{name: "float64", src: 19.99, want: 1999},
+		{name: "float64 с округлением до копеек", src: 0.1 + 0.2, want: 30},
+		{name: "некорректная строка", src: "abc", wantErr: ErrInvalidAmount},
+		{name: "неподдерживаемый тип", src: true, wantErr: ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(999, "USD")
+			err := m.Scan(tt.src)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Scan(%v) error = %v, want %v", tt.src, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan(%v) unexpected error: %v", tt.src, err)
+			}
+			if m.Units() != tt.want {
+				t.Errorf("Scan(%v) = %d units, want %d", tt.src, m.Units(), tt.want)
+			}
+			if m.Currency() != DefaultCurrency {
+				t.Errorf("Scan(%v) currency = %q, want %q", tt.src, m.Currency(), DefaultCurrency)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	v, err := MustParse("-3.5", "").Value()
+	if err != nil {
+		t.Fatalf("Value() unexpected error: %v", err)
+	}
+	if v != "-3.50" {
+		t.Errorf("Value() = %v, want -3.50", v)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: MustParse("500", ""), want: `{"value":"500.00","currency":"RUB"}`},
+		{money: MustParse("-0.05", "usd"), want: `{"value":"-0.05","currency":"USD"}`},
+		{money: Money{}, want: `{"value":"0.00","currency":"RUB"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.money)
+		if err != nil {
+			t.Fatalf("Marshal(%v) unexpected error: %v", tt.money, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		want         int64
+		wantCurrency string
+		wantErr      error
+	}{
+		{name: "объект", data: `{"value": "500.00", "currency": "USD"}`, want: 50000, wantCurrency: "USD"},
+		{name: "объект с числом", data: `{"value": 12.5}`, want: 1250, wantCurrency: DefaultCurrency},
+		{name: "объект с валютой в нижнем регистре", data: `{"value": "1", "currency": "eur"}`, want: 100, wantCurrency: "EUR"},
+		{name: "число", data: `19.99`, want: 1999, wantCurrency: DefaultCurrency},
+		{name: "строка", data: `"0.01"`, want: 1, wantCurrency: DefaultCurrency},
+		{name: "отрицательное число", data: `-7`, want: -700, wantCurrency: DefaultCurrency},
+		{name: "число без потери точности float64", data: `90071992547409.93`, want: 9007199254740993, wantCurrency: DefaultCurrency},
+		{name: "три дробные цифры", data: `1.005`, wantErr: ErrInvalidAmount},
+		{name: "экспонента", data: `1e2`, wantErr: ErrInvalidAmount},
+		{name: "объект без значения", data: `{"currency": "RUB"}`, wantErr: ErrInvalidAmount},
+		{name: "некорректный объект", data: `{"value": }`, wantErr: ErrInvalidAmount},
+		{name: "булево значение", data: `true`, wantErr: ErrInvalidAmount},
+		{name: "переполнение", data: `"92233720368547758.08"`, wantErr: ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			err := m.UnmarshalJSON([]byte(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Unmarshal(%s) error = %v, want %v", tt.data, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) unexpected error: %v", tt.data, err)
+			}
+			if m.Units() != tt.want || m.Currency() != tt.wantCurrency {
+				t.Errorf("Unmarshal(%s) = %d %s, want %d %s", tt.data, m.Units(), m.Currency(), tt.want, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONNull(t *testing.T) {
+	var req struct {
+		Amount *Money `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": null}`), &req); err != nil {
+		t.Fatalf("Unmarshal unexpected error: %v", err)
+	}
+	if req.Amount != nil {
+		t.Errorf("Amount = %v, want nil", req.Amount)
+	}
+
+	m := New(100, "USD")
+	if err := m.UnmarshalJSON([]byte("null")); err != nil {
+		t.Fatalf("UnmarshalJSON(null) unexpected error: %v", err)
+	}
+	if !m.Equal(New(100, "USD")) {
+		t.Errorf("UnmarshalJSON(null) changed value to %v", m)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, value := range []string{"0", "0.01", "-12.34", "92233720368547758.07"} {
+		original := MustParse(value, "USD")
+
+		data, err := json.Marshal(original)
+		if err != nil {
+			t.Fatalf("Marshal(%s) unexpected error: %v", value, err)
+		}
+
+		var decoded Money
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal(%s) unexpected error: %v", data, err)
+		}
+		if !decoded.Equal(original) {
+			t.Errorf("round trip %s: got %v, want %v", value, decoded, original)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz7_shop/pkg/config"
+	"github.com/director74/dz7_shop/pkg/events"
+	"github.com/director74/dz7_shop/pkg/rabbitmq"
+)
+
+// Статусы сообщений outbox
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	// StatusFailed сообщение, которое повтор не опубликует: оно не разбирается или не попало
+	// ни в одну очередь. Relay его больше не выбирает, запись остается для разбора вручную
+	StatusFailed = "failed"
+)
+
+// publishTimeout максимальное время ожидания подтверждения брокера для одного сообщения
+const publishTimeout = 5 * time.Second
+
+// Message событие, сохраненное в одной транзакции с изменением данных и ожидающее публикации
+type Message struct {
+	ID            uint       `gorm:"primaryKey"`
+	Exchange      string     `gorm:"size:255;not null"`
+	RoutingKey    string     `gorm:"size:255;not null"`
+	Payload       []byte     `gorm:"type:jsonb;not null"`
+	Status        string     `gorm:"size:20;not null;default:pending"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:text"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_messages_pending,where:status = 'pending'"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	SentAt        *time.Time `gorm:"index:idx_outbox_messages_sent_at"`
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Add сохраняет конверт события в outbox. db должен быть транзакцией, в которой меняются данные,
+// тогда событие будет опубликовано, только если изменение зафиксировано
+func Add(db *gorm.DB, exchange, routingKey string, envelope events.Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации события %s: %w", routingKey, err)
+	}
+
+	now := time.Now()
+	return db.Create(&Message{
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Payload:       payload,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
+
+// Publisher публикует конверт события и дожидается подтверждения его приема брокером
+type Publisher interface {
+	PublishEvent(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error
+}
+
+// Relay публикует сообщения из outbox в брокер и отмечает их отправленными.
+// Сообщения выбираются с SKIP LOCKED, поэтому несколько экземпляров сервиса не публикуют
+// одно сообщение одновременно. Доставка at-least-once: при сбое после публикации
+// сообщение будет отправлено повторно с тем же event_id
+type Relay struct {
+	db        *gorm.DB
+	publisher Publisher
+	config    config.OutboxConfig
+}
+
+// NewRelay создает relay. Задержка повтора после ошибки начинается с PollInterval
+// и удваивается до MaxBackoff, опубликованные сообщения хранятся Retention (0 - бессрочно)
+func NewRelay(db *gorm.DB, publisher Publisher, cfg config.OutboxConfig) *Relay {
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		config:    cfg,
+	}
+}
+
+// Run публикует накопившиеся сообщения каждые PollInterval до отмены контекста
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		// Выбираем пачки, пока outbox не опустеет
+		for ctx.Err() == nil {
+			processed, err := r.PublishPending(ctx)
+			if err != nil {
+				log.Printf("Ошибка при публикации сообщений outbox: %v", err)
+				break
+			}
+			if processed < r.config.BatchSize {
+				break
+			}
+		}
+
+		if r.config.Retention > 0 && time.Since(lastCleanup) >= time.Hour {
+			if err := r.DeleteSent(ctx, time.Now().Add(-r.config.Retention)); err != nil {
+				log.Printf("Ошибка при очистке outbox: %v", err)
+			}
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending публикует одну пачку сообщений, готовых к отправке, и возвращает их количество.
+// Неопубликованные сообщения откладываются с экспоненциальной задержкой
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	var messages []Message
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+			Order("id").
+			Limit(r.config.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return fmt.Errorf("ошибка при выборке сообщений: %w", err)
+		}
+
+		for i := range messages {
+			if err := r.publish(ctx, tx, &messages[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return len(messages), err
+}
+
+// DeleteSent удаляет сообщения, опубликованные раньше момента before
+func (r *Relay) DeleteSent(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, before).
+		Delete(&Message{}).Error
+}
+
+// publish отправляет сообщение и сохраняет результат попытки
+func (r *Relay) publish(ctx context.Context, tx *gorm.DB, message *Message) error {
+	var envelope events.Envelope
+	err := json.Unmarshal(message.Payload, &envelope)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", rabbitmq.ErrInvalidMessage, err)
+	} else {
+		publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err = r.publisher.PublishEvent(publishCtx, message.Exchange, message.RoutingKey, envelope)
+		cancel()
+	}
+
+	message.Attempts++
+	now := time.Now()
+
+	if isPermanent(err) {
+		log.Printf("ВНИМАНИЕ: сообщение outbox %d (%s) не опубликовано и больше не будет отправляться: %v",
+			message.ID, message.RoutingKey, err)
+
+		return tx.Model(&Message{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+			"status":     StatusFailed,
+			"attempts":   message.Attempts,
+			"last_error": err.Error(),
+		}).Error
+	}
+
+	if err != nil {
+		delay := r.backoff(message.Attempts)
+		log.Printf("Ошибка публикации сообщения outbox %d (%s, попытка %d), повтор через %v: %v",
+			message.ID, message.RoutingKey, message.Attempts, delay, err)
+
+		return tx.Model(&Message{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+			"attempts":        message.Attempts,
+			"last_error":      err.Error(),
+			"next_attempt_at": now.Add(delay),
+		}).Error
+	}
+
+	return tx.Model(&Message{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+		"status":     StatusSent,
+		"attempts":   message.Attempts,
+		"last_error": "",
+		"sent_at":    now,
+	}).Error
+}
+
+// isPermanent сообщает, что повтор публикации завершится той же ошибкой err
+func isPermanent(err error) bool {
+	return errors.Is(err, rabbitmq.ErrInvalidMessage) || errors.Is(err, rabbitmq.ErrUnroutable)
+}
+
+// backoff возвращает задержку перед следующей попыткой: PollInterval, удваиваемый
+// с каждой неудачной попыткой, но не больше MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.config.PollInterval
+	for i := 1; i < attempts && delay < r.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.config.MaxBackoff {
+		delay = r.config.MaxBackoff
+	}
+	return delay
+}
+
+// PublishCommitted возвращает функцию, которая публикует событие сразу после фиксации транзакции.
+// Заменяет relay для хранилищ в памяти, у которых нет таблицы outbox: ошибка публикации
+// записывается в журнал, повтора нет
+func PublishCommitted(publisher Publisher) func(exchange, routingKey string, envelope events.Envelope) {
+	return func(exchange, routingKey string, envelope events.Envelope) {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+
+		if err := publisher.PublishEvent(ctx, exchange, routingKey, envelope); err != nil {
+			log.Printf("ВНИМАНИЕ: событие %s (%s) не опубликовано: %v", envelope.Type, envelope.EventID, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Настройки подписки, если они не заданы ни в ConsumeMessages, ни в Config.Consumer
+const (
+	defaultPrefetch       = 10
+	defaultWorkers        = 1
+	defaultHandlerTimeout = 10 * time.Second
+)
+
+// Handler обрабатывает тело сообщения. Контекст отменяется по истечении HandlerTimeout
+// или если StopConsuming не дождался завершения обработки
+type Handler func(ctx context.Context, body []byte) error
+
+// ConsumerOptions настройки подписки. Нулевые значения заменяются настройками Config.Consumer
+type ConsumerOptions struct {
+	// Prefetch количество неподтвержденных сообщений, которые брокер передает подписке,
+	// не меньше Workers
+	Prefetch int
+	// Workers количество сообщений, обрабатываемых параллельно
+	Workers int
+	// HandlerTimeout максимальное время обработки одного сообщения
+	HandlerTimeout time.Duration
+}
+
+// WithDefaults заполняет незаданные настройки значениями defaults, а затем встроенными значениями
+func (o ConsumerOptions) WithDefaults(defaults ConsumerOptions) ConsumerOptions {
+	if o.Prefetch <= 0 {
+		o.Prefetch = defaults.Prefetch
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaults.Workers
+	}
+	if o.HandlerTimeout <= 0 {
+		o.HandlerTimeout = defaults.HandlerTimeout
+	}
+
+	if o.Prefetch <= 0 {
+		o.Prefetch = defaultPrefetch
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaultWorkers
+	}
+	if o.HandlerTimeout <= 0 {
+		o.HandlerTimeout = defaultHandlerTimeout
+	}
+	if o.Prefetch < o.Workers {
+		o.Prefetch = o.Workers
+	}
+	return o
+}
+
+// consumer подписка на очередь, возобновляемая после переподключения
+type consumer struct {
+	queue   string
+	name    string
+	handler Handler
+	options ConsumerOptions
+	// channel текущий канал подписки, защищен r.mu
+	channel *amqp.Channel
+}
+
+// ConsumeMessages начинает обработку сообщений из очереди в отдельном канале. Сообщения обрабатываются
+// opts.Workers обработчиками параллельно. Подписка возобновляется после закрытия канала или переподключения
+func (r *RabbitMQ) ConsumeMessages(queueName, consumerName string, opts ConsumerOptions, handler Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.consumingStopped() {
+		return fmt.Errorf("ошибка при начале обработки сообщений из очереди %s: подписки остановлены", queueName)
+	}
+	if err := r.ensureConnected(); err != nil {
+		return fmt.Errorf("ошибка при начале обработки сообщений: %w", err)
+	}
+
+	c := &consumer{
+		queue:   queueName,
+		name:    consumerName,
+		handler: handler,
+		options: opts.WithDefaults(r.config.Consumer),
+	}
+	msgs, err := r.consume(c)
+	if err != nil {
+		return err
+	}
+
+	r.consumers = append(r.consumers, c)
+	r.consuming.Add(1)
+	go r.runConsumer(c, msgs)
+
+	return nil
+}
+
+// StopConsuming отменяет все подписки, чтобы брокер перестал передавать сообщения, и ждет
+// завершения обрабатываемых сообщений до истечения ctx. Если ctx истек раньше, контекст
+// обработчиков отменяется, а неподтвержденные сообщения брокер вернет в очередь при закрытии соединения
+func (r *RabbitMQ) StopConsuming(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stopConsuming) })
+
+	r.mu.RLock()
+	for _, c := range r.consumers {
+		if c.channel != nil && !c.channel.IsClosed() {
+			if err := c.channel.Cancel(c.name, false); err != nil {
+				log.Printf("Ошибка при отмене подписки %s на очередь %s: %v", c.name, c.queue, err)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.consuming.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		r.cancelHandlers()
+		return fmt.Errorf("обработка сообщений не завершилась до остановки: %w", ctx.Err())
+	}
+}
+
+func (r *RabbitMQ) consumingStopped() bool {
+	select {
+	case <-r.stopConsuming:
+		return true
+	default:
+		return false
+	}
+}
+
+// consume открывает для подписки собственный канал, чтобы подтверждения обработчика
+// не конкурировали с публикацией и другими подписками. Вызывается под r.mu на запись
+func (r *RabbitMQ) consume(c *consumer) (<-chan amqp.Delivery, error) {
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open consumer channel: %w", err)
+	}
+
+	if err := ch.Qos(c.options.Prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ошибка при установке prefetch для очереди %s: %w", c.queue, err)
+	}
+
+	msgs, err := ch.Consume(
+		c.queue, // queue
+		c.name,  // consumer
+		false,   // auto-ack
+		false,   // exclusive
+		false,   // no-local
+		false,   // no-wait
+		nil,     // args
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("ошибка при начале обработки сообщений: %w", err)
+	}
+
+	c.channel = ch
+	return msgs, nil
+}
+
+// runConsumer обрабатывает сообщения подписки, а после закрытия ее канала подписывается заново.
+// Завершается после StopConsuming или Close
+func (r *RabbitMQ) runConsumer(c *consumer, msgs <-chan amqp.Delivery) {
+	defer r.consuming.Done()
+
+	for msgs != nil {
+		r.handleDeliveries(c, msgs)
+		msgs = r.resubscribe(c)
+	}
+
+	r.mu.RLock()
+	if c.channel != nil && !c.channel.IsClosed() {
+		c.channel.Close()
+	}
+	r.mu.RUnlock()
+}
+
+// resubscribe повторяет подписку с нарастающей задержкой, пока она не удастся. Пока соединение
+// восстанавливается, попытка повторяется сразу после восстановления. Возвращает nil,
+// если подписки остановлены или клиент закрыт
+func (r *RabbitMQ) resubscribe(c *consumer) <-chan amqp.Delivery {
+	delay := reconnectMinDelay
+	for {
+		if r.consumingStopped() {
+			return nil
+		}
+
+		r.mu.Lock()
+		state, reconnected := r.state, r.reconnected
+		var msgs <-chan amqp.Delivery
+		var err error
+		if state == StateConnected {
+			msgs, err = r.consume(c)
+		}
+		r.mu.Unlock()
+
+		if state == StateConnected && err == nil {
+			log.Printf("Подписка %s на очередь %s возобновлена", c.name, c.queue)
+			return msgs
+		}
+		if err != nil {
+			log.Printf("Ошибка при возобновлении подписки %s на очередь %s, повтор через %v: %v",
+				c.name, c.queue, delay, err)
+		}
+
+		select {
+		case <-r.done:
+			return nil
+		case <-r.stopConsuming:
+			return nil
+		case <-reconnected:
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// handleDeliveries обрабатывает сообщения подписки в Workers горутинах, пока канал сообщений не закрыт
+func (r *RabbitMQ) handleDeliveries(c *consumer, msgs <-chan amqp.Delivery) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range msgs {
+				r.handleDelivery(c, msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handleDelivery обрабатывает одно сообщение. Сообщение, которое не удалось обработать, откладывается
+// в очередь повтора, а после MaxRetries повторов переносится в очередь .dlq. Сообщение, полученное
+// после начала остановки или прерванное ею, возвращается в очередь без учета попытки
+func (r *RabbitMQ) handleDelivery(c *consumer, msg amqp.Delivery) {
+	if r.consumingStopped() {
+		msg.Nack(false, true)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.handlerCtx, c.options.HandlerTimeout)
+	err := c.handler(ctx, msg.Body)
+	cancel()
+
+	switch {
+	case err == nil:
+		msg.Ack(false) // Подтверждаем обработку сообщения
+	case r.handlerCtx.Err() != nil:
+		log.Printf("Обработка сообщения %s из очереди %s прервана остановкой: %v", msg.MessageId, c.queue, err)
+		msg.Nack(false, true)
+	default:
+		log.Printf("Ошибка обработки сообщения %s из очереди %s: %v", msg.MessageId, c.queue, err)
+		r.rejectMessage(c.queue, msg, err)
+	}
+}
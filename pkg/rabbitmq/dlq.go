@@ -0,0 +1,315 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Заголовки, которыми сопровождается повтор и перенос сообщения в очередь .dlq
+const (
+	HeaderRetryCount         = "x-retry-count"
+	HeaderLastError          = "x-last-error"
+	HeaderFailedAt           = "x-failed-at"
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+)
+
+// Заголовки конверта события, которых нет среди свойств сообщения AMQP
+const (
+	HeaderEventVersion = "x-event-version"
+	HeaderCausationID  = "x-causation-id"
+)
+
+// dlqRoutingKey ключ маршрутизации в exchange .dlx для очереди недоставленных сообщений
+const dlqRoutingKey = "dlq"
+
+// deadLetterOpTimeout максимальное время одной операции с очередью недоставленных сообщений
+const deadLetterOpTimeout = 5 * time.Second
+
+// DeadLetter сообщение из очереди недоставленных сообщений
+type DeadLetter struct {
+	MessageID  string
+	Exchange   string
+	RoutingKey string
+	RetryCount int
+	LastError  string
+	FailedAt   time.Time
+	Body       []byte
+}
+
+// DeadLetterQueueName возвращает имя очереди недоставленных сообщений для очереди queue
+func DeadLetterQueueName(queue string) string {
+	return queue + ".dlq"
+}
+
+func deadLetterExchangeName(queue string) string {
+	return queue + ".dlx"
+}
+
+func retryQueueName(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+func retryRoutingKey(attempt int) string {
+	return fmt.Sprintf("retry.%d", attempt)
+}
+
+// retryDelay возвращает задержку перед повторной попыткой attempt: RetryBaseDelay * 2^(attempt-1)
+func (r *RabbitMQ) retryDelay(attempt int) time.Duration {
+	return r.config.RetryBaseDelay << (attempt - 1)
+}
+
+// declareDeadLetterTopology объявляет для очереди queue exchange .dlx, очереди повторов .retry.N
+// с нарастающим TTL и очередь недоставленных сообщений .dlq. Сообщение из очереди повтора
+// по истечении TTL возвращается в основную очередь через exchange по умолчанию
+func (r *RabbitMQ) declareDeadLetterTopology(queue string) error {
+	dlx := deadLetterExchangeName(queue)
+	if err := r.channel.ExchangeDeclare(dlx, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("ошибка при объявлении exchange %s: %w", dlx, err)
+	}
+
+	dlq := DeadLetterQueueName(queue)
+	if _, err := r.channel.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("ошибка при объявлении очереди %s: %w", dlq, err)
+	}
+	if err := r.channel.QueueBind(dlq, dlqRoutingKey, dlx, false, nil); err != nil {
+		return fmt.Errorf("ошибка при привязке очереди %s: %w", dlq, err)
+	}
+
+	for attempt := 1; attempt <= r.config.MaxRetries; attempt++ {
+		name := retryQueueName(queue, attempt)
+		_, err := r.channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+			"x-message-ttl":             r.retryDelay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при объявлении очереди %s: %w", name, err)
+		}
+		if err := r.channel.QueueBind(name, retryRoutingKey(attempt), dlx, false, nil); err != nil {
+			return fmt.Errorf("ошибка при привязке очереди %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// rejectMessage откладывает необработанное сообщение в очередь следующего повтора,
+// а после исчерпания повторов переносит его в очередь .dlq
+func (r *RabbitMQ) rejectMessage(queue string, msg amqp.Delivery, handlerErr error) {
+	attempt := headerInt(msg.Headers, HeaderRetryCount) + 1
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderRetryCount] = int32(attempt)
+	headers[HeaderLastError] = handlerErr.Error()
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = msg.Exchange
+		headers[HeaderOriginalRoutingKey] = msg.RoutingKey
+	}
+
+	routingKey := retryRoutingKey(attempt)
+	if attempt > r.config.MaxRetries {
+		routingKey = dlqRoutingKey
+		headers[HeaderRetryCount] = int32(attempt - 1)
+		headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterOpTimeout)
+	defer cancel()
+
+	err := r.publishConfirmed(ctx, deadLetterExchangeName(queue), routingKey, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     msg.MessageId,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		CorrelationId: msg.CorrelationId,
+		Timestamp:     msg.Timestamp,
+		Body:          msg.Body,
+	})
+	if err != nil {
+		// Сообщение не удалось отложить: брокер сам перенесет его в .dlq по настройкам очереди
+		log.Printf("Ошибка при переносе сообщения из очереди %s (%s): %v", queue, routingKey, err)
+		msg.Nack(false, false)
+		return
+	}
+
+	if routingKey == dlqRoutingKey {
+		log.Printf("Сообщение %s из очереди %s перенесено в %s после %d повторов: %v",
+			msg.MessageId, queue, DeadLetterQueueName(queue), attempt-1, handlerErr)
+	} else {
+		log.Printf("Повтор %d/%d сообщения %s из очереди %s через %v",
+			attempt, r.config.MaxRetries, msg.MessageId, queue, r.retryDelay(attempt))
+	}
+
+	msg.Ack(false)
+}
+
+// ListDeadLetters возвращает до limit сообщений из очереди .dlq для очереди queue, не удаляя их
+func (r *RabbitMQ) ListDeadLetters(queue string, limit int) ([]DeadLetter, error) {
+	ch, err := r.adminChannel()
+	if err != nil {
+		return nil, err
+	}
+	// При закрытии канала неподтвержденные сообщения возвращаются в очередь
+	defer ch.Close()
+
+	var letters []DeadLetter
+	for len(letters) < limit {
+		msg, ok, err := ch.Get(DeadLetterQueueName(queue), false)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при чтении очереди %s: %w", DeadLetterQueueName(queue), err)
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, toDeadLetter(msg))
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters возвращает до limit сообщений из очереди .dlq в очередь queue со сброшенным
+// счетчиком повторов и возвращает их количество. Сообщение удаляется из .dlq только после
+// подтверждения брокером публикации в основную очередь
+func (r *RabbitMQ) ReplayDeadLetters(queue string, limit int) (int, error) {
+	ch, err := r.adminChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return 0, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	replayed := 0
+	for replayed < limit {
+		msg, ok, err := ch.Get(DeadLetterQueueName(queue), false)
+		if err != nil {
+			return replayed, fmt.Errorf("ошибка при чтении очереди %s: %w", DeadLetterQueueName(queue), err)
+		}
+		if !ok {
+			break
+		}
+
+		if err := replay(ch, returns, queue, msg); err != nil {
+			msg.Nack(false, true)
+			return replayed, err
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, fmt.Errorf("ошибка при удалении сообщения из %s: %w", DeadLetterQueueName(queue), err)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// PurgeDeadLetters удаляет все сообщения из очереди .dlq для очереди queue и возвращает их количество
+func (r *RabbitMQ) PurgeDeadLetters(queue string) (int, error) {
+	ch, err := r.adminChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	return ch.QueuePurge(DeadLetterQueueName(queue), false)
+}
+
+// adminChannel открывает отдельный канал для операций с очередью .dlq,
+// чтобы чтение без подтверждения не мешало публикации и обработке сообщений
+func (r *RabbitMQ) adminChannel() (*amqp.Channel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if err := r.ensureConnected(); err != nil {
+		return nil, err
+	}
+
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	return ch, nil
+}
+
+// replay публикует сообщение из .dlq в основную очередь через exchange по умолчанию,
+// чтобы оно не попало повторно в другие очереди, привязанные к исходному exchange.
+// Флаг mandatory не дает потерять сообщение, если основной очереди больше нет
+func replay(ch *amqp.Channel, returns <-chan amqp.Return, queue string, msg amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	delete(headers, HeaderRetryCount)
+	delete(headers, HeaderLastError)
+	delete(headers, HeaderFailedAt)
+	delete(headers, "x-death")
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterOpTimeout)
+	defer cancel()
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     msg.MessageId,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		CorrelationId: msg.CorrelationId,
+		Timestamp:     msg.Timestamp,
+		Body:          msg.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPublishFailed, err)
+	}
+
+	return waitConfirmation(ctx, confirmation, returns)
+}
+
+func toDeadLetter(msg amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageID:  msg.MessageId,
+		Exchange:   headerString(msg.Headers, HeaderOriginalExchange),
+		RoutingKey: headerString(msg.Headers, HeaderOriginalRoutingKey),
+		RetryCount: headerInt(msg.Headers, HeaderRetryCount),
+		LastError:  headerString(msg.Headers, HeaderLastError),
+		Body:       msg.Body,
+	}
+	if failedAt, err := time.Parse(time.RFC3339, headerString(msg.Headers, HeaderFailedAt)); err == nil {
+		letter.FailedAt = failedAt
+	}
+	return letter
+}
+
+func headerString(headers amqp.Table, key string) string {
+	if value, ok := headers[key].(string); ok {
+		return value
+	}
+	return ""
+}
+
+// headerInt читает целочисленный заголовок, тип которого зависит от отправителя
+func headerInt(headers amqp.Table, key string) int {
+	switch value := headers[key].(type) {
+	case int:
+		return value
+	case int16:
+		return int(value)
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Ошибки публикации. ErrPublishFailed, ErrPublishNacked и ErrConfirmTimeout означают, что брокер
+// не принял сообщение и его можно отправить повторно. ErrInvalidMessage и ErrUnroutable повтор
+// не исправит: сообщение не изменится, а очередь для него не появится без изменения топологии
+var (
+	ErrInvalidMessage = errors.New("некорректное сообщение")
+	ErrPublishFailed  = errors.New("ошибка при публикации сообщения")
+	ErrPublishNacked  = errors.New("брокер не подтвердил прием сообщения")
+	ErrConfirmTimeout = errors.New("не дождались подтверждения брокера")
+	ErrUnroutable     = errors.New("сообщение не попало ни в одну очередь")
+)
+
+// ReturnedError сообщение с флагом mandatory, возвращенное брокером, потому что
+// к exchange не привязана подходящая очередь. Соответствует ErrUnroutable
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("%s: exchange %q, ключ %q (%d %s)",
+		ErrUnroutable, e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+func (e *ReturnedError) Unwrap() error {
+	return ErrUnroutable
+}
+
+func newReturnedError(ret amqp.Return) *ReturnedError {
+	return &ReturnedError{
+		Exchange:   ret.Exchange,
+		RoutingKey: ret.RoutingKey,
+		ReplyCode:  ret.ReplyCode,
+		ReplyText:  ret.ReplyText,
+	}
+}
+
+// IsRetryable сообщает, имеет ли смысл повторить публикацию после ошибки err.
+// Сообщение, которое не попало ни в одну очередь, не повторяется: пока к exchange
+// не привязана очередь, брокер вернет его снова
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrNotConnected) ||
+		errors.Is(err, ErrPublishFailed) ||
+		errors.Is(err, ErrPublishNacked) ||
+		errors.Is(err, ErrConfirmTimeout)
+}
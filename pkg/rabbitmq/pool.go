@@ -0,0 +1,174 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultPublisherChannels размер пула каналов публикации, если он не задан в настройках
+const defaultPublisherChannels = 4
+
+// publisher канал публикации в режиме подтверждений. returns - сообщения с флагом mandatory,
+// возвращенные брокером в этом канале
+type publisher struct {
+	channel publishChannel
+	returns <-chan amqp.Return
+}
+
+// publishChannel канал, через который публикует пул. Рабочая реализация - amqpPublishChannel,
+// тесты пула подменяют ее, чтобы проверить выдачу и возврат каналов без брокера
+type publishChannel interface {
+	publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (deferredConfirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+// deferredConfirmation ожидание подтверждения брокера для опубликованного сообщения
+type deferredConfirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// amqpPublishChannel канал amqp в режиме подтверждений
+type amqpPublishChannel struct {
+	*amqp.Channel
+}
+
+func (c amqpPublishChannel) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (deferredConfirmation, error) {
+	confirmation, err := c.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		true,       // mandatory
+		false,      // immediate
+		msg,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return confirmation, nil
+}
+
+// newPublisherPool создает пул из size каналов публикации. Каналы открываются при первом использовании
+func newPublisherPool(size int) chan *publisher {
+	if size <= 0 {
+		size = defaultPublisherChannels
+	}
+
+	pool := make(chan *publisher, size)
+	for i := 0; i < size; i++ {
+		pool <- &publisher{}
+	}
+	return pool
+}
+
+// acquirePublisher забирает канал из пула, ожидая освобождения не дольше ctx. Канал, закрытый
+// вместе с соединением или после ошибки, открывается заново. Канал нужно вернуть releasePublisher
+func (r *RabbitMQ) acquirePublisher(ctx context.Context) (*publisher, error) {
+	var p *publisher
+	select {
+	case p = <-r.publishers:
+	case <-r.done:
+		return nil, fmt.Errorf("%w (%s)", ErrNotConnected, StateClosed)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: нет свободного канала публикации: %v", ErrPublishFailed, ctx.Err())
+	}
+
+	if p.channel != nil && !p.channel.IsClosed() {
+		return p, nil
+	}
+
+	if err := r.openPublisher(p); err != nil {
+		r.releasePublisher(p)
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *RabbitMQ) releasePublisher(p *publisher) {
+	r.publishers <- p
+}
+
+// openPublisher открывает канал публикации вместо закрытого
+func (r *RabbitMQ) openPublisher(p *publisher) error {
+	ch, returns, err := r.openPublishChannel()
+	if err != nil {
+		return err
+	}
+
+	p.channel = ch
+	p.returns = returns
+	return nil
+}
+
+// openConfirmChannel открывает канал amqp в режиме подтверждений
+func (r *RabbitMQ) openConfirmChannel() (publishChannel, <-chan amqp.Return, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if err := r.ensureConnected(); err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to open publisher channel: %v", ErrPublishFailed, err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("%w: failed to enable publisher confirms: %v", ErrPublishFailed, err)
+	}
+
+	return amqpPublishChannel{ch}, ch.NotifyReturn(make(chan amqp.Return, 1)), nil
+}
+
+// publishConfirmed публикует сообщение с флагом mandatory в канале из пула и ждет ответа брокера.
+// Брокер отправляет basic.return раньше подтверждения, а канал не используется конкурентно,
+// поэтому возврат, полученный до подтверждения, относится к этому сообщению
+func (r *RabbitMQ) publishConfirmed(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	r.mu.RLock()
+	err := r.ensureConnected()
+	r.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("ошибка при публикации сообщения: %w", err)
+	}
+
+	p, err := r.acquirePublisher(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.releasePublisher(p)
+
+	confirmation, err := p.channel.publish(ctx, exchange, routingKey, msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPublishFailed, err)
+	}
+
+	err = waitConfirmation(ctx, confirmation, p.returns)
+	if errors.Is(err, ErrConfirmTimeout) {
+		// Запоздавший возврат нельзя будет отличить от возврата следующего сообщения,
+		// поэтому канал не переиспользуется
+		p.channel.Close()
+	}
+	return err
+}
+
+// waitConfirmation ждет подтверждения брокера и проверяет, не вернул ли он сообщение
+func waitConfirmation(ctx context.Context, confirmation deferredConfirmation, returns <-chan amqp.Return) error {
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfirmTimeout, err)
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	select {
+	case ret := <-returns:
+		return newReturnedError(ret)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,305 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Ключи маршрутизации, для которых fakeBroker имитирует ответ брокера
+const (
+	keyUnroutable = "unroutable"
+	keyNacked     = "nacked"
+	keyNoConfirm  = "no_confirm"
+)
+
+// fakeBroker открывает каналы публикации без брокера и проверяет, что канал
+// не используется несколькими горутинами одновременно
+type fakeBroker struct {
+	mu       sync.Mutex
+	channels []*fakeChannel
+	failOpen int
+
+	concurrentUse atomic.Int32
+}
+
+func (b *fakeBroker) open() (publishChannel, <-chan amqp.Return, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failOpen > 0 {
+		b.failOpen--
+		return nil, nil, ErrPublishFailed
+	}
+
+	ch := &fakeChannel{broker: b, returns: make(chan amqp.Return, 1)}
+	b.channels = append(b.channels, ch)
+	return ch, ch.returns, nil
+}
+
+func (b *fakeBroker) opened() []*fakeChannel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*fakeChannel(nil), b.channels...)
+}
+
+type fakeChannel struct {
+	broker  *fakeBroker
+	returns chan amqp.Return
+	inUse   atomic.Int32
+	closed  atomic.Bool
+}
+
+func (c *fakeChannel) publish(_ context.Context, exchange, routingKey string, _ amqp.Publishing) (deferredConfirmation, error) {
+	if c.inUse.Add(1) != 1 {
+		c.broker.concurrentUse.Add(1)
+	}
+	defer c.inUse.Add(-1)
+
+	if c.closed.Load() {
+		return nil, amqp.ErrClosed
+	}
+	// Даем другим горутинам шанс взять тот же канал, если пул выдал его дважды
+	runtime.Gosched()
+
+	switch routingKey {
+	case keyUnroutable:
+		c.returns <- amqp.Return{Exchange: exchange, RoutingKey: routingKey, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+		return fakeConfirmation{acked: true}, nil
+	case keyNacked:
+		return fakeConfirmation{acked: false}, nil
+	case keyNoConfirm:
+		return fakeConfirmation{wait: true}, nil
+	default:
+		return fakeConfirmation{acked: true}, nil
+	}
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	return c.closed.Load()
+}
+
+func (c *fakeChannel) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// fakeConfirmation подтверждение брокера. При wait подтверждение не приходит до отмены ctx
+type fakeConfirmation struct {
+	acked bool
+	wait  bool
+}
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	if c.wait {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return c.acked, nil
+}
+
+// newTestPublisher возвращает клиент с пулом из size каналов, которые открывает fakeBroker
+func newTestPublisher(size int) (*RabbitMQ, *fakeBroker) {
+	broker := &fakeBroker{}
+	r := &RabbitMQ{
+		state:         StateConnected,
+		reconnected:   make(chan struct{}),
+		publishers:    newPublisherPool(size),
+		stopConsuming: make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	r.openPublishChannel = broker.open
+	r.handlerCtx, r.cancelHandlers = context.WithCancel(context.Background())
+	return r, broker
+}
+
+func publish(r *RabbitMQ, routingKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return r.publishConfirmed(ctx, "test_exchange", routingKey, amqp.Publishing{Body: []byte("{}")})
+}
+
+func TestPublisherPoolConcurrentPublish(t *testing.T) {
+	const size = 3
+	r, broker := newTestPublisher(size)
+
+	// Публикаций больше, чем каналов в пуле: горутины ждут свободный канал
+	const goroutines, perGoroutine = 20, 25
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				if err := publish(r, "key"); err != nil {
+					t.Errorf("publishConfirmed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := broker.concurrentUse.Load(); n > 0 {
+		t.Errorf("канал использовался одновременно несколькими горутинами %d раз", n)
+	}
+	if n := len(broker.opened()); n == 0 || n > size {
+		t.Errorf("открыто %d каналов, ожидалось от 1 до %d", n, size)
+	}
+	if n := len(r.publishers); n != size {
+		t.Errorf("в пуле %d каналов, ожидалось %d", n, size)
+	}
+}
+
+func TestPublisherPoolReturnedAndNackedMessages(t *testing.T) {
+	r, broker := newTestPublisher(1)
+
+	err := publish(r, keyUnroutable)
+	var returned *ReturnedError
+	if !errors.As(err, &returned) || returned.RoutingKey != keyUnroutable {
+		t.Errorf("публикация без очереди: ошибка %v, ожидалась *ReturnedError", err)
+	}
+	if err := publish(r, keyNacked); !errors.Is(err, ErrPublishNacked) {
+		t.Errorf("публикация без подтверждения: ошибка %v, ожидалась ErrPublishNacked", err)
+	}
+
+	// Возврат и отказ брокера не закрывают канал, следующая публикация идет через него же
+	if err := publish(r, "key"); err != nil {
+		t.Errorf("publishConfirmed: %v", err)
+	}
+	if n := len(broker.opened()); n != 1 {
+		t.Errorf("открыто %d каналов, ожидался 1", n)
+	}
+}
+
+func TestPublisherPoolReplacesChannelAfterConfirmTimeout(t *testing.T) {
+	r, broker := newTestPublisher(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := r.publishConfirmed(ctx, "test_exchange", keyNoConfirm, amqp.Publishing{})
+	if !errors.Is(err, ErrConfirmTimeout) {
+		t.Fatalf("ошибка %v, ожидалась ErrConfirmTimeout", err)
+	}
+
+	// Запоздавший возврат нельзя отличить от возврата следующего сообщения, поэтому канал закрыт
+	channels := broker.opened()
+	if len(channels) != 1 || !channels[0].IsClosed() {
+		t.Fatalf("канал после таймаута подтверждения не закрыт")
+	}
+
+	if err := publish(r, "key"); err != nil {
+		t.Fatalf("publishConfirmed: %v", err)
+	}
+	if n := len(broker.opened()); n != 2 {
+		t.Errorf("открыто %d каналов, ожидалось 2", n)
+	}
+}
+
+func TestPublisherPoolReleasesChannelWhenOpenFails(t *testing.T) {
+	r, broker := newTestPublisher(2)
+	broker.failOpen = 2
+
+	for i := 0; i < 2; i++ {
+		if err := publish(r, "key"); !errors.Is(err, ErrPublishFailed) {
+			t.Errorf("ошибка %v, ожидалась ErrPublishFailed", err)
+		}
+	}
+	if n := len(r.publishers); n != 2 {
+		t.Fatalf("в пуле %d каналов, ожидалось 2", n)
+	}
+
+	if err := publish(r, "key"); err != nil {
+		t.Errorf("publishConfirmed: %v", err)
+	}
+}
+
+func TestAcquirePublisherWaitsForFreeChannel(t *testing.T) {
+	r, _ := newTestPublisher(1)
+
+	p, err := r.acquirePublisher(context.Background())
+	if err != nil {
+		t.Fatalf("acquirePublisher: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquirePublisher(ctx); !errors.Is(err, ErrPublishFailed) {
+		t.Errorf("ошибка %v, ожидалась ErrPublishFailed: свободного канала нет", err)
+	}
+
+	acquired := make(chan *publisher)
+	go func() {
+		next, err := r.acquirePublisher(context.Background())
+		if err != nil {
+			t.Errorf("acquirePublisher: %v", err)
+		}
+		acquired <- next
+	}()
+
+	r.releasePublisher(p)
+	select {
+	case next := <-acquired:
+		if next != p {
+			t.Errorf("ожидавшая горутина получила другой канал")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("освобожденный канал не выдан ожидавшей горутине")
+	}
+}
+
+func TestClosePublisherWhilePublishing(t *testing.T) {
+	r, broker := newTestPublisher(2)
+
+	// Горутины публикуют, пока клиент не закроется, и должны остановиться с ErrNotConnected
+	const goroutines = 10
+	var published atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := publish(r, "key")
+				if err == nil {
+					published.Add(1)
+					continue
+				}
+				if !errors.Is(err, ErrNotConnected) {
+					t.Errorf("ошибка %v, ожидалась ErrNotConnected", err)
+				}
+				return
+			}
+		}()
+	}
+
+	for published.Load() < 100 {
+		runtime.Gosched()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(publishTimeout / 2):
+		t.Fatal("публикации не остановились после Close")
+	}
+
+	if n := broker.concurrentUse.Load(); n > 0 {
+		t.Errorf("канал использовался одновременно несколькими горутинами %d раз", n)
+	}
+	if err := publish(r, "key"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("публикация после Close: ошибка %v, ожидалась ErrNotConnected", err)
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/director74/dz7_shop/pkg/events"
 )
 
 // Config содержит настройки подключения к RabbitMQ
@@ -17,29 +20,79 @@ type Config struct {
 	User     string
 	Password string
 	VHost    string
+	// MaxRetries количество повторных попыток обработки сообщения до переноса в очередь .dlq
+	MaxRetries int
+	// RetryBaseDelay задержка перед первой повторной попыткой, каждая следующая вдвое больше
+	RetryBaseDelay time.Duration
+	// PublisherChannels размер пула каналов публикации
+	PublisherChannels int
+	// Consumer настройки подписок по умолчанию, незаданные в ConsumeMessages
+	Consumer ConsumerOptions
 }
 
-// RabbitMQ представляет клиент для работы с RabbitMQ
+// RabbitMQ представляет клиент для работы с RabbitMQ. При потере соединения или канала
+// клиент переподключается в фоне, заново объявляет exchanges, очереди и привязки,
+// объявленные через него, и возобновляет подписки ConsumeMessages.
+// Клиент безопасен для конкурентного использования: канал amqp не используется
+// несколькими горутинами одновременно
 type RabbitMQ struct {
-	config     Config
+	config Config
+
+	// mu защищает соединение, канал топологии, состояние и зарегистрированную топологию
+	mu         sync.RWMutex
 	connection *amqp.Connection
 	channel    *amqp.Channel
+	state      ConnectionState
+	exchanges  []exchangeDecl
+	queues     []string
+	bindings   []bindingDecl
+	consumers  []*consumer
+	// reconnected закрывается и заменяется новым после каждого восстановления соединения
+	reconnected chan struct{}
+
+	// publishers пул каналов публикации: канал берется из пула на время одной публикации
+	publishers chan *publisher
+	// openPublishChannel открывает канал публикации, по умолчанию openConfirmChannel
+	openPublishChannel func() (publishChannel, <-chan amqp.Return, error)
+
+	// handlerCtx родительский контекст обработчиков, отменяется при принудительной остановке
+	handlerCtx     context.Context
+	cancelHandlers context.CancelFunc
+	// consuming учитывает работающие подписки, stopConsuming закрывается при остановке подписок
+	consuming     sync.WaitGroup
+	stopConsuming chan struct{}
+	stopOnce      sync.Once
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// publishTimeout время ожидания подтверждения брокера в PublishMessage
+const publishTimeout = 5 * time.Second
+
 func NewRabbitMQ(cfg Config) (*RabbitMQ, error) {
 	rmq := &RabbitMQ{
-		config: cfg,
+		config:        cfg,
+		reconnected:   make(chan struct{}),
+		publishers:    newPublisherPool(cfg.PublisherChannels),
+		stopConsuming: make(chan struct{}),
+		done:          make(chan struct{}),
 	}
+	rmq.handlerCtx, rmq.cancelHandlers = context.WithCancel(context.Background())
+	rmq.openPublishChannel = rmq.openConfirmChannel
 
 	err := rmq.connect()
 	if err != nil {
 		return nil, err
 	}
+	rmq.state = StateConnected
+
+	go rmq.watch()
 
 	return rmq, nil
 }
 
-// connect устанавливает соединение с RabbitMQ
+// connect устанавливает соединение с RabbitMQ и открывает канал для объявления топологии
 func (r *RabbitMQ) connect() error {
 	connStr := fmt.Sprintf("amqp://%s:%s@%s:%s/%s",
 		r.config.User, r.config.Password, r.config.Host, r.config.Port, r.config.VHost)
@@ -60,25 +113,24 @@ func (r *RabbitMQ) connect() error {
 	return nil
 }
 
-// reconnect пытается восстановить соединение с RabbitMQ
-func (r *RabbitMQ) reconnect() error {
-	if r.connection != nil && !r.connection.IsClosed() {
-		return nil
-	}
+// Close закрывает соединение с RabbitMQ и останавливает переподключение.
+// Каналы публикации и подписок закрываются вместе с соединением, контекст обработчиков
+// отменяется. Чтобы дождаться обрабатываемых сообщений, перед Close вызывается StopConsuming
+func (r *RabbitMQ) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	r.cancelHandlers()
 
-	log.Println("Попытка переподключения к RabbitMQ...")
-	return r.connect()
-}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setState(StateClosed)
 
-// Close закрывает соединение с RabbitMQ
-func (r *RabbitMQ) Close() error {
 	var err error
-	if r.channel != nil {
+	if r.channel != nil && !r.channel.IsClosed() {
 		if err = r.channel.Close(); err != nil {
 			return fmt.Errorf("ошибка при закрытии канала: %w", err)
 		}
 	}
-	if r.connection != nil {
+	if r.connection != nil && !r.connection.IsClosed() {
 		if err = r.connection.Close(); err != nil {
 			return fmt.Errorf("ошибка при закрытии соединения: %w", err)
 		}
@@ -88,10 +140,21 @@ func (r *RabbitMQ) Close() error {
 
 // DeclareExchange объявляет exchange
 func (r *RabbitMQ) DeclareExchange(name string, kind string) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед объявлением exchange: %w", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ensureConnected(); err != nil {
+		return fmt.Errorf("ошибка при объявлении exchange %s: %w", name, err)
+	}
+	if err := r.declareExchange(name, kind); err != nil {
+		return err
 	}
 
+	r.registerExchange(name, kind)
+	return nil
+}
+
+func (r *RabbitMQ) declareExchange(name string, kind string) error {
 	return r.channel.ExchangeDeclare(
 		name,  // name
 		kind,  // type
@@ -103,45 +166,67 @@ func (r *RabbitMQ) DeclareExchange(name string, kind string) error {
 	)
 }
 
-// DeclareQueue объявляет очередь
+// DeclareQueue объявляет очередь вместе с ее очередями повторов и недоставленных сообщений
 func (r *RabbitMQ) DeclareQueue(name string) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед объявлением очереди: %w", err)
-	}
-
-	_, err := r.channel.QueueDeclare(
-		name,  // name
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	_, err := r.DeclareQueueWithReturn(name)
 	return err
 }
 
-// DeclareQueueWithReturn объявляет очередь и возвращает информацию о ней
+// DeclareQueueWithReturn объявляет очередь вместе с ее очередями повторов и недоставленных
+// сообщений и возвращает информацию об основной очереди
 func (r *RabbitMQ) DeclareQueueWithReturn(name string) (amqp.Queue, error) {
-	if err := r.reconnect(); err != nil {
-		return amqp.Queue{}, fmt.Errorf("ошибка переподключения перед объявлением очереди: %w", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ensureConnected(); err != nil {
+		return amqp.Queue{}, fmt.Errorf("ошибка при объявлении очереди %s: %w", name, err)
 	}
 
+	queue, err := r.declareQueue(name)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	r.registerQueue(name)
+	return queue, nil
+}
+
+func (r *RabbitMQ) declareQueue(name string) (amqp.Queue, error) {
+	if err := r.declareDeadLetterTopology(name); err != nil {
+		return amqp.Queue{}, err
+	}
+
+	// Отклоненные без повтора сообщения брокер сам переносит в очередь .dlq
 	return r.channel.QueueDeclare(
 		name,  // name
 		true,  // durable
 		false, // delete when unused
 		false, // exclusive
 		false, // no-wait
-		nil,   // arguments
+		amqp.Table{
+			"x-dead-letter-exchange":    deadLetterExchangeName(name),
+			"x-dead-letter-routing-key": dlqRoutingKey,
+		},
 	)
 }
 
 // BindQueue привязывает очередь к exchange
 func (r *RabbitMQ) BindQueue(queueName, exchangeName, routingKey string) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед привязкой очереди: %w", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ensureConnected(); err != nil {
+		return fmt.Errorf("ошибка при привязке очереди %s: %w", queueName, err)
+	}
+	if err := r.bindQueue(queueName, exchangeName, routingKey); err != nil {
+		return err
 	}
 
+	r.registerBinding(bindingDecl{queue: queueName, exchange: exchangeName, routingKey: routingKey})
+	return nil
+}
+
+func (r *RabbitMQ) bindQueue(queueName, exchangeName, routingKey string) error {
 	return r.channel.QueueBind(
 		queueName,    // queue name
 		routingKey,   // routing key
@@ -151,41 +236,41 @@ func (r *RabbitMQ) BindQueue(queueName, exchangeName, routingKey string) error {
 	)
 }
 
-// PublishMessage публикует сообщение в RabbitMQ
+// PublishMessage публикует сообщение в RabbitMQ и ждет подтверждения брокера
 func (r *RabbitMQ) PublishMessage(exchange, routingKey string, message interface{}) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед публикацией сообщения: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
 	defer cancel()
 
+	return r.PublishMessageContext(ctx, exchange, routingKey, message)
+}
+
+// PublishMessageContext публикует сообщение с флагом mandatory и ждет подтверждения брокера
+// до истечения ctx. Сообщение, не попавшее ни в одну очередь, возвращает *ReturnedError
+func (r *RabbitMQ) PublishMessageContext(ctx context.Context, exchange, routingKey string, message interface{}) error {
 	body, err := json.Marshal(message)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-
-	return r.channel.PublishWithContext(
-		ctx,
-		exchange,   // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			DeliveryMode: amqp.Persistent,
-			Body:         body,
-		},
-	)
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	return r.publishConfirmed(ctx, exchange, routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
 }
 
-// PublishMessageWithRetry публикует сообщение с повторными попытками
+// PublishMessageWithRetry публикует сообщение с повторными попытками. Повторяются только
+// публикации, которые брокер не принял (см. IsRetryable)
 func (r *RabbitMQ) PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error {
 	var err error
 	for i := 0; i <= retries; i++ {
 		if err = r.PublishMessage(exchange, routingKey, message); err == nil {
 			return nil
 		}
+		if !IsRetryable(err) {
+			return err
+		}
 
 		log.Printf("Ошибка публикации сообщения (попытка %d/%d): %v", i+1, retries+1, err)
 
@@ -199,39 +284,30 @@ func (r *RabbitMQ) PublishMessageWithRetry(exchange, routingKey string, message
 	return fmt.Errorf("не удалось опубликовать сообщение после %d попыток: %w", retries+1, err)
 }
 
-// ConsumeMessages начинает обработку сообщений из очереди с обработчиком
-func (r *RabbitMQ) ConsumeMessages(queueName, consumerName string, handler func([]byte) error) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед обработкой сообщений: %w", err)
-	}
-
-	msgs, err := r.channel.Consume(
-		queueName,    // queue
-		consumerName, // consumer
-		false,        // auto-ack
-		false,        // exclusive
-		false,        // no-local
-		false,        // no-wait
-		nil,          // args
-	)
-
+// PublishEvent публикует конверт события с флагом mandatory и ждет подтверждения приема брокером.
+// Поля конверта дублируются в свойствах сообщения AMQP: message_id, type, app_id, correlation_id,
+// timestamp и заголовках x-event-version, x-causation-id. Ошибка означает, что сообщение могло
+// не дойти до брокера и его нужно отправить повторно
+func (r *RabbitMQ) PublishEvent(ctx context.Context, exchange, routingKey string, envelope events.Envelope) error {
+	body, err := json.Marshal(envelope)
 	if err != nil {
-		return fmt.Errorf("ошибка при начале обработки сообщений: %w", err)
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
 	}
 
-	go r.HandleMessages(msgs, handler)
-
-	return nil
-}
-
-func (r *RabbitMQ) HandleMessages(msgs <-chan amqp.Delivery, handler func([]byte) error) {
-	for msg := range msgs {
-		err := handler(msg.Body)
-		if err != nil {
-			log.Printf("Error handling message: %v", err)
-			msg.Nack(false, true) // Сообщение не обработано и возвращается в очередь
-		} else {
-			msg.Ack(false) // Подтверждаем обработку сообщения
-		}
+	headers := amqp.Table{HeaderEventVersion: int32(envelope.Version)}
+	if envelope.CausationID != "" {
+		headers[HeaderCausationID] = envelope.CausationID
 	}
+
+	return r.publishConfirmed(ctx, exchange, routingKey, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     envelope.EventID,
+		Type:          envelope.Type,
+		AppId:         envelope.Producer,
+		CorrelationId: envelope.CorrelationID,
+		Timestamp:     envelope.OccurredAt,
+		Body:          body,
+	})
 }
@@ -0,0 +1,210 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ConnectionState состояние подключения к RabbitMQ
+type ConnectionState string
+
+const (
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+// Задержка между попытками переподключения: начинается с reconnectMinDelay
+// и удваивается до reconnectMaxDelay
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// ErrNotConnected ошибка, когда соединение с RabbitMQ потеряно и еще не восстановлено
+var ErrNotConnected = errors.New("нет соединения с RabbitMQ")
+
+// exchangeDecl объявленный exchange, восстанавливаемый после переподключения
+type exchangeDecl struct {
+	name string
+	kind string
+}
+
+// bindingDecl привязка очереди к exchange, восстанавливаемая после переподключения
+type bindingDecl struct {
+	queue      string
+	exchange   string
+	routingKey string
+}
+
+// State возвращает текущее состояние подключения
+func (r *RabbitMQ) State() ConnectionState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// IsConnected сообщает, установлено ли соединение с RabbitMQ
+func (r *RabbitMQ) IsConnected() bool {
+	return r.State() == StateConnected
+}
+
+// setState меняет состояние подключения. Вызывается под r.mu
+func (r *RabbitMQ) setState(state ConnectionState) {
+	if r.state == state {
+		return
+	}
+	log.Printf("Состояние подключения к RabbitMQ: %s -> %s", r.state, state)
+	r.state = state
+}
+
+// ensureConnected проверяет, что соединение установлено. Вызывается под r.mu
+func (r *RabbitMQ) ensureConnected() error {
+	if r.state != StateConnected {
+		return fmt.Errorf("%w (%s)", ErrNotConnected, r.state)
+	}
+	return nil
+}
+
+// watch следит за закрытием соединения и канала и восстанавливает их до вызова Close
+func (r *RabbitMQ) watch() {
+	for {
+		r.mu.RLock()
+		connClosed := r.connection.NotifyClose(make(chan *amqp.Error, 1))
+		chanClosed := r.channel.NotifyClose(make(chan *amqp.Error, 1))
+		r.mu.RUnlock()
+
+		select {
+		case <-r.done:
+			return
+		case err := <-connClosed:
+			log.Printf("Соединение с RabbitMQ закрыто: %v", err)
+		case err := <-chanClosed:
+			log.Printf("Канал RabbitMQ закрыт: %v", err)
+		}
+
+		r.mu.Lock()
+		if r.state == StateClosed {
+			r.mu.Unlock()
+			return
+		}
+		r.setState(StateReconnecting)
+		r.mu.Unlock()
+
+		if !r.restore() {
+			return
+		}
+	}
+}
+
+// restore повторяет переподключение с нарастающей задержкой, пока оно не удастся.
+// Возвращает false, если клиент закрыт
+func (r *RabbitMQ) restore() bool {
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := r.reopen()
+		if err == nil {
+			log.Printf("Соединение с RabbitMQ восстановлено (попытка %d)", attempt)
+			return true
+		}
+		log.Printf("Ошибка переподключения к RabbitMQ (попытка %d), повтор через %v: %v", attempt, delay, err)
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// reopen открывает соединение или только канал топологии, если соединение живо,
+// и заново объявляет известную топологию
+func (r *RabbitMQ) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == StateClosed {
+		return ErrNotConnected
+	}
+
+	if r.connection == nil || r.connection.IsClosed() {
+		if err := r.connect(); err != nil {
+			return err
+		}
+	} else {
+		ch, err := r.connection.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open channel: %w", err)
+		}
+		r.channel = ch
+	}
+
+	if err := r.restoreTopology(); err != nil {
+		// Канал мог закрыться из-за ошибки объявления, следующая попытка откроет новый
+		r.channel.Close()
+		return err
+	}
+
+	r.setState(StateConnected)
+	// Подписки ждут восстановления соединения и подписываются заново
+	close(r.reconnected)
+	r.reconnected = make(chan struct{})
+	return nil
+}
+
+// restoreTopology объявляет exchanges, очереди и привязки в порядке их регистрации
+func (r *RabbitMQ) restoreTopology() error {
+	for _, e := range r.exchanges {
+		if err := r.declareExchange(e.name, e.kind); err != nil {
+			return fmt.Errorf("ошибка при восстановлении exchange %s: %w", e.name, err)
+		}
+	}
+	for _, q := range r.queues {
+		if _, err := r.declareQueue(q); err != nil {
+			return fmt.Errorf("ошибка при восстановлении очереди %s: %w", q, err)
+		}
+	}
+	for _, b := range r.bindings {
+		if err := r.bindQueue(b.queue, b.exchange, b.routingKey); err != nil {
+			return fmt.Errorf("ошибка при восстановлении привязки очереди %s: %w", b.queue, err)
+		}
+	}
+	return nil
+}
+
+func (r *RabbitMQ) registerExchange(name, kind string) {
+	for i, e := range r.exchanges {
+		if e.name == name {
+			r.exchanges[i].kind = kind
+			return
+		}
+	}
+	r.exchanges = append(r.exchanges, exchangeDecl{name: name, kind: kind})
+}
+
+func (r *RabbitMQ) registerQueue(name string) {
+	for _, q := range r.queues {
+		if q == name {
+			return
+		}
+	}
+	r.queues = append(r.queues, name)
+}
+
+func (r *RabbitMQ) registerBinding(b bindingDecl) {
+	for _, existing := range r.bindings {
+		if existing == b {
+			return
+		}
+	}
+	r.bindings = append(r.bindings, b)
+}
@@ -0,0 +1,190 @@
+// Сага оплаты заказа проверяется на всех трех сервисах в одном процессе: настоящие usecase и
+// обработчики очередей сервисов заказов, биллинга и уведомлений работают на общем брокере в памяти
+// с топологией из их app.SetupMessaging, как в cmd/devstack. Данные сервисов хранятся в памяти
+package saga
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/director74/dz7_shop/billing-service/billingtest"
+	"github.com/director74/dz7_shop/notification-service/notificationtest"
+	"github.com/director74/dz7_shop/order-service/ordertest"
+	"github.com/director74/dz7_shop/pkg/messaging"
+	"github.com/director74/dz7_shop/pkg/money"
+)
+
+// waitTimeout максимальное время ожидания результата саги
+const waitTimeout = 5 * time.Second
+
+type services struct {
+	orders        *ordertest.Service
+	billing       *billingtest.Service
+	notifications *notificationtest.Service
+}
+
+func startServices(t *testing.T) *services {
+	t.Helper()
+
+	broker := messaging.NewMemoryBroker(messaging.MemoryConfig{MaxRetries: 3, RetryBaseDelay: 10 * time.Millisecond})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		if err := broker.StopConsuming(ctx); err != nil {
+			t.Errorf("StopConsuming: %v", err)
+		}
+		broker.Close()
+	})
+
+	// Очереди всех сервисов объявляются до первой публикации
+	notifications, err := notificationtest.Start(broker)
+	if err != nil {
+		t.Fatalf("запуск сервиса уведомлений: %v", err)
+	}
+	billing, err := billingtest.Start(broker)
+	if err != nil {
+		t.Fatalf("запуск биллинга: %v", err)
+	}
+	orders, err := ordertest.Start(broker)
+	if err != nil {
+		t.Fatalf("запуск сервиса заказов: %v", err)
+	}
+	return &services{orders: orders, billing: billing, notifications: notifications}
+}
+
+// placeOrder создает покупателя со счетом на balance и заказ на два товара по price
+func (s *services) placeOrder(t *testing.T, balance, price string) (userID, orderID uint) {
+	t.Helper()
+	ctx := context.Background()
+
+	userID, err := s.orders.CreateUser(ctx, "buyer", "buyer@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := s.billing.OpenAccount(ctx, userID, money.MustParse(balance, "")); err != nil {
+		t.Fatalf("OpenAccount: %v", err)
+	}
+	productID, err := s.orders.AddProduct(ctx, "SKU-1", money.MustParse(price, ""))
+	if err != nil {
+		t.Fatalf("AddProduct: %v", err)
+	}
+	orderID, err = s.orders.CreateOrder(ctx, userID, productID, 2)
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return userID, orderID
+}
+
+// waitFor ждет, пока check не вернет пустое описание расхождения
+func waitFor(t *testing.T, check func() string) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		mismatch := check()
+		if mismatch == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("сага не завершилась за %s: %s", waitTimeout, mismatch)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *services) waitStatus(t *testing.T, orderID uint, want string) {
+	t.Helper()
+	waitFor(t, func() string {
+		status, err := s.orders.OrderStatus(context.Background(), orderID)
+		if err != nil {
+			return err.Error()
+		}
+		if status != want {
+			return fmt.Sprintf("статус заказа %d %s, ожидался %s", orderID, status, want)
+		}
+		return ""
+	})
+}
+
+func (s *services) waitBalance(t *testing.T, userID uint, want string) {
+	t.Helper()
+	waitFor(t, func() string {
+		balance, err := s.billing.Balance(context.Background(), userID)
+		if err != nil {
+			return err.Error()
+		}
+		if !balance.Equal(money.MustParse(want, "")) {
+			return fmt.Sprintf("баланс %s, ожидался %s", balance, want)
+		}
+		return ""
+	})
+}
+
+// waitNotifications ждет уведомления пользователя с темами subjects
+func (s *services) waitNotifications(t *testing.T, userID uint, subjects ...string) {
+	t.Helper()
+	waitFor(t, func() string {
+		sent, err := s.notifications.Subjects(context.Background(), userID)
+		if err != nil {
+			return err.Error()
+		}
+		for _, subject := range subjects {
+			if !slices.Contains(sent, subject) {
+				return fmt.Sprintf("нет уведомления %q, отправлены %q", subject, sent)
+			}
+		}
+		return ""
+	})
+}
+
+func (s *services) assertLedger(t *testing.T) {
+	t.Helper()
+	if err := s.billing.Reconcile(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOrderIsPaidThroughSaga(t *testing.T) {
+	s := startServices(t)
+	userID, orderID := s.placeOrder(t, "100.00", "30.00")
+
+	s.waitStatus(t, orderID, "paid")
+	s.waitBalance(t, userID, "40.00")
+	s.waitNotifications(t, userID,
+		"Пополнение баланса",
+		fmt.Sprintf("Заказ #%d успешно оформлен", orderID),
+		fmt.Sprintf("Заказ #%d оплачен", orderID))
+	s.assertLedger(t)
+}
+
+func TestOrderFailsWithoutFunds(t *testing.T) {
+	s := startServices(t)
+	userID, orderID := s.placeOrder(t, "50.00", "30.00")
+
+	s.waitStatus(t, orderID, "failed")
+	s.waitNotifications(t, userID,
+		"Недостаточно средств на вашем счете",
+		fmt.Sprintf("Проблема с заказом #%d", orderID))
+	s.waitBalance(t, userID, "50.00")
+	s.assertLedger(t)
+}
+
+func TestCanceledPaidOrderIsRefunded(t *testing.T) {
+	s := startServices(t)
+	userID, orderID := s.placeOrder(t, "100.00", "30.00")
+	s.waitStatus(t, orderID, "paid")
+
+	if err := s.orders.CancelOrder(context.Background(), orderID); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+
+	s.waitBalance(t, userID, "100.00")
+	s.waitNotifications(t, userID,
+		fmt.Sprintf("Заказ #%d отменен", orderID),
+		"Возврат средств")
+	s.waitStatus(t, orderID, "canceled")
+	s.assertLedger(t)
+}